@@ -41,6 +41,11 @@ type NearestResult struct {
 
 	// Results contains an array of Targets matching the client request.
 	Results []Target `json:"results,omitempty"`
+
+	// Warnings contains human-readable advisories about the request or the
+	// results, e.g. deprecated services or degraded results. Warnings never
+	// indicate a failure and clients MAY ignore them.
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 // MonitoringResult contains one Target with a single-purpose access-token
@@ -67,6 +72,21 @@ type MonitoringResult struct {
 	Results []Target `json:"results,omitempty"`
 }
 
+// TokenResult is returned by the location service in response to token
+// requests from clients with an API key.
+type TokenResult struct {
+	// Error contains information about request failures.
+	Error *Error `json:"error,omitempty"`
+
+	// AccessToken is the access token embedded in the NextRequest URL. It may
+	// be used in the access_token parameter of other priority requests while
+	// it is valid.
+	AccessToken string `json:"access_token,omitempty"`
+
+	// NextRequest contains the priority request URL using the access token.
+	NextRequest *NextRequest `json:"next_request,omitempty"`
+}
+
 // NextRequest contains a URL for scheduling the next request. The URL embeds an
 // access token that will be valid after `NotBefore`. The access token will
 // remain valid until it `Expires`. If a client uses an expired URL, the request
@@ -107,6 +127,15 @@ type Target struct {
 	// Location contains metadata about the geographic location of the target machine.
 	Location *Location `json:"location,omitempty"`
 
+	// IPv4 and IPv6 are the addresses of the target machine. They are only
+	// included when requested, e.g. to pre-resolve or pin connections.
+	IPv4 string `json:"ipv4,omitempty"`
+	IPv6 string `json:"ipv6,omitempty"`
+
+	// ServiceInfo describes the protocol revisions supported by the
+	// measurement service, if reported by the target machine.
+	ServiceInfo *ServiceInfo `json:"service_info,omitempty"`
+
 	// URLs contains measurement service resource names and the complete URL for
 	// running a measurement.
 	//
@@ -147,27 +176,98 @@ type HeartbeatMessage struct {
 // Registration contains a set of identifying fields
 // for a server instance.
 type Registration struct {
-	City          string              // City (e.g., New York).
-	CountryCode   string              // Country code (e.g., US).
-	ContinentCode string              // Continent code (e.g., NA).
-	Experiment    string              // Experiment (e.g., ndt).
-	Hostname      string              // Fully qualified service hostname.
-	Latitude      float64             // Latitude.
-	Longitude     float64             // Longitude.
-	Machine       string              // Machine (e.g., mlab1).
-	Metro         string              // Metro (e.g., lga).
-	Project       string              // Project (e.g., mlab-sandbox).
-	Probability   float64             // Probability of picking site (e.g., 0.3).
-	Site          string              // Site (e.g.. lga01).
-	Type          string              // Machine type (e.g., physical, virtual).
-	Uplink        string              // Uplink capacity.
-	Services      map[string][]string // Mapping of service names.
+	City          string                 // City (e.g., New York).
+	CountryCode   string                 // Country code (e.g., US).
+	ContinentCode string                 // Continent code (e.g., NA).
+	Experiment    string                 // Experiment (e.g., ndt).
+	Hostname      string                 // Fully qualified service hostname.
+	Latitude      float64                // Latitude.
+	Longitude     float64                // Longitude.
+	Machine       string                 // Machine (e.g., mlab1).
+	Metro         string                 // Metro (e.g., lga).
+	Project       string                 // Project (e.g., mlab-sandbox).
+	Probability   float64                // Probability of picking site (e.g., 0.3).
+	Site          string                 // Site (e.g.. lga01).
+	Type          string                 // Machine type (e.g., physical, virtual).
+	Uplink        string                 // Uplink capacity.
+	Services      map[string][]string    // Mapping of service names.
+	IPv4          string                 `json:",omitempty"` // IPv4 address (e.g., 192.0.2.1).
+	IPv6          string                 `json:",omitempty"` // IPv6 address (e.g., 2001:db8::1).
+	ServiceInfo   map[string]ServiceInfo // Protocol metadata of services, if known.
+	Maintenance   []MaintenanceWindow    `json:",omitempty"` // Planned maintenance windows.
+	// ProtocolVersion is the heartbeat protocol version of the agent, or 0
+	// for agents that predate versioning.
+	ProtocolVersion int `json:",omitempty"`
+}
+
+// HeartbeatProtocolVersion is the heartbeat protocol version spoken by the
+// agents of this module. Agents send it with their registration, so that the
+// server only uses the message types each agent supports. Agents that do not
+// send a version speak the original protocol (version 0).
+const HeartbeatProtocolVersion = 1
+
+// MaintenanceWindow is a planned period during which a machine must not be
+// selected as a target.
+type MaintenanceWindow struct {
+	Start time.Time `json:"start"` // Start of the window (inclusive).
+	End   time.Time `json:"end"`   // End of the window (exclusive).
+}
+
+// Contains returns whether t is within the window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// InMaintenance returns whether t is within any of the maintenance windows of
+// the registration.
+func (r *Registration) InMaintenance(t time.Time) bool {
+	for _, w := range r.Maintenance {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceInfo describes the protocol revisions and optional features
+// supported by a measurement service.
+type ServiceInfo struct {
+	// Versions lists the supported protocol versions (e.g., "v1").
+	Versions []string `json:"versions,omitempty"`
+	// Capabilities lists optional protocol features (e.g., "bbr").
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 // Health is the structure used by the heartbeat service
 // to report health updates.
+//
+// Only Score is required. The capacity fields are optional, and omitted or
+// zero when unknown, so that heartbeats from older agents remain valid.
 type Health struct {
 	Score float64 // Health score.
+	// ActiveTests is the number of tests in progress.
+	ActiveTests int `json:",omitempty"`
+	// MaxTests is the maximum number of concurrent tests.
+	MaxTests int `json:",omitempty"`
+	// LoadAvg is the 1-minute load average of the machine.
+	LoadAvg float64 `json:",omitempty"`
+	// Timestamp is the Unix time in milliseconds when the sample was taken.
+	// Samples buffered during disconnects are replayed with their original
+	// timestamps; samples older than the current health are ignored.
+	Timestamp int64 `json:",omitempty"`
+	// Services maps service names (e.g., "ndt/ndt7") to their health score,
+	// for machines whose services are checked individually. Services without
+	// a score of their own use Score.
+	Services map[string]float64 `json:",omitempty"`
+}
+
+// ServiceScore returns the health score of the service, or Score if the
+// service has no score of its own.
+func (h *Health) ServiceScore(service string) float64 {
+	if score, ok := h.Services[service]; ok {
+		return score
+	}
+	return h.Score
 }
 
 // Prometheus contains the health data reported by Prometheus.
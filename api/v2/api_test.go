@@ -0,0 +1,76 @@
+package v2
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistration_InMaintenance(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	r := &Registration{
+		Maintenance: []MaintenanceWindow{{Start: start, End: start.Add(2 * time.Hour)}},
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{
+			name: "before",
+			t:    start.Add(-time.Second),
+			want: false,
+		},
+		{
+			name: "start",
+			t:    start,
+			want: true,
+		},
+		{
+			name: "during",
+			t:    start.Add(time.Hour),
+			want: true,
+		},
+		{
+			name: "end",
+			t:    start.Add(2 * time.Hour),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.InMaintenance(tt.t); got != tt.want {
+				t.Errorf("InMaintenance() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealth_ServiceScore(t *testing.T) {
+	h := &Health{
+		Score:    1,
+		Services: map[string]float64{"msak/throughput1": 0},
+	}
+	tests := []struct {
+		name    string
+		service string
+		want    float64
+	}{
+		{
+			name:    "service-score",
+			service: "msak/throughput1",
+			want:    0,
+		},
+		{
+			name:    "machine-score",
+			service: "ndt/ndt7",
+			want:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.ServiceScore(tt.service); got != tt.want {
+				t.Errorf("ServiceScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
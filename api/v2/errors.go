@@ -0,0 +1,48 @@
+package v2
+
+// ErrorTypeBase is the prefix of the problem-type URIs used in Error.Type.
+const ErrorTypeBase = "https://locate.measurementlab.net/v2/errors/"
+
+// Problem types of Error.Type. The URIs are stable, so clients may branch on
+// them programmatically instead of parsing the Title.
+const (
+	// ErrorTypeRateLimited is used when the client exceeds its request rate.
+	ErrorTypeRateLimited = ErrorTypeBase + "rate-limited"
+	// ErrorTypeQuotaExceeded is used when the API key exceeds its quota.
+	ErrorTypeQuotaExceeded = ErrorTypeBase + "quota-exceeded"
+	// ErrorTypeOverloaded is used when the service only serves requests with
+	// an API key during capacity incidents.
+	ErrorTypeOverloaded = ErrorTypeBase + "overloaded"
+	// ErrorTypeClientLocation is used when the client location is unknown.
+	ErrorTypeClientLocation = ErrorTypeBase + "client-location"
+	// ErrorTypeNoServers is used when no servers can serve the request.
+	ErrorTypeNoServers = ErrorTypeBase + "no-servers"
+	// ErrorTypeInvalidRequest is used for missing or invalid parameters.
+	ErrorTypeInvalidRequest = ErrorTypeBase + "invalid-request"
+	// ErrorTypeUnknownService is used when the requested service does not
+	// exist.
+	ErrorTypeUnknownService = ErrorTypeBase + "unknown-service"
+	// ErrorTypeUnauthorized is used for missing or invalid credentials (e.g.,
+	// API keys, signatures or tokens).
+	ErrorTypeUnauthorized = ErrorTypeBase + "unauthorized"
+	// ErrorTypeUnavailable is used when a dependency of the service (e.g.,
+	// siteinfo or the API key store) cannot be reached.
+	ErrorTypeUnavailable = ErrorTypeBase + "unavailable"
+	// ErrorTypeInternal is used for any other server error.
+	ErrorTypeInternal = ErrorTypeBase + "internal"
+)
+
+// ErrorTypes is the catalogue of the problem types, mapping each URI to its
+// description.
+var ErrorTypes = map[string]string{
+	ErrorTypeRateLimited:    "The client exceeded its request rate. Retry later, respecting Retry-After when given.",
+	ErrorTypeQuotaExceeded:  "The API key exceeded its request quota.",
+	ErrorTypeOverloaded:     "The platform is at capacity and only serves requests with an API key.",
+	ErrorTypeClientLocation: "The client location could not be determined.",
+	ErrorTypeNoServers:      "No servers are available for the request.",
+	ErrorTypeInvalidRequest: "The request has missing or invalid parameters.",
+	ErrorTypeUnknownService: "The requested service does not exist.",
+	ErrorTypeUnauthorized:   "The request credentials are missing or invalid.",
+	ErrorTypeUnavailable:    "A dependency of the service is unavailable. Retry later.",
+	ErrorTypeInternal:       "The server failed to process the request.",
+}
@@ -0,0 +1,17 @@
+package v2
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestErrorTypes(t *testing.T) {
+	for typ, desc := range ErrorTypes {
+		if !strings.HasPrefix(typ, ErrorTypeBase) || typ == ErrorTypeBase {
+			t.Errorf("ErrorTypes has invalid type %q", typ)
+		}
+		if desc == "" {
+			t.Errorf("ErrorTypes has no description for %q", typ)
+		}
+	}
+}
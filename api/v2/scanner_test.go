@@ -48,6 +48,16 @@ var tests = []struct {
 			Score: 1.0,
 		},
 	},
+	{
+		name:     "health-capacity-success",
+		receiver: &Health{},
+		scanObj: &Health{
+			Score:       0.5,
+			ActiveTests: 3,
+			MaxTests:    10,
+			LoadAvg:     1.25,
+		},
+	},
 	{
 		name:     "prometheus-success",
 		receiver: &Prometheus{},
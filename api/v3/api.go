@@ -0,0 +1,135 @@
+// Package v3 defines a draft of the next request API for the location
+// service.
+//
+// Unlike v2, where requests are described by the URL path, query parameters
+// and headers, a v3 request is an explicit, typed NearestRequest sent as the
+// JSON body of a POST to /v3/nearest. Results also describe how the targets
+// were selected. The v3 API is a draft and may change; v2 is unaffected.
+package v3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// Supported address families.
+const (
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
+)
+
+// MaxCount is the maximum number of targets of a request.
+const MaxCount = 10
+
+// NearestRequest describes a request for the nearest targets of a service.
+type NearestRequest struct {
+	// Service is the measurement service (e.g., "ndt/ndt7").
+	Service string `json:"service"`
+
+	// Latitude and Longitude are the client location. Both are given or
+	// neither; when omitted, the client location is found from the request.
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+
+	// Count is the maximum number of targets, between 1 and MaxCount. When
+	// zero, as many targets as for a v2 request are returned.
+	Count int `json:"count,omitempty"`
+
+	// Exclude lists machines (e.g., "mlab1-lga0t.measurement-lab.org") or
+	// sites (e.g., "lga0t") never returned as targets.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// AddressFamily limits results to only machines with an address of this
+	// family (AddressFamilyIPv4 or AddressFamilyIPv6), when given.
+	AddressFamily string `json:"address_family,omitempty"`
+}
+
+// Validate checks the request fields, except for whether the service exists.
+func (r *NearestRequest) Validate() error {
+	if strings.Count(r.Service, "/") != 1 || strings.HasPrefix(r.Service, "/") || strings.HasSuffix(r.Service, "/") {
+		return errors.New("service must be of the form <experiment>/<datatype>")
+	}
+	if (r.Latitude == nil) != (r.Longitude == nil) {
+		return errors.New("latitude and longitude must be given together")
+	}
+	if r.Latitude != nil && (*r.Latitude < -90 || *r.Latitude > 90 || *r.Longitude < -180 || *r.Longitude > 180) {
+		return errors.New("latitude or longitude out of range")
+	}
+	if r.Count < 0 || r.Count > MaxCount {
+		return fmt.Errorf("count must be between 0 (the default) and %d", MaxCount)
+	}
+	if r.AddressFamily != "" && r.AddressFamily != AddressFamilyIPv4 && r.AddressFamily != AddressFamilyIPv6 {
+		return fmt.Errorf("address_family must be %q or %q", AddressFamilyIPv4, AddressFamilyIPv6)
+	}
+	return nil
+}
+
+// NearestResult is returned by the location service in response to a
+// NearestRequest.
+type NearestResult struct {
+	// Error contains information about request failures.
+	Error *v2.Error `json:"error,omitempty"`
+
+	// Results contains the targets matching the request. Targets are picked
+	// at random with a preference for nearer sites, so they are not ordered by
+	// distance; use DistanceKm and MetroRank to compare them.
+	Results []Target `json:"results,omitempty"`
+
+	// Selection describes how the results were selected.
+	Selection *Selection `json:"selection,omitempty"`
+
+	// Warnings contains human-readable advisories about the request or the
+	// results.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Target contains the information needed to run a measurement to a
+// measurement service on a single M-Lab machine.
+type Target struct {
+	// Machine is the FQDN of the machine hosting the measurement service.
+	Machine string `json:"machine"`
+
+	// Hostname is the FQDN of the measurement service targeted in URLs.
+	Hostname string `json:"hostname"`
+
+	// Location contains metadata about the location of the target machine.
+	Location *v2.Location `json:"location,omitempty"`
+
+	// IPv4 and IPv6 are the addresses of the target machine, if known.
+	IPv4 string `json:"ipv4,omitempty"`
+	IPv6 string `json:"ipv6,omitempty"`
+
+	// DistanceKm is the distance between the client and the target machine.
+	DistanceKm float64 `json:"distance_km"`
+
+	// MetroRank is the rank of the target metro by distance from the client,
+	// starting at 0 for the nearest metro.
+	MetroRank int `json:"metro_rank"`
+
+	// ServiceInfo describes the protocol revisions supported by the
+	// measurement service, if reported by the target machine.
+	ServiceInfo *v2.ServiceInfo `json:"service_info,omitempty"`
+
+	// URLs maps the measurement service resource names to their complete URLs.
+	URLs map[string]string `json:"urls"`
+}
+
+// Selection describes how the targets of a NearestResult were selected.
+type Selection struct {
+	// Latitude and Longitude are the client location used for the selection.
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+
+	// LocationMethod is "request" when the location was given in the
+	// request, or "detected" when found from the request origin.
+	LocationMethod string `json:"location_method"`
+
+	// Country is the client country used to prefer targets, if known.
+	Country string `json:"country,omitempty"`
+
+	// Candidates is the number of sites the targets were picked from.
+	Candidates int `json:"candidates"`
+}
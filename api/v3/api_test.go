@@ -0,0 +1,65 @@
+package v3
+
+import "testing"
+
+func TestNearestRequest_Validate(t *testing.T) {
+	lat, lon, far := 40.7, -74.0, 200.0
+	tests := []struct {
+		name    string
+		req     NearestRequest
+		wantErr bool
+	}{
+		{
+			name: "success",
+			req:  NearestRequest{Service: "ndt/ndt7"},
+		},
+		{
+			name: "success-all-fields",
+			req: NearestRequest{
+				Service:       "ndt/ndt7",
+				Latitude:      &lat,
+				Longitude:     &lon,
+				Count:         MaxCount,
+				Exclude:       []string{"lga0t"},
+				AddressFamily: AddressFamilyIPv6,
+			},
+		},
+		{
+			name:    "missing-service",
+			req:     NearestRequest{},
+			wantErr: true,
+		},
+		{
+			name:    "invalid-service",
+			req:     NearestRequest{Service: "ndt/ndt7/extra"},
+			wantErr: true,
+		},
+		{
+			name:    "latitude-only",
+			req:     NearestRequest{Service: "ndt/ndt7", Latitude: &lat},
+			wantErr: true,
+		},
+		{
+			name:    "longitude-out-of-range",
+			req:     NearestRequest{Service: "ndt/ndt7", Latitude: &lat, Longitude: &far},
+			wantErr: true,
+		},
+		{
+			name:    "count-too-large",
+			req:     NearestRequest{Service: "ndt/ndt7", Count: MaxCount + 1},
+			wantErr: true,
+		},
+		{
+			name:    "invalid-address-family",
+			req:     NearestRequest{Service: "ndt/ndt7", AddressFamily: "ipx"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("NearestRequest.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
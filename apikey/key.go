@@ -0,0 +1,58 @@
+// Package apikey verifies the API keys issued to integrations, e.g. for
+// requests to the priority path.
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Prefix is the prefix of API keys issued by M-Lab.
+const Prefix = "mlabk."
+
+var (
+	// ErrMalformedKey is returned for keys not of the form
+	// mlabk.<key id>.<secret>.
+	ErrMalformedKey = errors.New("malformed API key")
+	// ErrInvalidKey is returned for unknown, disabled or mismatched keys.
+	ErrInvalidKey = errors.New("invalid API key")
+)
+
+// IsKey reports whether key has the M-Lab API key prefix. Other keys are not
+// verified by this package.
+func IsKey(key string) bool {
+	return strings.HasPrefix(key, Prefix)
+}
+
+// Parse returns the key ID of an API key of the form mlabk.<key id>.<secret>.
+func Parse(key string) (string, error) {
+	if !IsKey(key) {
+		return "", ErrMalformedKey
+	}
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, Prefix), ".")
+	if !ok || id == "" || secret == "" || !validID(id) {
+		return "", ErrMalformedKey
+	}
+	return id, nil
+}
+
+// Hash returns the hex-encoded SHA-256 hash of the API key. Only hashes are
+// stored, so that keys cannot be recovered from the store.
+func Hash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// validID reports whether id contains only letters, digits, '-' and '_'.
+func validID(id string) bool {
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
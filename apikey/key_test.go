@@ -0,0 +1,65 @@
+package apikey
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "success",
+			key:  "mlabk.ki_abc-123.secret",
+			want: "ki_abc-123",
+		},
+		{
+			name: "success-secret-with-dots",
+			key:  "mlabk.abc.se.cret",
+			want: "abc",
+		},
+		{
+			name:    "no-prefix",
+			key:     "AIzaSyExample",
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "no-secret",
+			key:     "mlabk.abc",
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "empty-id",
+			key:     "mlabk..secret",
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:    "invalid-id",
+			key:     "mlabk.a:b.secret",
+			wantErr: ErrMalformedKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	// echo -n mlabk.abc.secret | sha256sum
+	want := "d02dfeea77496ad37f1e212851071e13213f889716c5d03545efe1c8ac31c97e"
+	if got := Hash("mlabk.abc.secret"); got != want {
+		t.Errorf("Hash() = %q, want %q", got, want)
+	}
+}
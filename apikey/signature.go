@@ -0,0 +1,162 @@
+package apikey
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	log "github.com/sirupsen/logrus"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/metrics"
+)
+
+var (
+	// ErrStaleSignature is returned for signatures with a timestamp outside
+	// of the allowed clock skew.
+	ErrStaleSignature = errors.New("stale request signature")
+	// ErrReplayedSignature is returned for signatures that were already used.
+	ErrReplayedSignature = errors.New("replayed request signature")
+)
+
+// Sign returns the signature of a request for path and query at time t, made
+// with the given API key. Integrations may send the signature, timestamp and
+// key ID of a request instead of the key itself, so that the key does not
+// appear in URLs or logs.
+//
+// The signature is the hex-encoded HMAC-SHA256 of
+// "<unix timestamp>\n<path>\n<query>", keyed by the SHA-256 hash of the API
+// key (see Hash). The query is canonicalized by CanonicalQuery, so every query
+// parameter except the signature itself is covered.
+func Sign(key string, t time.Time, path string, query url.Values) string {
+	return sign(Hash(key), strconv.FormatInt(t.Unix(), 10), path, CanonicalQuery(query))
+}
+
+// CanonicalQuery returns the query parameters without "signature", encoded
+// in order of their names.
+func CanonicalQuery(query url.Values) string {
+	q := make(url.Values, len(query))
+	for k, v := range query {
+		if k != "signature" {
+			q[k] = v
+		}
+	}
+	return q.Encode()
+}
+
+func sign(hash, timestamp, path, query string) string {
+	mac := hmac.New(sha256.New, []byte(hash))
+	mac.Write([]byte(timestamp + "\n" + path + "\n" + query))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureVerifier verifies signed requests against a Store. Since the key
+// hashes are the signing keys, the Store must be as protected as the keys.
+//
+// Each signature is only accepted once within the allowed clock skew, which is
+// enforced with keys in Redis.
+type SignatureVerifier struct {
+	store     Store
+	pool      *redis.Pool
+	keyPrefix string
+	skew      time.Duration
+	now       func() time.Time
+}
+
+// NewSignatureVerifier returns a new SignatureVerifier using the given Store,
+// accepting timestamps within skew of the current time. Used signatures are
+// kept in the given Redis pool, prefixed by keyPrefix.
+func NewSignatureVerifier(store Store, pool *redis.Pool, keyPrefix string, skew time.Duration) *SignatureVerifier {
+	return &SignatureVerifier{
+		store:     store,
+		pool:      pool,
+		keyPrefix: keyPrefix,
+		skew:      skew,
+		now:       time.Now,
+	}
+}
+
+// Verify returns the Integration owning the key ID if signature is valid for
+// path, query and timestamp (in Unix seconds) and was not used before. It returns
+// ErrMalformedKey, ErrInvalidKey, ErrStaleSignature or ErrReplayedSignature if
+// the request must be rejected, and any other error if the signature could
+// not be verified.
+func (sv *SignatureVerifier) Verify(keyID, timestamp, path string, query url.Values, signature string) (*Integration, error) {
+	if keyID == "" || !validID(keyID) {
+		return nil, ErrMalformedKey
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrMalformedKey
+	}
+	if d := sv.now().Sub(time.Unix(ts, 0)); d > sv.skew || d < -sv.skew {
+		return nil, ErrStaleSignature
+	}
+
+	rec, err := sv.store.Get(keyID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return nil, ErrInvalidKey
+	case err != nil:
+		return nil, err
+	case rec.Disabled || !hmac.Equal([]byte(sign(rec.Hash, timestamp, path, CanonicalQuery(query))), []byte(signature)):
+		return nil, ErrInvalidKey
+	}
+
+	// Signatures expire once their timestamp is outside the allowed skew.
+	conn := sv.pool.Get()
+	defer conn.Close()
+	reply, err := conn.Do("SET", sv.keyPrefix+"signature:"+signature, 1, "NX", "PX", (2 * sv.skew).Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrReplayedSignature
+	}
+	return &Integration{ID: rec.Integration, KeyID: rec.KeyID}, nil
+}
+
+// Limit is a middleware that verifies requests with a "signature" parameter
+// and no "key" parameter, using the "key_id" and "timestamp" parameters.
+// Requests with an invalid signature are rejected, and requests with a valid
+// signature are annotated with the Integration, which can be retrieved with
+// FromContext. Other requests are passed through unchanged.
+func (sv *SignatureVerifier) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		signature := req.FormValue("signature")
+		if signature == "" || req.FormValue("key") != "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		integration, err := sv.Verify(req.FormValue("key_id"), req.FormValue("timestamp"), req.URL.Path, req.URL.Query(), signature)
+		switch {
+		case errors.Is(err, ErrReplayedSignature):
+			metrics.APIKeyVerificationsTotal.WithLabelValues("replayed").Inc()
+			writeError(rw, v2.ErrorTypeUnauthorized, http.StatusUnauthorized, replayedSignature)
+			return
+		case errors.Is(err, ErrMalformedKey), errors.Is(err, ErrInvalidKey), errors.Is(err, ErrStaleSignature):
+			metrics.APIKeyVerificationsTotal.WithLabelValues("invalid").Inc()
+			writeError(rw, v2.ErrorTypeUnauthorized, http.StatusUnauthorized, invalidSignature)
+			return
+		case err != nil:
+			log.Errorf("Failed to verify request signature: %v", err)
+			metrics.APIKeyVerificationsTotal.WithLabelValues("error").Inc()
+			writeError(rw, v2.ErrorTypeUnavailable, http.StatusServiceUnavailable, "Failed to verify request signature")
+			return
+		}
+		metrics.APIKeyVerificationsTotal.WithLabelValues("valid").Inc()
+		metrics.IntegrationRequestsTotal.WithLabelValues(integration.ID).Inc()
+		next.ServeHTTP(rw, req.WithContext(NewContext(req.Context(), integration)))
+	})
+}
+
+const (
+	invalidSignature  = "Invalid or expired request signature. Please contact support@measurementlab.net."
+	replayedSignature = "Request signature already used. Please sign each request."
+)
@@ -0,0 +1,224 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestSignatureVerifier_Verify(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	path := "/v2/priority/nearest/ndt/ndt7"
+	query := url.Values{"key_id": {"ki_1"}, "client_name": {"ndt7-js"}}
+	valid := Sign("mlabk.ki_1.secret", now, path, query)
+	tests := []struct {
+		name      string
+		keyID     string
+		timestamp time.Time
+		signature string
+		seen      bool
+		redisErr  error
+		want      *Integration
+		wantErr   error
+	}{
+		{
+			name:      "valid",
+			keyID:     "ki_1",
+			timestamp: now,
+			signature: valid,
+			want:      &Integration{ID: "partner", KeyID: "ki_1"},
+		},
+		{
+			name:      "replayed",
+			keyID:     "ki_1",
+			timestamp: now,
+			signature: valid,
+			seen:      true,
+			wantErr:   ErrReplayedSignature,
+		},
+		{
+			name:      "stale",
+			keyID:     "ki_1",
+			timestamp: now.Add(-10 * time.Minute),
+			signature: Sign("mlabk.ki_1.secret", now.Add(-10*time.Minute), path, query),
+			wantErr:   ErrStaleSignature,
+		},
+		{
+			name:      "wrong-key",
+			keyID:     "ki_1",
+			timestamp: now,
+			signature: Sign("mlabk.ki_1.wrong", now, path, query),
+			wantErr:   ErrInvalidKey,
+		},
+		{
+			name:      "wrong-path",
+			keyID:     "ki_1",
+			timestamp: now,
+			signature: Sign("mlabk.ki_1.secret", now, "/v2/nearest/ndt/ndt7", query),
+			wantErr:   ErrInvalidKey,
+		},
+		{
+			name:      "wrong-query",
+			keyID:     "ki_1",
+			timestamp: now,
+			signature: Sign("mlabk.ki_1.secret", now, path, url.Values{"key_id": {"ki_1"}, "client_name": {"other"}}),
+			wantErr:   ErrInvalidKey,
+		},
+		{
+			name:      "disabled",
+			keyID:     "ki_2",
+			timestamp: now,
+			signature: Sign("mlabk.ki_2.secret", now, path, query),
+			wantErr:   ErrInvalidKey,
+		},
+		{
+			name:      "unknown",
+			keyID:     "ki_3",
+			timestamp: now,
+			signature: valid,
+			wantErr:   ErrInvalidKey,
+		},
+		{
+			name:      "malformed-key-id",
+			keyID:     "ki:1",
+			timestamp: now,
+			signature: valid,
+			wantErr:   ErrMalformedKey,
+		},
+		{
+			name:      "redis-error",
+			keyID:     "ki_1",
+			timestamp: now,
+			signature: valid,
+			redisErr:  errors.New("fake redis error"),
+			wantErr:   errors.New("fake redis error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := redigomock.NewConn()
+			pool := &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			cmd := conn.Command("SET", "signature:"+tt.signature, 1, "NX", "PX", int64(600000))
+			switch {
+			case tt.redisErr != nil:
+				cmd.ExpectError(tt.redisErr)
+			case tt.seen:
+				cmd.Expect(nil)
+			default:
+				cmd.Expect("OK")
+			}
+
+			sv := NewSignatureVerifier(newFakeStore(), pool, "", 5*time.Minute)
+			sv.now = func() time.Time { return now }
+			got, err := sv.Verify(tt.keyID, strconv.FormatInt(tt.timestamp.Unix(), 10), path, query, tt.signature)
+			if (err != nil) != (tt.wantErr != nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Fatalf("SignatureVerifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("SignatureVerifier.Verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// signed returns the params with the signature of the request.
+func signed(key string, t time.Time, path string, params url.Values) url.Values {
+	params.Set("signature", Sign(key, t, path, params))
+	return params
+}
+
+func TestSignatureVerifier_Limit(t *testing.T) {
+	now := time.Now()
+	path := "/v2/priority/nearest/ndt/ndt7"
+	tests := []struct {
+		name            string
+		params          url.Values
+		wantStatus      int
+		wantIntegration string
+	}{
+		{
+			name: "valid-signature",
+			params: signed("mlabk.ki_1.secret", now, path, url.Values{
+				"key_id":    {"ki_1"},
+				"timestamp": {strconv.FormatInt(now.Unix(), 10)},
+				"lat":       {"40.7"},
+				"lon":       {"-74.0"},
+			}),
+			wantStatus:      http.StatusOK,
+			wantIntegration: "partner",
+		},
+		{
+			name: "invalid-signature",
+			params: signed("mlabk.ki_1.wrong", now, path, url.Values{
+				"key_id":    {"ki_1"},
+				"timestamp": {strconv.FormatInt(now.Unix(), 10)},
+			}),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "modified-query",
+			params: func() url.Values {
+				params := signed("mlabk.ki_1.secret", now, path, url.Values{
+					"key_id":    {"ki_1"},
+					"timestamp": {strconv.FormatInt(now.Unix(), 10)},
+					"lat":       {"40.7"},
+				})
+				params.Set("lat", "-33.9")
+				return params
+			}(),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "no-signature",
+			params:     url.Values{},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "key-and-signature",
+			params: url.Values{
+				"key":       {"mlabk.ki_1.secret"},
+				"signature": {"ignored"},
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := redigomock.NewConn()
+			pool := &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			conn.GenericCommand("SET").Expect("OK")
+			sv := NewSignatureVerifier(newFakeStore(), pool, "", 5*time.Minute)
+			gotIntegration := ""
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if integration, ok := FromContext(req.Context()); ok {
+					gotIntegration = integration.ID
+				}
+			})
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path+"?"+tt.params.Encode(), nil)
+			sv.Limit(next).ServeHTTP(rw, req)
+			if rw.Code != tt.wantStatus {
+				t.Errorf("Limit() status = %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if gotIntegration != tt.wantIntegration {
+				t.Errorf("Limit() integration = %q, want %q", gotIntegration, tt.wantIntegration)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package apikey
+
+import (
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNotFound is returned by a Store for unknown key IDs.
+var ErrNotFound = errors.New("API key not found")
+
+// Record is the stored state of an API key.
+type Record struct {
+	KeyID       string
+	Integration string
+	Hash        string // Hex-encoded SHA-256 hash of the full key.
+	Disabled    bool
+}
+
+// Store defines the interface for looking up API keys by key ID.
+type Store interface {
+	Get(keyID string) (*Record, error)
+}
+
+// RedisStore is a Store backed by Redis hashes, e.g.:
+//
+//	HSET apikey:<key id> integration <name> hash <sha256 hex> status enabled
+type RedisStore struct {
+	pool      *redis.Pool
+	keyPrefix string
+}
+
+// NewRedisStore returns a new RedisStore using the given Redis pool. Redis
+// keys are prefixed by keyPrefix.
+func NewRedisStore(pool *redis.Pool, keyPrefix string) *RedisStore {
+	return &RedisStore{
+		pool:      pool,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Get returns the record of the key ID, or ErrNotFound.
+func (rs *RedisStore) Get(keyID string) (*Record, error) {
+	conn := rs.pool.Get()
+	defer conn.Close()
+
+	fields, err := redis.StringMap(conn.Do("HGETALL", rs.keyPrefix+"apikey:"+keyID))
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrNotFound
+	}
+	return &Record{
+		KeyID:       keyID,
+		Integration: fields["integration"],
+		Hash:        fields["hash"],
+		Disabled:    fields["status"] == "disabled",
+	}, nil
+}
@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestRedisStore_Get(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   interface{}
+		err     error
+		want    *Record
+		wantErr error
+	}{
+		{
+			name: "enabled",
+			reply: []interface{}{
+				[]byte("integration"), []byte("partner"),
+				[]byte("hash"), []byte("abcd"),
+				[]byte("status"), []byte("enabled"),
+			},
+			want: &Record{KeyID: "ki_1", Integration: "partner", Hash: "abcd"},
+		},
+		{
+			name: "disabled",
+			reply: []interface{}{
+				[]byte("integration"), []byte("partner"),
+				[]byte("hash"), []byte("abcd"),
+				[]byte("status"), []byte("disabled"),
+			},
+			want: &Record{KeyID: "ki_1", Integration: "partner", Hash: "abcd", Disabled: true},
+		},
+		{
+			name:    "not-found",
+			reply:   []interface{}{},
+			wantErr: ErrNotFound,
+		},
+		{
+			name:    "redis-error",
+			err:     errors.New("fake redis error"),
+			wantErr: errors.New("fake redis error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := redigomock.NewConn()
+			pool := &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			cmd := conn.Command("HGETALL", "test:apikey:ki_1")
+			if tt.err != nil {
+				cmd.ExpectError(tt.err)
+			} else {
+				cmd.Expect(tt.reply)
+			}
+
+			rs := NewRedisStore(pool, "test:")
+			got, err := rs.Get("ki_1")
+			if (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("RedisStore.Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(tt.wantErr, ErrNotFound) && !errors.Is(err, ErrNotFound) {
+				t.Errorf("RedisStore.Get() error = %v, want %v", err, ErrNotFound)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RedisStore.Get() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
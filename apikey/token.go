@@ -0,0 +1,71 @@
+package apikey
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/m-lab/locate/metrics"
+	"github.com/m-lab/locate/static"
+)
+
+// TokenVerifier defines the interface for verifying access tokens.
+type TokenVerifier interface {
+	Verify(token string, exp jwt.Expected) (*jwt.Claims, error)
+}
+
+// Subject returns the access token subject identifying the Integration.
+func (i *Integration) Subject() string {
+	return i.ID + "/" + i.KeyID
+}
+
+// AccessTokens verifies the access tokens issued to integrations for the
+// High Availability Pool, i.e. with the locate issuer and audience.
+type AccessTokens struct {
+	verifier TokenVerifier
+	now      func() time.Time
+}
+
+// NewAccessTokens returns a new AccessTokens using the given verifier.
+func NewAccessTokens(verifier TokenVerifier) *AccessTokens {
+	return &AccessTokens{
+		verifier: verifier,
+		now:      time.Now,
+	}
+}
+
+// Limit is a middleware that annotates requests with a valid
+// "access_token" parameter with the Integration named by the token subject,
+// which can be retrieved with FromContext. Requests with an expired or
+// invalid token are handled as if no token were provided.
+func (at *AccessTokens) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		token := req.FormValue("access_token")
+		if token == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		cl, err := at.verifier.Verify(token, jwt.Expected{
+			Issuer:   static.IssuerLocate,
+			Audience: jwt.Audience{static.AudienceLocate},
+			Time:     at.now(),
+		})
+		if err != nil {
+			metrics.APIKeyVerificationsTotal.WithLabelValues("invalid token").Inc()
+			next.ServeHTTP(rw, req)
+			return
+		}
+		id, keyID, ok := strings.Cut(cl.Subject, "/")
+		if !ok || id == "" || !validID(keyID) {
+			metrics.APIKeyVerificationsTotal.WithLabelValues("invalid token").Inc()
+			next.ServeHTTP(rw, req)
+			return
+		}
+		integration := &Integration{ID: id, KeyID: keyID}
+		metrics.APIKeyVerificationsTotal.WithLabelValues("valid token").Inc()
+		metrics.IntegrationRequestsTotal.WithLabelValues(integration.ID).Inc()
+		next.ServeHTTP(rw, req.WithContext(NewContext(req.Context(), integration)))
+	})
+}
@@ -0,0 +1,86 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	"github.com/m-lab/locate/static"
+)
+
+type fakeTokenVerifier struct {
+	claims *jwt.Claims
+	err    error
+	exp    jwt.Expected
+}
+
+func (f *fakeTokenVerifier) Verify(token string, exp jwt.Expected) (*jwt.Claims, error) {
+	f.exp = exp
+	return f.claims, f.err
+}
+
+func TestAccessTokens_Limit(t *testing.T) {
+	tests := []struct {
+		name            string
+		target          string
+		claims          *jwt.Claims
+		err             error
+		wantIntegration string
+	}{
+		{
+			name:            "valid-token",
+			target:          "/v2/priority/nearest/ndt/ndt7?access_token=token",
+			claims:          &jwt.Claims{Subject: "partner/ki_1"},
+			wantIntegration: "partner",
+		},
+		{
+			name:   "no-token",
+			target: "/v2/priority/nearest/ndt/ndt7",
+		},
+		{
+			name:   "expired-token",
+			target: "/v2/priority/nearest/ndt/ndt7?access_token=token",
+			claims: &jwt.Claims{Subject: "partner/ki_1"},
+			err:    jwt.ErrExpired,
+		},
+		{
+			name:   "invalid-token",
+			target: "/v2/priority/nearest/ndt/ndt7?access_token=token",
+			err:    errors.New("fake verify error"),
+		},
+		{
+			name:   "invalid-subject",
+			target: "/v2/priority/nearest/ndt/ndt7?access_token=token",
+			claims: &jwt.Claims{Subject: "mlab1-lga0t.mlab-sandbox.measurement-lab.org"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &fakeTokenVerifier{claims: tt.claims, err: tt.err}
+			at := NewAccessTokens(v)
+			gotIntegration := ""
+			called := false
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				called = true
+				if integration, ok := FromContext(req.Context()); ok {
+					gotIntegration = integration.ID
+				}
+			})
+
+			rw := httptest.NewRecorder()
+			at.Limit(next).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if !called {
+				t.Fatal("Limit() did not call the next handler")
+			}
+			if gotIntegration != tt.wantIntegration {
+				t.Errorf("Limit() integration = %q, want %q", gotIntegration, tt.wantIntegration)
+			}
+			if tt.claims != nil && (v.exp.Issuer != static.IssuerLocate || !v.exp.Audience.Contains(static.AudienceLocate)) {
+				t.Errorf("Limit() expected claims = %+v, want locate issuer and audience", v.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package apikey
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/locate/metrics"
+)
+
+// usageRetention is how long daily usage counters are kept in Redis.
+const usageRetention = 90 * 24 * time.Hour
+
+// Usage counts the requests of each integration and API key. Counts are kept
+// in memory and added to daily counters in Redis by Flush, so that the
+// counters of every Locate instance are aggregated.
+type Usage struct {
+	pool      *redis.Pool
+	keyPrefix string
+	now       func() time.Time
+
+	mu     sync.Mutex
+	counts map[Integration]int64
+}
+
+// UsageReport reports the requests of each integration on a given day.
+type UsageReport struct {
+	Date         string                      `json:"date"`
+	Integrations map[string]IntegrationUsage `json:"integrations"`
+}
+
+// IntegrationUsage reports the requests of an integration, in total and by
+// key ID.
+type IntegrationUsage struct {
+	Requests int64            `json:"requests"`
+	Keys     map[string]int64 `json:"keys"`
+}
+
+// NewUsage returns a new Usage using the given Redis pool. Redis keys are
+// prefixed by keyPrefix.
+func NewUsage(pool *redis.Pool, keyPrefix string) *Usage {
+	return &Usage{
+		pool:      pool,
+		keyPrefix: keyPrefix,
+		now:       time.Now,
+		counts:    make(map[Integration]int64),
+	}
+}
+
+// Record counts one request of the integration.
+func (u *Usage) Record(integration *Integration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.counts[*integration]++
+}
+
+// Count is a middleware that records the requests annotated with an
+// Integration by Verifier.Limit.
+func (u *Usage) Count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if integration, ok := FromContext(req.Context()); ok {
+			u.Record(integration)
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// Flush adds the counts recorded since the last flush to the counters of the
+// current day in Redis. On error, the counts are kept for the next flush.
+func (u *Usage) Flush() error {
+	u.mu.Lock()
+	counts := u.counts
+	u.counts = make(map[Integration]int64)
+	u.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	conn := u.pool.Get()
+	defer conn.Close()
+
+	key := u.dayKey(u.now())
+	for integration, n := range counts {
+		field := integration.ID + "/" + integration.KeyID
+		if _, err := conn.Do("HINCRBY", key, field, n); err != nil {
+			u.restore(counts)
+			metrics.APIKeyUsageFlushesTotal.WithLabelValues("error").Inc()
+			return err
+		}
+		delete(counts, integration)
+	}
+	if _, err := conn.Do("EXPIRE", key, int(usageRetention.Seconds())); err != nil {
+		metrics.APIKeyUsageFlushesTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	metrics.APIKeyUsageFlushesTotal.WithLabelValues("OK").Inc()
+	return nil
+}
+
+// Report returns the usage counters flushed for the day of t.
+func (u *Usage) Report(t time.Time) (*UsageReport, error) {
+	conn := u.pool.Get()
+	defer conn.Close()
+
+	fields, err := redis.StringMap(conn.Do("HGETALL", u.dayKey(t)))
+	if err != nil {
+		return nil, err
+	}
+	report := &UsageReport{
+		Date:         t.UTC().Format("2006-01-02"),
+		Integrations: make(map[string]IntegrationUsage),
+	}
+	for field, value := range fields {
+		id, keyID, ok := strings.Cut(field, "/")
+		if !ok {
+			return nil, fmt.Errorf("malformed usage field %q", field)
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		iu, ok := report.Integrations[id]
+		if !ok {
+			iu = IntegrationUsage{Keys: make(map[string]int64)}
+		}
+		iu.Requests += n
+		iu.Keys[keyID] += n
+		report.Integrations[id] = iu
+	}
+	return report, nil
+}
+
+// restore adds counts that could not be flushed back to the current counts.
+func (u *Usage) restore(counts map[Integration]int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for integration, n := range counts {
+		u.counts[integration] += n
+	}
+}
+
+func (u *Usage) dayKey(t time.Time) string {
+	return u.keyPrefix + "usage:" + t.UTC().Format("20060102")
+}
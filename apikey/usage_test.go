@@ -0,0 +1,99 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestUsage_Flush(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		err        error
+		wantErr    bool
+		wantCounts map[Integration]int64
+	}{
+		{
+			name:       "success",
+			wantCounts: map[Integration]int64{},
+		},
+		{
+			name:       "redis-error",
+			err:        errors.New("fake redis error"),
+			wantErr:    true,
+			wantCounts: map[Integration]int64{{ID: "partner", KeyID: "ki_1"}: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := redigomock.NewConn()
+			pool := &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			cmd := conn.Command("HINCRBY", "test:usage:20240301", "partner/ki_1", int64(2))
+			if tt.err != nil {
+				cmd.ExpectError(tt.err)
+			} else {
+				cmd.Expect(int64(2))
+			}
+			conn.Command("EXPIRE", "test:usage:20240301", int(usageRetention.Seconds())).Expect(int64(1))
+
+			u := NewUsage(pool, "test:")
+			u.now = func() time.Time { return now }
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/v2/priority/nearest/ndt/ndt7", nil)
+				req = req.WithContext(NewContext(req.Context(), &Integration{ID: "partner", KeyID: "ki_1"}))
+				u.Count(next).ServeHTTP(httptest.NewRecorder(), req)
+			}
+			// Requests without an Integration are not counted.
+			u.Count(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if err := u.Flush(); (err != nil) != tt.wantErr {
+				t.Fatalf("Usage.Flush() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(u.counts, tt.wantCounts) {
+				t.Errorf("Usage.Flush() counts = %v, want %v", u.counts, tt.wantCounts)
+			}
+		})
+	}
+}
+
+func TestUsage_Report(t *testing.T) {
+	conn := redigomock.NewConn()
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return conn, nil
+		},
+	}
+	conn.Command("HGETALL", "usage:20240301").Expect([]interface{}{
+		[]byte("partner/ki_1"), []byte("3"),
+		[]byte("partner/ki_2"), []byte("4"),
+		[]byte("other/ki_3"), []byte("1"),
+	})
+
+	u := NewUsage(pool, "")
+	got, err := u.Report(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Usage.Report() error = %v", err)
+	}
+	want := &UsageReport{
+		Date: "2024-03-01",
+		Integrations: map[string]IntegrationUsage{
+			"partner": {Requests: 7, Keys: map[string]int64{"ki_1": 3, "ki_2": 4}},
+			"other":   {Requests: 1, Keys: map[string]int64{"ki_3": 1}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Usage.Report() = %+v, want %+v", got, want)
+	}
+}
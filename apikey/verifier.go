@@ -0,0 +1,188 @@
+package apikey
+
+import (
+	"container/list"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/metrics"
+)
+
+// Integration identifies the owner of a verified API key.
+type Integration struct {
+	ID    string
+	KeyID string
+}
+
+// Verifier verifies API keys against a Store. Results are kept in an LRU
+// cache: verified keys for ttl, and unknown, disabled or mismatched keys for
+// negativeTTL, so repeated invalid keys do not reach the Store either.
+type Verifier struct {
+	store       Store
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used first.
+}
+
+type cacheEntry struct {
+	hash        string
+	integration *Integration // Nil for invalid keys.
+	expires     time.Time
+}
+
+// NewVerifier returns a new Verifier using the given Store, with at most size
+// cached results.
+func NewVerifier(store Store, size int, ttl, negativeTTL time.Duration) *Verifier {
+	return &Verifier{
+		store:       store,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+// Verify returns the Integration owning the API key. It returns
+// ErrMalformedKey or ErrInvalidKey if the key must be rejected, and the Store
+// error if the key could not be verified.
+func (v *Verifier) Verify(key string) (*Integration, error) {
+	hash := Hash(key)
+	if e, ok := v.get(hash); ok {
+		metrics.APIKeyCacheTotal.WithLabelValues("hit").Inc()
+		if e.integration == nil {
+			return nil, ErrInvalidKey
+		}
+		return e.integration, nil
+	}
+	metrics.APIKeyCacheTotal.WithLabelValues("miss").Inc()
+
+	id, err := Parse(key)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := v.store.Get(id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		v.put(hash, nil)
+		return nil, ErrInvalidKey
+	case err != nil:
+		return nil, err
+	case rec.Disabled || subtle.ConstantTimeCompare([]byte(rec.Hash), []byte(hash)) != 1:
+		v.put(hash, nil)
+		return nil, ErrInvalidKey
+	}
+	integration := &Integration{ID: rec.Integration, KeyID: rec.KeyID}
+	v.put(hash, integration)
+	return integration, nil
+}
+
+// Limit is a middleware that rejects requests with an invalid M-Lab API key
+// in the "key" parameter, and annotates requests with a valid key with the
+// Integration, which can be retrieved with FromContext. Requests without a
+// key, or with keys not issued by M-Lab, are passed through unchanged.
+func (v *Verifier) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		key := req.FormValue("key")
+		if !IsKey(key) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		integration, err := v.Verify(key)
+		switch {
+		case errors.Is(err, ErrMalformedKey), errors.Is(err, ErrInvalidKey):
+			metrics.APIKeyVerificationsTotal.WithLabelValues("invalid").Inc()
+			writeError(rw, v2.ErrorTypeUnauthorized, http.StatusUnauthorized, invalidKey)
+			return
+		case err != nil:
+			log.Errorf("Failed to verify API key: %v", err)
+			metrics.APIKeyVerificationsTotal.WithLabelValues("error").Inc()
+			writeError(rw, v2.ErrorTypeUnavailable, http.StatusServiceUnavailable, "Failed to verify API key")
+			return
+		}
+		metrics.APIKeyVerificationsTotal.WithLabelValues("valid").Inc()
+		metrics.IntegrationRequestsTotal.WithLabelValues(integration.ID).Inc()
+		next.ServeHTTP(rw, req.WithContext(NewContext(req.Context(), integration)))
+	})
+}
+
+const invalidKey = "Invalid API key. Please contact support@measurementlab.net."
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the Integration.
+func NewContext(ctx context.Context, integration *Integration) context.Context {
+	return context.WithValue(ctx, contextKey{}, integration)
+}
+
+// FromContext returns the Integration of a request verified by Limit.
+func FromContext(ctx context.Context) (*Integration, bool) {
+	integration, ok := ctx.Value(contextKey{}).(*Integration)
+	return integration, ok
+}
+
+func (v *Verifier) get(hash string) (cacheEntry, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	elem, ok := v.entries[hash]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	e := elem.Value.(cacheEntry)
+	if !v.now().Before(e.expires) {
+		v.lru.Remove(elem)
+		delete(v.entries, hash)
+		return cacheEntry{}, false
+	}
+	v.lru.MoveToFront(elem)
+	return e, true
+}
+
+func (v *Verifier) put(hash string, integration *Integration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ttl := v.ttl
+	if integration == nil {
+		ttl = v.negativeTTL
+	}
+	if ttl <= 0 || v.size <= 0 {
+		return
+	}
+	e := cacheEntry{hash: hash, integration: integration, expires: v.now().Add(ttl)}
+	if elem, ok := v.entries[hash]; ok {
+		elem.Value = e
+		v.lru.MoveToFront(elem)
+		return
+	}
+	if v.lru.Len() >= v.size {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.entries, oldest.Value.(cacheEntry).hash)
+	}
+	v.entries[hash] = v.lru.PushFront(e)
+}
+
+// writeError writes a JSON error response.
+func writeError(rw http.ResponseWriter, typ string, status int, msg string) {
+	result := v2.NearestResult{
+		Error: v2.NewError(typ, msg, status),
+	}
+	b, _ := json.Marshal(result)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(b)
+}
@@ -0,0 +1,188 @@
+package apikey
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	records map[string]*Record
+	err     error
+	calls   int
+}
+
+func (fs *fakeStore) Get(keyID string) (*Record, error) {
+	fs.calls++
+	if fs.err != nil {
+		return nil, fs.err
+	}
+	rec, ok := fs.records[keyID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rec, nil
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		records: map[string]*Record{
+			"ki_1": {KeyID: "ki_1", Integration: "partner", Hash: Hash("mlabk.ki_1.secret")},
+			"ki_2": {KeyID: "ki_2", Integration: "partner", Hash: Hash("mlabk.ki_2.secret"), Disabled: true},
+		},
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		storeErr error
+		want     *Integration
+		wantErr  error
+	}{
+		{
+			name: "valid",
+			key:  "mlabk.ki_1.secret",
+			want: &Integration{ID: "partner", KeyID: "ki_1"},
+		},
+		{
+			name:    "wrong-secret",
+			key:     "mlabk.ki_1.wrong",
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "disabled",
+			key:     "mlabk.ki_2.secret",
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "unknown",
+			key:     "mlabk.ki_3.secret",
+			wantErr: ErrInvalidKey,
+		},
+		{
+			name:    "malformed",
+			key:     "mlabk.ki_1",
+			wantErr: ErrMalformedKey,
+		},
+		{
+			name:     "store-error",
+			key:      "mlabk.ki_1.secret",
+			storeErr: errors.New("fake store error"),
+			wantErr:  errors.New("fake store error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newFakeStore()
+			fs.err = tt.storeErr
+			v := NewVerifier(fs, 10, time.Minute, time.Minute)
+
+			got, err := v.Verify(tt.key)
+			if (err != nil) != (tt.wantErr != nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Fatalf("Verifier.Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("Verifier.Verify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifier_Cache(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	fs := newFakeStore()
+	v := NewVerifier(fs, 2, time.Minute, 10*time.Second)
+	v.now = func() time.Time { return now }
+
+	// Valid and invalid results are both cached.
+	for i := 0; i < 3; i++ {
+		v.Verify("mlabk.ki_1.secret")
+		v.Verify("mlabk.ki_3.secret")
+	}
+	if fs.calls != 2 {
+		t.Errorf("Store.Get() calls = %d, want 2", fs.calls)
+	}
+
+	// Invalid results expire sooner.
+	now = now.Add(30 * time.Second)
+	v.Verify("mlabk.ki_1.secret")
+	v.Verify("mlabk.ki_3.secret")
+	if fs.calls != 3 {
+		t.Errorf("Store.Get() calls = %d, want 3", fs.calls)
+	}
+
+	// Adding a third key evicts the least recently used one (ki_1).
+	v.Verify("mlabk.ki_2.secret")
+	v.Verify("mlabk.ki_3.secret")
+	if fs.calls != 4 {
+		t.Errorf("Store.Get() calls = %d, want 4", fs.calls)
+	}
+	v.Verify("mlabk.ki_1.secret")
+	if fs.calls != 5 {
+		t.Errorf("Store.Get() calls = %d, want 5", fs.calls)
+	}
+}
+
+func TestVerifier_Limit(t *testing.T) {
+	tests := []struct {
+		name            string
+		target          string
+		storeErr        error
+		wantStatus      int
+		wantIntegration string
+	}{
+		{
+			name:            "valid-key",
+			target:          "/v2/priority/nearest/ndt/ndt7?key=mlabk.ki_1.secret",
+			wantStatus:      http.StatusOK,
+			wantIntegration: "partner",
+		},
+		{
+			name:       "no-key",
+			target:     "/v2/priority/nearest/ndt/ndt7",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "other-key",
+			target:     "/v2/priority/nearest/ndt/ndt7?key=AIzaSyExample",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid-key",
+			target:     "/v2/priority/nearest/ndt/ndt7?key=mlabk.ki_1.wrong",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "store-error",
+			target:     "/v2/priority/nearest/ndt/ndt7?key=mlabk.ki_1.secret",
+			storeErr:   errors.New("fake store error"),
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newFakeStore()
+			fs.err = tt.storeErr
+			v := NewVerifier(fs, 10, time.Minute, time.Minute)
+			gotIntegration := ""
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if integration, ok := FromContext(req.Context()); ok {
+					gotIntegration = integration.ID
+				}
+			})
+
+			rw := httptest.NewRecorder()
+			v.Limit(next).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rw.Code != tt.wantStatus {
+				t.Errorf("Limit() status = %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if gotIntegration != tt.wantIntegration {
+				t.Errorf("Limit() integration = %q, want %q", gotIntegration, tt.wantIntegration)
+			}
+		})
+	}
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/apex/log"
 	"github.com/m-lab/locate/metrics"
-	"github.com/m-lab/locate/static"
 )
 
 var (
@@ -27,19 +26,28 @@ var (
 
 // NewAppEngineLocator creates a new AppEngineLocator.
 func NewAppEngineLocator() *AppEngineLocator {
-	return &AppEngineLocator{}
+	return NewAppEngineLocatorWithPrecision(-1)
+}
+
+// NewAppEngineLocatorWithPrecision creates a new AppEngineLocator that rounds
+// the client coordinates it logs to the given number of decimal digits. A
+// negative digits value disables rounding.
+func NewAppEngineLocatorWithPrecision(digits int) *AppEngineLocator {
+	return &AppEngineLocator{logDigits: digits}
 }
 
 // AppEngineLocator finds a client location using AppEngine headers for lat/lon,
 // region, or country.
-type AppEngineLocator struct{}
+type AppEngineLocator struct {
+	logDigits int
+}
 
 // Locate finds a location for the given client request using AppEngine headers.
 // If no location is found, an error is returned.
 func (sl *AppEngineLocator) Locate(req *http.Request) (*Location, error) {
 	headers := req.Header
 	fields := log.Fields{
-		"CityLatLong": headers.Get("X-AppEngine-CityLatLong"),
+		"CityLatLong": roundLatLon(headers.Get("X-AppEngine-CityLatLong"), sl.logDigits),
 		"Country":     headers.Get("X-AppEngine-Country"),
 		"Region":      headers.Get("X-AppEngine-Region"),
 		"Proto":       headers.Get("X-Forwarded-Proto"),
@@ -59,7 +67,7 @@ func (sl *AppEngineLocator) Locate(req *http.Request) (*Location, error) {
 		return loc, nil
 	}
 	// The next two fallback methods require the country, so check this next.
-	if country == "" || static.Countries[country] == "" {
+	if country == "" || countryCentroid(country) == "" {
 		// Without a valid country value, we can neither lookup the
 		// region nor country.
 		log.WithFields(fields).Info(noneMethod)
@@ -68,18 +76,20 @@ func (sl *AppEngineLocator) Locate(req *http.Request) (*Location, error) {
 	}
 	// Second, country is valid, so try to lookup region.
 	region := strings.ToUpper(headers.Get("X-AppEngine-Region"))
-	if region != "" && static.Regions[country+"-"+region] != "" {
-		latlon = static.Regions[country+"-"+region]
+	if region != "" && regionCentroid(country+"-"+region) != "" {
+		latlon = regionCentroid(country + "-" + region)
 		log.WithFields(fields).Info(regionMethod)
 		loc, err := splitLatLon(latlon)
+		loc.AccuracyKm = RegionAccuracyKm
 		loc.Headers.Set(hLocateClientlatlon, latlon)
 		loc.Headers.Set(hLocateClientlatlonMethod, regionMethod)
 		return loc, err
 	}
 	// Third, region was not found, fallback to using the country.
-	latlon = static.Countries[country]
+	latlon = countryCentroid(country)
 	log.WithFields(fields).Info(countryMethod)
 	loc, err = splitLatLon(latlon)
+	loc.AccuracyKm = CountryAccuracyKm
 	loc.Headers.Set(hLocateClientlatlon, latlon)
 	loc.Headers.Set(hLocateClientlatlonMethod, countryMethod)
 	return loc, err
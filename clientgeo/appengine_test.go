@@ -50,8 +50,9 @@ func TestAppEngineLocator_Locate(t *testing.T) {
 				"X-AppEngine-Region":  "NY",
 			},
 			want: &Location{
-				Latitude:  "43.19880000",
-				Longitude: "-75.3242000",
+				Latitude:   "43.19880000",
+				Longitude:  "-75.3242000",
+				AccuracyKm: RegionAccuracyKm,
 				Headers: http.Header{
 					hLocateClientlatlonMethod: []string{"appengine-region"},
 					hLocateClientlatlon:       []string{"43.19880000,-75.3242000"},
@@ -66,8 +67,9 @@ func TestAppEngineLocator_Locate(t *testing.T) {
 				"X-AppEngine-Region":      "NY",
 			},
 			want: &Location{
-				Latitude:  "43.19880000",
-				Longitude: "-75.3242000",
+				Latitude:   "43.19880000",
+				Longitude:  "-75.3242000",
+				AccuracyKm: RegionAccuracyKm,
 				Headers: http.Header{
 					hLocateClientlatlonMethod: []string{"appengine-region"},
 					hLocateClientlatlon:       []string{"43.19880000,-75.3242000"},
@@ -80,8 +82,9 @@ func TestAppEngineLocator_Locate(t *testing.T) {
 				"X-AppEngine-Country": "US",
 			},
 			want: &Location{
-				Latitude:  "37.09024",
-				Longitude: "-95.712891",
+				Latitude:   "37.09024",
+				Longitude:  "-95.712891",
+				AccuracyKm: CountryAccuracyKm,
 				Headers: http.Header{
 					hLocateClientlatlonMethod: []string{"appengine-country"},
 					hLocateClientlatlon:       []string{"37.09024,-95.712891"},
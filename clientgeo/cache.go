@@ -0,0 +1,133 @@
+package clientgeo
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/m-lab/locate/metrics"
+)
+
+// Prefix lengths used to group client IPs into networks.
+const (
+	prefixLenV4 = 24
+	prefixLenV6 = 48
+)
+
+// PrefixCache wraps an IP-based Locator and caches its results by client
+// network prefix (/24 for IPv4 and /48 for IPv6), so bursts of requests from
+// the same network do not repeat the same lookup.
+//
+// PrefixCache must only wrap Locators whose result depends solely on the
+// client IP, e.g. MaxmindLocator or IPInfoLocator.
+type PrefixCache struct {
+	Locator
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	loc     *Location
+	expires time.Time
+}
+
+// NewPrefixCache creates a new PrefixCache for the given Locator. Results are
+// cached for ttl, with at most size entries.
+func NewPrefixCache(l Locator, ttl time.Duration, size int) *PrefixCache {
+	return &PrefixCache{
+		Locator: l,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// Locate returns the cached Location for the client network or, on a cache
+// miss, the result of the wrapped Locator. Only successful results are cached.
+func (pc *PrefixCache) Locate(req *http.Request) (*Location, error) {
+	ip, err := ClientIP(req)
+	if err != nil || ip == nil {
+		return pc.Locator.Locate(req)
+	}
+	key := prefixKey(ip)
+
+	if loc, ok := pc.get(key); ok {
+		metrics.ClientgeoCacheTotal.WithLabelValues("hit").Inc()
+		return loc, nil
+	}
+	metrics.ClientgeoCacheTotal.WithLabelValues("miss").Inc()
+
+	loc, err := pc.Locator.Locate(req)
+	if err != nil {
+		return nil, err
+	}
+	pc.put(key, loc)
+	return copyLocation(loc), nil
+}
+
+// Reload clears the cache and reloads the wrapped Locator.
+func (pc *PrefixCache) Reload(ctx context.Context) {
+	pc.Locator.Reload(ctx)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.entries = make(map[string]cacheEntry)
+}
+
+// BuildDate returns the build date of the data of the wrapped Locator, or the
+// zero time if the Locator does not report one.
+func (pc *PrefixCache) BuildDate() time.Time {
+	if d, ok := pc.Locator.(interface{ BuildDate() time.Time }); ok {
+		return d.BuildDate()
+	}
+	return time.Time{}
+}
+
+func (pc *PrefixCache) get(key string) (*Location, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e, ok := pc.entries[key]
+	if !ok || !pc.now().Before(e.expires) {
+		return nil, false
+	}
+	return copyLocation(e.loc), true
+}
+
+func (pc *PrefixCache) put(key string, loc *Location) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	now := pc.now()
+	if len(pc.entries) >= pc.size {
+		// Drop expired entries first; if the cache is still full, start over.
+		for k, e := range pc.entries {
+			if !now.Before(e.expires) {
+				delete(pc.entries, k)
+			}
+		}
+		if len(pc.entries) >= pc.size {
+			pc.entries = make(map[string]cacheEntry)
+		}
+	}
+	pc.entries[key] = cacheEntry{loc: copyLocation(loc), expires: now.Add(pc.ttl)}
+}
+
+// prefixKey returns the network prefix of ip as a string.
+func prefixKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(prefixLenV4, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(prefixLenV6, 128)).String() + "/48"
+}
+
+// copyLocation returns a copy of loc that does not share its headers.
+func copyLocation(loc *Location) *Location {
+	c := *loc
+	c.Headers = loc.Headers.Clone()
+	return &c
+}
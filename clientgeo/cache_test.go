@@ -0,0 +1,153 @@
+package clientgeo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type countingLocator struct {
+	calls    int
+	reloaded bool
+	err      error
+}
+
+func (c *countingLocator) Locate(req *http.Request) (*Location, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &Location{
+		Latitude:  "1",
+		Longitude: "2",
+		Headers:   http.Header{hLocateClientlatlon: []string{"1,2"}},
+	}, nil
+}
+
+func (c *countingLocator) Reload(ctx context.Context) {
+	c.reloaded = true
+}
+
+func TestPrefixCache_Locate(t *testing.T) {
+	tests := []struct {
+		name      string
+		addrs     []string
+		err       error
+		advance   time.Duration
+		wantCalls int
+	}{
+		{
+			name:      "success-same-ipv4-prefix",
+			addrs:     []string{"1.2.3.4:1234", "1.2.3.200:1234"},
+			wantCalls: 1,
+		},
+		{
+			name:      "success-different-ipv4-prefix",
+			addrs:     []string{"1.2.3.4:1234", "1.2.4.4:1234"},
+			wantCalls: 2,
+		},
+		{
+			name:      "success-same-ipv6-prefix",
+			addrs:     []string{"[2001:db8:1::1]:1234", "[2001:db8:1:ffff::1]:1234"},
+			wantCalls: 1,
+		},
+		{
+			name:      "success-expired",
+			addrs:     []string{"1.2.3.4:1234", "1.2.3.4:1234"},
+			advance:   time.Minute,
+			wantCalls: 2,
+		},
+		{
+			name:      "success-no-ip",
+			addrs:     []string{"invalid", "invalid"},
+			wantCalls: 2,
+		},
+		{
+			name:      "error-not-cached",
+			addrs:     []string{"1.2.3.4:1234", "1.2.3.4:1234"},
+			err:       errors.New("fake error"),
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &countingLocator{err: tt.err}
+			pc := NewPrefixCache(cl, time.Minute, 10)
+			now := time.Now()
+			pc.now = func() time.Time { return now }
+
+			for _, addr := range tt.addrs {
+				req := httptest.NewRequest(http.MethodGet, "/v2/nearest", nil)
+				req.RemoteAddr = addr
+				loc, err := pc.Locate(req)
+				if (err != nil) != (tt.err != nil) {
+					t.Fatalf("PrefixCache.Locate() error = %v, want %v", err, tt.err)
+				}
+				if err == nil {
+					// Mutating the result must not affect the cached value.
+					loc.Headers.Set(hLocateClientlatlon, "changed")
+				}
+				now = now.Add(tt.advance)
+			}
+			if cl.calls != tt.wantCalls {
+				t.Errorf("PrefixCache.Locate() wrong locator calls; got %d, want %d", cl.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPrefixCache_Reload(t *testing.T) {
+	cl := &countingLocator{}
+	pc := NewPrefixCache(cl, time.Minute, 1)
+	pc.put("a", &Location{})
+	pc.put("b", &Location{})
+	if _, ok := pc.get("a"); ok {
+		t.Errorf("PrefixCache.put() did not evict entry from full cache")
+	}
+	pc.Reload(context.Background())
+	if _, ok := pc.get("b"); ok {
+		t.Errorf("PrefixCache.Reload() did not clear cache")
+	}
+	if !cl.reloaded {
+		t.Errorf("PrefixCache.Reload() did not reload wrapped locator")
+	}
+}
+
+type datedLocator struct {
+	countingLocator
+	date time.Time
+}
+
+func (d *datedLocator) BuildDate() time.Time {
+	return d.date
+}
+
+func TestPrefixCache_BuildDate(t *testing.T) {
+	date := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if got := NewPrefixCache(&datedLocator{date: date}, time.Minute, 1).BuildDate(); !got.Equal(date) {
+		t.Errorf("PrefixCache.BuildDate() = %s, want %s", got, date)
+	}
+	if got := NewPrefixCache(&countingLocator{}, time.Minute, 1).BuildDate(); !got.IsZero() {
+		t.Errorf("PrefixCache.BuildDate() = %s, want zero time", got)
+	}
+}
+
+func Test_prefixKey(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "1.2.3.4", want: "1.2.3.0/24"},
+		{ip: "::ffff:1.2.3.4", want: "1.2.3.0/24"},
+		{ip: "2001:db8:1:2::1", want: "2001:db8:1::/48"},
+	}
+	for _, tt := range tests {
+		if got := prefixKey(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("prefixKey(%s) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package clientgeo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrNoCDNHeaders is returned when a request has no usable CDN geo headers.
+var ErrNoCDNHeaders = errors.New("no usable cdn geo headers")
+
+// cdnSource describes the request headers that a CDN or load balancer adds to
+// identify the client location. Empty header names are not supported by the
+// source.
+type cdnSource struct {
+	name    string
+	latlon  string // Combined "<lat>,<lon>" header.
+	lat     string // Separate latitude header.
+	lon     string // Separate longitude header.
+	country string // ISO 3166-1 alpha-2 country code header.
+	region  string // ISO 3166-2 subdivision header, without the country code.
+	// cldrRegion is true when the region header is a CLDR subdivision ID,
+	// i.e. the country code is prefixed without a separator (e.g. "USCA").
+	cldrRegion bool
+}
+
+// cdnSources lists the supported CDN header conventions in order of preference.
+//
+// The GCLB headers are user-defined request headers that must be configured on
+// the backend service using the {client_city_lat_long}, {client_region} and
+// {client_region_subdivision} variables. The Fastly headers must be set from
+// client.geo.* in the service VCL. Cloudflare headers are added by the
+// "Add visitor location headers" managed transform.
+var cdnSources = []cdnSource{
+	{
+		name:       "gclb",
+		latlon:     "X-Client-City-Lat-Long",
+		country:    "X-Client-Region",
+		region:     "X-Client-Region-Subdivision",
+		cldrRegion: true,
+	},
+	{
+		name:    "cloudflare",
+		lat:     "Cf-Iplatitude",
+		lon:     "Cf-Iplongitude",
+		country: "Cf-Ipcountry",
+		region:  "Cf-Region-Code",
+	},
+	{
+		name:    "fastly",
+		lat:     "Fastly-Geo-Latitude",
+		lon:     "Fastly-Geo-Longitude",
+		country: "Fastly-Geo-Country",
+		region:  "Fastly-Geo-Region",
+	},
+}
+
+// CDNLocator finds a client location using the geo headers added by a CDN or
+// load balancer in front of the Locate service, e.g. when deployed on Cloud
+// Run rather than AppEngine.
+type CDNLocator struct{}
+
+// NewCDNLocator creates a new CDNLocator.
+func NewCDNLocator() *CDNLocator {
+	return &CDNLocator{}
+}
+
+// Locate finds a location for the given client request using CDN headers. Each
+// source is tried for lat/lon first, then region, then country. If no location
+// is found, an error is returned.
+func (cl *CDNLocator) Locate(req *http.Request) (*Location, error) {
+	for _, src := range cdnSources {
+		if loc, err := src.locate(req.Header); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, ErrNoCDNHeaders
+}
+
+// Reload does nothing.
+func (cl *CDNLocator) Reload(ctx context.Context) {}
+
+func (src *cdnSource) locate(headers http.Header) (*Location, error) {
+	// First, try the given lat/lon.
+	latlon := src.getLatLon(headers)
+	if latlon != "" {
+		if loc, err := splitLatLon(latlon); err == nil {
+			return src.withHeaders(loc, latlon, "latlon"), nil
+		}
+	}
+	// The next two fallback methods require the country.
+	country := strings.ToUpper(headers.Get(src.country))
+	if src.country == "" || countryCentroid(country) == "" {
+		return nil, ErrNoCDNHeaders
+	}
+	// Second, try the region.
+	region := strings.ToUpper(headers.Get(src.region))
+	if src.region != "" && region != "" {
+		if src.cldrRegion {
+			region = strings.TrimPrefix(region, country)
+		}
+		if ll := regionCentroid(country + "-" + region); ll != "" {
+			loc, err := splitLatLon(ll)
+			loc.AccuracyKm = RegionAccuracyKm
+			return src.withHeaders(loc, ll, "region"), err
+		}
+	}
+	// Third, fallback to using the country.
+	ll := countryCentroid(country)
+	loc, err := splitLatLon(ll)
+	loc.AccuracyKm = CountryAccuracyKm
+	return src.withHeaders(loc, ll, "country"), err
+}
+
+func (src *cdnSource) getLatLon(headers http.Header) string {
+	if src.latlon != "" {
+		return headers.Get(src.latlon)
+	}
+	lat, lon := headers.Get(src.lat), headers.Get(src.lon)
+	if lat == "" || lon == "" {
+		return ""
+	}
+	return lat + "," + lon
+}
+
+func (src *cdnSource) withHeaders(loc *Location, latlon, method string) *Location {
+	loc.Headers.Set(hLocateClientlatlon, latlon)
+	loc.Headers.Set(hLocateClientlatlonMethod, src.name+"-"+method)
+	return loc
+}
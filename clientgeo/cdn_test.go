@@ -0,0 +1,142 @@
+package clientgeo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCDNLocator_Locate(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    *Location
+		wantErr bool
+	}{
+		{
+			name: "success-gclb-latlon",
+			headers: map[string]string{
+				"X-Client-City-Lat-Long": "40.7,-74.0",
+				"X-Client-Region":        "US",
+			},
+			want: &Location{
+				Latitude:  "40.7",
+				Longitude: "-74.0",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"40.7,-74.0"},
+					hLocateClientlatlonMethod: []string{"gclb-latlon"},
+				},
+			},
+		},
+		{
+			name: "success-gclb-region",
+			headers: map[string]string{
+				"X-Client-City-Lat-Long":      "0.000000,0.000000",
+				"X-Client-Region":             "US",
+				"X-Client-Region-Subdivision": "USNY",
+			},
+			want: &Location{
+				Latitude:   "43.19880000",
+				Longitude:  "-75.3242000",
+				AccuracyKm: RegionAccuracyKm,
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"43.19880000,-75.3242000"},
+					hLocateClientlatlonMethod: []string{"gclb-region"},
+				},
+			},
+		},
+		{
+			name: "success-cloudflare-latlon",
+			headers: map[string]string{
+				"Cf-Iplatitude":  "51.5",
+				"Cf-Iplongitude": "-0.1",
+				"Cf-Ipcountry":   "GB",
+			},
+			want: &Location{
+				Latitude:  "51.5",
+				Longitude: "-0.1",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"51.5,-0.1"},
+					hLocateClientlatlonMethod: []string{"cloudflare-latlon"},
+				},
+			},
+		},
+		{
+			name: "success-cloudflare-region",
+			headers: map[string]string{
+				"Cf-Ipcountry":   "US",
+				"Cf-Region-Code": "ny",
+			},
+			want: &Location{
+				Latitude:   "43.19880000",
+				Longitude:  "-75.3242000",
+				AccuracyKm: RegionAccuracyKm,
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"43.19880000,-75.3242000"},
+					hLocateClientlatlonMethod: []string{"cloudflare-region"},
+				},
+			},
+		},
+		{
+			name: "success-cloudflare-country",
+			headers: map[string]string{
+				"Cf-Ipcountry": "US",
+			},
+			want: &Location{
+				Latitude:   "37.09024",
+				Longitude:  "-95.712891",
+				AccuracyKm: CountryAccuracyKm,
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"37.09024,-95.712891"},
+					hLocateClientlatlonMethod: []string{"cloudflare-country"},
+				},
+			},
+		},
+		{
+			name: "success-fastly-latlon",
+			headers: map[string]string{
+				"Fastly-Geo-Latitude":  "12",
+				"Fastly-Geo-Longitude": "34",
+			},
+			want: &Location{
+				Latitude:  "12",
+				Longitude: "34",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"12,34"},
+					hLocateClientlatlonMethod: []string{"fastly-latlon"},
+				},
+			},
+		},
+		{
+			name: "error-unknown-country",
+			headers: map[string]string{
+				"Cf-Ipcountry": "XX",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "error-no-headers",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := NewCDNLocator()
+			req := httptest.NewRequest(http.MethodGet, "/v2/nearest", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			got, err := cl.Locate(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CDNLocator.Locate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CDNLocator.Locate() = %v, want %v", got, tt.want)
+			}
+			cl.Reload(context.Background())
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package clientgeo
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/locate/static"
+)
+
+// Centroids contains mappings of country codes and ISO 3166-2 region codes
+// (prefixed with the country code) to their geographic center, formatted as
+// "<lat>,<lon>".
+type Centroids struct {
+	Countries map[string]string `json:"countries"`
+	Regions   map[string]string `json:"regions"`
+}
+
+var (
+	centroidsMu sync.RWMutex
+	centroids   = &Centroids{
+		Countries: static.Countries,
+		Regions:   static.Regions,
+	}
+)
+
+// countryCentroid returns the center of the given country, or the empty string
+// if the country is unknown.
+func countryCentroid(country string) string {
+	centroidsMu.RLock()
+	defer centroidsMu.RUnlock()
+	return centroids.Countries[country]
+}
+
+// KnownCountry reports whether the given ISO 3166-1 alpha-2 country code has a
+// centroid, including corrections loaded by a CentroidLoader.
+func KnownCountry(country string) bool {
+	return countryCentroid(country) != ""
+}
+
+// regionCentroid returns the center of the given region, or the empty string if
+// the region is unknown.
+func regionCentroid(region string) string {
+	centroidsMu.RLock()
+	defer centroidsMu.RUnlock()
+	return centroids.Regions[region]
+}
+
+// CentroidLoader loads corrections to the compiled-in country and region
+// centroids from an external dataset. The dataset is a JSON encoded Centroids
+// value. Entries in the dataset replace the compiled-in values; an entry with
+// an empty value removes the code. Entries with an invalid lat/lon are ignored.
+type CentroidLoader struct {
+	provider content.Provider
+}
+
+// NewCentroidLoader creates a new CentroidLoader and loads the current dataset.
+func NewCentroidLoader(ctx context.Context, provider content.Provider) (*CentroidLoader, error) {
+	cl := &CentroidLoader{provider: provider}
+	if err := cl.load(ctx); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// Reload loads the dataset again if it has changed. On error, the previously
+// loaded centroids remain in use.
+func (cl *CentroidLoader) Reload(ctx context.Context) {
+	if err := cl.load(ctx); err != nil {
+		log.Println("Could not reload centroids dataset:", err)
+	}
+}
+
+func (cl *CentroidLoader) load(ctx context.Context) error {
+	b, err := cl.provider.Get(ctx)
+	if err == content.ErrNoChange {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	overlay := &Centroids{}
+	if err := json.Unmarshal(b, overlay); err != nil {
+		return err
+	}
+
+	c := &Centroids{
+		Countries: merge(static.Countries, overlay.Countries),
+		Regions:   merge(static.Regions, overlay.Regions),
+	}
+	centroidsMu.Lock()
+	defer centroidsMu.Unlock()
+	centroids = c
+	return nil
+}
+
+// merge returns a copy of base with the valid entries of overlay applied.
+func merge(base, overlay map[string]string) map[string]string {
+	m := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range overlay {
+		k = strings.ToUpper(k)
+		if v == "" {
+			delete(m, k)
+			continue
+		}
+		fields := strings.Split(v, ",")
+		if len(fields) != 2 || !validLatLon(fields[0], fields[1]) {
+			log.Printf("Ignoring invalid centroid for %s: %q", k, v)
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
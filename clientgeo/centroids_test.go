@@ -0,0 +1,71 @@
+package clientgeo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/locate/static"
+)
+
+type fakeProvider struct {
+	b   []byte
+	err error
+}
+
+func (f *fakeProvider) Get(ctx context.Context) ([]byte, error) {
+	return f.b, f.err
+}
+
+func TestCentroidLoader(t *testing.T) {
+	ctx := context.Background()
+	defer func() {
+		centroids = &Centroids{Countries: static.Countries, Regions: static.Regions}
+	}()
+
+	cl, err := NewCentroidLoader(ctx, loadProvider("file:./testdata/centroids.json"))
+	if err != nil {
+		t.Fatalf("NewCentroidLoader() returned error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "override-country", got: countryCentroid("US"), want: "38.0,-97.0"},
+		{name: "new-country", got: countryCentroid("XK"), want: "42.6,20.9"},
+		{name: "removed-country", got: countryCentroid("AN"), want: ""},
+		{name: "invalid-country", got: countryCentroid("ZZ"), want: ""},
+		{name: "unchanged-country", got: countryCentroid("GB"), want: static.Countries["GB"]},
+		{name: "override-region", got: regionCentroid("US-NY"), want: "42.9,-75.5"},
+		{name: "unchanged-region", got: regionCentroid("US-CA"), want: static.Regions["US-CA"]},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+	if !KnownCountry("XK") || KnownCountry("AN") {
+		t.Errorf("KnownCountry() does not match the loaded centroids")
+	}
+
+	// Errors and unchanged data keep the previous centroids.
+	for _, p := range []*fakeProvider{
+		{err: content.ErrNoChange},
+		{err: errors.New("fake error")},
+		{b: []byte("{")},
+	} {
+		cl.provider = p
+		cl.Reload(ctx)
+		if got := countryCentroid("US"); got != "38.0,-97.0" {
+			t.Errorf("CentroidLoader.Reload() changed centroids on failure; got %q", got)
+		}
+	}
+
+	_, err = NewCentroidLoader(ctx, &fakeProvider{err: errors.New("fake error")})
+	if err == nil {
+		t.Errorf("NewCentroidLoader() expected error; got nil")
+	}
+}
@@ -0,0 +1,40 @@
+package clientgeo
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies is the number of X-Forwarded-For entries appended after the
+// client IP by trusted proxies. For example, the Google load balancer in front
+// of App Engine flex appends the client IP followed by its own address, so the
+// client IP is the second-to-last entry.
+var TrustedProxies = 1
+
+// ClientIP returns the IP of the client that sent req. It is the
+// X-Forwarded-For entry that precedes the TrustedProxies last entries, since
+// earlier entries are provided by the client and may be forged. If there are
+// not enough entries, the first one is used. Without X-Forwarded-For, it is
+// the remote address of the request. The IP is nil if the address is not a
+// valid IP.
+func ClientIP(req *http.Request) (net.IP, error) {
+	fwd := req.Header.Values("X-Forwarded-For")
+	if len(fwd) == 0 || fwd[0] == "" {
+		h, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return nil, errors.New("failed to parse remote addr")
+		}
+		return net.ParseIP(h), nil
+	}
+	addrs := strings.Split(strings.Join(fwd, ","), ",")
+	i := len(addrs) - 1
+	if TrustedProxies > 0 {
+		i -= TrustedProxies
+	}
+	if i < 0 {
+		i = 0
+	}
+	return net.ParseIP(strings.TrimSpace(addrs[i])), nil
+}
@@ -0,0 +1,91 @@
+package clientgeo
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    int
+		forwarded  []string
+		remoteAddr string
+		want       net.IP
+		wantErr    bool
+	}{
+		{
+			name:       "load-balancer",
+			proxies:    1,
+			forwarded:  []string{"2.125.160.216, 192.168.0.2"},
+			remoteAddr: "192.168.0.3:1234",
+			want:       net.ParseIP("2.125.160.216"),
+		},
+		{
+			name:       "load-balancer-forged",
+			proxies:    1,
+			forwarded:  []string{"192.0.2.1, 2.125.160.216, 192.168.0.2"},
+			remoteAddr: "192.168.0.3:1234",
+			want:       net.ParseIP("2.125.160.216"),
+		},
+		{
+			name:       "multiple-headers",
+			proxies:    1,
+			forwarded:  []string{"192.0.2.1", "2.125.160.216, 192.168.0.2"},
+			remoteAddr: "192.168.0.3:1234",
+			want:       net.ParseIP("2.125.160.216"),
+		},
+		{
+			name:       "no-proxies",
+			forwarded:  []string{"192.0.2.1, 2.125.160.216"},
+			remoteAddr: "192.168.0.3:1234",
+			want:       net.ParseIP("2.125.160.216"),
+		},
+		{
+			name:       "too-few-entries",
+			proxies:    2,
+			forwarded:  []string{"2.125.160.216"},
+			remoteAddr: "192.168.0.3:1234",
+			want:       net.ParseIP("2.125.160.216"),
+		},
+		{
+			name:       "remote-addr",
+			proxies:    1,
+			remoteAddr: "2.125.160.216:1234",
+			want:       net.ParseIP("2.125.160.216"),
+		},
+		{
+			name:       "invalid-forwarded",
+			proxies:    1,
+			forwarded:  []string{"bogus, 192.168.0.2"},
+			remoteAddr: "192.168.0.3:1234",
+		},
+		{
+			name:       "error-remote-addr",
+			proxies:    1,
+			remoteAddr: "2.125.160.216",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n int) { TrustedProxies = n }(TrustedProxies)
+			TrustedProxies = tt.proxies
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, f := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", f)
+			}
+			got, err := ClientIP(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ClientIP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ClientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package clientgeo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrBudgetExhausted is returned when the IPinfo lookup budget for the
+	// current period has been used up.
+	ErrBudgetExhausted = errors.New("ipinfo lookup budget exhausted")
+
+	// errNoResponse is returned when a lookup fails without a response from
+	// the API, so that it does not count against the budget.
+	errNoResponse = errors.New("no response from ipinfo")
+
+	ipinfoMethod = "ipinfo-remoteip"
+)
+
+// IPInfoConfig contains the parameters needed to create an IPInfoLocator.
+type IPInfoConfig struct {
+	// URL is the base API URL (e.g. https://ipinfo.io). The client IP is
+	// appended as the final path element.
+	URL *url.URL
+	// Token is the API access token.
+	Token string
+	// Budget is the maximum number of API lookups allowed per BudgetPeriod.
+	// A zero value disables the limit.
+	Budget int
+	// BudgetPeriod is the window after which the Budget count resets.
+	BudgetPeriod time.Duration
+	// CacheTTL is the duration for which lookups are cached, including those
+	// of IPs that have no location.
+	CacheTTL time.Duration
+	// CacheSize is the maximum number of cached entries.
+	CacheSize int
+	// Timeout bounds each API request.
+	Timeout time.Duration
+}
+
+// IPInfoLocator finds a client location by querying the IPinfo API using the
+// client's remote IP or IP from the X-Forwarded-For header.
+type IPInfoLocator struct {
+	config IPInfoConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	cache       map[string]ipinfoEntry
+	used        int
+	periodStart time.Time
+	now         func() time.Time
+}
+
+type ipinfoEntry struct {
+	lat, lon string
+	err      error // Set for IPs that have no location.
+	expires  time.Time
+}
+
+// ipinfoResponse is the subset of the IPinfo API response used by the locator.
+type ipinfoResponse struct {
+	Loc string `json:"loc"`
+}
+
+// NewIPInfoLocator creates a new IPInfoLocator.
+func NewIPInfoLocator(config IPInfoConfig) *IPInfoLocator {
+	return &IPInfoLocator{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		cache:  make(map[string]ipinfoEntry),
+		now:    time.Now,
+	}
+}
+
+// Locate finds the Location of the given request by querying the IPinfo API.
+// Results are cached for CacheTTL. Only lookups that get a response from the
+// API count against the budget; once it is exhausted for the current period, only
+// cached results are returned.
+func (il *IPInfoLocator) Locate(req *http.Request) (*Location, error) {
+	ip, err := ClientIP(req)
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return nil, errors.New("cannot locate nil IP")
+	}
+	key := ip.String()
+
+	e, ok := il.lookupCache(key)
+	if !ok {
+		if !il.spendBudget() {
+			return nil, ErrBudgetExhausted
+		}
+		e.lat, e.lon, err = il.query(req.Context(), key)
+		switch {
+		case errors.Is(err, ErrBadLatLonFormat):
+			// The IP has no location, so there is no point in asking again.
+			e.err = err
+		case errors.Is(err, errNoResponse):
+			il.refundBudget()
+			return nil, err
+		case err != nil:
+			return nil, err
+		}
+		il.storeCache(key, e)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return &Location{
+		Latitude:  e.lat,
+		Longitude: e.lon,
+		Headers: http.Header{
+			hLocateClientlatlon:       []string{e.lat + "," + e.lon},
+			hLocateClientlatlonMethod: []string{ipinfoMethod},
+		},
+	}, nil
+}
+
+// Reload resets the lookup cache.
+func (il *IPInfoLocator) Reload(ctx context.Context) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	il.cache = make(map[string]ipinfoEntry)
+}
+
+func (il *IPInfoLocator) lookupCache(key string) (ipinfoEntry, bool) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	e, ok := il.cache[key]
+	if !ok || il.now().After(e.expires) {
+		return ipinfoEntry{}, false
+	}
+	return e, true
+}
+
+func (il *IPInfoLocator) storeCache(key string, e ipinfoEntry) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if len(il.cache) >= il.config.CacheSize {
+		// Drop expired entries first; if the cache is still full, start over.
+		now := il.now()
+		for k, e := range il.cache {
+			if now.After(e.expires) {
+				delete(il.cache, k)
+			}
+		}
+		if len(il.cache) >= il.config.CacheSize {
+			il.cache = make(map[string]ipinfoEntry)
+		}
+	}
+	e.expires = il.now().Add(il.config.CacheTTL)
+	il.cache[key] = e
+}
+
+// spendBudget reports whether another API lookup is allowed in the current
+// period and, if so, records it.
+func (il *IPInfoLocator) spendBudget() bool {
+	if il.config.Budget <= 0 {
+		return true
+	}
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	now := il.now()
+	if now.Sub(il.periodStart) >= il.config.BudgetPeriod {
+		il.periodStart = now
+		il.used = 0
+	}
+	if il.used >= il.config.Budget {
+		return false
+	}
+	il.used++
+	return true
+}
+
+// refundBudget returns a lookup recorded by spendBudget, for lookups that
+// failed without a response from the API.
+func (il *IPInfoLocator) refundBudget() {
+	if il.config.Budget <= 0 {
+		return
+	}
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	if il.used > 0 {
+		il.used--
+	}
+}
+
+// query requests the location of ip from the IPinfo API.
+func (il *IPInfoLocator) query(ctx context.Context, ip string) (string, string, error) {
+	u := il.config.URL.JoinPath(ip)
+	if il.config.Token != "" {
+		q := u.Query()
+		q.Set("token", il.config.Token)
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := il.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", errNoResponse, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("ipinfo request failed: %s", resp.Status)
+	}
+
+	r := &ipinfoResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		return "", "", err
+	}
+	fields := strings.Split(r.Loc, ",")
+	if len(fields) != 2 || r.Loc == nullLatLon {
+		return "", "", ErrBadLatLonFormat
+	}
+	return fields[0], fields[1], nil
+}
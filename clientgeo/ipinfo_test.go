@@ -0,0 +1,212 @@
+package clientgeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestIPInfoLocator_Locate(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		remoteIP string
+		budget   int
+		lookups  int
+		want     *Location
+		wantHits int
+		wantErr  bool
+	}{
+		{
+			name:     "success",
+			status:   http.StatusOK,
+			body:     `{"ip": "2.125.160.216", "loc": "51.7500,-1.2500", "country": "GB"}`,
+			remoteIP: "2.125.160.216:1234",
+			lookups:  1,
+			want: &Location{
+				Latitude:  "51.7500",
+				Longitude: "-1.2500",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"51.7500,-1.2500"},
+					hLocateClientlatlonMethod: []string{"ipinfo-remoteip"},
+				},
+			},
+			wantHits: 1,
+		},
+		{
+			name:     "success-cached",
+			status:   http.StatusOK,
+			body:     `{"ip": "2.125.160.216", "loc": "51.7500,-1.2500", "country": "GB"}`,
+			remoteIP: "2.125.160.216:1234",
+			lookups:  3,
+			budget:   1,
+			want: &Location{
+				Latitude:  "51.7500",
+				Longitude: "-1.2500",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"51.7500,-1.2500"},
+					hLocateClientlatlonMethod: []string{"ipinfo-remoteip"},
+				},
+			},
+			wantHits: 1,
+		},
+		{
+			name:     "error-status-counted",
+			status:   http.StatusTooManyRequests,
+			remoteIP: "2.125.160.216:1234",
+			lookups:  2,
+			budget:   1,
+			wantHits: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "error-bad-json",
+			status:   http.StatusOK,
+			body:     `{`,
+			remoteIP: "2.125.160.216:1234",
+			lookups:  1,
+			wantHits: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "error-bad-loc",
+			status:   http.StatusOK,
+			body:     `{"loc": "bogus"}`,
+			remoteIP: "2.125.160.216:1234",
+			lookups:  1,
+			wantHits: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "error-bad-loc-cached",
+			status:   http.StatusOK,
+			body:     `{"loc": "bogus"}`,
+			remoteIP: "2.125.160.216:1234",
+			lookups:  2,
+			budget:   1,
+			wantHits: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "error-remote-ip",
+			remoteIP: "invalid-ip:1234",
+			lookups:  1,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				hits++
+				if req.URL.Query().Get("token") != "fake-token" {
+					t.Errorf("IPInfoLocator.Locate() missing token; got %q", req.URL.RawQuery)
+				}
+				rw.WriteHeader(tt.status)
+				fmt.Fprint(rw, tt.body)
+			}))
+			defer srv.Close()
+			u, err := url.Parse(srv.URL)
+			rtx.Must(err, "failed to parse server url")
+
+			il := NewIPInfoLocator(IPInfoConfig{
+				URL:          u,
+				Token:        "fake-token",
+				Budget:       tt.budget,
+				BudgetPeriod: time.Hour,
+				CacheTTL:     time.Hour,
+				CacheSize:    10,
+				Timeout:      time.Second,
+			})
+			req := httptest.NewRequest(http.MethodGet, "/anytarget", nil)
+			req.RemoteAddr = tt.remoteIP
+
+			var got *Location
+			for i := 0; i < tt.lookups; i++ {
+				got, err = il.Locate(req)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("IPInfoLocator.Locate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IPInfoLocator.Locate() = %v, want %v", got, tt.want)
+			}
+			if hits != tt.wantHits {
+				t.Errorf("IPInfoLocator.Locate() wrong API hits; got %d, want %d", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestIPInfoLocator_Locate_BudgetExhausted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, `{"loc": "51.7500,-1.2500"}`)
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	rtx.Must(err, "failed to parse server url")
+
+	il := NewIPInfoLocator(IPInfoConfig{
+		URL:          u,
+		Budget:       1,
+		BudgetPeriod: time.Hour,
+		CacheTTL:     time.Hour,
+		CacheSize:    10,
+		Timeout:      time.Second,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/anytarget", nil)
+	req.RemoteAddr = "2.125.160.216:1234"
+	_, err = il.Locate(req)
+	rtx.Must(err, "failed to locate first client")
+
+	req.RemoteAddr = "2.125.160.217:1234"
+	if _, err = il.Locate(req); err != ErrBudgetExhausted {
+		t.Errorf("IPInfoLocator.Locate() error = %v, want %v", err, ErrBudgetExhausted)
+	}
+}
+
+func TestIPInfoLocator_Locate_NoResponse(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	u, err := url.Parse(srv.URL)
+	rtx.Must(err, "failed to parse server url")
+	srv.Close()
+
+	il := NewIPInfoLocator(IPInfoConfig{
+		URL:          u,
+		Budget:       1,
+		BudgetPeriod: time.Hour,
+		CacheTTL:     time.Hour,
+		CacheSize:    10,
+		Timeout:      time.Second,
+	})
+	req := httptest.NewRequest(http.MethodGet, "/anytarget", nil)
+	req.RemoteAddr = "2.125.160.216:1234"
+	for i := 0; i < 2; i++ {
+		if _, err = il.Locate(req); !errors.Is(err, errNoResponse) {
+			t.Errorf("IPInfoLocator.Locate() error = %v, want %v", err, errNoResponse)
+		}
+	}
+}
+
+func TestIPInfoLocator_Reload(t *testing.T) {
+	il := NewIPInfoLocator(IPInfoConfig{CacheSize: 1, CacheTTL: time.Hour})
+	il.storeCache("a", ipinfoEntry{lat: "1", lon: "2"})
+	il.storeCache("b", ipinfoEntry{lat: "3", lon: "4"})
+	if _, ok := il.lookupCache("a"); ok {
+		t.Errorf("IPInfoLocator.storeCache() did not evict entry from full cache")
+	}
+	il.Reload(context.Background())
+	if _, ok := il.lookupCache("b"); ok {
+		t.Errorf("IPInfoLocator.Reload() did not reset cache")
+	}
+}
@@ -4,15 +4,19 @@ package clientgeo
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/m-lab/locate/metrics"
 )
 
 // Constants defining the X-Locate-* header names produced by Locators.
 const (
 	hLocateClientlatlon       = "X-Locate-Clientlatlon"
 	hLocateClientlatlonMethod = "X-Locate-Clientlatlon-Method"
+	hLocateClientASN          = "X-Locate-Clientasn"
+	hLocateClientISP          = "X-Locate-Clientisp"
 )
 
 // Locator supports locating a client request and Reloading the underlying database.
@@ -21,11 +25,24 @@ type Locator interface {
 	Reload(context.Context)
 }
 
+// Approximate accuracy of locations derived from region and country centroids.
+const (
+	RegionAccuracyKm  = 250
+	CountryAccuracyKm = 1000
+)
+
 // Location contains an estimated the latitude and longitude of a client IP.
+// When available, the client's autonomous system number and ISP are included.
 type Location struct {
 	Latitude  string
 	Longitude string
-	Headers   http.Header
+	// AccuracyKm is the estimated accuracy radius of the location in km, or
+	// zero if unknown.
+	AccuracyKm float64
+	Country    string // Two-letter country code, or empty if unknown.
+	ASN        uint   // Autonomous system number, or zero if unknown.
+	ISP        string // Autonomous system organization, or empty if unknown.
+	Headers    http.Header
 }
 
 // NullLocator always returns a client location of 0,0.
@@ -45,19 +62,62 @@ func (f *NullLocator) Reload(ctx context.Context) {}
 // MultiLocator wraps several Locator types into the Locate interface.
 type MultiLocator []Locator
 
+// namedLocator associates a Locator with its name for metrics.
+type namedLocator struct {
+	name string
+	Locator
+}
+
+// LocatorNames lists the names of all Locators that may be used with
+// NewMultiLocator.
+var LocatorNames = []string{"override", "user", "appengine", "cdn", "maxmind", "ipinfo"}
+
+// NewMultiLocator creates a MultiLocator from the available Locators using the
+// precedence given by order. Each name in order must be one of LocatorNames.
+// Names without an available Locator (e.g. because it is disabled) are
+// skipped. Every available Locator must appear in order so that no configured
+// Locator is silently ignored.
+func NewMultiLocator(order []string, available map[string]Locator) (MultiLocator, error) {
+	ml := MultiLocator{}
+	seen := map[string]bool{}
+	for _, name := range order {
+		if !contains(LocatorNames, name) {
+			return nil, fmt.Errorf("unknown locator %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate locator %q", name)
+		}
+		seen[name] = true
+		if l, ok := available[name]; ok {
+			ml = append(ml, &namedLocator{name: name, Locator: l})
+		}
+	}
+	for name := range available {
+		if !seen[name] {
+			return nil, fmt.Errorf("locator %q is enabled but missing from order", name)
+		}
+	}
+	return ml, nil
+}
+
 // Locate calls Locate on all client Locators. The first successfully identifiec
 // location is returned. If all Locators returns an error, a multierror.Error is
 // returned as an error with all Locator error messages.
 func (g MultiLocator) Locate(req *http.Request) (*Location, error) {
 	var merr *multierror.Error
 	for _, locator := range g {
+		name := locatorName(locator)
 		l, err := locator.Locate(req)
 		if err != nil {
+			metrics.ClientgeoLocatorTotal.WithLabelValues(name, "error").Inc()
 			merr = multierror.Append(merr, err)
 			continue
 		}
+		metrics.ClientgeoLocatorTotal.WithLabelValues(name, "OK").Inc()
+		metrics.ClientgeoSelectedTotal.WithLabelValues(name).Inc()
 		return l, nil
 	}
+	metrics.ClientgeoSelectedTotal.WithLabelValues("none").Inc()
 	return nil, merr
 }
 
@@ -67,3 +127,21 @@ func (g MultiLocator) Reload(ctx context.Context) {
 		locator.Reload(ctx)
 	}
 }
+
+// locatorName returns the name given to the Locator by NewMultiLocator.
+func locatorName(l Locator) string {
+	if nl, ok := l.(*namedLocator); ok {
+		return nl.name
+	}
+	return "unknown"
+}
+
+// contains reports whether the given string array contains the given value.
+func contains(sa []string, value string) bool {
+	for _, v := range sa {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
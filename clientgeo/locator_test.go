@@ -49,6 +49,21 @@ func TestMultiLocator(t *testing.T) {
 		}
 		ml.Reload(req.Context())
 	})
+	t.Run("success-named", func(t *testing.T) {
+		ml, err := NewMultiLocator([]string{"user", "maxmind"},
+			map[string]Locator{"user": &errLocator{}, "maxmind": &NullLocator{}})
+		if err != nil {
+			t.Fatalf("NewMultiLocator returned error: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/anyurl", nil)
+		l, err := ml.Locate(req)
+		if err != nil {
+			t.Errorf("MultiLocator.Locate returned error: %v", err)
+		}
+		if !reflect.DeepEqual(l, want) {
+			t.Errorf("MultiLocator() = %v, want %v", l, want)
+		}
+	})
 	t.Run("all-errors", func(t *testing.T) {
 		ml := MultiLocator{&errLocator{}, &errLocator{}}
 		req := httptest.NewRequest(http.MethodGet, "/anyurl", nil)
@@ -58,3 +73,54 @@ func TestMultiLocator(t *testing.T) {
 		}
 	})
 }
+
+func TestNewMultiLocator(t *testing.T) {
+	user := &UserLocator{}
+	null := &NullLocator{}
+	tests := []struct {
+		name      string
+		order     []string
+		available map[string]Locator
+		want      MultiLocator
+		wantErr   bool
+	}{
+		{
+			name:      "success",
+			order:     []string{"maxmind", "user", "appengine"},
+			available: map[string]Locator{"user": user, "maxmind": null},
+			want: MultiLocator{
+				&namedLocator{name: "maxmind", Locator: null},
+				&namedLocator{name: "user", Locator: user},
+			},
+		},
+		{
+			name:      "error-unknown-name",
+			order:     []string{"user", "unknown"},
+			available: map[string]Locator{"user": user},
+			wantErr:   true,
+		},
+		{
+			name:      "error-duplicate-name",
+			order:     []string{"user", "user"},
+			available: map[string]Locator{"user": user},
+			wantErr:   true,
+		},
+		{
+			name:      "error-enabled-not-ordered",
+			order:     []string{"user"},
+			available: map[string]Locator{"user": user, "maxmind": null},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMultiLocator(tt.order, tt.available)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMultiLocator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) && !tt.wantErr {
+				t.Errorf("NewMultiLocator() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,11 +7,13 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/m-lab/go/content"
 	"github.com/m-lab/go/rtx"
+	"github.com/m-lab/locate/metrics"
 	"github.com/oschwald/geoip2-golang"
 
 	"github.com/m-lab/uuid-annotator/tarreader"
@@ -20,12 +22,24 @@ import (
 // NewMaxmindLocator creates a new MaxmindLocator and loads the current copy of
 // MaxMind data stored in GCS.
 func NewMaxmindLocator(ctx context.Context, mm content.Provider) *MaxmindLocator {
+	return NewMaxmindLocatorWithASN(ctx, mm, nil)
+}
+
+// NewMaxmindLocatorWithASN creates a new MaxmindLocator that also enriches
+// locations with the client ASN and ISP using the MaxMind ASN database. If asn
+// is nil, no ASN enrichment is performed.
+func NewMaxmindLocatorWithASN(ctx context.Context, mm content.Provider, asn content.Provider) *MaxmindLocator {
 	mml := &MaxmindLocator{
 		dataSource: mm,
+		asnSource:  asn,
 	}
 	var err error
 	mml.maxmind, err = mml.load(ctx)
 	rtx.Must(err, "Could not load annotation db")
+	if asn != nil {
+		mml.asn, err = mml.loadASN(ctx)
+		rtx.Must(err, "Could not load asn db")
+	}
 	return mml
 }
 
@@ -33,7 +47,9 @@ func NewMaxmindLocator(ctx context.Context, mm content.Provider) *MaxmindLocator
 type MaxmindLocator struct {
 	mut        sync.RWMutex
 	dataSource content.Provider
+	asnSource  content.Provider
 	maxmind    *geoip2.Reader
+	asn        *geoip2.Reader
 }
 
 var emptyResult = geoip2.City{}
@@ -44,7 +60,7 @@ func (mml *MaxmindLocator) Locate(req *http.Request) (*Location, error) {
 	mml.mut.RLock()
 	defer mml.mut.RUnlock()
 
-	ip, err := ipFromRequest(req)
+	ip, err := ClientIP(req)
 	if err != nil {
 		return nil, err
 	}
@@ -73,29 +89,38 @@ func (mml *MaxmindLocator) Locate(req *http.Request) (*Location, error) {
 	lat := fmt.Sprintf("%f", record.Location.Latitude)
 	lon := fmt.Sprintf("%f", record.Location.Longitude)
 	tmp := &Location{
-		Latitude:  lat,
-		Longitude: lon,
+		Latitude:   lat,
+		Longitude:  lon,
+		AccuracyKm: float64(record.Location.AccuracyRadius),
 		Headers: http.Header{
 			hLocateClientlatlon:       []string{lat + "," + lon},
 			hLocateClientlatlonMethod: []string{"maxmind-remoteip"},
 		},
 	}
+	mml.enrichASN(ip, tmp)
 	return tmp, nil
 }
 
-func ipFromRequest(req *http.Request) (net.IP, error) {
-	fwdIPs := strings.Split(req.Header.Get("X-Forwarded-For"), ", ")
-	var ip net.IP
-	if fwdIPs[0] != "" {
-		ip = net.ParseIP(fwdIPs[0])
-	} else {
-		h, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err != nil {
-			return nil, errors.New("failed to parse remote addr")
-		}
-		ip = net.ParseIP(h)
+// enrichASN adds the ASN and ISP of the given IP to the Location, if an ASN
+// database is loaded. Lookup failures are not fatal to locating the client.
+func (mml *MaxmindLocator) enrichASN(ip net.IP, loc *Location) {
+	if mml.asn == nil {
+		return
+	}
+	record, err := mml.asn.ASN(ip)
+	if err != nil {
+		metrics.ClientASNLookupsTotal.WithLabelValues("error").Inc()
+		return
+	}
+	if record.AutonomousSystemNumber == 0 {
+		metrics.ClientASNLookupsTotal.WithLabelValues("not found").Inc()
+		return
 	}
-	return ip, nil
+	metrics.ClientASNLookupsTotal.WithLabelValues("OK").Inc()
+	loc.ASN = record.AutonomousSystemNumber
+	loc.ISP = record.AutonomousSystemOrganization
+	loc.Headers.Set(hLocateClientASN, strconv.FormatUint(uint64(loc.ASN), 10))
+	loc.Headers.Set(hLocateClientISP, loc.ISP)
 }
 
 // Reload is intended to be regularly called in a loop. It should check whether
@@ -107,10 +132,30 @@ func (mml *MaxmindLocator) Reload(ctx context.Context) {
 		log.Println("Could not reload maxmind dataset:", err)
 		return
 	}
+	var asn *geoip2.Reader
+	if mml.asnSource != nil {
+		asn, err = mml.loadASN(ctx)
+		if err != nil {
+			log.Println("Could not reload maxmind asn dataset:", err)
+			return
+		}
+	}
 	// Don't acquire the lock until after the data is in RAM.
 	mml.mut.Lock()
 	defer mml.mut.Unlock()
 	mml.maxmind = mm
+	mml.asn = asn
+}
+
+// BuildDate returns the build date of the currently loaded MaxMind City
+// database, or the zero time if no database is loaded.
+func (mml *MaxmindLocator) BuildDate() time.Time {
+	mml.mut.RLock()
+	defer mml.mut.RUnlock()
+	if mml.maxmind == nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(mml.maxmind.Metadata().BuildEpoch), 0).UTC()
 }
 
 func isEmpty(r *geoip2.City) bool {
@@ -133,3 +178,19 @@ func (mml *MaxmindLocator) load(ctx context.Context) (*geoip2.Reader, error) {
 	}
 	return geoip2.FromBytes(data)
 }
+
+// loadASN unconditionally loads the ASN dataset and returns it.
+func (mml *MaxmindLocator) loadASN(ctx context.Context) (*geoip2.Reader, error) {
+	tgz, err := mml.asnSource.Get(ctx)
+	if err == content.ErrNoChange {
+		return mml.asn, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := tarreader.FromTarGZ(tgz, "GeoLite2-ASN.mmdb")
+	if err != nil {
+		return nil, err
+	}
+	return geoip2.FromBytes(data)
+}
@@ -36,8 +36,9 @@ func TestNewMaxmindLocator(t *testing.T) {
 			},
 			remoteIP: remoteIP + ":1234",
 			want: &Location{
-				Latitude:  "51.750000",
-				Longitude: "-1.250000",
+				Latitude:   "51.750000",
+				Longitude:  "-1.250000",
+				AccuracyKm: 100,
 				Headers: http.Header{
 					hLocateClientlatlon:       []string{"51.750000,-1.250000"},
 					hLocateClientlatlonMethod: []string{"maxmind-remoteip"},
@@ -49,8 +50,9 @@ func TestNewMaxmindLocator(t *testing.T) {
 			name:     "success-using-remote-ip",
 			remoteIP: remoteIP + ":1234",
 			want: &Location{
-				Latitude:  "51.750000",
-				Longitude: "-1.250000",
+				Latitude:   "51.750000",
+				Longitude:  "-1.250000",
+				AccuracyKm: 100,
 				Headers: http.Header{
 					hLocateClientlatlon:       []string{"51.750000,-1.250000"},
 					hLocateClientlatlonMethod: []string{"maxmind-remoteip"},
@@ -198,3 +200,77 @@ func TestMaxmindLocator_Reload(t *testing.T) {
 		})
 	}
 }
+
+func TestMaxmindLocator_ASN(t *testing.T) {
+	tests := []struct {
+		name      string
+		remoteIP  string
+		asn       string
+		wrongType bool
+		wantASN   uint
+		wantISP   string
+	}{
+		{
+			name:     "success-asn-found",
+			remoteIP: remoteIP + ":1234",
+			asn:      "file:./testdata/fake-asn.tar.gz",
+			wantASN:  15169,
+			wantISP:  "Google LLC",
+		},
+		{
+			name:     "success-asn-not-found",
+			remoteIP: localIP + ":1234",
+			asn:      "file:./testdata/fake-asn.tar.gz",
+		},
+		{
+			name:      "success-asn-wrong-db-type",
+			remoteIP:  remoteIP + ":1234",
+			asn:       "file:./testdata/fake-asn.tar.gz",
+			wrongType: true,
+		},
+		{
+			name:     "success-no-asn-db",
+			remoteIP: remoteIP + ":1234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var asn content.Provider
+			if tt.asn != "" {
+				asn = loadProvider(tt.asn)
+			}
+			mml := NewMaxmindLocatorWithASN(ctx, loadProvider("file:./testdata/fake.tar.gz"), asn)
+			if tt.wrongType {
+				// Use the City database in place of the ASN database.
+				mml.asn = mml.maxmind
+			}
+			mml.Reload(ctx)
+
+			req := httptest.NewRequest(http.MethodGet, "/anytarget", nil)
+			req.RemoteAddr = tt.remoteIP
+			l, err := mml.Locate(req)
+			if err != nil {
+				t.Fatalf("MaxmindLocator.Locate() returned error: %v", err)
+			}
+			if l.ASN != tt.wantASN || l.ISP != tt.wantISP {
+				t.Errorf("MaxmindLocator.Locate() = %d/%q, want %d/%q", l.ASN, l.ISP, tt.wantASN, tt.wantISP)
+			}
+			if tt.wantASN != 0 && l.Headers.Get(hLocateClientASN) != "15169" {
+				t.Errorf("MaxmindLocator.Locate() missing ASN header; got %q", l.Headers.Get(hLocateClientASN))
+			}
+		})
+	}
+}
+
+func TestMaxmindLocator_BuildDate(t *testing.T) {
+	ctx := context.Background()
+	mml := NewMaxmindLocator(ctx, loadProvider("file:./testdata/fake.tar.gz"))
+	if mml.BuildDate().IsZero() {
+		t.Errorf("MaxmindLocator.BuildDate() returned zero time for loaded db")
+	}
+	mml.maxmind = nil
+	if !mml.BuildDate().IsZero() {
+		t.Errorf("MaxmindLocator.BuildDate() = %v, want zero time", mml.BuildDate())
+	}
+}
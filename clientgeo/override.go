@@ -0,0 +1,138 @@
+package clientgeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/m-lab/go/content"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrNoOverride is returned when no override matches the client IP.
+var ErrNoOverride = errors.New("no override for client ip")
+
+// Override defines a manual location for all client IPs within Prefix.
+type Override struct {
+	Prefix    string  `yaml:"prefix"`
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+	Country   string  `yaml:"country"`
+}
+
+type override struct {
+	network *net.IPNet
+	loc     Location
+}
+
+// OverrideLocator locates clients using an operator-maintained table of
+// network prefixes. It is meant to correct networks that other Locators
+// consistently misplace, and should be consulted before them.
+type OverrideLocator struct {
+	dataSource content.Provider
+
+	mu        sync.RWMutex
+	overrides []override
+}
+
+// NewOverrideLocator creates a new OverrideLocator and loads the YAML override
+// table from the given provider.
+func NewOverrideLocator(ctx context.Context, provider content.Provider) (*OverrideLocator, error) {
+	ol := &OverrideLocator{dataSource: provider}
+	overrides, err := ol.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ol.overrides = overrides
+	return ol, nil
+}
+
+// Locate returns the override Location of the most specific prefix containing
+// the client IP.
+func (ol *OverrideLocator) Locate(req *http.Request) (*Location, error) {
+	ip, err := ClientIP(req)
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return nil, errors.New("cannot locate nil IP")
+	}
+
+	ol.mu.RLock()
+	defer ol.mu.RUnlock()
+	// Overrides are sorted by decreasing prefix length, so the first match is
+	// the most specific.
+	for _, o := range ol.overrides {
+		if o.network.Contains(ip) {
+			return copyLocation(&o.loc), nil
+		}
+	}
+	return nil, ErrNoOverride
+}
+
+// Reload loads the override table again if it has changed. On error, the
+// previous table remains in use.
+func (ol *OverrideLocator) Reload(ctx context.Context) {
+	overrides, err := ol.load(ctx)
+	if err != nil {
+		log.Println("Could not reload override table:", err)
+		return
+	}
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+	ol.overrides = overrides
+}
+
+func (ol *OverrideLocator) load(ctx context.Context) ([]override, error) {
+	b, err := ol.dataSource.Get(ctx)
+	if err == content.ErrNoChange {
+		ol.mu.RLock()
+		defer ol.mu.RUnlock()
+		return ol.overrides, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var table []Override
+	if err := yaml.Unmarshal(b, &table); err != nil {
+		return nil, err
+	}
+
+	overrides := make([]override, 0, len(table))
+	for _, entry := range table {
+		_, network, err := net.ParseCIDR(entry.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		lat := strconv.FormatFloat(entry.Latitude, 'f', -1, 64)
+		lon := strconv.FormatFloat(entry.Longitude, 'f', -1, 64)
+		if !validLatLon(lat, lon) {
+			return nil, fmt.Errorf("invalid lat/lon for prefix %s", entry.Prefix)
+		}
+		overrides = append(overrides, override{
+			network: network,
+			loc: Location{
+				Latitude:  lat,
+				Longitude: lon,
+				Country:   strings.ToUpper(entry.Country),
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{lat + "," + lon},
+					hLocateClientlatlonMethod: []string{"override-prefix"},
+				},
+			},
+		})
+	}
+	sort.SliceStable(overrides, func(i, j int) bool {
+		oi, _ := overrides[i].network.Mask.Size()
+		oj, _ := overrides[j].network.Mask.Size()
+		return oi > oj
+	})
+	return overrides, nil
+}
@@ -0,0 +1,133 @@
+package clientgeo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/content"
+)
+
+func TestOverrideLocator_Locate(t *testing.T) {
+	ol, err := NewOverrideLocator(context.Background(), loadProvider("file:./testdata/overrides.yaml"))
+	if err != nil {
+		t.Fatalf("NewOverrideLocator() returned error: %v", err)
+	}
+	tests := []struct {
+		name     string
+		remoteIP string
+		want     *Location
+		wantErr  bool
+	}{
+		{
+			name:     "success-most-specific",
+			remoteIP: "2.125.160.216:1234",
+			want: &Location{
+				Latitude:  "48.85",
+				Longitude: "2.35",
+				Country:   "FR",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"48.85,2.35"},
+					hLocateClientlatlonMethod: []string{"override-prefix"},
+				},
+			},
+		},
+		{
+			name:     "success-less-specific",
+			remoteIP: "2.125.1.1:1234",
+			want: &Location{
+				Latitude:  "51.5",
+				Longitude: "-0.1",
+				Country:   "GB",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"51.5,-0.1"},
+					hLocateClientlatlonMethod: []string{"override-prefix"},
+				},
+			},
+		},
+		{
+			name:     "success-ipv6",
+			remoteIP: "[2001:db8::1]:1234",
+			want: &Location{
+				Latitude:  "40.7",
+				Longitude: "-74",
+				Country:   "US",
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"40.7,-74"},
+					hLocateClientlatlonMethod: []string{"override-prefix"},
+				},
+			},
+		},
+		{
+			name:     "error-no-match",
+			remoteIP: "192.0.2.1:1234",
+			wantErr:  true,
+		},
+		{
+			name:     "error-remote-ip",
+			remoteIP: "invalid-ip:1234",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/anytarget", nil)
+			req.RemoteAddr = tt.remoteIP
+			got, err := ol.Locate(req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OverrideLocator.Locate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OverrideLocator.Locate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOverrideLocator_Errors(t *testing.T) {
+	ctx := context.Background()
+	for _, p := range []*fakeProvider{
+		{err: errors.New("fake error")},
+		{b: []byte("{")},
+		{b: []byte("- prefix: not-a-prefix\n")},
+		{b: []byte("- prefix: 192.0.2.0/24\n  latitude: 91\n")},
+	} {
+		if _, err := NewOverrideLocator(ctx, p); err == nil {
+			t.Errorf("NewOverrideLocator() expected error for %q", p.b)
+		}
+	}
+}
+
+func TestOverrideLocator_Reload(t *testing.T) {
+	ctx := context.Background()
+	p := &fakeProvider{b: []byte("- prefix: 192.0.2.0/24\n  latitude: 1\n  longitude: 2\n")}
+	ol, err := NewOverrideLocator(ctx, p)
+	if err != nil {
+		t.Fatalf("NewOverrideLocator() returned error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/anytarget", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	// Failed reloads keep the previous table.
+	for _, f := range []*fakeProvider{
+		{err: content.ErrNoChange},
+		{err: errors.New("fake error")},
+		{b: []byte("{")},
+	} {
+		ol.dataSource = f
+		ol.Reload(ctx)
+		if loc, err := ol.Locate(req); err != nil || loc.Latitude != "1" {
+			t.Errorf("OverrideLocator.Reload() changed table on failure; got %v, %v", loc, err)
+		}
+	}
+
+	ol.dataSource = &fakeProvider{b: []byte("[]")}
+	ol.Reload(ctx)
+	if _, err := ol.Locate(req); err != ErrNoOverride {
+		t.Errorf("OverrideLocator.Reload() did not replace table; got %v", err)
+	}
+}
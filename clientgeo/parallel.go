@@ -0,0 +1,96 @@
+package clientgeo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/m-lab/locate/metrics"
+)
+
+// ErrLocatorTimeout is returned for a Locator that did not respond in time.
+var ErrLocatorTimeout = errors.New("locator timed out")
+
+// ParallelLocator queries all Locators of a MultiLocator concurrently so that a
+// slow Locator does not delay every request. The result is chosen using the
+// MultiLocator precedence among the Locators that responded within timeout.
+//
+// Unlike MultiLocator, every Locator is queried for every request, including
+// those that consume an external API budget.
+type ParallelLocator struct {
+	locators MultiLocator
+	timeout  time.Duration
+}
+
+type locateResult struct {
+	loc *Location
+	err error
+}
+
+// NewParallelLocator creates a ParallelLocator that waits at most timeout for
+// each Locator in ml.
+func NewParallelLocator(ml MultiLocator, timeout time.Duration) *ParallelLocator {
+	return &ParallelLocator{locators: ml, timeout: timeout}
+}
+
+// Locate calls Locate on all Locators concurrently. The first successful
+// location in precedence order is returned as soon as all Locators of higher
+// precedence have failed or timed out. If no Locator succeeds, a
+// multierror.Error is returned with all Locator error messages.
+func (p *ParallelLocator) Locate(req *http.Request) (*Location, error) {
+	results := make([]chan locateResult, len(p.locators))
+	for i, locator := range p.locators {
+		// Buffered so that Locators finishing after the deadline do not block.
+		results[i] = make(chan locateResult, 1)
+		go func(l Locator, c chan<- locateResult) {
+			loc, err := l.Locate(req)
+			c <- locateResult{loc: loc, err: err}
+		}(locator, results[i])
+	}
+
+	// All Locators start together, so they share the same deadline.
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	expired := false
+
+	var merr *multierror.Error
+	for i, locator := range p.locators {
+		name := locatorName(locator)
+		var r locateResult
+		if expired {
+			select {
+			case r = <-results[i]:
+			default:
+				r.err = ErrLocatorTimeout
+			}
+		} else {
+			select {
+			case r = <-results[i]:
+			case <-timer.C:
+				expired = true
+				r.err = ErrLocatorTimeout
+			}
+		}
+		if r.err != nil {
+			status := "error"
+			if r.err == ErrLocatorTimeout {
+				status = "timeout"
+			}
+			metrics.ClientgeoLocatorTotal.WithLabelValues(name, status).Inc()
+			merr = multierror.Append(merr, r.err)
+			continue
+		}
+		metrics.ClientgeoLocatorTotal.WithLabelValues(name, "OK").Inc()
+		metrics.ClientgeoSelectedTotal.WithLabelValues(name).Inc()
+		return r.loc, nil
+	}
+	metrics.ClientgeoSelectedTotal.WithLabelValues("none").Inc()
+	return nil, merr
+}
+
+// Reload calls Reload on all Locators.
+func (p *ParallelLocator) Reload(ctx context.Context) {
+	p.locators.Reload(ctx)
+}
@@ -0,0 +1,73 @@
+package clientgeo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type slowLocator struct {
+	delay time.Duration
+	loc   *Location
+}
+
+func (s *slowLocator) Locate(req *http.Request) (*Location, error) {
+	time.Sleep(s.delay)
+	return s.loc, nil
+}
+
+func (s *slowLocator) Reload(ctx context.Context) {}
+
+func TestParallelLocator_Locate(t *testing.T) {
+	fast := &Location{Latitude: "1", Longitude: "2"}
+	slow := &Location{Latitude: "3", Longitude: "4"}
+	tests := []struct {
+		name     string
+		locators MultiLocator
+		want     *Location
+		wantErr  bool
+	}{
+		{
+			name:     "success-precedence",
+			locators: MultiLocator{&slowLocator{delay: 10 * time.Millisecond, loc: slow}, &slowLocator{loc: fast}},
+			want:     slow,
+		},
+		{
+			name:     "success-skip-error",
+			locators: MultiLocator{&errLocator{}, &slowLocator{loc: fast}},
+			want:     fast,
+		},
+		{
+			name:     "success-skip-timeout",
+			locators: MultiLocator{&slowLocator{delay: time.Second, loc: slow}, &slowLocator{loc: fast}},
+			want:     fast,
+		},
+		{
+			name:     "error-all-timeout",
+			locators: MultiLocator{&slowLocator{delay: time.Second, loc: slow}},
+			wantErr:  true,
+		},
+		{
+			name:     "error-all-errors",
+			locators: MultiLocator{&errLocator{}, &errLocator{}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParallelLocator(tt.locators, 100*time.Millisecond)
+			req := httptest.NewRequest(http.MethodGet, "/anyurl", nil)
+			got, err := p.Locate(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParallelLocator.Locate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParallelLocator.Locate() = %v, want %v", got, tt.want)
+			}
+			p.Reload(req.Context())
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package clientgeo
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PrivacyLocator wraps a Locator and rounds the client coordinates reported in
+// the X-Locate-Clientlatlon header to a fixed number of decimal digits. The
+// Location latitude and longitude used for server selection are unchanged.
+//
+// One decimal digit is roughly 11km at the equator, i.e. city precision.
+type PrivacyLocator struct {
+	Locator
+	digits int
+}
+
+// NewPrivacyLocator creates a new PrivacyLocator. A negative digits value
+// disables rounding.
+func NewPrivacyLocator(l Locator, digits int) *PrivacyLocator {
+	return &PrivacyLocator{
+		Locator: l,
+		digits:  digits,
+	}
+}
+
+// Locate calls the wrapped Locator and rounds the coordinates in the returned
+// headers.
+func (p *PrivacyLocator) Locate(req *http.Request) (*Location, error) {
+	loc, err := p.Locator.Locate(req)
+	if err != nil || loc == nil || p.digits < 0 {
+		return loc, err
+	}
+	if latlon := loc.Headers.Get(hLocateClientlatlon); latlon != "" {
+		loc.Headers.Set(hLocateClientlatlon, roundLatLon(latlon, p.digits))
+	}
+	return loc, nil
+}
+
+// roundLatLon rounds each value in a "<lat>,<lon>" string to the given number
+// of decimal digits. Values that cannot be parsed are replaced with an empty
+// string so that the original value is never leaked. A negative digits value
+// returns latlon unchanged.
+func roundLatLon(latlon string, digits int) string {
+	if digits < 0 {
+		return latlon
+	}
+	fields := strings.Split(latlon, ",")
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			fields[i] = ""
+			continue
+		}
+		scale := math.Pow(10, float64(digits))
+		fields[i] = strconv.FormatFloat(math.Round(v*scale)/scale, 'f', digits, 64)
+	}
+	return strings.Join(fields, ",")
+}
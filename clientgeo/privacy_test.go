@@ -0,0 +1,73 @@
+package clientgeo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrivacyLocator_Locate(t *testing.T) {
+	tests := []struct {
+		name       string
+		loc        Locator
+		digits     int
+		wantLatLon string
+		wantErr    bool
+	}{
+		{
+			name:       "success-rounded",
+			loc:        &UserLocator{},
+			digits:     1,
+			wantLatLon: "40.8,-74.0",
+		},
+		{
+			name:       "success-disabled",
+			loc:        &UserLocator{},
+			digits:     -1,
+			wantLatLon: "40.7812,-73.9665",
+		},
+		{
+			name:    "error",
+			loc:     &errLocator{},
+			digits:  1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrivacyLocator(tt.loc, tt.digits)
+			req := httptest.NewRequest(http.MethodGet, "/v2/nearest?lat=40.7812&lon=-73.9665", nil)
+			loc, err := p.Locate(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PrivacyLocator.Locate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := loc.Headers.Get(hLocateClientlatlon); got != tt.wantLatLon {
+				t.Errorf("PrivacyLocator.Locate() header = %q, want %q", got, tt.wantLatLon)
+			}
+			if loc.Latitude != "40.7812" || loc.Longitude != "-73.9665" {
+				t.Errorf("PrivacyLocator.Locate() changed location = %s,%s", loc.Latitude, loc.Longitude)
+			}
+		})
+	}
+}
+
+func Test_roundLatLon(t *testing.T) {
+	tests := []struct {
+		latlon string
+		digits int
+		want   string
+	}{
+		{latlon: "40.7812,-73.9665", digits: 2, want: "40.78,-73.97"},
+		{latlon: "40.7812,-73.9665", digits: 0, want: "41,-74"},
+		{latlon: "40.7812,-73.9665", digits: -1, want: "40.7812,-73.9665"},
+		{latlon: "corrupt,1.25", digits: 1, want: ",1.3"},
+	}
+	for _, tt := range tests {
+		if got := roundLatLon(tt.latlon, tt.digits); got != tt.want {
+			t.Errorf("roundLatLon(%q, %d) = %q, want %q", tt.latlon, tt.digits, got, tt.want)
+		}
+	}
+}
@@ -6,37 +6,65 @@ import (
 	"math"
 	"net/http"
 	"strconv"
-
-	"github.com/m-lab/locate/static"
+	"strings"
+	"sync"
+	"time"
 )
 
 // UserLocator definition for accepting user provided location hints.
-type UserLocator struct{}
+type UserLocator struct {
+	// limit is the maximum number of user-provided locations accepted from a
+	// single client IP per window. A zero value disables the limit.
+	limit  int
+	window time.Duration
+
+	mu          sync.Mutex
+	counts      map[string]int
+	windowStart time.Time
+	now         func() time.Time
+}
 
 // Error values returned by Locate.
 var (
 	ErrNoUserParameters       = errors.New("no user location parameters provided")
 	ErrUnusableUserParameters = errors.New("user provided location parameters were unusable")
+	ErrUserParametersLimited  = errors.New("too many user provided locations from client")
 )
 
 // NewUserLocator creates a new UserLocator.
 func NewUserLocator() *UserLocator {
-	return &UserLocator{}
+	return NewUserLocatorWithLimit(0, 0)
+}
+
+// NewUserLocatorWithLimit creates a new UserLocator that accepts at most limit
+// user-provided locations from the same client IP within each window. Requests
+// over the limit fall through to the next Locator.
+func NewUserLocatorWithLimit(limit int, window time.Duration) *UserLocator {
+	return &UserLocator{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]int),
+		now:    time.Now,
+	}
 }
 
 // Locate looks for user-provided parameters to specify the client location.
 func (u *UserLocator) Locate(req *http.Request) (*Location, error) {
+	loc, err := u.locate(req)
+	if err != nil {
+		return nil, err
+	}
+	if u.isLimited(req) {
+		return nil, ErrUserParametersLimited
+	}
+	return loc, nil
+}
+
+func (u *UserLocator) locate(req *http.Request) (*Location, error) {
 	lat := req.URL.Query().Get("lat")
 	lon := req.URL.Query().Get("lon")
 	if lat != "" && lon != "" {
-		// Verify that these are valid floating values.
-		flat, errLat := strconv.ParseFloat(lat, 64)
-		flon, errLon := strconv.ParseFloat(lon, 64)
-		if errLat != nil || errLon != nil ||
-			math.IsNaN(flat) || math.IsInf(flat, 0) ||
-			math.IsNaN(flon) || math.IsInf(flon, 0) ||
-			-90 > flat || flat > 90 ||
-			-180 > flon || flon > 180 {
+		if !validLatLon(lat, lon) {
 			return nil, ErrUnusableUserParameters
 		}
 		loc := &Location{
@@ -48,8 +76,9 @@ func (u *UserLocator) Locate(req *http.Request) (*Location, error) {
 		loc.Headers.Set(hLocateClientlatlonMethod, "user-latlon")
 		return loc, nil
 	}
-	if ll, ok := static.Regions[req.URL.Query().Get("region")]; ok {
+	if ll := regionCentroid(strings.ToUpper(req.URL.Query().Get("region"))); ll != "" {
 		loc, err := splitLatLon(ll)
+		loc.AccuracyKm = RegionAccuracyKm
 		loc.Headers.Set(hLocateClientlatlon, ll)
 		loc.Headers.Set(hLocateClientlatlonMethod, "user-region")
 		return loc, err
@@ -58,9 +87,10 @@ func (u *UserLocator) Locate(req *http.Request) (*Location, error) {
 	// If the user requested a specific country without strict=true, set the
 	// lat/lon to the geographic center of that country. If the user requested
 	// a specific country with strict=true, keep lat/lon as it is.
-	if ll, ok := static.Countries[req.URL.Query().Get("country")]; ok &&
+	if ll := countryCentroid(req.URL.Query().Get("country")); ll != "" &&
 		req.URL.Query().Get("strict") != "true" {
 		loc, err := splitLatLon(ll)
+		loc.AccuracyKm = CountryAccuracyKm
 		loc.Headers.Set(hLocateClientlatlon, ll)
 		loc.Headers.Set(hLocateClientlatlonMethod, "user-country")
 		return loc, err
@@ -70,3 +100,38 @@ func (u *UserLocator) Locate(req *http.Request) (*Location, error) {
 
 // Reload does nothing.
 func (u *UserLocator) Reload(ctx context.Context) {}
+
+// isLimited counts a user-provided location from the request client IP and
+// reports whether the client has exceeded the limit for the current window.
+func (u *UserLocator) isLimited(req *http.Request) bool {
+	if u.limit <= 0 {
+		return false
+	}
+	ip, err := ClientIP(req)
+	if err != nil || ip == nil {
+		// Without a client IP there is nothing to count against.
+		return false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.now()
+	if now.Sub(u.windowStart) >= u.window {
+		u.windowStart = now
+		u.counts = make(map[string]int)
+	}
+	u.counts[ip.String()]++
+	return u.counts[ip.String()] > u.limit
+}
+
+// validLatLon reports whether lat and lon are finite floating point values
+// within the valid coordinate ranges.
+func validLatLon(lat, lon string) bool {
+	flat, errLat := strconv.ParseFloat(lat, 64)
+	flon, errLon := strconv.ParseFloat(lon, 64)
+	return errLat == nil && errLon == nil &&
+		!math.IsNaN(flat) && !math.IsInf(flat, 0) &&
+		!math.IsNaN(flon) && !math.IsInf(flon, 0) &&
+		-90 <= flat && flat <= 90 &&
+		-180 <= flon && flon <= 180
+}
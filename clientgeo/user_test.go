@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestUserLocator_Locate(t *testing.T) {
@@ -34,8 +35,9 @@ func TestUserLocator_Locate(t *testing.T) {
 		{
 			name: "success-user-region",
 			want: &Location{
-				Latitude:  "43.19880000",
-				Longitude: "-75.3242000",
+				Latitude:   "43.19880000",
+				Longitude:  "-75.3242000",
+				AccuracyKm: RegionAccuracyKm,
 				Headers: http.Header{
 					hLocateClientlatlon:       []string{"43.19880000,-75.3242000"},
 					hLocateClientlatlonMethod: []string{"user-region"},
@@ -45,11 +47,27 @@ func TestUserLocator_Locate(t *testing.T) {
 				"region": []string{"US-NY"},
 			},
 		},
+		{
+			name: "success-user-region-lowercase",
+			want: &Location{
+				Latitude:   "43.19880000",
+				Longitude:  "-75.3242000",
+				AccuracyKm: RegionAccuracyKm,
+				Headers: http.Header{
+					hLocateClientlatlon:       []string{"43.19880000,-75.3242000"},
+					hLocateClientlatlonMethod: []string{"user-region"},
+				},
+			},
+			vals: url.Values{
+				"region": []string{"us-ny"},
+			},
+		},
 		{
 			name: "success-user-country",
 			want: &Location{
-				Latitude:  "37.09024",
-				Longitude: "-95.712891",
+				Latitude:   "37.09024",
+				Longitude:  "-95.712891",
+				AccuracyKm: CountryAccuracyKm,
 				Headers: http.Header{
 					hLocateClientlatlon:       []string{"37.09024,-95.712891"},
 					hLocateClientlatlonMethod: []string{"user-country"},
@@ -129,3 +147,36 @@ func TestUserLocator_Locate(t *testing.T) {
 		})
 	}
 }
+
+func TestUserLocator_LocateWithLimit(t *testing.T) {
+	u := NewUserLocatorWithLimit(2, time.Minute)
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	u.now = func() time.Time { return now }
+
+	locate := func(remoteAddr string) error {
+		req := httptest.NewRequest(http.MethodGet, "/v2/nearest?lat=12&lon=34", nil)
+		req.RemoteAddr = remoteAddr
+		_, err := u.Locate(req)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := locate("1.2.3.4:1234"); err != nil {
+			t.Fatalf("UserLocator.Locate() returned error under limit: %v", err)
+		}
+	}
+	if err := locate("1.2.3.4:1234"); err != ErrUserParametersLimited {
+		t.Errorf("UserLocator.Locate() over limit; got %v, want %v", err, ErrUserParametersLimited)
+	}
+	if err := locate("5.6.7.8:1234"); err != nil {
+		t.Errorf("UserLocator.Locate() limited a different client: %v", err)
+	}
+	if err := locate("invalid-addr"); err != nil {
+		t.Errorf("UserLocator.Locate() limited a client without IP: %v", err)
+	}
+
+	// The limit resets once the window elapses.
+	now = now.Add(time.Minute)
+	if err := locate("1.2.3.4:1234"); err != nil {
+		t.Errorf("UserLocator.Locate() returned error in new window: %v", err)
+	}
+}
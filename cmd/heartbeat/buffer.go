@@ -0,0 +1,48 @@
+package main
+
+import (
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// healthBuffer is a bounded queue of health messages that could not be sent
+// while the connection was down. When full, the oldest message is dropped.
+type healthBuffer struct {
+	size int
+	msgs []v2.HeartbeatMessage
+}
+
+// newHealthBuffer returns a new healthBuffer holding up to size messages. A
+// size of zero disables buffering.
+func newHealthBuffer(size int) *healthBuffer {
+	return &healthBuffer{size: size}
+}
+
+// add appends a message to the buffer, dropping the oldest message if the
+// buffer is full.
+func (b *healthBuffer) add(hbm v2.HeartbeatMessage) {
+	if b.size <= 0 {
+		return
+	}
+	if len(b.msgs) >= b.size {
+		b.msgs = b.msgs[1:]
+	}
+	b.msgs = append(b.msgs, hbm)
+}
+
+// len returns the number of buffered messages.
+func (b *healthBuffer) len() int {
+	return len(b.msgs)
+}
+
+// flush sends the buffered messages from oldest to newest. It stops at the
+// first error, keeping the unsent messages.
+func (b *healthBuffer) flush(send func(v2.HeartbeatMessage) error) error {
+	for len(b.msgs) > 0 {
+		if err := send(b.msgs[0]); err != nil {
+			return err
+		}
+		b.msgs = b.msgs[1:]
+	}
+	b.msgs = nil
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+func healthMsg(ts int64) v2.HeartbeatMessage {
+	return v2.HeartbeatMessage{Health: &v2.Health{Score: 1, Timestamp: ts}}
+}
+
+func timestamps(msgs []v2.HeartbeatMessage) []int64 {
+	ts := []int64{}
+	for _, m := range msgs {
+		ts = append(ts, m.Health.Timestamp)
+	}
+	return ts
+}
+
+func Test_healthBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		add      []int64
+		failAt   int // Number of successful sends before failing (-1 never fails).
+		wantSent []int64
+		wantLeft []int64
+		wantErr  bool
+	}{
+		{
+			name:     "replay-in-order",
+			size:     3,
+			add:      []int64{1, 2},
+			failAt:   -1,
+			wantSent: []int64{1, 2},
+			wantLeft: []int64{},
+		},
+		{
+			name:     "drop-oldest",
+			size:     2,
+			add:      []int64{1, 2, 3},
+			failAt:   -1,
+			wantSent: []int64{2, 3},
+			wantLeft: []int64{},
+		},
+		{
+			name:     "disabled",
+			size:     0,
+			add:      []int64{1, 2},
+			failAt:   -1,
+			wantSent: []int64{},
+			wantLeft: []int64{},
+		},
+		{
+			name:     "keep-unsent",
+			size:     3,
+			add:      []int64{1, 2, 3},
+			failAt:   1,
+			wantSent: []int64{1},
+			wantLeft: []int64{2, 3},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newHealthBuffer(tt.size)
+			for _, ts := range tt.add {
+				b.add(healthMsg(ts))
+			}
+			sent := []v2.HeartbeatMessage{}
+			err := b.flush(func(m v2.HeartbeatMessage) error {
+				if tt.failAt >= 0 && len(sent) == tt.failAt {
+					return errors.New("fake write error")
+				}
+				sent = append(sent, m)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("healthBuffer.flush() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := timestamps(sent); !reflect.DeepEqual(got, tt.wantSent) {
+				t.Errorf("healthBuffer.flush() sent = %v, want %v", got, tt.wantSent)
+			}
+			if got := timestamps(b.msgs); !reflect.DeepEqual(got, tt.wantLeft) {
+				t.Errorf("healthBuffer.flush() left = %v, want %v", got, tt.wantLeft)
+			}
+		})
+	}
+}
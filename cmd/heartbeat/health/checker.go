@@ -1,6 +1,9 @@
 package health
 
 import (
+	"math"
+	"sync"
+
 	"golang.org/x/net/context"
 )
 
@@ -9,6 +12,11 @@ type Checker struct {
 	pp  *PortProbe
 	k8s *KubernetesClient
 	ec  *EndpointClient
+	sys *SystemChecker
+
+	mu         sync.Mutex
+	components map[string]float64
+	services   map[string]float64
 }
 
 // NewChecker creates a new Checker.
@@ -28,22 +36,130 @@ func NewCheckerK8S(pp *PortProbe, k8s *KubernetesClient, ec *EndpointClient) *Ch
 	}
 }
 
-// GetHealth combines a set of health checks into a single score.
+// WithSystemChecker factors the system metrics scored by sc into the health
+// score, and returns the Checker.
+func (hc *Checker) WithSystemChecker(sc *SystemChecker) *Checker {
+	hc.sys = sc
+	return hc
+}
+
+// SetServices replaces the services whose ports are checked.
+func (hc *Checker) SetServices(services map[string][]string) {
+	hc.pp.SetServices(services)
+}
+
+// GetHealth combines a set of health checks into a single score. The score is
+// 0 if any check fails. Otherwise, it is the system score if a SystemChecker
+// is configured, or 1. If only some of the services have their ports open,
+// the port check does not fail and the closed services are reported by
+// ServiceScores instead.
 func (hc *Checker) GetHealth(ctx context.Context) float64 {
-	if !hc.pp.checkPorts() {
-		return 0
+	c := map[string]float64{}
+	var services map[string]float64
+	defer func() { hc.setComponents(c, services) }()
+
+	if hc.pp.dualStack() {
+		// Report each address family so that v6-only breakage is visible.
+		c["ports"] = 1
+		for f, ok := range hc.pp.checkFamilies() {
+			c["ports_"+f] = score(ok)
+			c["ports"] = math.Min(c["ports"], c["ports_"+f])
+		}
+	} else {
+		c["ports"] = score(hc.pp.checkPorts())
+	}
+	if c["ports"] == 0 {
+		services = hc.closedServices()
+		if services == nil {
+			return 0
+		}
 	}
 
-	if hc.k8s != nil && !hc.k8s.isHealthy(ctx) {
-		return 0
+	if hc.k8s != nil {
+		c["kubernetes"] = score(hc.k8s.isHealthy(ctx))
+		if c["kubernetes"] == 0 {
+			return 0
+		}
 	}
 
 	// Some experiments might not support a /health endpoint, so
 	// the result is only taken into account if the request error
 	// is nil.
 	status, err := hc.ec.checkHealthEndpoint()
-	if err == nil && !status {
-		return 0
+	if err == nil {
+		c["endpoint"] = score(status)
+		if !status {
+			return 0
+		}
+	}
+
+	if hc.sys == nil {
+		return 1
+	}
+	total := 1.0
+	for resource, s := range hc.sys.scores() {
+		c[resource] = s
+		total = math.Min(total, s)
+	}
+	return total
+}
+
+// Components returns the scores of the individual checks evaluated by the
+// last call to GetHealth. Checks after the first failure are not evaluated.
+func (hc *Checker) Components() map[string]float64 {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	c := make(map[string]float64, len(hc.components))
+	for k, v := range hc.components {
+		c[k] = v
+	}
+	return c
+}
+
+// ServiceScores returns the scores of the services whose ports were closed
+// during the last call to GetHealth, or nil if all services share the overall
+// score.
+func (hc *Checker) ServiceScores() map[string]float64 {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.services == nil {
+		return nil
+	}
+	s := make(map[string]float64, len(hc.services))
+	for k, v := range hc.services {
+		s[k] = v
+	}
+	return s
+}
+
+func (hc *Checker) setComponents(c, services map[string]float64) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.components = c
+	hc.services = services
+}
+
+// closedServices checks the ports of each service and returns a score of 0
+// for those whose ports are closed, if the ports of some other service are
+// open. Otherwise, it returns nil. Open services use the overall score.
+func (hc *Checker) closedServices() map[string]float64 {
+	s := make(map[string]float64)
+	results := hc.pp.checkServices()
+	for service, ok := range results {
+		if !ok {
+			s[service] = 0
+		}
+	}
+	if len(s) == 0 || len(s) == len(results) {
+		return nil
+	}
+	return s
+}
+
+// score converts the result of a check into a score.
+func score(healthy bool) float64 {
+	if healthy {
+		return 1
 	}
-	return 1
+	return 0
 }
@@ -2,6 +2,7 @@ package health
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
 	"github.com/m-lab/locate/cmd/heartbeat/health/healthtest"
@@ -126,6 +127,18 @@ func TestChecker_getHealth(t *testing.T) {
 			),
 			want: 0,
 		},
+		{
+			name: "system-constrained",
+			checker: NewChecker(
+				&PortProbe{},
+				&EndpointClient{},
+			).WithSystemChecker(&SystemChecker{
+				config: SystemConfig{ProcPath: "testdata/proc", Threshold: 0.5},
+				cpus:   4,
+			}),
+			endpointStatus: 200,
+			want:           0.5,
+		},
 		{
 			name: "all-unhealthy-k8s-nil",
 			checker: NewChecker(
@@ -153,3 +166,67 @@ func TestChecker_getHealth(t *testing.T) {
 		})
 	}
 }
+
+func TestChecker_Components(t *testing.T) {
+	srv := healthtest.TestHealthServer(500)
+	healthAddress = srv.URL + "/health"
+	defer srv.Close()
+
+	hc := NewCheckerK8S(&PortProbe{}, &KubernetesClient{clientset: healthyClientset}, &EndpointClient{})
+	if got := hc.Components(); len(got) != 0 {
+		t.Errorf("Checker.Components() before GetHealth = %v, want empty", got)
+	}
+	hc.GetHealth(context.Background())
+	want := map[string]float64{"ports": 1, "kubernetes": 1, "endpoint": 0}
+	if got := hc.Components(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Checker.Components() = %v, want %v", got, want)
+	}
+
+	hc = NewChecker(NewDualStackPortProbe(map[string][]string{}), &EndpointClient{})
+	hc.GetHealth(context.Background())
+	want = map[string]float64{"ports": 1, "ports_ipv4": 1, "ports_ipv6": 1, "endpoint": 0}
+	if got := hc.Components(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Checker.Components() dual-stack = %v, want %v", got, want)
+	}
+}
+
+func TestChecker_ServiceScores(t *testing.T) {
+	srv := healthtest.TestHealthServer(200)
+	defer srv.Close()
+	healthAddress = srv.URL + "/health"
+
+	tests := []struct {
+		name     string
+		services map[string][]string
+		want     float64
+		wantSvcs map[string]float64
+	}{
+		{
+			name:     "all-open",
+			services: map[string][]string{"ndt/ndt5": {srv.URL}, "ndt/ndt7": {srv.URL}},
+			want:     1,
+		},
+		{
+			name:     "some-closed",
+			services: map[string][]string{"ndt/ndt5": {"ws://:65536/ndt_protocol"}, "ndt/ndt7": {srv.URL}},
+			want:     1,
+			wantSvcs: map[string]float64{"ndt/ndt5": 0},
+		},
+		{
+			name:     "all-closed",
+			services: map[string][]string{"ndt/ndt5": {"ws://:65536/ndt_protocol"}, "ndt/ndt7": {"ws://:65536/ndt/v7"}},
+			want:     0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := NewChecker(NewPortProbe(tt.services), &EndpointClient{})
+			if got := hc.GetHealth(context.Background()); got != tt.want {
+				t.Errorf("Checker.GetHealth() = %v, want %v", got, tt.want)
+			}
+			if got := hc.ServiceScores(); !reflect.DeepEqual(got, tt.wantSvcs) {
+				t.Errorf("Checker.ServiceScores() = %v, want %v", got, tt.wantSvcs)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m-lab/locate/metrics"
+)
+
+// waitDelay bounds the wait for the program's output after it is killed.
+var waitDelay = time.Second
+
+// ExecChecker runs an operator-provided program to generate a health score,
+// so that platforms may encode their own health logic.
+//
+// The program is healthy (score 1) if it exits with status 0 and prints
+// nothing. It may instead print a score between 0 and 1 on the first line of
+// its output. Any other exit status, output or a timeout is unhealthy (score 0).
+type ExecChecker struct {
+	path string
+	args []string
+	env  []string
+}
+
+// NewExecChecker returns a new ExecChecker that runs the program at path with
+// the given arguments. The program also receives the variables in env (e.g.,
+// "HEARTBEAT_EXPERIMENT=ndt") in addition to the agent's environment.
+func NewExecChecker(path string, args []string, env []string) *ExecChecker {
+	return &ExecChecker{
+		path: path,
+		args: args,
+		env:  env,
+	}
+}
+
+// GetHealth runs the program and converts its result into a health score.
+// The program is killed when ctx is done.
+func (c *ExecChecker) GetHealth(ctx context.Context) float64 {
+	cmd := exec.CommandContext(ctx, c.path, c.args...)
+	cmd.Env = append(os.Environ(), c.env...)
+	// Do not wait for child processes holding the output open after a kill.
+	cmd.WaitDelay = waitDelay
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		metrics.HealthExecChecksTotal.WithLabelValues("timeout").Inc()
+		return 0
+	}
+	if err != nil {
+		metrics.HealthExecChecksTotal.WithLabelValues("exit error").Inc()
+		return 0
+	}
+
+	line := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if line == "" {
+		metrics.HealthExecChecksTotal.WithLabelValues("OK").Inc()
+		return 1
+	}
+	score, err := strconv.ParseFloat(line, 64)
+	if err != nil || score < 0 || score > 1 {
+		metrics.HealthExecChecksTotal.WithLabelValues("invalid output").Inc()
+		return 0
+	}
+	metrics.HealthExecChecksTotal.WithLabelValues("OK").Inc()
+	return score
+}
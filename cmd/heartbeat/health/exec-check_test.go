@@ -0,0 +1,74 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecChecker_GetHealth(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		env     []string
+		timeout time.Duration
+		want    float64
+	}{
+		{
+			name:   "healthy-no-output",
+			script: "exit 0",
+			want:   1,
+		},
+		{
+			name:   "fractional-score",
+			script: "echo 0.5",
+			want:   0.5,
+		},
+		{
+			name:   "score-first-line",
+			script: "echo ' 0.25 '; echo details",
+			want:   0.25,
+		},
+		{
+			name:   "env",
+			script: `test "$HEARTBEAT_EXPERIMENT" = ndt`,
+			env:    []string{"HEARTBEAT_EXPERIMENT=ndt"},
+			want:   1,
+		},
+		{
+			name:   "exit-error",
+			script: "echo 1; exit 1",
+			want:   0,
+		},
+		{
+			name:   "invalid-output",
+			script: "echo healthy",
+			want:   0,
+		},
+		{
+			name:   "score-out-of-range",
+			script: "echo 2",
+			want:   0,
+		},
+		{
+			name:    "timeout",
+			script:  "exec sleep 5",
+			timeout: 100 * time.Millisecond,
+			want:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			c := NewExecChecker("/bin/sh", []string{"-c", tt.script}, tt.env)
+			if got := c.GetHealth(ctx); got != tt.want {
+				t.Errorf("ExecChecker.GetHealth() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
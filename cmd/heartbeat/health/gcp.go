@@ -11,6 +11,7 @@ import (
 // GCPChecker queries the VM's load balancer to check its status.
 type GCPChecker struct {
 	client GCEClient
+	global GlobalGCEClient
 	md     Metadata
 }
 
@@ -27,7 +28,14 @@ type GCEClient interface {
 	GetHealth(context.Context, *computepb.GetHealthRegionBackendServiceRequest, ...gax.CallOption) (*computepb.BackendServiceGroupHealth, error)
 }
 
-// NewGCPChecker returns a new instance of GCPChecker.
+// GlobalGCEClient queries the Compute API for health updates of global
+// backend services.
+type GlobalGCEClient interface {
+	GetHealth(context.Context, *computepb.GetHealthBackendServiceRequest, ...gax.CallOption) (*computepb.BackendServiceGroupHealth, error)
+}
+
+// NewGCPChecker returns a new instance of GCPChecker for a regional backend
+// service.
 func NewGCPChecker(c GCEClient, md Metadata) *GCPChecker {
 	return &GCPChecker{
 		client: c,
@@ -35,19 +43,19 @@ func NewGCPChecker(c GCEClient, md Metadata) *GCPChecker {
 	}
 }
 
+// NewGlobalGCPChecker returns a new instance of GCPChecker for a global
+// backend service.
+func NewGlobalGCPChecker(c GlobalGCEClient, md Metadata) *GCPChecker {
+	return &GCPChecker{
+		global: c,
+		md:     md,
+	}
+}
+
 // GetHealth contacts the GCP load balancer to get the latest VM health status
 // and uses the data to generate a health score.
 func (c *GCPChecker) GetHealth(ctx context.Context) float64 {
-	g := c.md.Group()
-	req := &computepb.GetHealthRegionBackendServiceRequest{
-		BackendService: c.md.Backend(),
-		Project:        c.md.Project(),
-		Region:         c.md.Region(),
-		ResourceGroupReferenceResource: &computepb.ResourceGroupReference{
-			Group: &g,
-		},
-	}
-	lbHealth, err := c.client.GetHealth(ctx, req)
+	lbHealth, err := c.getGroupHealth(ctx)
 	if err != nil {
 		return 0
 	}
@@ -61,3 +69,25 @@ func (c *GCPChecker) GetHealth(ctx context.Context) float64 {
 
 	return 0
 }
+
+// getGroupHealth returns the health of the instance group in the regional or
+// global backend service.
+func (c *GCPChecker) getGroupHealth(ctx context.Context) (*computepb.BackendServiceGroupHealth, error) {
+	g := c.md.Group()
+	group := &computepb.ResourceGroupReference{
+		Group: &g,
+	}
+	if c.global != nil {
+		return c.global.GetHealth(ctx, &computepb.GetHealthBackendServiceRequest{
+			BackendService:                 c.md.Backend(),
+			Project:                        c.md.Project(),
+			ResourceGroupReferenceResource: group,
+		})
+	}
+	return c.client.GetHealth(ctx, &computepb.GetHealthRegionBackendServiceRequest{
+		BackendService:                 c.md.Backend(),
+		Project:                        c.md.Project(),
+		Region:                         c.md.Region(),
+		ResourceGroupReferenceResource: group,
+	})
+}
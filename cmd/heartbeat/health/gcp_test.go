@@ -66,6 +66,80 @@ func TestGCPChecker_GetHealth(t *testing.T) {
 	}
 }
 
+func TestGCPChecker_GetHealthGlobal(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *fakeGlobalGCEClient
+		want   float64
+	}{
+		{
+			name:   "healthy",
+			client: &fakeGlobalGCEClient{status: []string{"UNHEALTHY", "HEALTHY"}},
+			want:   1,
+		},
+		{
+			name:   "unhealthy",
+			client: &fakeGlobalGCEClient{status: []string{"UNHEALTHY"}},
+			want:   0,
+		},
+		{
+			name:   "error",
+			client: &fakeGlobalGCEClient{err: true},
+			want:   0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := metadata.NewGCPMetadataWithTopology(&fakeMetadataClient{}, "ndt-mlab1-lga0t.mlab-sandbox.measurement-lab.org",
+				metadata.Topology{GlobalBackend: true, ZonalGroup: true, Group: "ndt-mig"})
+			if err != nil {
+				t.Fatalf("NewGCPMetadataWithTopology() error = %v", err)
+			}
+			c := NewGlobalGCPChecker(tt.client, md)
+			if got := c.GetHealth(context.Background()); got != tt.want {
+				t.Errorf("GCPChecker.GetHealth() = %v, want %v", got, tt.want)
+			}
+			wantGroup := "https://www.googleapis.com/compute/v1/projects/mlab-sandbox/zones/us-east1-b/instanceGroups/ndt-mig"
+			if tt.client.req.GetResourceGroupReferenceResource().GetGroup() != wantGroup {
+				t.Errorf("GCPChecker.GetHealth() group = %q, want %q",
+					tt.client.req.GetResourceGroupReferenceResource().GetGroup(), wantGroup)
+			}
+		})
+	}
+}
+
+type fakeMetadataClient struct{}
+
+func (c *fakeMetadataClient) ProjectID() (string, error) {
+	return "mlab-sandbox", nil
+}
+
+func (c *fakeMetadataClient) Zone() (string, error) {
+	return "us-east1-b", nil
+}
+
+type fakeGlobalGCEClient struct {
+	status []string
+	err    bool
+	req    *computepb.GetHealthBackendServiceRequest
+}
+
+func (c *fakeGlobalGCEClient) GetHealth(ctx context.Context, req *computepb.GetHealthBackendServiceRequest, opts ...gax.CallOption) (*computepb.BackendServiceGroupHealth, error) {
+	c.req = req
+	if c.err {
+		return nil, errors.New("health error")
+	}
+
+	health := make([]*computepb.HealthStatus, 0)
+	for _, s := range c.status {
+		statusPtr := s
+		health = append(health, &computepb.HealthStatus{HealthState: &statusPtr})
+	}
+	return &computepb.BackendServiceGroupHealth{
+		HealthStatus: health,
+	}, nil
+}
+
 type fakeGCEClient struct {
 	status []string
 	err    bool
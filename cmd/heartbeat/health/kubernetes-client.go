@@ -2,8 +2,12 @@ package health
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/url"
+	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -47,6 +51,83 @@ func MustNewKubernetesClient(url *url.URL, pod, node, namespace, auth string) *K
 	return client
 }
 
+// PodInfo identifies the pod of the agent in the cluster.
+type PodInfo struct {
+	Pod       string
+	Node      string
+	Namespace string
+}
+
+// InClusterURL returns the URL of the Kubernetes API server advertised to the
+// pod, or nil when not running in a cluster.
+func InClusterURL() *url.URL {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil
+	}
+	return &url.URL{Scheme: "https", Host: net.JoinHostPort(host, port)}
+}
+
+// DiscoverPod fills in the fields of info that are empty from the in-cluster
+// configuration. The pod name and namespace are read from the downward API
+// volume at podinfo (files "name" and "namespace"), falling back to the pod's
+// hostname and the service account namespace at auth. The node is read from
+// the pod's spec through the API server at url.
+func DiscoverPod(ctx context.Context, url *url.URL, auth, podinfo string, info PodInfo) (PodInfo, error) {
+	restConfig, err := getDefaultClientConfig(url, auth).ClientConfig()
+	if err != nil {
+		return info, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return info, err
+	}
+	return discoverPod(ctx, clientset, auth, podinfo, info)
+}
+
+func discoverPod(ctx context.Context, clientset kubernetes.Interface, auth, podinfo string, info PodInfo) (PodInfo, error) {
+	var err error
+	if info.Pod == "" {
+		info.Pod, err = readFirst(path.Join(podinfo, "name"))
+		if err != nil {
+			info.Pod, err = os.Hostname()
+		}
+		if err != nil {
+			return info, err
+		}
+	}
+	if info.Namespace == "" {
+		info.Namespace, err = readFirst(path.Join(podinfo, "namespace"), path.Join(auth, "namespace"))
+		if err != nil {
+			return info, err
+		}
+	}
+	if info.Node == "" {
+		pod, err := clientset.CoreV1().Pods(info.Namespace).Get(ctx, info.Pod, metav1.GetOptions{})
+		if err != nil {
+			return info, err
+		}
+		if pod.Spec.NodeName == "" {
+			return info, fmt.Errorf("pod %s is not scheduled on a node", info.Pod)
+		}
+		info.Node = pod.Spec.NodeName
+	}
+	return info, nil
+}
+
+// readFirst returns the trimmed content of the first file that can be read.
+func readFirst(files ...string) (string, error) {
+	err := errors.New("no files given")
+	for _, f := range files {
+		var b []byte
+		b, err = os.ReadFile(f)
+		if err == nil {
+			return strings.TrimSpace(string(b)), nil
+		}
+	}
+	return "", err
+}
+
 func getDefaultClientConfig(url *url.URL, auth string) clientcmd.ClientConfig {
 	// This is a low-level structure normally created from parsing a kubeconfig
 	// file.  Since we know all values we can create the client object directly.
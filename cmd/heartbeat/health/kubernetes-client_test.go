@@ -9,6 +9,7 @@ import (
 	"github.com/go-test/deep"
 	"github.com/m-lab/go/testingx"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 )
@@ -170,3 +171,76 @@ func Test_extractError(t *testing.T) {
 		})
 	}
 }
+
+func TestInClusterURL(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if got := InClusterURL(); got != nil {
+		t.Errorf("InClusterURL() = %v, want nil", got)
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	if got := InClusterURL(); got == nil || got.String() != "https://10.0.0.1:443" {
+		t.Errorf("InClusterURL() = %v, want https://10.0.0.1:443", got)
+	}
+}
+
+func Test_discoverPod(t *testing.T) {
+	scheduledPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ndt-abcde", Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: "mlab1-lga0t.mlab-sandbox.measurement-lab.org"},
+	}
+	unscheduledPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ndt-abcde", Namespace: "default"},
+	}
+
+	tests := []struct {
+		name      string
+		clientset kubernetes.Interface
+		podinfo   string
+		info      PodInfo
+		want      PodInfo
+		wantErr   bool
+	}{
+		{
+			name:      "discover-all",
+			clientset: fake.NewSimpleClientset(scheduledPod),
+			podinfo:   "testdata/podinfo/",
+			want: PodInfo{
+				Pod:       "ndt-abcde",
+				Node:      "mlab1-lga0t.mlab-sandbox.measurement-lab.org",
+				Namespace: "default",
+			},
+		},
+		{
+			name:      "keep-given",
+			clientset: fake.NewSimpleClientset(),
+			podinfo:   "testdata/podinfo/",
+			info:      PodInfo{Pod: "ndt-fghij", Node: "node", Namespace: "ndt"},
+			want:      PodInfo{Pod: "ndt-fghij", Node: "node", Namespace: "ndt"},
+		},
+		{
+			name:      "pod-not-found",
+			clientset: fake.NewSimpleClientset(),
+			podinfo:   "testdata/podinfo/",
+			wantErr:   true,
+		},
+		{
+			name:      "pod-not-scheduled",
+			clientset: fake.NewSimpleClientset(unscheduledPod),
+			podinfo:   "testdata/podinfo/",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := discoverPod(context.Background(), tt.clientset, "testdata/", tt.podinfo, tt.info)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("discoverPod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("discoverPod() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
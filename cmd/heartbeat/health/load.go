@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/m-lab/locate/metrics"
+	"github.com/prometheus/common/expfmt"
+)
+
+// LoadClient reads the number of tests in progress from the Prometheus
+// metrics of the local experiment (e.g., ndt-server's /metrics).
+type LoadClient struct {
+	client   http.Client
+	url      string
+	metric   string
+	capacity int
+}
+
+// NewLoadClient returns a new LoadClient that sums the samples of the given
+// gauge or counter metric at url. Capacity is the maximum number of concurrent
+// tests configured for the experiment, or zero if unknown.
+func NewLoadClient(url, metric string, capacity int, timeout time.Duration) *LoadClient {
+	return &LoadClient{
+		client:   http.Client{Timeout: timeout},
+		url:      url,
+		metric:   metric,
+		capacity: capacity,
+	}
+}
+
+// Capacity returns the maximum number of concurrent tests, or zero if
+// unknown.
+func (lc *LoadClient) Capacity() int {
+	return lc.capacity
+}
+
+// GetLoad returns the number of tests in progress.
+func (lc *LoadClient) GetLoad(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lc.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := lc.client.Do(req)
+	if err != nil {
+		metrics.LoadChecksTotal.WithLabelValues("HTTP request error").Inc()
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		metrics.LoadChecksTotal.WithLabelValues(http.StatusText(resp.StatusCode)).Inc()
+		return 0, fmt.Errorf("failed to read metrics, status: %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		metrics.LoadChecksTotal.WithLabelValues("parse error").Inc()
+		return 0, err
+	}
+	family, ok := families[lc.metric]
+	if !ok {
+		metrics.LoadChecksTotal.WithLabelValues("metric not found").Inc()
+		return 0, fmt.Errorf("metric %s not found", lc.metric)
+	}
+	load := 0.0
+	for _, m := range family.GetMetric() {
+		switch {
+		case m.Gauge != nil:
+			load += m.Gauge.GetValue()
+		case m.Counter != nil:
+			load += m.Counter.GetValue()
+		case m.Untyped != nil:
+			load += m.Untyped.GetValue()
+		}
+	}
+	metrics.LoadChecksTotal.WithLabelValues("OK").Inc()
+	return int(load), nil
+}
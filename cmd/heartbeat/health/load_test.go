@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const fakeMetrics = `# HELP ndt7_client_test_active Number of active tests.
+# TYPE ndt7_client_test_active gauge
+ndt7_client_test_active{direction="download"} 3
+ndt7_client_test_active{direction="upload"} 2
+# HELP ndt7_client_test_total Number of tests.
+# TYPE ndt7_client_test_total counter
+ndt7_client_test_total 42
+`
+
+func TestLoadClient_GetLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  string
+		status  int
+		body    string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:   "gauge",
+			metric: "ndt7_client_test_active",
+			status: http.StatusOK,
+			body:   fakeMetrics,
+			want:   5,
+		},
+		{
+			name:   "counter",
+			metric: "ndt7_client_test_total",
+			status: http.StatusOK,
+			body:   fakeMetrics,
+			want:   42,
+		},
+		{
+			name:    "metric-not-found",
+			metric:  "missing",
+			status:  http.StatusOK,
+			body:    fakeMetrics,
+			wantErr: true,
+		},
+		{
+			name:    "invalid-metrics",
+			metric:  "ndt7_client_test_active",
+			status:  http.StatusOK,
+			body:    "invalid metrics {",
+			wantErr: true,
+		},
+		{
+			name:    "http-error",
+			metric:  "ndt7_client_test_active",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(tt.status)
+				rw.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			lc := NewLoadClient(srv.URL, tt.metric, 10, time.Second)
+			got, err := lc.GetLoad(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadClient.GetLoad() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("LoadClient.GetLoad() = %d, want %d", got, tt.want)
+			}
+			if lc.Capacity() != 10 {
+				t.Errorf("LoadClient.Capacity() = %d, want 10", lc.Capacity())
+			}
+		})
+	}
+}
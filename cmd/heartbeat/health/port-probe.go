@@ -13,24 +13,97 @@ const (
 	defaultPortSecure = "443"
 )
 
+// Address families checked by a dual-stack PortProbe.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// loopback maps each address family to its network and loopback address.
+var loopback = map[string][2]string{
+	FamilyIPv4: {"tcp4", "127.0.0.1"},
+	FamilyIPv6: {"tcp6", "::1"},
+}
+
 // PortProbe checks whether a set of ports are open.
 type PortProbe struct {
-	ports map[string]bool
+	ports    map[string]bool
+	services map[string]map[string]bool // Ports of each service.
+	families []string
 }
 
 // NewPortProbe creates a new PortProbe.
 func NewPortProbe(services map[string][]string) *PortProbe {
 	pp := PortProbe{
-		ports: getPorts(services),
+		ports:    getPorts(services),
+		services: getServicePorts(services),
 	}
 	return &pp
 }
 
+// SetServices replaces the set of ports with those of services.
+func (ps *PortProbe) SetServices(services map[string][]string) {
+	ps.ports = getPorts(services)
+	ps.services = getServicePorts(services)
+}
+
+// NewDualStackPortProbe creates a new PortProbe that checks the ports are
+// open over both IPv4 and IPv6.
+func NewDualStackPortProbe(services map[string][]string) *PortProbe {
+	pp := PortProbe{
+		ports:    getPorts(services),
+		services: getServicePorts(services),
+		families: []string{FamilyIPv4, FamilyIPv6},
+	}
+	return &pp
+}
+
+// dualStack returns whether the ports are checked for each address family.
+func (ps *PortProbe) dualStack() bool {
+	return len(ps.families) > 0
+}
+
 // checkPorts returns true if all the given ports are open and false
-// otherwise.
+// otherwise. A dual-stack probe requires them to be open over all address
+// families.
 func (ps *PortProbe) checkPorts() bool {
+	if !ps.dualStack() {
+		return ps.checkHost("tcp", "localhost")
+	}
+	for _, ok := range ps.checkFamilies() {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// checkServices returns whether the ports of each service are open. A
+// dual-stack probe requires them to be open over all address families.
+func (ps *PortProbe) checkServices() map[string]bool {
+	results := make(map[string]bool, len(ps.services))
+	for service, ports := range ps.services {
+		p := &PortProbe{ports: ports, families: ps.families}
+		results[service] = p.checkPorts()
+	}
+	return results
+}
+
+// checkFamilies returns whether all the given ports are open over each
+// address family.
+func (ps *PortProbe) checkFamilies() map[string]bool {
+	results := make(map[string]bool, len(ps.families))
+	for _, f := range ps.families {
+		results[f] = ps.checkHost(loopback[f][0], loopback[f][1])
+	}
+	return results
+}
+
+// checkHost returns true if all the given ports are open on host and false
+// otherwise.
+func (ps *PortProbe) checkHost(network, host string) bool {
 	for p := range ps.ports {
-		conn, err := net.DialTimeout("tcp", "localhost:"+p, time.Second)
+		conn, err := net.DialTimeout(network, net.JoinHostPort(host, p), time.Second)
 		if err != nil {
 			metrics.PortChecksTotal.WithLabelValues(err.Error()).Inc()
 			return false
@@ -63,6 +136,16 @@ func getPorts(services map[string][]string) map[string]bool {
 	return ports
 }
 
+// getServicePorts extracts the set of ports of each service from a map of
+// service names to their URL templates.
+func getServicePorts(services map[string][]string) map[string]map[string]bool {
+	ports := make(map[string]map[string]bool, len(services))
+	for name, urls := range services {
+		ports[name] = getPorts(map[string][]string{name: urls})
+	}
+	return ports
+}
+
 // getPort extracts the port from a single URL. If no port is specified,
 // it sets a default.
 func getPort(url url.URL) string {
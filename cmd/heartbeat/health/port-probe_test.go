@@ -1,6 +1,7 @@
 package health
 
 import (
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -70,6 +71,82 @@ func TestPortProbe_scanPorts(t *testing.T) {
 	}
 }
 
+func TestPortProbe_checkServices(t *testing.T) {
+	srv := httptest.NewServer(http.NewServeMux())
+	defer srv.Close()
+
+	pp := NewPortProbe(map[string][]string{
+		"ndt/ndt7": {srv.URL},
+		"ndt/ndt5": {srv.URL, "ws://:65536/ndt_protocol"},
+	})
+	want := map[string]bool{"ndt/ndt7": true, "ndt/ndt5": false}
+	if got := pp.checkServices(); !reflect.DeepEqual(got, want) {
+		t.Errorf("PortProbe.checkServices() = %v, want %v", got, want)
+	}
+}
+
+func TestPortProbe_checkFamilies(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+		want    map[string]bool
+	}{
+		{
+			name:    "ipv4-only",
+			network: "tcp4",
+			address: "127.0.0.1:0",
+			want:    map[string]bool{FamilyIPv4: true, FamilyIPv6: false},
+		},
+		{
+			name:    "ipv6-only",
+			network: "tcp6",
+			address: "[::1]:0",
+			want:    map[string]bool{FamilyIPv4: false, FamilyIPv6: true},
+		},
+		{
+			name:    "dual-stack",
+			network: "tcp",
+			address: ":0",
+			want:    map[string]bool{FamilyIPv4: true, FamilyIPv6: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen(tt.network, tt.address)
+			if err != nil {
+				t.Skipf("cannot listen on %s: %v", tt.address, err)
+			}
+			defer l.Close()
+			if tt.want[FamilyIPv6] && !ipv6Loopback() {
+				t.Skip("IPv6 loopback is not available")
+			}
+			_, port, _ := net.SplitHostPort(l.Addr().String())
+
+			pp := NewDualStackPortProbe(map[string][]string{"test": {"ws://:" + port}})
+			got := pp.checkFamilies()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PortProbe.checkFamilies() = %v, want %v", got, tt.want)
+			}
+			wantPorts := tt.want[FamilyIPv4] && tt.want[FamilyIPv6]
+			if got := pp.checkPorts(); got != wantPorts {
+				t.Errorf("PortProbe.checkPorts() = %v, want %v", got, wantPorts)
+			}
+		})
+	}
+}
+
+// ipv6Loopback returns whether the IPv6 loopback address is usable.
+func ipv6Loopback() bool {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
 func Test_getPorts(t *testing.T) {
 	tests := []struct {
 		name     string
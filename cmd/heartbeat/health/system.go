@@ -0,0 +1,228 @@
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/m-lab/locate/metrics"
+)
+
+// errNotConfigured is returned for resources that are not checked.
+var errNotConfigured = errors.New("not configured")
+
+// SystemConfig configures the system metrics factored into a health score.
+type SystemConfig struct {
+	// ProcPath and SysPath are the mount points of procfs and sysfs.
+	ProcPath string
+	SysPath  string
+	// DiskPath is a path on the filesystem checked for disk pressure. Disk
+	// pressure is not checked when empty.
+	DiskPath string
+	// Interface is the network interface checked for utilization. NIC
+	// utilization is not checked when empty.
+	Interface string
+	// InterfaceSpeed is the capacity of Interface in bits per second. When
+	// zero, it is read from sysfs.
+	InterfaceSpeed float64
+	// Threshold is the utilization (0-1) of any resource above which the
+	// score decreases linearly, reaching 0 at full utilization.
+	Threshold float64
+}
+
+// SystemChecker scores the health of the local system from its CPU load,
+// NIC utilization and disk pressure. Each resource is scored separately and
+// the lowest score, i.e. the most constrained resource, is returned.
+type SystemChecker struct {
+	config SystemConfig
+	cpus   int
+	now    func() time.Time
+
+	mu       sync.Mutex
+	lastRead time.Time
+	lastRx   uint64
+	lastTx   uint64
+}
+
+// NewSystemChecker returns a new SystemChecker.
+func NewSystemChecker(config SystemConfig) *SystemChecker {
+	if config.ProcPath == "" {
+		config.ProcPath = "/proc"
+	}
+	if config.SysPath == "" {
+		config.SysPath = "/sys"
+	}
+	return &SystemChecker{
+		config: config,
+		cpus:   runtime.NumCPU(),
+		now:    time.Now,
+	}
+}
+
+// GetScore returns a score between 0 and 1 for the local system. Resources
+// that cannot be read are not taken into account.
+func (sc *SystemChecker) GetScore() float64 {
+	score := 1.0
+	for _, s := range sc.scores() {
+		score = math.Min(score, s)
+	}
+	return score
+}
+
+// scores returns the score of each resource that could be read.
+func (sc *SystemChecker) scores() map[string]float64 {
+	scores := map[string]float64{}
+	if u, err := sc.cpuUtilization(); err == nil {
+		scores["cpu"] = sc.record("cpu", u)
+	}
+	if u, err := sc.nicUtilization(); err == nil {
+		scores["nic"] = sc.record("nic", u)
+	}
+	if u, err := sc.diskUtilization(); err == nil {
+		scores["disk"] = sc.record("disk", u)
+	}
+	return scores
+}
+
+// record scores the utilization of a resource and reports it as a metric.
+func (sc *SystemChecker) record(resource string, utilization float64) float64 {
+	s := utilizationScore(utilization, sc.config.Threshold)
+	metrics.HealthSystemScore.WithLabelValues(resource).Set(s)
+	return s
+}
+
+// utilizationScore returns 1 for utilizations up to threshold, decreasing
+// linearly to 0 at full utilization.
+func utilizationScore(utilization, threshold float64) float64 {
+	if utilization <= threshold {
+		return 1
+	}
+	if utilization >= 1 || threshold >= 1 {
+		return 0
+	}
+	return (1 - utilization) / (1 - threshold)
+}
+
+// cpuUtilization returns the 1-minute load average per CPU.
+func (sc *SystemChecker) cpuUtilization() (float64, error) {
+	load, err := LoadAvg(sc.config.ProcPath)
+	if err != nil {
+		return 0, err
+	}
+	return load / float64(sc.cpus), nil
+}
+
+// LoadAvg returns the 1-minute load average read from the loadavg file of
+// the given procfs mount point (e.g., /proc).
+func LoadAvg(procPath string) (float64, error) {
+	b, err := os.ReadFile(path.Join(procPath, "loadavg"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid loadavg: %q", b)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// nicUtilization returns the utilization of the busiest direction of the
+// network interface since the previous call. The first call reports no
+// utilization.
+func (sc *SystemChecker) nicUtilization() (float64, error) {
+	if sc.config.Interface == "" {
+		return 0, errNotConfigured
+	}
+	speed, err := sc.interfaceSpeed()
+	if err != nil {
+		return 0, err
+	}
+	rx, tx, err := sc.interfaceBytes()
+	if err != nil {
+		return 0, err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	now := sc.now()
+	elapsed := now.Sub(sc.lastRead).Seconds()
+	first := sc.lastRead.IsZero() || rx < sc.lastRx || tx < sc.lastTx
+	prevRx, prevTx := sc.lastRx, sc.lastTx
+	sc.lastRead, sc.lastRx, sc.lastTx = now, rx, tx
+	if first || elapsed <= 0 {
+		return 0, nil
+	}
+	n := math.Max(float64(rx-prevRx), float64(tx-prevTx))
+	return n * 8 / elapsed / speed, nil
+}
+
+// interfaceSpeed returns the capacity of the network interface in bits per
+// second.
+func (sc *SystemChecker) interfaceSpeed() (float64, error) {
+	if sc.config.InterfaceSpeed > 0 {
+		return sc.config.InterfaceSpeed, nil
+	}
+	b, err := os.ReadFile(path.Join(sc.config.SysPath, "class/net", sc.config.Interface, "speed"))
+	if err != nil {
+		return 0, err
+	}
+	mbps, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	if err != nil || mbps <= 0 {
+		return 0, fmt.Errorf("unknown speed for interface %s", sc.config.Interface)
+	}
+	return mbps * 1e6, nil
+}
+
+// interfaceBytes returns the bytes received and transmitted by the network
+// interface.
+func (sc *SystemChecker) interfaceBytes() (uint64, uint64, error) {
+	b, err := os.ReadFile(path.Join(sc.config.ProcPath, "net/dev"))
+	if err != nil {
+		return 0, 0, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		name, stats, ok := strings.Cut(s.Text(), ":")
+		if !ok || strings.TrimSpace(name) != sc.config.Interface {
+			continue
+		}
+		// Receive bytes is the first field and transmit bytes the ninth.
+		fields := strings.Fields(stats)
+		if len(fields) < 9 {
+			break
+		}
+		rx, errRx := strconv.ParseUint(fields[0], 10, 64)
+		tx, errTx := strconv.ParseUint(fields[8], 10, 64)
+		if errRx != nil || errTx != nil {
+			break
+		}
+		return rx, tx, nil
+	}
+	return 0, 0, fmt.Errorf("interface %s not found", sc.config.Interface)
+}
+
+// diskUtilization returns the fraction of the filesystem that is not
+// available to unprivileged users.
+func (sc *SystemChecker) diskUtilization() (float64, error) {
+	if sc.config.DiskPath == "" {
+		return 0, errNotConfigured
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(sc.config.DiskPath, &st); err != nil {
+		return 0, err
+	}
+	if st.Blocks == 0 {
+		return 0, fmt.Errorf("empty filesystem at %s", sc.config.DiskPath)
+	}
+	return 1 - float64(st.Bavail)/float64(st.Blocks), nil
+}
@@ -0,0 +1,156 @@
+package health
+
+import (
+	"math"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func Test_utilizationScore(t *testing.T) {
+	tests := []struct {
+		name        string
+		utilization float64
+		threshold   float64
+		want        float64
+	}{
+		{
+			name:        "below-threshold",
+			utilization: 0.5,
+			threshold:   0.8,
+			want:        1,
+		},
+		{
+			name:        "above-threshold",
+			utilization: 0.9,
+			threshold:   0.8,
+			want:        0.5,
+		},
+		{
+			name:        "saturated",
+			utilization: 1.5,
+			threshold:   0.8,
+			want:        0,
+		},
+		{
+			name:        "threshold-one",
+			utilization: 1,
+			threshold:   1,
+			want:        1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := utilizationScore(tt.utilization, tt.threshold)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("utilizationScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemChecker_cpuUtilization(t *testing.T) {
+	sc := NewSystemChecker(SystemConfig{ProcPath: "testdata/proc"})
+	sc.cpus = 4
+	got, err := sc.cpuUtilization()
+	if err != nil || got != 0.75 {
+		t.Errorf("SystemChecker.cpuUtilization() = %v, %v, want 0.75, nil", got, err)
+	}
+
+	sc = NewSystemChecker(SystemConfig{ProcPath: "testdata/missing"})
+	if _, err := sc.cpuUtilization(); err == nil {
+		t.Errorf("SystemChecker.cpuUtilization() expected error for missing procfs")
+	}
+}
+
+func TestSystemChecker_nicUtilization(t *testing.T) {
+	proc := t.TempDir()
+	if err := os.Mkdir(path.Join(proc, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeDev := func(rx, tx string) {
+		dev := "Inter-|   Receive\n face |bytes\n  eth0: " + rx + " 0 0 0 0 0 0 0 " + tx + " 0 0 0 0 0 0 0\n"
+		if err := os.WriteFile(path.Join(proc, "net/dev"), []byte(dev), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	sc := NewSystemChecker(SystemConfig{ProcPath: proc, SysPath: "testdata/sys", Interface: "eth0"})
+	sc.now = func() time.Time { return now }
+
+	writeDev("0", "0")
+	if got, err := sc.nicUtilization(); err != nil || got != 0 {
+		t.Errorf("SystemChecker.nicUtilization() first call = %v, %v, want 0, nil", got, err)
+	}
+
+	// 100MB transmitted in 1s on a 1000Mb/s interface.
+	now = now.Add(time.Second)
+	writeDev("1000", "100000000")
+	if got, err := sc.nicUtilization(); err != nil || got != 0.8 {
+		t.Errorf("SystemChecker.nicUtilization() = %v, %v, want 0.8, nil", got, err)
+	}
+
+	sc = NewSystemChecker(SystemConfig{ProcPath: "testdata/proc", SysPath: "testdata/sys", Interface: "eth1"})
+	if _, err := sc.nicUtilization(); err == nil {
+		t.Errorf("SystemChecker.nicUtilization() expected error for unknown interface")
+	}
+}
+
+func TestSystemChecker_interfaceBytes(t *testing.T) {
+	sc := NewSystemChecker(SystemConfig{ProcPath: "testdata/proc", Interface: "eth0"})
+	rx, tx, err := sc.interfaceBytes()
+	if err != nil || rx != 5000000 || tx != 12000000 {
+		t.Errorf("SystemChecker.interfaceBytes() = %d, %d, %v, want 5000000, 12000000, nil", rx, tx, err)
+	}
+}
+
+func TestSystemChecker_GetScore(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SystemConfig
+		cpus   int
+		want   float64
+	}{
+		{
+			name:   "idle",
+			config: SystemConfig{ProcPath: "testdata/proc", Threshold: 0.8},
+			cpus:   8,
+			want:   1,
+		},
+		{
+			name:   "cpu-overloaded",
+			config: SystemConfig{ProcPath: "testdata/proc", Threshold: 0.8},
+			cpus:   2,
+			want:   0,
+		},
+		{
+			name:   "cpu-constrained",
+			config: SystemConfig{ProcPath: "testdata/proc", Threshold: 0.5},
+			cpus:   4,
+			want:   0.5,
+		},
+		{
+			name:   "disk",
+			config: SystemConfig{ProcPath: "testdata/missing", DiskPath: "/", Threshold: 1},
+			want:   1,
+		},
+		{
+			name:   "nothing-readable",
+			config: SystemConfig{ProcPath: "testdata/missing", DiskPath: "testdata/missing", Interface: "eth0"},
+			want:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewSystemChecker(tt.config)
+			if tt.cpus > 0 {
+				sc.cpus = tt.cpus
+			}
+			if got := sc.GetScore(); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("SystemChecker.GetScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
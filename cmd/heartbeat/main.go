@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,6 +23,7 @@ import (
 	md "cloud.google.com/go/compute/metadata"
 	"github.com/gorilla/websocket"
 	"github.com/m-lab/go/flagx"
+	"github.com/m-lab/go/host"
 	"github.com/m-lab/go/memoryless"
 	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/rtx"
@@ -36,9 +45,38 @@ var (
 	namespace           string
 	kubernetesAuth      = "/var/run/secrets/kubernetes.io/serviceaccount/"
 	kubernetesURL       = flagx.URL{}
+	kubernetesAuto      bool
+	podinfoDir          = "/etc/podinfo/"
 	registrationURL     = flagx.URL{}
+	proxyURL            = flagx.URL{}
+	registrationOverlay string
 	services            = flagx.KeyValueArray{}
+	servicesFile        string
+	servicesInterval    time.Duration
+	targets             = flagx.KeyValueArray{}
+	healthExec          string
+	loadURL             string
+	loadMetric          string
+	loadCapacity        int
+	healthBufferSize    int
+	drainTimeout        time.Duration
+	statusAddress       string
+	gcpTopology         = metadata.Topology{}
+	statuses            = newAgentStatus()
+	systemHealth        bool
+	dualStackPorts      bool
+	addresses           bool
+	systemConfig        = health.SystemConfig{}
+	healthExecArgs      = flagx.StringArray{}
+	tlsCertFile         string
+	tlsKeyFile          string
+	tlsCAFile           string
+	tlsServerName       string
+	tlsInsecure         bool
 	heartbeatPeriod     = static.HeartbeatPeriod
+	healthTimeout       = static.HealthEndpointTimeout
+	pingInterval        time.Duration
+	pongTimeout         time.Duration
 	mainCtx, mainCancel = context.WithCancel(context.Background())
 	lbPath              = "/metadata/loadbalanced"
 )
@@ -48,89 +86,387 @@ type Checker interface {
 	GetHealth(ctx context.Context) float64 // Health score.
 }
 
+// ServiceScorer is implemented by Checkers that score services individually.
+type ServiceScorer interface {
+	ServiceScores() map[string]float64 // Scores of the last health check.
+}
+
+// LoadReader reads the number of tests in progress in the local experiment.
+type LoadReader interface {
+	GetLoad(ctx context.Context) (int, error)
+	Capacity() int // Maximum number of concurrent tests (0 if unknown).
+}
+
+// ServicesUpdater is implemented by Checkers whose checks depend on the
+// services of the instance.
+type ServicesUpdater interface {
+	SetServices(svcs map[string][]string)
+}
+
 func init() {
 	flag.StringVar(&heartbeatURL, "heartbeat-url", "ws://localhost:8080/v2/platform/heartbeat",
 		"URL for locate service")
 	flag.Var(&hostname, "hostname", "The service hostname (may be read from @/path/file)")
+	flag.DurationVar(&heartbeatPeriod, "heartbeat-period", static.HeartbeatPeriod,
+		fmt.Sprintf("How often health is reported (between %v and %v)", static.HeartbeatPeriodMin, static.HeartbeatPeriodMax))
+	flag.DurationVar(&healthTimeout, "health-timeout", static.HealthEndpointTimeout,
+		fmt.Sprintf("Timeout of the health endpoint and load requests (between %v and -heartbeat-period)", static.HealthEndpointTimeoutMin))
+	flag.DurationVar(&pingInterval, "ping-interval", static.WebsocketPingInterval, "How often pings are sent to detect a lost connection with the Locate service (0 disables pings)")
+	flag.DurationVar(&pongTimeout, "pong-timeout", static.WebsocketPongTimeout, "How long to wait for a pong after a ping before reconnecting")
 	flag.StringVar(&experiment, "experiment", "", "Experiment name")
 	flag.StringVar(&pod, "pod", "", "Kubernetes pod name")
 	flag.StringVar(&node, "node", "", "Kubernetes node name")
 	flag.StringVar(&namespace, "namespace", "", "Kubernetes namespace")
 	flag.Var(&kubernetesURL, "kubernetes-url", "URL for Kubernetes API")
+	flag.BoolVar(&kubernetesAuto, "kubernetes-auto", false, "Derive -kubernetes-url, -pod, -node, -namespace and -hostname, when not given, from the in-cluster configuration and the downward API")
 	flag.Var(&registrationURL, "registration-url", "URL for site registration")
+	flag.StringVar(&registrationOverlay, "registration-overlay", "", "Optional JSON file of registration fields (e.g., corrected coordinates or extra service URLs) merged on top of the registration data")
 	flag.Var(&services, "services", "Maps experiment target names to their set of services")
+	flag.StringVar(&servicesFile, "services-file", "", "Optional JSON file mapping service names to their URL templates, merged with -services and reloaded without restarting when it changes")
+	flag.DurationVar(&servicesInterval, "services-reload-interval", time.Minute, "How often -services-file is checked for changes")
+	flag.Var(&targets, "targets", "Maps additional experiment names to their service hostnames (e.g., msak=msak-mlab1-lga0t.mlab-sandbox.measurement-lab.org), each reported over its own connection")
+	flag.StringVar(&healthExec, "health-exec", "", "Optional program that generates the health score, replacing the built-in checks")
+	flag.Var(&healthExecArgs, "health-exec-arg", "Comma-separated arguments for -health-exec (may be repeated)")
+	flag.BoolVar(&dualStackPorts, "dual-stack-ports", false, "Check that service ports are open over both IPv4 and IPv6")
+	flag.BoolVar(&addresses, "addresses", true, "Include the IPv4 and IPv6 addresses of the service hostname, resolved with DNS, in the registration")
+	flag.BoolVar(&systemHealth, "system-health", false, "Factor CPU load, NIC utilization and disk pressure into the health score")
+	flag.StringVar(&systemConfig.Interface, "system-health-interface", "", "Network interface checked for utilization by -system-health (empty disables the check)")
+	flag.Float64Var(&systemConfig.InterfaceSpeed, "system-health-interface-speed", 0, "Capacity of -system-health-interface in bits per second (0 reads it from sysfs)")
+	flag.StringVar(&systemConfig.DiskPath, "system-health-disk-path", "/", "Path checked for disk pressure by -system-health (empty disables the check)")
+	flag.Float64Var(&systemConfig.Threshold, "system-health-threshold", 0.8, "Utilization (0-1) of any resource above which the -system-health score decreases")
+	flag.StringVar(&loadURL, "load-metrics-url", "", "Optional URL of the local experiment's Prometheus metrics used to report the number of tests in progress (e.g., http://localhost:9990/metrics)")
+	flag.StringVar(&loadMetric, "load-metric", "", "Metric at -load-metrics-url counting the tests in progress; samples are summed across labels")
+	flag.IntVar(&loadCapacity, "load-capacity", 0, "Maximum number of concurrent tests reported with the load (0 means unknown)")
+	flag.IntVar(&healthBufferSize, "health-buffer-size", 60, "Maximum number of health messages buffered while disconnected and replayed after reconnecting (0 disables buffering)")
+	flag.Var(&proxyURL, "proxy-url", "Optional HTTP(S) proxy for registration and heartbeat requests, replacing the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 10*time.Minute, "Maximum time to wait for tests in progress to finish after SIGUSR1 before exiting")
+	flag.StringVar(&statusAddress, "status-address", "localhost:9991", "Listen address of the local /status endpoint summarizing each target (empty disables it)")
+	flag.BoolVar(&gcpTopology.GlobalBackend, "gcp-global-backend", false, "Load-balanced VMs are in a global, rather than regional, backend service")
+	flag.BoolVar(&gcpTopology.ZonalGroup, "gcp-zonal-group", false, "Load-balanced VMs are in a zonal instance group (e.g., a zonal managed instance group)")
+	flag.StringVar(&gcpTopology.Group, "gcp-group", "", "Name of the instance group of load-balanced VMs, if different from the backend service (e.g., a managed instance group)")
+	flag.StringVar(&tlsCertFile, "tls-cert-file", "", "Client certificate file (PEM) for registration and heartbeat requests")
+	flag.StringVar(&tlsKeyFile, "tls-key-file", "", "Client private key file (PEM) for -tls-cert-file")
+	flag.StringVar(&tlsCAFile, "tls-ca-file", "", "CA bundle file (PEM) used instead of the system roots to verify servers")
+	flag.StringVar(&tlsServerName, "tls-server-name", "", "Server name (SNI) used to verify the heartbeat server, if different from the -heartbeat-url host")
+	flag.BoolVar(&tlsInsecure, "tls-insecure-skip-verify", false, "Do not verify the heartbeat server's certificate (for test deployments only)")
 }
 
 func main() {
 	flag.Parse()
 	rtx.Must(flagx.ArgsFromEnvWithLog(flag.CommandLine, false), "failed to read args from env")
+	rtx.Must(checkPeriods(heartbeatPeriod, healthTimeout), "invalid heartbeat period or health timeout")
+	if kubernetesAuto {
+		rtx.Must(autoConfigure(mainCtx), "failed to derive the configuration from Kubernetes")
+	}
 
 	// Start metrics server.
 	prom := prometheusx.MustServeMetrics()
 	defer prom.Close()
 
+	// Start local status server.
+	if statusAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/status", statuses)
+		srv := &http.Server{Addr: statusAddress, Handler: mux}
+		go func() {
+			log.Printf("status server stopped, err: %v", srv.ListenAndServe())
+		}()
+		defer srv.Close()
+	}
+
+	tlsConfig, err := newTLSConfig(tlsCertFile, tlsKeyFile, tlsCAFile)
+	rtx.Must(err, "could not load TLS configuration")
+	transport := newTransport(tlsConfig, proxyURL.URL)
+
+	// TODO(kinkade): cause a fatal error if lberr is not nil. Not fatally
+	// exiting on lberr is just a workaround to get this rolled out while we
+	// wait for every physical machine on the platform to actually have that
+	// file, which won't be the case until the rolling reboot in production
+	// completes in 4 or 5 days, as of this comment 2024-08-06.
+	lbbytes, lberr := os.ReadFile(lbPath)
+
+	// If the "loadbalanced" file exists, then make sure that the content of the
+	// file is "true". If the file doesn't exist, then, for now, just consider
+	// the machine as not loadbalanced.
+	loadbalanced := lberr == nil && string(lbbytes) == "true"
+
+	svcs, err := loadServices(servicesFile, services.Get())
+	rtx.Must(err, "could not load services from %s", servicesFile)
+
+	// Each target registers and reports health over its own connection.
+	var wg sync.WaitGroup
+	tgts := getTargets(experiment, hostname.Value, targets.Get(), svcs)
+	reload := make([]chan map[string][]string, len(tgts))
+	for i, t := range tgts {
+		conn, hc, ldr := startTarget(t, transport, loadbalanced)
+		var lc LoadReader
+		if loadURL != "" {
+			lc = health.NewLoadClient(loadURL, loadMetric, loadCapacity, healthTimeout)
+		}
+		reload[i] = make(chan map[string][]string, 1)
+		wg.Add(1)
+		go func(t target, reload <-chan map[string][]string) {
+			defer wg.Done()
+			write(t, conn, hc, ldr, lc, reload)
+		}(t, reload[i])
+	}
+	if servicesFile != "" {
+		go watchServices(mainCtx, servicesFile, servicesInterval, services.Get(), reload)
+	}
+	wg.Wait()
+}
+
+// checkPeriods returns an error if the heartbeat period or the health
+// timeout are out of bounds. Periods above the maximum would let Locate
+// expire the instance between heartbeats.
+func checkPeriods(period, timeout time.Duration) error {
+	if period < static.HeartbeatPeriodMin || period > static.HeartbeatPeriodMax {
+		return fmt.Errorf("-heartbeat-period %v must be between %v and %v",
+			period, static.HeartbeatPeriodMin, static.HeartbeatPeriodMax)
+	}
+	if timeout < static.HealthEndpointTimeoutMin || timeout > period {
+		return fmt.Errorf("-health-timeout %v must be between %v and -heartbeat-period %v",
+			timeout, static.HealthEndpointTimeoutMin, period)
+	}
+	return nil
+}
+
+// autoConfigure derives the Kubernetes flags that were not given from the
+// in-cluster configuration of the pod. If the hostname was not given, it is
+// derived from the experiment and the node name (e.g., "ndt" and
+// "mlab1-lga0t.mlab-sandbox.measurement-lab.org").
+func autoConfigure(ctx context.Context) error {
+	if kubernetesURL.URL == nil {
+		kubernetesURL.URL = health.InClusterURL()
+	}
+	if kubernetesURL.URL == nil {
+		return errors.New("not running in a Kubernetes cluster")
+	}
+	info, err := health.DiscoverPod(ctx, kubernetesURL.URL, kubernetesAuth, podinfoDir,
+		health.PodInfo{Pod: pod, Node: node, Namespace: namespace})
+	if err != nil {
+		return err
+	}
+	pod, node, namespace = info.Pod, info.Node, info.Namespace
+	log.Printf("using pod %s in namespace %s on node %s", pod, namespace, node)
+
+	if hostname.Value == "" {
+		hostname.Value, err = hostnameFor(experiment, node)
+	}
+	return err
+}
+
+// hostnameFor returns the service hostname of the experiment on an M-Lab
+// node.
+func hostnameFor(exp, node string) (string, error) {
+	h := exp + "-" + node
+	if _, err := host.Parse(h); err != nil {
+		return "", fmt.Errorf("cannot derive the hostname from node %s: %w", node, err)
+	}
+	return h, nil
+}
+
+// target is an experiment instance reported by the heartbeat agent.
+type target struct {
+	experiment string
+	hostname   string
+	services   map[string][]string
+}
+
+// getTargets returns the primary target followed by the additional targets,
+// sorted by experiment. When there are additional targets, each target only
+// reports the services of its own experiment (e.g., "msak/throughput1" for
+// "msak").
+func getTargets(exp, host string, extra map[string][]string, svcs map[string][]string) []target {
+	if len(extra) == 0 {
+		return []target{{experiment: exp, hostname: host, services: svcs}}
+	}
+	t := []target{{experiment: exp, hostname: host, services: servicesFor(exp, svcs)}}
+	exps := make([]string, 0, len(extra))
+	for e := range extra {
+		exps = append(exps, e)
+	}
+	sort.Strings(exps)
+	for _, e := range exps {
+		for _, h := range extra[e] {
+			t = append(t, target{experiment: e, hostname: h, services: servicesFor(e, svcs)})
+		}
+	}
+	return t
+}
+
+// servicesFor returns the services whose names start with the experiment.
+func servicesFor(exp string, svcs map[string][]string) map[string][]string {
+	s := make(map[string][]string)
+	for name, urls := range svcs {
+		if strings.HasPrefix(name, exp+"/") {
+			s[name] = urls
+		}
+	}
+	return s
+}
+
+// startTarget loads the registration data of the target, establishes its
+// connection with the heartbeat service and creates its health checker.
+func startTarget(t target, transport *http.Transport, loadbalanced bool) (*connection.Conn, Checker, *registration.Loader) {
 	// Load registration data.
 	ldrConfig := memoryless.Config{
 		Min:      static.RegistrationLoadMin,
 		Expected: static.RegistrationLoadExpected,
 		Max:      static.RegistrationLoadMax,
 	}
-	svcs := services.Get()
-	ldr, err := registration.NewLoader(mainCtx, registrationURL.URL, hostname.Value, experiment, svcs, ldrConfig)
-	rtx.Must(err, "could not initialize registration loader")
+	ldr, err := registration.NewLoader(mainCtx, registrationURL.URL, t.hostname, t.experiment, t.services, ldrConfig)
+	rtx.Must(err, "could not initialize registration loader for %s", t.hostname)
+	ldr.Client = &http.Client{Transport: transport}
+	if addresses {
+		ldr.Resolver = net.DefaultResolver
+	}
+	if registrationOverlay != "" {
+		ldr.Overlay, err = registration.LoadOverlay(registrationOverlay)
+		rtx.Must(err, "could not load registration overlay %s", registrationOverlay)
+	}
 	r, err := ldr.GetRegistration(mainCtx)
-	rtx.Must(err, "could not load registration data")
+	rtx.Must(err, "could not load registration data for %s", t.hostname)
+	statuses.update(t, func(ts *targetStatus) {
+		ts.Registration = r
+		now := time.Now()
+		ts.RegistrationTime = &now
+	})
 	hbm := v2.HeartbeatMessage{Registration: r}
 
 	// Establish a connection.
 	conn := connection.NewConn()
-	err = conn.Dial(heartbeatURL, http.Header{}, hbm)
+	conn.TLSClientConfig = heartbeatTLSConfig(transport.TLSClientConfig, tlsServerName, tlsInsecure)
+	conn.Proxy = transport.Proxy
+	conn.PingInterval = pingInterval
+	conn.PongTimeout = pongTimeout
+	err = conn.DialContext(mainCtx, heartbeatURL, http.Header{}, hbm)
 	rtx.Must(err, "failed to establish a websocket connection with %s", heartbeatURL)
+	statuses.update(t, func(ts *targetStatus) { ts.Connected = conn.IsConnected() })
 
-	probe := health.NewPortProbe(svcs)
-	ec := health.NewEndpointClient(static.HealthEndpointTimeout)
+	probe := health.NewPortProbe(t.services)
+	if dualStackPorts {
+		probe = health.NewDualStackPortProbe(t.services)
+	}
+	ec := health.NewEndpointClient(healthTimeout)
 	var hc Checker
-
-	// TODO(kinkade): cause a fatal error if lberr is not nil. Not fatally
-	// exiting on lberr is just a workaround to get this rolled out while we
-	// wait for every physical machine on the platform to actually have that
-	// file, which won't be the case until the rolling reboot in production
-	// completes in 4 or 5 days, as of this comment 2024-08-06.
-	lbbytes, lberr := os.ReadFile(lbPath)
-
-	// If the "loadbalanced" file exists, then make sure that the content of the
-	// file is "true". If the file doesn't exist, then, for now, just consider
-	// the machine as not loadbalanced.
-	if lberr == nil && string(lbbytes) == "true" {
-		gcpmd, err := metadata.NewGCPMetadata(md.NewClient(http.DefaultClient), hostname.Value)
+	if healthExec != "" {
+		env := []string{"HEARTBEAT_EXPERIMENT=" + t.experiment, "HEARTBEAT_HOSTNAME=" + t.hostname}
+		hc = health.NewExecChecker(healthExec, healthExecArgs, env)
+	} else if loadbalanced {
+		gcpmd, err := metadata.NewGCPMetadataWithTopology(md.NewClient(http.DefaultClient), t.hostname, gcpTopology)
 		rtx.Must(err, "failed to get VM metadata")
-		gceClient, err := compute.NewRegionBackendServicesRESTClient(mainCtx)
-		rtx.Must(err, "failed to create GCE client")
-		hc = health.NewGCPChecker(gceClient, gcpmd)
-	} else if kubernetesURL.URL == nil {
-		hc = health.NewChecker(probe, ec)
+		if gcpmd.Global() {
+			gceClient, err := compute.NewBackendServicesRESTClient(mainCtx)
+			rtx.Must(err, "failed to create GCE client")
+			hc = health.NewGlobalGCPChecker(gceClient, gcpmd)
+		} else {
+			gceClient, err := compute.NewRegionBackendServicesRESTClient(mainCtx)
+			rtx.Must(err, "failed to create GCE client")
+			hc = health.NewGCPChecker(gceClient, gcpmd)
+		}
 	} else {
-		k8s := health.MustNewKubernetesClient(kubernetesURL.URL, pod, node, namespace, kubernetesAuth)
-		hc = health.NewCheckerK8S(probe, k8s, ec)
+		var c *health.Checker
+		if kubernetesURL.URL == nil {
+			c = health.NewChecker(probe, ec)
+		} else {
+			k8s := health.MustNewKubernetesClient(kubernetesURL.URL, pod, node, namespace, kubernetesAuth)
+			c = health.NewCheckerK8S(probe, k8s, ec)
+		}
+		if systemHealth {
+			c.WithSystemChecker(health.NewSystemChecker(systemConfig))
+		}
+		hc = c
 	}
+	return conn, hc, ldr
+}
+
+// newTransport returns an HTTP transport with the given TLS configuration
+// and proxy. When proxy is nil, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables are used.
+func newTransport(tlsConfig *tls.Config, proxy *url.URL) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	t.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	return t
+}
 
-	write(conn, hc, ldr)
+// heartbeatTLSConfig returns a copy of config for the heartbeat connection
+// with the server name and certificate verification overridden, or config
+// itself if nothing is overridden. Registration requests keep using config.
+func heartbeatTLSConfig(config *tls.Config, serverName string, insecure bool) *tls.Config {
+	if serverName == "" && !insecure {
+		return config
+	}
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config != nil {
+		c = config.Clone()
+	}
+	c.ServerName = serverName
+	if insecure {
+		log.Println("WARNING: not verifying the heartbeat server's certificate")
+		c.InsecureSkipVerify = true
+	}
+	return c
+}
+
+// newTLSConfig returns a TLS configuration with the client certificate and CA
+// bundle from the given files, or nil if no files are given.
+func newTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("-tls-cert-file and -tls-key-file must be given together")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		b, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
 }
 
 // write starts a write loop to send health messages every
-// HeartbeatPeriod.
-func write(ws *connection.Conn, hc Checker, ldr *registration.Loader) {
+// HeartbeatPeriod. Services received from reload are re-registered and, if
+// hc supports it, health checked.
+func write(tgt target, ws *connection.Conn, hc Checker, ldr *registration.Loader, lc LoadReader, reload <-chan map[string][]string) {
 	defer ws.Close()
-	hbTicker := *time.NewTicker(heartbeatPeriod)
+	hbTicker := time.NewTicker(heartbeatPeriod)
 	defer hbTicker.Stop()
 
 	// Register the channel to receive SIGTERM events.
 	sigterm := make(chan os.Signal, 1)
 	defer close(sigterm)
 	signal.Notify(sigterm, syscall.SIGTERM)
+	defer signal.Stop(sigterm)
+
+	// Register the channel to receive SIGUSR1 (drain) events.
+	sigusr1 := make(chan os.Signal, 1)
+	signal.Notify(sigusr1, syscall.SIGUSR1)
+	defer signal.Stop(sigusr1)
+	var drainDeadline time.Time // Zero unless draining.
 
 	defer ldr.Ticker.Stop()
 
+	buf := newHealthBuffer(healthBufferSize)
 	for {
 		select {
 		case <-mainCtx.Done():
@@ -142,21 +478,69 @@ func write(ws *connection.Conn, hc Checker, ldr *registration.Loader) {
 			sendExitMessage(ws)
 			mainCancel()
 			return
+		case <-sigusr1:
+			log.Printf("received SIGUSR1, draining for up to %v", drainTimeout)
+			drainDeadline = time.Now().Add(drainTimeout)
+			statuses.update(tgt, func(ts *targetStatus) { ts.Draining = true })
+			sendExitMessage(ws)
+		case svcs := <-reload:
+			log.Printf("reloading services for %s", tgt.hostname)
+			ldr.SetServices(svcs)
+			if su, ok := hc.(ServicesUpdater); ok {
+				su.SetServices(svcs)
+			}
+			reg, err := ldr.GetRegistration(mainCtx)
+			if err != nil {
+				log.Printf("could not load registration data, err: %v", err)
+			}
+			if reg != nil {
+				sendMessage(mainCtx, ws, v2.HeartbeatMessage{Registration: reg}, "registration")
+				statuses.update(tgt, func(ts *targetStatus) {
+					ts.Registration = reg
+					now := time.Now()
+		ts.RegistrationTime = &now
+				})
+				log.Printf("updated registration to %v", reg)
+			}
 		case <-ldr.Ticker.C:
 			reg, err := ldr.GetRegistration(mainCtx)
 			if err != nil {
 				log.Printf("could not load registration data, err: %v", err)
 			}
 			if reg != nil {
-				sendMessage(ws, v2.HeartbeatMessage{Registration: reg}, "registration")
+				sendMessage(mainCtx, ws, v2.HeartbeatMessage{Registration: reg}, "registration")
+				statuses.update(tgt, func(ts *targetStatus) {
+					ts.Registration = reg
+					now := time.Now()
+		ts.RegistrationTime = &now
+				})
 				log.Printf("updated registration to %v", reg)
 			}
 		case <-hbTicker.C:
+			if !drainDeadline.IsZero() {
+				if isDrained(lc, drainDeadline, time.Now()) {
+					log.Println("drain complete")
+					sendExitMessage(ws)
+					return
+				}
+				// Keep reporting the instance as unhealthy while draining.
+				sendExitMessage(ws)
+				continue
+			}
 			t := time.Now()
-			score := getHealth(hc)
-			healthMsg := v2.Health{Score: score}
+			healthMsg := getHealthMessage(hc, lc)
+			healthMsg.Timestamp = t.UnixMilli()
+			score := healthMsg.Score
 			hbm := v2.HeartbeatMessage{Health: &healthMsg}
-			sendMessage(ws, hbm, "health")
+			sendHealth(ws, buf, hbm)
+			statuses.update(tgt, func(ts *targetStatus) {
+				ts.Connected = ws.IsConnected()
+				ts.Health = &healthMsg
+				ts.HealthTime = &t
+				if cr, ok := hc.(ComponentReporter); ok {
+					ts.Components = cr.Components()
+				}
+			})
 
 			// Record duration metric.
 			fmtScore := fmt.Sprintf("%.1f", score)
@@ -165,23 +549,93 @@ func write(ws *connection.Conn, hc Checker, ldr *registration.Loader) {
 	}
 }
 
+// isDrained returns whether the experiment has no tests in progress or the
+// drain deadline has passed. Without a LoadReader, tests in progress are
+// unknown, so the drain lasts until the deadline.
+func isDrained(lc LoadReader, deadline, now time.Time) bool {
+	if !now.Before(deadline) {
+		return true
+	}
+	if lc == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(mainCtx, heartbeatPeriod)
+	defer cancel()
+	load, err := lc.GetLoad(ctx)
+	if err != nil {
+		log.Printf("could not read experiment load while draining, err: %v", err)
+		return false
+	}
+	return load == 0
+}
+
+// readLoadAvg returns the 1-minute load average of the machine.
+var readLoadAvg = func() (float64, error) {
+	return health.LoadAvg("/proc")
+}
+
+// getHealthMessage returns the health score, the load average of the machine
+// and, if lc is not nil, the current load and capacity of the experiment. The
+// load average and load are omitted if they cannot be read.
+func getHealthMessage(hc Checker, lc LoadReader) v2.Health {
+	h := v2.Health{Score: getHealth(hc)}
+	if ss, ok := hc.(ServiceScorer); ok {
+		h.Services = ss.ServiceScores()
+	}
+	if la, err := readLoadAvg(); err == nil {
+		h.LoadAvg = la
+	}
+	if lc == nil {
+		return h
+	}
+	ctx, cancel := context.WithTimeout(mainCtx, heartbeatPeriod)
+	defer cancel()
+	load, err := lc.GetLoad(ctx)
+	if err != nil {
+		log.Printf("could not read experiment load, err: %v", err)
+		return h
+	}
+	h.ActiveTests = load
+	h.MaxTests = lc.Capacity()
+	return h
+}
+
 func getHealth(hc Checker) float64 {
 	ctx, cancel := context.WithTimeout(mainCtx, heartbeatPeriod)
 	defer cancel()
 	return hc.GetHealth(ctx)
 }
 
-func sendMessage(ws *connection.Conn, hbm v2.HeartbeatMessage, msgType string) {
+// sendHealth sends a health message after replaying any messages buffered
+// while the connection was down. Messages that cannot be sent are buffered.
+func sendHealth(ws *connection.Conn, buf *healthBuffer, hbm v2.HeartbeatMessage) {
+	send := func(m v2.HeartbeatMessage) error {
+		return sendMessage(mainCtx, ws, m, "health")
+	}
+	if n := buf.len(); n > 0 {
+		if err := buf.flush(send); err != nil {
+			buf.add(hbm)
+			return
+		}
+		log.Printf("replayed %d buffered health messages", n)
+	}
+	if err := send(hbm); err != nil {
+		buf.add(hbm)
+	}
+}
+
+func sendMessage(ctx context.Context, ws *connection.Conn, hbm v2.HeartbeatMessage, msgType string) error {
 	// If a new registration message was found, update the websocket's dial message.
 	// The message is sent whenever the connection is restarted (i.e., once per hour in App Engine).
 	if msgType == "registration" {
-		ws.DialMessage = hbm
+		ws.SetDialMessage(hbm)
 	}
 
-	err := ws.WriteMessage(websocket.TextMessage, hbm)
+	err := ws.WriteMessageContext(ctx, websocket.TextMessage, hbm)
 	if err != nil {
 		log.Printf("failed to write %s message, err: %v", msgType, err)
 	}
+	return err
 }
 
 func sendExitMessage(ws *connection.Conn) {
@@ -191,5 +645,9 @@ func sendExitMessage(ws *connection.Conn) {
 			Score: 0,
 		},
 	}
-	sendMessage(ws, hbm, "final health")
+	// The exit message is also sent after mainCtx is cancelled, so it gets
+	// its own deadline instead to not hang the shutdown while reconnecting.
+	ctx, cancel := context.WithTimeout(context.Background(), heartbeatPeriod)
+	defer cancel()
+	sendMessage(ctx, ws, hbm, "final health")
 }
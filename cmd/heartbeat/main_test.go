@@ -2,10 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"flag"
+	"math/big"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"time"
@@ -36,6 +47,8 @@ func Test_main(t *testing.T) {
 	flag.Set("namespace", "default")
 	flag.Set("registration-url", "file:./registration/testdata/registration.json")
 	flag.Set("services", "ndt/ndt7=ws://:"+u.Port()+"/ndt/v7/download")
+	flag.Set("status-address", "localhost:0")
+	flag.Set("health-timeout", "1s")
 
 	heartbeatPeriod = 2 * time.Second
 	timer := time.NewTimer(2 * heartbeatPeriod)
@@ -69,6 +82,88 @@ func Test_main(t *testing.T) {
 	main()
 }
 
+func Test_checkPeriods(t *testing.T) {
+	tests := []struct {
+		name    string
+		period  time.Duration
+		timeout time.Duration
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			period:  10 * time.Second,
+			timeout: 5 * time.Second,
+		},
+		{
+			name:    "bounds",
+			period:  time.Second,
+			timeout: time.Second,
+		},
+		{
+			name:    "period-too-short",
+			period:  500 * time.Millisecond,
+			timeout: 100 * time.Millisecond,
+			wantErr: true,
+		},
+		{
+			name:    "period-too-long",
+			period:  time.Minute,
+			timeout: 5 * time.Second,
+			wantErr: true,
+		},
+		{
+			name:    "timeout-too-short",
+			period:  10 * time.Second,
+			timeout: time.Millisecond,
+			wantErr: true,
+		},
+		{
+			name:    "timeout-above-period",
+			period:  2 * time.Second,
+			timeout: 5 * time.Second,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPeriods(tt.period, tt.timeout); (err != nil) != tt.wantErr {
+				t.Errorf("checkPeriods() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_hostnameFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		node    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "mlab-node",
+			node: "mlab1-lga0t.mlab-sandbox.measurement-lab.org",
+			want: "ndt-mlab1-lga0t.mlab-sandbox.measurement-lab.org",
+		},
+		{
+			name:    "other-node",
+			node:    "gke-cluster-pool-1234",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hostnameFor("ndt", tt.node)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hostnameFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("hostnameFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_sendMessage(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -94,7 +189,7 @@ func Test_sendMessage(t *testing.T) {
 			ws := connection.NewConn()
 			defer ws.Close()
 
-			sendMessage(ws, tt.msg, tt.msgType)
+			sendMessage(context.Background(), ws, tt.msg, tt.msgType)
 			if !reflect.DeepEqual(ws.DialMessage, tt.wantDialMsg) {
 				t.Errorf("sendMessage() error updating websocket dial message; got: %v, want: %v",
 					ws.DialMessage, tt.wantDialMsg)
@@ -102,3 +197,359 @@ func Test_sendMessage(t *testing.T) {
 		})
 	}
 }
+
+// writeTestCert writes a self-signed certificate and its private key to dir.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtx.Must(err, "could not generate key")
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "heartbeat-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	rtx.Must(err, "could not create certificate")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	rtx.Must(err, "could not marshal key")
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	rtx.Must(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), "could not write cert")
+	rtx.Must(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600), "could not write key")
+	return certFile, keyFile
+}
+
+func Test_newTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	rtx.Must(os.WriteFile(invalidFile, []byte("invalid"), 0600), "could not write file")
+
+	tests := []struct {
+		name      string
+		certFile  string
+		keyFile   string
+		caFile    string
+		wantNil   bool
+		wantCerts int
+		wantRoots bool
+		wantErr   bool
+	}{
+		{
+			name:    "no-files",
+			wantNil: true,
+		},
+		{
+			name:      "client-cert",
+			certFile:  certFile,
+			keyFile:   keyFile,
+			wantCerts: 1,
+		},
+		{
+			name:      "ca-bundle",
+			caFile:    certFile,
+			wantRoots: true,
+		},
+		{
+			name:      "client-cert-and-ca-bundle",
+			certFile:  certFile,
+			keyFile:   keyFile,
+			caFile:    certFile,
+			wantCerts: 1,
+			wantRoots: true,
+		},
+		{
+			name:     "missing-key",
+			certFile: certFile,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid-key",
+			certFile: certFile,
+			keyFile:  invalidFile,
+			wantErr:  true,
+		},
+		{
+			name:    "invalid-ca-bundle",
+			caFile:  invalidFile,
+			wantErr: true,
+		},
+		{
+			name:    "missing-ca-bundle",
+			caFile:  filepath.Join(dir, "missing.pem"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTLSConfig(tt.certFile, tt.keyFile, tt.caFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("newTLSConfig() = %v, wantNil %v", got, tt.wantNil)
+			}
+			if got == nil {
+				return
+			}
+			if len(got.Certificates) != tt.wantCerts {
+				t.Errorf("newTLSConfig() certificates = %d, want %d", len(got.Certificates), tt.wantCerts)
+			}
+			if (got.RootCAs != nil) != tt.wantRoots {
+				t.Errorf("newTLSConfig() root CAs = %v, want %v", got.RootCAs != nil, tt.wantRoots)
+			}
+		})
+	}
+}
+
+func Test_heartbeatTLSConfig(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS13}
+	tests := []struct {
+		name       string
+		config     *tls.Config
+		serverName string
+		insecure   bool
+		want       *tls.Config
+	}{
+		{
+			name: "no-config",
+		},
+		{
+			name:   "no-overrides",
+			config: base,
+			want:   base,
+		},
+		{
+			name:       "server-name",
+			config:     base,
+			serverName: "locate.example.org",
+			want:       &tls.Config{MinVersion: tls.VersionTLS13, ServerName: "locate.example.org"},
+		},
+		{
+			name:     "insecure-without-config",
+			insecure: true,
+			want:     &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := heartbeatTLSConfig(tt.config, tt.serverName, tt.insecure)
+			if got == nil || tt.want == nil {
+				if got != tt.want {
+					t.Errorf("heartbeatTLSConfig() = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			if got.MinVersion != tt.want.MinVersion || got.ServerName != tt.want.ServerName ||
+				got.InsecureSkipVerify != tt.want.InsecureSkipVerify {
+				t.Errorf("heartbeatTLSConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if base.ServerName != "" {
+		t.Errorf("heartbeatTLSConfig() modified the registration config")
+	}
+}
+
+func Test_getTargets(t *testing.T) {
+	svcs := map[string][]string{
+		"ndt/ndt7":          {"ws:///ndt/v7/download"},
+		"msak/throughput1":  {"ws:///throughput/v1/download"},
+		"wehe/replay":       {"wss://:4443/v0/envelope/access"},
+		"ndtx/experimental": {"ws:///ndtx"},
+	}
+	tests := []struct {
+		name  string
+		extra map[string][]string
+		want  []target
+	}{
+		{
+			name: "single-target",
+			want: []target{
+				{experiment: "ndt", hostname: "ndt-mlab1-lga0t", services: svcs},
+			},
+		},
+		{
+			name: "multiple-targets",
+			extra: map[string][]string{
+				"wehe": {"wehe-mlab1-lga0t"},
+				"msak": {"msak-mlab1-lga0t"},
+			},
+			want: []target{
+				{experiment: "ndt", hostname: "ndt-mlab1-lga0t", services: map[string][]string{
+					"ndt/ndt7": {"ws:///ndt/v7/download"},
+				}},
+				{experiment: "msak", hostname: "msak-mlab1-lga0t", services: map[string][]string{
+					"msak/throughput1": {"ws:///throughput/v1/download"},
+				}},
+				{experiment: "wehe", hostname: "wehe-mlab1-lga0t", services: map[string][]string{
+					"wehe/replay": {"wss://:4443/v0/envelope/access"},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getTargets("ndt", "ndt-mlab1-lga0t", tt.extra, svcs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeChecker struct {
+	score float64
+}
+
+func (c *fakeChecker) GetHealth(ctx context.Context) float64 {
+	return c.score
+}
+
+type fakeServiceChecker struct {
+	fakeChecker
+	services map[string]float64
+}
+
+func (c *fakeServiceChecker) ServiceScores() map[string]float64 {
+	return c.services
+}
+
+type fakeLoadReader struct {
+	load     int
+	capacity int
+	err      error
+}
+
+func (r *fakeLoadReader) GetLoad(ctx context.Context) (int, error) {
+	return r.load, r.err
+}
+
+func (r *fakeLoadReader) Capacity() int {
+	return r.capacity
+}
+
+func Test_getHealthMessage(t *testing.T) {
+	defer func(f func() (float64, error)) { readLoadAvg = f }(readLoadAvg)
+
+	tests := []struct {
+		name       string
+		hc         Checker
+		lc         LoadReader
+		loadAvgErr error
+		want       v2.Health
+	}{
+		{
+			name: "no-load",
+			want: v2.Health{Score: 0.5, LoadAvg: 1.5},
+		},
+		{
+			name: "services",
+			hc: &fakeServiceChecker{
+				fakeChecker: fakeChecker{score: 0.5},
+				services:    map[string]float64{"ndt/ndt5": 0, "ndt/ndt7": 0.5},
+			},
+			want: v2.Health{
+				Score:    0.5,
+				LoadAvg:  1.5,
+				Services: map[string]float64{"ndt/ndt5": 0, "ndt/ndt7": 0.5},
+			},
+		},
+		{
+			name: "load",
+			lc:   &fakeLoadReader{load: 3, capacity: 10},
+			want: v2.Health{Score: 0.5, ActiveTests: 3, MaxTests: 10, LoadAvg: 1.5},
+		},
+		{
+			name: "load-error",
+			lc:   &fakeLoadReader{capacity: 10, err: errors.New("fake error")},
+			want: v2.Health{Score: 0.5, LoadAvg: 1.5},
+		},
+		{
+			name:       "loadavg-error",
+			loadAvgErr: errors.New("fake error"),
+			want:       v2.Health{Score: 0.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readLoadAvg = func() (float64, error) {
+				return 1.5, tt.loadAvgErr
+			}
+			hc := tt.hc
+			if hc == nil {
+				hc = &fakeChecker{score: 0.5}
+			}
+			got := getHealthMessage(hc, tt.lc)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHealthMessage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newTransport(t *testing.T) {
+	proxy, err := url.Parse("http://proxy.example.org:3128")
+	rtx.Must(err, "could not parse proxy URL")
+	req, err := http.NewRequest(http.MethodGet, "https://locate.measurementlab.net/v2/platform/heartbeat", nil)
+	rtx.Must(err, "could not create request")
+
+	tr := newTransport(nil, proxy)
+	got, err := tr.Proxy(req)
+	if err != nil || got.String() != proxy.String() {
+		t.Errorf("newTransport() proxy = %v, %v, want %v", got, err, proxy)
+	}
+}
+
+func Test_isDrained(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		lc       LoadReader
+		deadline time.Time
+		want     bool
+	}{
+		{
+			name:     "no-tests",
+			lc:       &fakeLoadReader{load: 0},
+			deadline: now.Add(time.Minute),
+			want:     true,
+		},
+		{
+			name:     "tests-in-progress",
+			lc:       &fakeLoadReader{load: 2},
+			deadline: now.Add(time.Minute),
+			want:     false,
+		},
+		{
+			name:     "load-error",
+			lc:       &fakeLoadReader{err: errors.New("fake error")},
+			deadline: now.Add(time.Minute),
+			want:     false,
+		},
+		{
+			name:     "no-load-reader",
+			deadline: now.Add(time.Minute),
+			want:     false,
+		},
+		{
+			name:     "deadline-passed",
+			lc:       &fakeLoadReader{load: 2},
+			deadline: now,
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDrained(tt.lc, tt.deadline, now); got != tt.want {
+				t.Errorf("isDrained() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -7,7 +7,23 @@ import (
 	"github.com/m-lab/go/host"
 )
 
-const groupTemplate = "https://www.googleapis.com/compute/v1/projects/%s/regions/%s/instanceGroups/%s"
+const (
+	groupTemplate      = "https://www.googleapis.com/compute/v1/projects/%s/regions/%s/instanceGroups/%s"
+	zonalGroupTemplate = "https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instanceGroups/%s"
+)
+
+// Topology describes how the VM is load balanced. The zero value is a
+// regional backend service with a regional instance group of the same name.
+type Topology struct {
+	// GlobalBackend is true if the backend service is global.
+	GlobalBackend bool
+	// ZonalGroup is true if the instance group is zonal (e.g., a zonal
+	// managed instance group) rather than regional.
+	ZonalGroup bool
+	// Group is the name of the instance group, if different from the
+	// backend service (e.g., a managed instance group).
+	Group string
+}
 
 // GCPMetadata contains metadata about a GCP VM.
 type GCPMetadata struct {
@@ -15,6 +31,7 @@ type GCPMetadata struct {
 	backend string
 	region  string
 	group   string
+	global  bool
 }
 
 // Client uses HTTP requests to query the metadata service.
@@ -23,8 +40,15 @@ type Client interface {
 	Zone() (string, error)
 }
 
-// NewGCPMetadata returns a new instance of GCPMetadata.
+// NewGCPMetadata returns a new instance of GCPMetadata for a VM behind a
+// regional backend service.
 func NewGCPMetadata(c Client, hostname string) (*GCPMetadata, error) {
+	return NewGCPMetadataWithTopology(c, hostname, Topology{})
+}
+
+// NewGCPMetadataWithTopology returns a new instance of GCPMetadata for a VM
+// load balanced with the given topology.
+func NewGCPMetadataWithTopology(c Client, hostname string, t Topology) (*GCPMetadata, error) {
 	h, err := host.Parse(hostname)
 	if err != nil {
 		return nil, err
@@ -46,11 +70,21 @@ func NewGCPMetadata(c Client, hostname string) (*GCPMetadata, error) {
 	}
 	region := zone[:len(zone)-2]
 
+	name := backend
+	if t.Group != "" {
+		name = t.Group
+	}
+	group := fmt.Sprintf(groupTemplate, project, region, name)
+	if t.ZonalGroup {
+		group = fmt.Sprintf(zonalGroupTemplate, project, zone, name)
+	}
+
 	return &GCPMetadata{
 		project: project,
 		backend: backend,
 		region:  region,
-		group:   fmt.Sprintf(groupTemplate, project, region, backend),
+		group:   group,
+		global:  t.GlobalBackend,
 	}, nil
 }
 
@@ -73,3 +107,8 @@ func (m *GCPMetadata) Region() string {
 func (m *GCPMetadata) Group() string {
 	return m.group
 }
+
+// Global returns whether the backend service is global rather than regional.
+func (m *GCPMetadata) Global() bool {
+	return m.global
+}
@@ -73,6 +73,64 @@ func TestNewGCPMetadata(t *testing.T) {
 	}
 }
 
+func TestNewGCPMetadataWithTopology(t *testing.T) {
+	client := &fakeClient{proj: "mlab-sandbox", zone: "us-west1-a"}
+	hostname := "ndt-mlab1-lga0t.mlab-sandbox.measurement-lab.org-t95j"
+	backend := "mlab1-lga0t-mlab-sandbox-measurement-lab-org"
+	tests := []struct {
+		name     string
+		topology Topology
+		want     *GCPMetadata
+	}{
+		{
+			name:     "global-backend",
+			topology: Topology{GlobalBackend: true},
+			want: &GCPMetadata{
+				project: "mlab-sandbox",
+				backend: backend,
+				region:  "us-west1",
+				group:   fmt.Sprintf(groupTemplate, "mlab-sandbox", "us-west1", backend),
+				global:  true,
+			},
+		},
+		{
+			name:     "zonal-mig",
+			topology: Topology{GlobalBackend: true, ZonalGroup: true, Group: "ndt-mig"},
+			want: &GCPMetadata{
+				project: "mlab-sandbox",
+				backend: backend,
+				region:  "us-west1",
+				group:   fmt.Sprintf(zonalGroupTemplate, "mlab-sandbox", "us-west1-a", "ndt-mig"),
+				global:  true,
+			},
+		},
+		{
+			name:     "regional-mig",
+			topology: Topology{Group: "ndt-mig"},
+			want: &GCPMetadata{
+				project: "mlab-sandbox",
+				backend: backend,
+				region:  "us-west1",
+				group:   fmt.Sprintf(groupTemplate, "mlab-sandbox", "us-west1", "ndt-mig"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewGCPMetadataWithTopology(client, hostname, tt.topology)
+			if err != nil {
+				t.Fatalf("NewGCPMetadataWithTopology() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewGCPMetadataWithTopology() = %v, want %v", got, tt.want)
+			}
+			if got.Global() != tt.topology.GlobalBackend {
+				t.Errorf("GCPMetadata.Global() = %v, want %v", got.Global(), tt.topology.GlobalBackend)
+			}
+		})
+	}
+}
+
 type fakeClient struct {
 	proj    string
 	projErr bool
@@ -0,0 +1,134 @@
+package registration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// Overlay is a set of local registration fields (e.g., corrected coordinates
+// or extra service URLs) merged on top of the registration data downloaded
+// from the registration URL.
+type Overlay struct {
+	fields   map[string]json.RawMessage
+	services map[string][]string
+}
+
+// LoadOverlay reads an overlay from a JSON file with the same fields as a
+// v2.Registration. Only the fields present in the file are overridden.
+// Services are added to the configured services instead.
+func LoadOverlay(path string) (*Overlay, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseOverlay(b)
+}
+
+func parseOverlay(b []byte) (*Overlay, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+
+	known, err := toFields(v2.Registration{})
+	if err != nil {
+		return nil, err
+	}
+	o := &Overlay{fields: make(map[string]json.RawMessage)}
+	for k, v := range fields {
+		switch {
+		case k == "Hostname" || k == "Experiment":
+			return nil, fmt.Errorf("overlay cannot override %s", k)
+		case k == "Services":
+			if err := json.Unmarshal(v, &o.services); err != nil {
+				return nil, fmt.Errorf("invalid overlay services: %w", err)
+			}
+		case known[k] == nil:
+			return nil, fmt.Errorf("unknown overlay field %q", k)
+		default:
+			o.fields[k] = v
+		}
+	}
+
+	// Verify the field types by applying the overlay to an empty registration.
+	if _, _, err := o.apply(v2.Registration{}); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// apply returns reg with the overlay fields merged on top, and a description
+// of the fields whose values changed.
+func (o *Overlay) apply(reg v2.Registration) (v2.Registration, []string, error) {
+	fields, err := toFields(reg)
+	if err != nil {
+		return reg, nil, err
+	}
+
+	var conflicts []string
+	for k, v := range o.fields {
+		if !bytes.Equal(fields[k], v) {
+			conflicts = append(conflicts, fmt.Sprintf("%s: %s -> %s", k, fields[k], v))
+		}
+		fields[k] = v
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return reg, nil, err
+	}
+	var merged v2.Registration
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return reg, nil, fmt.Errorf("invalid overlay: %w", err)
+	}
+	return merged, conflicts, nil
+}
+
+// mergeServices returns svcs with the overlay service URLs for experiment
+// exp added.
+func (o *Overlay) mergeServices(exp string, svcs map[string][]string) map[string][]string {
+	if len(o.services) == 0 {
+		return svcs
+	}
+
+	merged := make(map[string][]string, len(svcs))
+	for k, v := range svcs {
+		merged[k] = append([]string(nil), v...)
+	}
+	for k, urls := range o.services {
+		if !strings.HasPrefix(k, exp+"/") {
+			continue
+		}
+		for _, u := range urls {
+			if !contains(merged[k], u) {
+				merged[k] = append(merged[k], u)
+			}
+		}
+	}
+	return merged
+}
+
+// toFields converts a registration to a map of its JSON fields.
+func toFields(reg v2.Registration) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(reg)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(b, &fields)
+	return fields, err
+}
+
+func contains(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package registration
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/go/testingx"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+func TestLoadOverlay(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{
+			name: "success",
+			path: "testdata/overlay.json",
+		},
+		{
+			name:    "non-existent-file",
+			path:    "testdata/non-existent.json",
+			wantErr: true,
+		},
+		{
+			name:    "invalid-json",
+			path:    "testdata/invalid",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadOverlay(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadOverlay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseOverlay(t *testing.T) {
+	tests := []struct {
+		name    string
+		overlay string
+		wantErr bool
+	}{
+		{
+			name:    "fields",
+			overlay: `{"City": "Newark", "Probability": 0.5}`,
+		},
+		{
+			name:    "services",
+			overlay: `{"Services": {"ndt/ndt7": ["ws:///ndt/v7/download"]}}`,
+		},
+		{
+			name:    "service-info",
+			overlay: `{"ServiceInfo": {"msak/throughput1": {"versions": ["v1"], "capabilities": ["bbr"]}}}`,
+		},
+		{
+			name:    "invalid-service-info",
+			overlay: `{"ServiceInfo": {"msak/throughput1": ["v1"]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown-field",
+			overlay: `{"Town": "Newark"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid-type",
+			overlay: `{"Latitude": "north"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid-services",
+			overlay: `{"Services": ["ws:///ndt/v7/download"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "hostname",
+			overlay: `{"Hostname": "ndt-mlab2-lga0t.mlab-sandbox.measurement-lab.org"}`,
+			wantErr: true,
+		},
+		{
+			name:    "experiment",
+			overlay: `{"Experiment": "msak"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseOverlay([]byte(tt.overlay))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseOverlay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOverlay_apply(t *testing.T) {
+	o, err := parseOverlay([]byte(`{"City": "Newark", "Uplink": "10g"}`))
+	testingx.Must(t, err, "could not parse overlay")
+
+	got, conflicts, err := o.apply(v2.Registration{City: "New York", Uplink: "10g", Site: "lga0t"})
+	testingx.Must(t, err, "could not apply overlay")
+	want := v2.Registration{City: "Newark", Uplink: "10g", Site: "lga0t"}
+	if diff := deep.Equal(got, want); diff != nil {
+		t.Errorf("Overlay.apply() = %+v, want %+v", got, want)
+	}
+	wantConflicts := []string{`City: "New York" -> "Newark"`}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("Overlay.apply() conflicts = %v, want %v", conflicts, wantConflicts)
+	}
+}
+
+func TestOverlay_mergeServices(t *testing.T) {
+	o, err := LoadOverlay("testdata/overlay.json")
+	testingx.Must(t, err, "could not load overlay")
+
+	svcs := map[string][]string{
+		"ndt/ndt7": {"ws:///ndt/v7/download", "wss://:4443/ndt/v7/download"},
+		"ndt/ndt5": {"ws://:3001/ndt_protocol"},
+	}
+	got := o.mergeServices("ndt", svcs)
+	if !reflect.DeepEqual(got, svcs) {
+		t.Errorf("Overlay.mergeServices() = %v, want %v", got, svcs)
+	}
+
+	got = o.mergeServices("msak", map[string][]string{})
+	want := map[string][]string{"msak/throughput1": {"wss:///throughput/v1/download"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Overlay.mergeServices() = %v, want %v", got, want)
+	}
+}
+
+func Test_GetRegistrationWithOverlay(t *testing.T) {
+	u, err := url.Parse(validURL)
+	testingx.Must(t, err, "could not parse URL")
+	h, err := host.Parse(validHostname)
+	testingx.Must(t, err, "could not parse hostname")
+	o, err := LoadOverlay("testdata/overlay.json")
+	testingx.Must(t, err, "could not load overlay")
+
+	ldr := &Loader{
+		url:      u,
+		hostname: h,
+		exp:      "ndt",
+		svcs:     map[string][]string{"ndt/ndt7": {"ws:///ndt/v7/download"}},
+		Overlay:  o,
+	}
+	got, err := ldr.GetRegistration(context.Background())
+	testingx.Must(t, err, "could not get registration")
+
+	want := *validMsg
+	want.Experiment = "ndt"
+	want.Latitude = 40.7128
+	want.Longitude = -74.006
+	want.Services = map[string][]string{
+		"ndt/ndt7": {"ws:///ndt/v7/download", "wss://:4443/ndt/v7/download"},
+	}
+	if diff := deep.Equal(got, &want); diff != nil {
+		t.Errorf("GetRegistration() = %+v, want %+v", got, want)
+	}
+
+	// The overlay does not count as a change in later reloads.
+	got, err = ldr.GetRegistration(context.Background())
+	if got != nil || err != nil {
+		t.Errorf("GetRegistration() = %v, %v, want nil, nil", got, err)
+	}
+}
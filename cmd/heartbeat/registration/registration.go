@@ -4,6 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"time"
 
@@ -23,6 +27,21 @@ type Loader struct {
 	exp      string
 	svcs     map[string][]string
 	reg      v2.Registration
+
+	// Client downloads http and https registration URLs, e.g. through a proxy
+	// or with client certificates or a custom CA bundle. When nil, the
+	// default HTTP client is used.
+	Client *http.Client
+	// Overlay is merged on top of the downloaded registration data, if set.
+	Overlay *Overlay
+	// Resolver looks up the IPv4 and IPv6 addresses of the service hostname
+	// included in the registration. When nil, no addresses are included.
+	Resolver Resolver
+}
+
+// Resolver looks up the IP addresses of a host (e.g., a *net.Resolver).
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
 }
 
 // NewLoader returns a new loader for registration data.
@@ -53,11 +72,7 @@ func NewLoader(ctx context.Context, url *url.URL, hostname, exp string, svcs map
 // GetRegistration downloads the registration data from the registration
 // URL and matches it with the provided hostname.
 func (ldr *Loader) GetRegistration(ctx context.Context) (*v2.Registration, error) {
-	provider, err := content.FromURL(ctx, ldr.url)
-	if err != nil {
-		return nil, err
-	}
-	exp, err := provider.Get(ctx)
+	exp, err := ldr.get(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -79,17 +94,87 @@ func (ldr *Loader) GetRegistration(ctx context.Context) (*v2.Registration, error
 	if ok {
 		// Register with fully qualified name.
 		v.Hostname = ldr.hostname.StringWithService()
+		var conflicts []string
+		if ldr.Overlay != nil {
+			v, conflicts, err = ldr.Overlay.apply(v)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ldr.Resolver != nil {
+			v.IPv4, v.IPv6 = ldr.lookupAddrs(ctx, v.Hostname)
+		}
+		// The protocol version is that of this agent, whatever the overlay says.
+		v.ProtocolVersion = v2.HeartbeatProtocolVersion
 		// If the registration has not changed, there is nothing new to return.
 		if cmp.Equal(ldr.reg, v) {
 			return nil, nil
 		}
 
+		for _, c := range conflicts {
+			log.Printf("registration overlay for %s overrides %s", v.Hostname, c)
+		}
 		ldr.reg = v
 		v.Experiment = ldr.exp
 		v.Services = ldr.svcs
+		if ldr.Overlay != nil {
+			v.Services = ldr.Overlay.mergeServices(ldr.exp, ldr.svcs)
+		}
 		metrics.RegistrationUpdateTime.Set(float64(time.Now().Unix()))
 		return &v, nil
 	}
 
 	return nil, fmt.Errorf("hostname %s not found", ldr.hostname)
 }
+
+// lookupAddrs returns the first IPv4 and IPv6 addresses of the host. Lookup
+// failures are not fatal, since the addresses are informational only.
+func (ldr *Loader) lookupAddrs(ctx context.Context, hostname string) (ipv4, ipv6 string) {
+	addrs, err := ldr.Resolver.LookupIPAddr(ctx, hostname)
+	if err != nil {
+		log.Printf("could not look up the addresses of %s: %v", hostname, err)
+		return "", ""
+	}
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			if ipv4 == "" {
+				ipv4 = a.IP.String()
+			}
+		} else if ipv6 == "" {
+			ipv6 = a.IP.String()
+		}
+	}
+	return ipv4, ipv6
+}
+
+// SetServices replaces the services of the registration. The next call to
+// GetRegistration returns the registration with the new services.
+func (ldr *Loader) SetServices(svcs map[string][]string) {
+	ldr.svcs = svcs
+	ldr.reg = v2.Registration{}
+}
+
+// get downloads the registration data from the registration URL.
+func (ldr *Loader) get(ctx context.Context) ([]byte, error) {
+	if ldr.Client == nil || (ldr.url.Scheme != "https" && ldr.url.Scheme != "http") {
+		provider, err := content.FromURL(ctx, ldr.url)
+		if err != nil {
+			return nil, err
+		}
+		return provider.Get(ctx)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ldr.url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ldr.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download registration data, status: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -2,7 +2,13 @@ package registration
 
 import (
 	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"reflect"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -32,6 +38,8 @@ var (
 		Site:          "lga0t",
 		Type:          "physical",
 		Uplink:        "10g",
+
+		ProtocolVersion: v2.HeartbeatProtocolVersion,
 	}
 	validAutojoinMsg = &v2.Registration{
 		City:          "New York",
@@ -47,6 +55,8 @@ var (
 		Site:          "lga12345",
 		Type:          "unknown",
 		Uplink:        "unknown",
+
+		ProtocolVersion: v2.HeartbeatProtocolVersion,
 	}
 )
 
@@ -265,3 +275,167 @@ func Test_GetRegistration(t *testing.T) {
 		})
 	}
 }
+
+func Test_GetRegistrationWithClient(t *testing.T) {
+	b, err := os.ReadFile("testdata/registration.json")
+	testingx.Must(t, err, "could not read registration data")
+	s := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/registration.json" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write(b)
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		client  *http.Client
+		wantErr bool
+		wantMsg *v2.Registration
+	}{
+		{
+			name:    "success",
+			path:    "/registration.json",
+			client:  s.Client(),
+			wantMsg: validMsg,
+		},
+		{
+			name:    "not-found",
+			path:    "/missing.json",
+			client:  s.Client(),
+			wantErr: true,
+		},
+		{
+			name:    "untrusted-server",
+			path:    "/registration.json",
+			client:  &http.Client{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(s.URL + tt.path)
+			testingx.Must(t, err, "could not parse URL")
+			h, err := host.Parse(validHostname)
+			testingx.Must(t, err, "could not parse hostname")
+
+			ldr := &Loader{Client: tt.client, url: u, hostname: h}
+			gotMsg, gotErr := ldr.GetRegistration(context.Background())
+			if (gotErr != nil) != tt.wantErr {
+				t.Errorf("GetRegistration() error: %v, want: %v", gotErr, tt.wantErr)
+			}
+			if diff := deep.Equal(gotMsg, tt.wantMsg); diff != nil {
+				t.Errorf("GetRegistration() message did not match; got: \n%+v, want: \n%+v", gotMsg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func Test_GetRegistrationWithClient_HTTP(t *testing.T) {
+	b, err := os.ReadFile("testdata/registration.json")
+	testingx.Must(t, err, "could not read registration data")
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write(b)
+	}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL + "/registration.json")
+	testingx.Must(t, err, "could not parse URL")
+	h, err := host.Parse(validHostname)
+	testingx.Must(t, err, "could not parse hostname")
+
+	tr := &countingTransport{}
+	ldr := &Loader{Client: &http.Client{Transport: tr}, url: u, hostname: h}
+	gotMsg, err := ldr.GetRegistration(context.Background())
+	testingx.Must(t, err, "could not get registration")
+	if diff := deep.Equal(gotMsg, validMsg); diff != nil {
+		t.Errorf("GetRegistration() message did not match; got: \n%+v, want: \n%+v", gotMsg, validMsg)
+	}
+	if tr.requests != 1 {
+		t.Errorf("GetRegistration() sent %d requests through the client, want 1", tr.requests)
+	}
+}
+
+func Test_SetServices(t *testing.T) {
+	u, err := url.Parse(validURL)
+	testingx.Must(t, err, "could not parse URL")
+	h, err := host.Parse(validHostname)
+	testingx.Must(t, err, "could not parse hostname")
+
+	ldr := &Loader{url: u, hostname: h}
+	_, err = ldr.GetRegistration(context.Background())
+	testingx.Must(t, err, "could not get registration")
+
+	svcs := map[string][]string{"ndt/ndt7": {"ws:///ndt/v7/download"}}
+	ldr.SetServices(svcs)
+	got, err := ldr.GetRegistration(context.Background())
+	testingx.Must(t, err, "could not get registration")
+	if got == nil || !reflect.DeepEqual(got.Services, svcs) {
+		t.Errorf("GetRegistration() after SetServices() = %+v, want services %v", got, svcs)
+	}
+}
+
+type fakeResolver struct {
+	addrs []net.IPAddr
+	err   error
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.addrs, r.err
+}
+
+func Test_GetRegistrationWithResolver(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver *fakeResolver
+		wantIPv4 string
+		wantIPv6 string
+	}{
+		{
+			name: "dual-stack",
+			resolver: &fakeResolver{addrs: []net.IPAddr{
+				{IP: net.ParseIP("2001:db8::1")},
+				{IP: net.ParseIP("192.0.2.1")},
+				{IP: net.ParseIP("192.0.2.2")},
+			}},
+			wantIPv4: "192.0.2.1",
+			wantIPv6: "2001:db8::1",
+		},
+		{
+			name:     "ipv4-only",
+			resolver: &fakeResolver{addrs: []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}}},
+			wantIPv4: "192.0.2.1",
+		},
+		{
+			name:     "lookup-error",
+			resolver: &fakeResolver{err: errors.New("fake lookup error")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(validURL)
+			testingx.Must(t, err, "could not parse URL")
+			h, err := host.Parse(validHostname)
+			testingx.Must(t, err, "could not parse hostname")
+
+			ldr := &Loader{url: u, hostname: h, Resolver: tt.resolver}
+			got, err := ldr.GetRegistration(context.Background())
+			testingx.Must(t, err, "could not get registration")
+			if got.IPv4 != tt.wantIPv4 || got.IPv6 != tt.wantIPv6 {
+				t.Errorf("GetRegistration() addresses = %q, %q, want %q, %q", got.IPv4, got.IPv6, tt.wantIPv4, tt.wantIPv6)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// loadServices reads a JSON file mapping service names to their URL
+// templates (e.g., {"ndt/ndt7": ["ws:///ndt/v7/download"]}) and merges it
+// with svcs. Services in the file replace those with the same name in svcs.
+func loadServices(path string, svcs map[string][]string) (map[string][]string, error) {
+	merged := make(map[string][]string, len(svcs))
+	for k, v := range svcs {
+		merged[k] = v
+	}
+	if path == "" {
+		return merged, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file map[string][]string
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, err
+	}
+	for k, v := range file {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// watchServices reloads the services file every interval until ctx is
+// done. When the services change, the services of each target are sent to
+// the channel at the same index. A pending update is replaced by a newer one.
+func watchServices(ctx context.Context, path string, interval time.Duration, svcs map[string][]string, reload []chan map[string][]string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, err := loadServices(path, svcs)
+	if err != nil {
+		log.Printf("could not load services from %s, err: %v", path, err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current, err := loadServices(path, svcs)
+			if err != nil {
+				// Keep the last services until the file is fixed.
+				log.Printf("could not reload services from %s, err: %v", path, err)
+				continue
+			}
+			if reflect.DeepEqual(current, last) {
+				continue
+			}
+			log.Printf("services changed in %s", path)
+			last = current
+			for i, t := range getTargets(experiment, hostname.Value, targets.Get(), current) {
+				if i >= len(reload) {
+					break
+				}
+				select {
+				case <-reload[i]:
+				default:
+				}
+				reload[i] <- t.services
+			}
+		}
+	}
+}
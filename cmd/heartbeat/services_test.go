@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func Test_loadServices(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "services.json")
+	rtx.Must(os.WriteFile(valid, []byte(`{"ndt/ndt7": ["wss:///ndt/v7/download"], "ndt/ndt5": ["ws://:3001/ndt_protocol"]}`), 0644), "could not write file")
+	invalid := filepath.Join(dir, "invalid.json")
+	rtx.Must(os.WriteFile(invalid, []byte(`["ndt/ndt7"]`), 0644), "could not write file")
+	svcs := map[string][]string{"ndt/ndt7": {"ws:///ndt/v7/download"}}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name: "no-file",
+			want: svcs,
+		},
+		{
+			name: "file",
+			path: valid,
+			want: map[string][]string{
+				"ndt/ndt7": {"wss:///ndt/v7/download"},
+				"ndt/ndt5": {"ws://:3001/ndt_protocol"},
+			},
+		},
+		{
+			name:    "invalid-file",
+			path:    invalid,
+			wantErr: true,
+		},
+		{
+			name:    "missing-file",
+			path:    filepath.Join(dir, "missing.json"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadServices(tt.path, svcs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadServices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadServices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_watchServices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	rtx.Must(os.WriteFile(path, []byte(`{"ndt/ndt7": ["ws:///ndt/v7/download"]}`), 0644), "could not write file")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := []chan map[string][]string{make(chan map[string][]string, 1)}
+
+	go watchServices(ctx, path, 10*time.Millisecond, nil, reload)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case svcs := <-reload[0]:
+		t.Fatalf("watchServices() sent %v before the services changed", svcs)
+	default:
+	}
+
+	rtx.Must(os.WriteFile(path, []byte(`{"ndt/ndt7": ["ws:///ndt/v7/download", "ws:///ndt/v7/upload"]}`), 0644), "could not write file")
+	want := map[string][]string{"ndt/ndt7": {"ws:///ndt/v7/download", "ws:///ndt/v7/upload"}}
+	select {
+	case got := <-reload[0]:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("watchServices() = %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchServices() did not send the changed services")
+	}
+}
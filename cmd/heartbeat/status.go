@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// ComponentReporter is implemented by Checkers that report the scores of
+// their individual checks.
+type ComponentReporter interface {
+	Components() map[string]float64
+}
+
+// targetStatus summarizes the state of a target for local debugging.
+type targetStatus struct {
+	Experiment       string
+	Hostname         string
+	Connected        bool
+	Draining         bool               `json:",omitempty"`
+	Registration     *v2.Registration   `json:",omitempty"`
+	RegistrationTime *time.Time         `json:",omitempty"`
+	Health           *v2.Health         `json:",omitempty"`
+	HealthTime       *time.Time         `json:",omitempty"`
+	Components       map[string]float64 `json:",omitempty"`
+}
+
+// agentStatus tracks the status of every target reported by the agent.
+type agentStatus struct {
+	mu      sync.Mutex
+	targets map[string]*targetStatus
+}
+
+func newAgentStatus() *agentStatus {
+	return &agentStatus{targets: map[string]*targetStatus{}}
+}
+
+// update applies f to the status of the target with the given hostname.
+func (s *agentStatus) update(t target, f func(ts *targetStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts, ok := s.targets[t.hostname]
+	if !ok {
+		ts = &targetStatus{Experiment: t.experiment, Hostname: t.hostname}
+		s.targets[t.hostname] = ts
+	}
+	f(ts)
+}
+
+// get returns a copy of the status of every target, sorted by hostname.
+func (s *agentStatus) get() []targetStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all := make([]targetStatus, 0, len(s.targets))
+	for _, ts := range s.targets {
+		all = append(all, *ts)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Hostname < all[j].Hostname
+	})
+	return all
+}
+
+// ServeHTTP writes the status of every target as JSON.
+func (s *agentStatus) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	b, err := json.MarshalIndent(s.get(), "", "  ")
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(b)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+func Test_agentStatus(t *testing.T) {
+	s := newAgentStatus()
+	msak := target{experiment: "msak", hostname: "msak-mlab1-lga0t"}
+	ndt := target{experiment: "ndt", hostname: "ndt-mlab1-lga0t"}
+	healthTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	s.update(ndt, func(ts *targetStatus) {
+		ts.Connected = true
+		ts.Health = &v2.Health{Score: 0.5}
+		ts.HealthTime = &healthTime
+		ts.Components = map[string]float64{"ports": 1, "cpu": 0.5}
+	})
+	s.update(msak, func(ts *targetStatus) { ts.Draining = true })
+	s.update(ndt, func(ts *targetStatus) { ts.Registration = &v2.Registration{Site: "lga0t"} })
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("agentStatus.ServeHTTP() status = %d, want %d", rw.Code, http.StatusOK)
+	}
+	// Times that were never set are omitted.
+	if n := strings.Count(rw.Body.String(), "Time"); n != 1 {
+		t.Errorf("agentStatus.ServeHTTP() reported %d times, want 1:\n%s", n, rw.Body.String())
+	}
+	var got []targetStatus
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("agentStatus.ServeHTTP() returned invalid JSON: %v", err)
+	}
+	want := []targetStatus{
+		{Experiment: "msak", Hostname: "msak-mlab1-lga0t", Draining: true},
+		{
+			Experiment:   "ndt",
+			Hostname:     "ndt-mlab1-lga0t",
+			Connected:    true,
+			Registration: &v2.Registration{Site: "lga0t"},
+			Health:       &v2.Health{Score: 0.5},
+			HealthTime:   &healthTime,
+			Components:   map[string]float64{"ports": 1, "cpu": 0.5},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("agentStatus.ServeHTTP() = %+v, want %+v", got, want)
+	}
+}
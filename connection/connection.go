@@ -3,10 +3,14 @@
 package connection
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -20,9 +24,24 @@ var (
 	// ErrNotDialed is returned when WriteMessage is called, but
 	// the websocket has not been created yet (call Dial).
 	ErrNotDailed = errors.New("websocket not created yet, please call Dial()")
+	// ErrClosed is returned when Close is called while connecting.
+	ErrClosed = errors.New("websocket closed")
 	// retryErrors contains the list of errors that may become successful
 	// if the request is retried.
-	retryErrors = map[int]bool{408: true, 425: true, 500: true, 502: true, 503: true, 504: true}
+	retryErrors = map[int]bool{408: true, 425: true, 429: true, 500: true, 502: true, 503: true, 504: true}
+)
+
+// connState is the state of a Conn. Dial moves a closed Conn to
+// connecting, and then to connected or, on failure, disconnected. A read or
+// write failure moves a connected Conn to disconnected, and the next write
+// reconnects it. Close moves a Conn in any state to closed.
+type connState int
+
+const (
+	stateClosed connState = iota // Dial not called yet, or Close called.
+	stateConnecting
+	stateConnected
+	stateDisconnected
 )
 
 // Conn contains the state needed to connect, reconnect, and send
@@ -46,15 +65,49 @@ type Conn struct {
 	// returns Stop. It never stops if MaxElapsedTime == 0.
 	MaxElapsedTime time.Duration
 	// DialMessage is the message sent when the connection is started.
+	// After Dial, it must only be changed with SetDialMessage.
 	DialMessage interface{}
-	dialer      websocket.Dialer
-	ws          *websocket.Conn
-	url         url.URL
-	header      http.Header
-	ticker      time.Ticker
-	mu          sync.Mutex
-	isDialed    bool
-	isConnected bool
+	// TLSClientConfig is the TLS configuration used for wss URLs, e.g. to
+	// present client certificates or trust a custom CA bundle. When nil,
+	// the default configuration is used.
+	TLSClientConfig *tls.Config
+	// Proxy returns the proxy for a request, or nil for a direct connection.
+	// NewConn sets it to use the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables.
+	Proxy func(*http.Request) (*url.URL, error)
+	// OnMessage, if set, is called with each message received from the
+	// server (e.g., configuration pushes or errors), including ping and
+	// close frames. Messages are read by a goroutine started on each
+	// connection, so OnMessage must be safe to call concurrently with the
+	// writer.
+	OnMessage func(messageType int, data []byte)
+	// PingInterval is how often pings are sent to detect half-open
+	// connections without waiting for a write to fail. Pings are disabled
+	// if PingInterval is zero.
+	PingInterval time.Duration
+	// PongTimeout is how long to wait for a pong (or any other message)
+	// after a ping before the connection is considered lost.
+	PongTimeout time.Duration
+	// QueueSize is the maximum number of messages queued by WriteMessage.
+	// When QueueSize is positive, WriteMessage queues messages and returns
+	// immediately; a goroutine sends them in order, reconnecting as needed,
+	// and the oldest messages are dropped when the queue is full. When
+	// QueueSize is zero, WriteMessage sends and reconnects synchronously.
+	QueueSize int
+	dialer    websocket.Dialer
+	url       url.URL
+	// writeMu serializes writers, including reconnections.
+	writeMu sync.Mutex
+	// mu guards the fields below.
+	mu       sync.Mutex
+	state    connState
+	ws       *websocket.Conn
+	header   http.Header
+	cause    string // Cause of the last disconnection detected by read.
+	queue    []message
+	flushing bool
+	ctx      context.Context // Cancelled by Close to stop reconnections.
+	cancel   context.CancelFunc
 }
 
 // NewConn creates a new Conn with default values.
@@ -65,6 +118,7 @@ func NewConn() *Conn {
 		Multiplier:          static.BackoffMultiplier,
 		MaxInterval:         static.BackoffMaxInterval,
 		MaxElapsedTime:      static.BackoffMaxElapsedTime,
+		Proxy:               http.ProxyFromEnvironment,
 	}
 	return c
 }
@@ -79,19 +133,53 @@ func NewConn() *Conn {
 // be called again if the connection needs to be recreated.
 //
 // The function returns an error if the url is invalid or if
-// a 4XX error (except 408 and 425) is received in the HTTP
+// a 4XX error (except 408, 425 and 429) is received in the HTTP
 // response.
 func (c *Conn) Dial(address string, header http.Header, dialMsg interface{}) error {
+	return c.DialContext(context.Background(), address, header, dialMsg)
+}
+
+// DialContext is like Dial, but stops retrying and returns the context's
+// error if ctx is done before the connection is established.
+func (c *Conn) DialContext(ctx context.Context, address string, header http.Header, dialMsg interface{}) error {
 	u, err := url.ParseRequestURI(address)
 	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
 		return errors.New("malformed ws or wss URL")
 	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	c.url = *u
+	c.dialer = websocket.Dialer{
+		Proxy:           c.Proxy,
+		TLSClientConfig: c.TLSClientConfig,
+	}
+	c.mu.Lock()
 	c.DialMessage = dialMsg
-	c.header = header
-	c.dialer = websocket.Dialer{}
-	c.isDialed = true
-	return c.connect()
+	c.header = header.Clone()
+	c.state = stateConnecting
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.mu.Unlock()
+	return c.connect(ctx)
+}
+
+// SetHeader replaces the HTTP header sent when the connection is
+// reestablished (e.g., after refreshing a token).
+func (c *Conn) SetHeader(header http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header = header.Clone()
+}
+
+// SetDialMessage replaces the message sent when the connection is
+// reestablished.
+func (c *Conn) SetDialMessage(msg interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.DialMessage = msg
 }
 
 // WriteMessage sends the JSON encoding of `data` as a message.
@@ -106,58 +194,115 @@ func (c *Conn) Dial(address string, header http.Header, dialMsg interface{}) err
 //  3. The write call in the websocket package failed
 //     (gorilla/websocket error).
 func (c *Conn) WriteMessage(messageType int, data interface{}) error {
-	if !c.isDialed {
+	return c.WriteMessageContext(context.Background(), messageType, data)
+}
+
+// WriteMessageContext is like WriteMessage, but stops trying to reconnect
+// and returns the context's error if ctx is done before the connection is
+// reestablished.
+//
+// If QueueSize is positive, the message is queued instead and ctx is not
+// used.
+func (c *Conn) WriteMessageContext(ctx context.Context, messageType int, data interface{}) error {
+	if !c.dialed() {
 		return ErrNotDailed
 	}
+	if c.QueueSize > 0 {
+		c.enqueue(message{messageType: messageType, data: data})
+		return nil
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.send(ctx, message{messageType: messageType, data: data})
+}
 
+// send writes m, reconnecting first if a disconnect has been detected, and
+// reconnecting and writing m again if the write fails. The caller must hold
+// writeMu.
+func (c *Conn) send(ctx context.Context, m message) error {
 	// If a disconnect has already been detected, try to reconnect.
 	if !c.IsConnected() {
-		if err := c.closeAndReconnect(); err != nil {
+		c.mu.Lock()
+		cause := c.cause
+		c.mu.Unlock()
+		if cause == "" {
+			cause = "disconnected"
+		}
+		if err := c.closeAndReconnect(ctx, cause); err != nil {
 			return err
 		}
 	}
 
 	// If the write fails, reconnect and send the message again.
-	if err := c.write(messageType, data); err != nil {
-		if err := c.closeAndReconnect(); err != nil {
+	if err := c.write(m.messageType, m.data); err != nil {
+		setLastError(err, nil)
+		if err := c.closeAndReconnect(ctx, "write error"); err != nil {
 			return err
 		}
-		return c.write(messageType, data)
+		return c.write(m.messageType, m.data)
 	}
 	return nil
 }
 
 // IsConnected returns the WebSocket connection state.
 func (c *Conn) IsConnected() bool {
-	return c.isConnected
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state == stateConnected
 }
 
 // Close closes the network connection and cleans up private
-// resources after the connection is done.
+// resources after the connection is done. Queued messages are dropped,
+// and a concurrent (re)connection stops with ErrClosed.
 func (c *Conn) Close() error {
-	if c.isDialed {
-		c.isDialed = false
+	c.mu.Lock()
+	connected := c.state == stateConnected
+	c.state = stateClosed
+	c.queue = nil
+	if c.cancel != nil {
+		c.cancel()
+	}
+	ws := c.ws
+	c.mu.Unlock()
+	if connected && ws != nil {
+		metrics.ConnectionEstablishedTime.Set(0)
+		return ws.Close()
 	}
-	return c.close()
+	return nil
+}
+
+// dialed returns whether Dial has been called since the last Close.
+func (c *Conn) dialed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state != stateClosed
 }
 
-// closeAndReconnect calls close and reconnects.
-func (c *Conn) closeAndReconnect() error {
+// closeAndReconnect calls close and reconnects, counting the reconnection
+// by its cause.
+func (c *Conn) closeAndReconnect(ctx context.Context, cause string) error {
+	metrics.ConnectionReconnectsTotal.WithLabelValues(cause).Inc()
 	err := c.close()
 	if err != nil {
 		return err
 	}
-	return c.connect()
+	return c.connect(ctx)
 }
 
 // close closes the underlying network connection without
 // sending or waiting for a close frame.
 func (c *Conn) close() error {
-	if c.IsConnected() {
-		c.isConnected = false
-		if c.ws != nil {
-			return c.ws.Close()
-		}
+	c.mu.Lock()
+	connected := c.state == stateConnected
+	if connected {
+		c.state = stateDisconnected
+	}
+	ws := c.ws
+	c.mu.Unlock()
+	if connected && ws != nil {
+		metrics.ConnectionEstablishedTime.Set(0)
+		return ws.Close()
 	}
 	return nil
 }
@@ -165,41 +310,212 @@ func (c *Conn) close() error {
 // connect creates a new client connection and sends the
 // registration message.
 // In case of failure, it uses an exponential backoff to
-// increase the duration of retry attempts.
-func (c *Conn) connect() error {
+// increase the duration of retry attempts until ctx is done. If the server
+// responds with a Retry-After header, the next attempt waits for that long
+// instead, up to MaxInterval and the time left before MaxElapsedTime.
+func (c *Conn) connect(ctx context.Context) error {
 	b := c.getBackoff()
-	ticker := backoff.NewTicker(b)
+	b.Reset()
 
-	var ws *websocket.Conn
-	var resp *http.Response
-	var err error
-	for range ticker.C {
-		ws, resp, err = c.dialer.Dial(c.url.String(), c.header)
-		if err != nil {
-			if resp != nil && !retryErrors[resp.StatusCode] {
-				log.Printf("error trying to establish a connection with %s, err: %v, status: %d",
-					c.url.String(), err, resp.StatusCode)
-				metrics.ConnectionRequestsTotal.WithLabelValues("error").Inc()
-				ticker.Stop()
-				return err
+	c.mu.Lock()
+	if c.state == stateClosed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.state = stateConnecting
+	closed := c.ctx.Done()
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		header := c.header
+		c.mu.Unlock()
+		ws, resp, err := c.dialer.DialContext(ctx, c.url.String(), header)
+		if err == nil {
+			c.mu.Lock()
+			if c.state == stateClosed {
+				c.mu.Unlock()
+				ws.Close()
+				return ErrClosed
+			}
+			c.ws = ws
+			c.state = stateConnected
+			c.cause = ""
+			dialMsg := c.DialMessage
+			c.mu.Unlock()
+			if c.OnMessage != nil || c.PingInterval > 0 {
+				go c.read(ws)
 			}
-			log.Printf("could not establish a connection with %s (will retry), err: %v",
-				c.url.String(), err)
-			metrics.ConnectionRequestsTotal.WithLabelValues("retry").Inc()
-			continue
+			if c.PingInterval > 0 {
+				go c.ping(ws)
+			}
+			log.Printf("successfully established a connection with %s", c.url.String())
+			metrics.ConnectionRequestsTotal.WithLabelValues("OK").Inc()
+			metrics.ConnectionEstablishedTime.Set(float64(time.Now().Unix()))
+			return c.write(websocket.TextMessage, dialMsg)
 		}
 
-		c.ws = ws
-		c.isConnected = true
-		log.Printf("successfully established a connection with %s", c.url.String())
-		metrics.ConnectionRequestsTotal.WithLabelValues("OK").Inc()
-		ticker.Stop()
+		setLastError(err, resp)
+		if resp != nil && !retryErrors[resp.StatusCode] {
+			log.Printf("error trying to establish a connection with %s, err: %v, status: %d",
+				c.url.String(), err, resp.StatusCode)
+			metrics.ConnectionRequestsTotal.WithLabelValues("error").Inc()
+			c.setDisconnected()
+			return err
+		}
+		if ctx.Err() != nil {
+			c.setDisconnected()
+			return ctx.Err()
+		}
+		next := b.NextBackOff()
+		if next == backoff.Stop {
+			c.setDisconnected()
+			return err
+		}
+		if d, ok := retryAfter(resp, time.Now()); ok {
+			next = capDelay(d, c.MaxInterval, c.MaxElapsedTime-b.GetElapsedTime())
+		}
+		log.Printf("could not establish a connection with %s (will retry in %v), err: %v",
+			c.url.String(), next, err)
+		metrics.ConnectionRequestsTotal.WithLabelValues("retry").Inc()
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			c.setDisconnected()
+			return ctx.Err()
+		case <-closed:
+			timer.Stop()
+			return ErrClosed
+		case <-timer.C:
+			metrics.ConnectionBackoffSecondsTotal.Add(next.Seconds())
+		}
 	}
+}
 
-	if c.isConnected {
-		err = c.write(websocket.TextMessage, c.DialMessage)
+// setDisconnected marks a failed connection attempt, unless the Conn has
+// been closed.
+func (c *Conn) setDisconnected() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != stateClosed {
+		c.state = stateDisconnected
+	}
+}
+
+// retryAfter returns the delay suggested by the Retry-After header of a
+// 429 or 503 response, given in seconds or as an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests &&
+		resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// capDelay limits the delay d to maxInterval and to left, the time left
+// before the backoff stops. Zero or negative limits are ignored, since they
+// stand for no MaxInterval or MaxElapsedTime.
+func capDelay(d, maxInterval, left time.Duration) time.Duration {
+	if maxInterval > 0 && d > maxInterval {
+		d = maxInterval
+	}
+	if left > 0 && d > left {
+		d = left
+	}
+	return d
+}
+
+// read dispatches the messages received on ws to OnMessage until reading
+// fails. A failure marks the connection as disconnected, so the next write
+// reconnects. If pings are enabled, reading also fails when nothing
+// (including a pong) is received within PingInterval plus PongTimeout.
+func (c *Conn) read(ws *websocket.Conn) {
+	extendDeadline := func() {
+		if c.PingInterval > 0 {
+			ws.SetReadDeadline(time.Now().Add(c.PingInterval + c.PongTimeout))
+		}
+	}
+	extendDeadline()
+	ws.SetPongHandler(func(string) error {
+		extendDeadline()
+		return nil
+	})
+	ws.SetPingHandler(func(data string) error {
+		extendDeadline()
+		if c.OnMessage != nil {
+			c.OnMessage(websocket.PingMessage, []byte(data))
+		}
+		err := ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		messageType, data, err := ws.ReadMessage()
+		if err != nil {
+			cause := "read error"
+			var ce *websocket.CloseError
+			var ne net.Error
+			if errors.As(err, &ce) {
+				cause = "closed by server"
+				if c.OnMessage != nil {
+					c.OnMessage(websocket.CloseMessage, []byte(ce.Text))
+				}
+			} else if errors.As(err, &ne) && ne.Timeout() {
+				cause = "pong timeout"
+			}
+			c.mu.Lock()
+			// Do not mark a newer connection as disconnected.
+			if c.ws == ws && c.state == stateConnected {
+				setLastError(err, nil)
+				metrics.ConnectionEstablishedTime.Set(0)
+				log.Printf("lost connection with %s, err: %v", c.url.String(), err)
+				c.state = stateDisconnected
+				c.cause = cause
+				ws.Close()
+			}
+			c.mu.Unlock()
+			return
+		}
+		extendDeadline()
+		if c.OnMessage != nil {
+			c.OnMessage(messageType, data)
+		}
+	}
+}
+
+// ping sends a ping on ws every PingInterval while it is the current
+// connection.
+func (c *Conn) ping(ws *websocket.Conn) {
+	ticker := time.NewTicker(c.PingInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		current := c.ws == ws && c.state == stateConnected
+		c.mu.Unlock()
+		if !current {
+			return
+		}
+		// A failed ping is detected by read when the deadline passes.
+		ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
 	}
-	return err
 }
 
 // write is a helper function that gets a writer using NextWriter,
@@ -214,9 +530,12 @@ func (c *Conn) write(messageType int, data interface{}) error {
 	// NextWriter is called with a PingMessage type because it is
 	// effectively a no-op, while using other message types can
 	// cause side-effects (e.g, loading an empty msg to the buffer).
-	w, err := c.ws.NextWriter(websocket.PingMessage)
+	c.mu.Lock()
+	ws := c.ws
+	c.mu.Unlock()
+	w, err := ws.NextWriter(websocket.PingMessage)
 	if err == nil {
-		err = c.ws.WriteJSON(data)
+		err = ws.WriteJSON(data)
 		w.Close()
 	}
 	return err
@@ -1,9 +1,17 @@
 package connection
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -111,6 +119,355 @@ func Test_Dial_ServerDown(t *testing.T) {
 	}
 }
 
+func Test_DialContext_Cancelled(t *testing.T) {
+	c := NewConn()
+	defer c.Close()
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	s.Close()
+
+	// Without the context, the backoff would retry forever.
+	c.InitialInterval = 100 * time.Millisecond
+	c.MaxElapsedTime = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	err := c.DialContext(ctx, s.URL, http.Header{}, testdata.FakeRegistration)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("DialContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWriteMessageContext_Cancelled(t *testing.T) {
+	c := NewConn()
+	defer c.Close()
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	c.InitialInterval = 100 * time.Millisecond
+	c.MaxElapsedTime = 0
+	if err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	// Shut down server for testing.
+	fh.Close()
+	s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		err := c.WriteMessageContext(ctx, websocket.TextMessage, []byte("Health message!"))
+		if err == nil {
+			t.Fatal("WriteMessageContext() should fail after the context is cancelled")
+		}
+	}
+	if c.IsConnected() {
+		t.Errorf("IsConnected() should be false after writing to closed server.")
+	}
+}
+
+func Test_OnMessage(t *testing.T) {
+	c := NewConn()
+	defer c.Close()
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	defer s.Close()
+
+	type message struct {
+		messageType int
+		data        string
+	}
+	msgs := make(chan message, 10)
+	c.OnMessage = func(messageType int, data []byte) {
+		msgs <- message{messageType, string(data)}
+	}
+	c.InitialInterval = 100 * time.Millisecond
+	c.MaxElapsedTime = time.Second
+	if err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if _, err := fh.Read(); err != nil {
+		t.Fatalf("could not read dial message: %v", err)
+	}
+
+	if err := fh.Write([]byte("config")); err != nil {
+		t.Fatalf("could not write message: %v", err)
+	}
+	if err := fh.Ping([]byte("ping")); err != nil {
+		t.Fatalf("could not write ping: %v", err)
+	}
+	fh.Close()
+
+	want := []message{
+		{websocket.TextMessage, "config"},
+		{websocket.PingMessage, "ping"},
+	}
+	for _, w := range want {
+		select {
+		case got := <-msgs:
+			if got != w {
+				t.Errorf("OnMessage() got %v, want %v", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnMessage() was not called with %v", w)
+		}
+	}
+
+	// The read failure after the server closes is detected without writing.
+	deadline := time.Now().Add(5 * time.Second)
+	for c.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.IsConnected() {
+		t.Error("IsConnected() should be false after the server closed the connection")
+	}
+}
+
+func Test_PingTimeout(t *testing.T) {
+	c := NewConn()
+	defer c.Close()
+	fh := testdata.FakeHandler{}
+	// The fake server does not read, so it never answers pings.
+	s := testdata.FakeServer(fh.Upgrade)
+	defer s.Close()
+
+	c.PingInterval = 100 * time.Millisecond
+	c.PongTimeout = 100 * time.Millisecond
+	if err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.IsConnected() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.IsConnected() {
+		t.Fatal("IsConnected() should be false when pings are not answered")
+	}
+
+	// The next write reconnects.
+	if err := c.WriteMessage(websocket.TextMessage, []byte("Health message!")); err != nil {
+		t.Errorf("WriteMessage() error = %v", err)
+	}
+	if !c.IsConnected() {
+		t.Error("IsConnected() should be true after reconnecting")
+	}
+}
+
+func Test_PingAnswered(t *testing.T) {
+	c := NewConn()
+	defer c.Close()
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	defer s.Close()
+
+	c.PingInterval = 50 * time.Millisecond
+	c.PongTimeout = 100 * time.Millisecond
+	if err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	// Reading on the server answers pings with pongs.
+	go func() {
+		for {
+			if _, err := fh.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	if !c.IsConnected() {
+		t.Error("IsConnected() should be true while pings are answered")
+	}
+}
+
+func Test_Dial_TLS(t *testing.T) {
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeTLSServer(fh.Upgrade)
+	defer s.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+
+	tests := []struct {
+		name    string
+		config  *tls.Config
+		wantErr bool
+	}{
+		{
+			name:    "untrusted-server",
+			wantErr: true,
+		},
+		{
+			name:   "custom-ca",
+			config: &tls.Config{RootCAs: roots},
+		},
+		{
+			name:   "server-name",
+			config: &tls.Config{RootCAs: roots, ServerName: "example.com"},
+		},
+		{
+			name:    "wrong-server-name",
+			config:  &tls.Config{RootCAs: roots, ServerName: "locate.example.org"},
+			wantErr: true,
+		},
+		{
+			name:   "insecure-skip-verify",
+			config: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConn()
+			defer c.Close()
+			c.InitialInterval = 100 * time.Millisecond
+			c.MaxElapsedTime = 300 * time.Millisecond
+			c.TLSClientConfig = tt.config
+
+			err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_Dial_RetryAfter(t *testing.T) {
+	fh := testdata.FakeHandler{}
+	var mu sync.Mutex
+	attempts := 0
+	s := testdata.FakeServer(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n == 1 {
+			rw.Header().Set("Retry-After", "1")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fh.Upgrade(rw, req)
+	})
+	defer s.Close()
+
+	c := NewConn()
+	defer c.Close()
+	c.InitialInterval = 10 * time.Millisecond
+	c.RandomizationFactor = 0
+	c.MaxElapsedTime = 5 * time.Second
+
+	start := time.Now()
+	if err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Dial() retried after %v, want at least the Retry-After of 1s", elapsed)
+	}
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		status int
+		header string
+		want   time.Duration
+		wantOK bool
+	}{
+		{
+			name:   "seconds",
+			status: http.StatusTooManyRequests,
+			header: "30",
+			want:   30 * time.Second,
+			wantOK: true,
+		},
+		{
+			name:   "http-date",
+			status: http.StatusServiceUnavailable,
+			header: now.Add(time.Minute).Format(http.TimeFormat),
+			want:   time.Minute,
+			wantOK: true,
+		},
+		{
+			name:   "past-date",
+			status: http.StatusServiceUnavailable,
+			header: now.Add(-time.Minute).Format(http.TimeFormat),
+			want:   0,
+			wantOK: true,
+		},
+		{
+			name:   "no-header",
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:   "invalid-header",
+			status: http.StatusTooManyRequests,
+			header: "soon",
+		},
+		{
+			name:   "other-status",
+			status: http.StatusBadGateway,
+			header: "30",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := retryAfter(resp, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_capDelay(t *testing.T) {
+	tests := []struct {
+		name        string
+		d           time.Duration
+		maxInterval time.Duration
+		left        time.Duration
+		want        time.Duration
+	}{
+		{
+			name:        "within-limits",
+			d:           time.Minute,
+			maxInterval: time.Hour,
+			left:        time.Hour,
+			want:        time.Minute,
+		},
+		{
+			name:        "max-interval",
+			d:           24 * time.Hour,
+			maxInterval: time.Hour,
+			left:        2 * time.Hour,
+			want:        time.Hour,
+		},
+		{
+			name:        "elapsed-time-left",
+			d:           24 * time.Hour,
+			maxInterval: time.Hour,
+			left:        time.Minute,
+			want:        time.Minute,
+		},
+		{
+			name: "no-limits",
+			d:    24 * time.Hour,
+			left: -time.Minute,
+			want: 24 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := capDelay(tt.d, tt.maxInterval, tt.left); got != tt.want {
+				t.Errorf("capDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_Dial_BadRequest(t *testing.T) {
 	c := NewConn()
 	fh := testdata.FakeHandler{}
@@ -204,3 +561,136 @@ func close(c *Conn, s *httptest.Server) {
 	c.Close()
 	s.Close()
 }
+
+func Test_Dial_Proxy(t *testing.T) {
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	defer s.Close()
+
+	// The proxy tunnels CONNECT requests to their destination.
+	var mu sync.Mutex
+	connects := 0
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		connects++
+		mu.Unlock()
+		dst, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		src, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			dst.Close()
+			return
+		}
+		go func() {
+			io.Copy(dst, src)
+			dst.Close()
+		}()
+		go func() {
+			io.Copy(src, dst)
+			src.Close()
+		}()
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("could not parse proxy URL: %v", err)
+	}
+
+	c := NewConn()
+	c.Proxy = http.ProxyURL(proxyURL)
+	defer c.Close()
+	if err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration); err != nil {
+		t.Fatalf("Dial() should have returned nil error, err: %v", err)
+	}
+	if !c.IsConnected() {
+		t.Error("Dial() error, not connected")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if connects != 1 {
+		t.Errorf("Dial() proxy CONNECT requests = %d, want 1", connects)
+	}
+}
+
+func Test_ConcurrentAccess(t *testing.T) {
+	c := NewConn()
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	defer s.Close()
+	c.InitialInterval = 10 * time.Millisecond
+	c.MaxElapsedTime = time.Second
+	c.OnMessage = func(int, []byte) {}
+	if err := c.Dial(s.URL, http.Header{}, testdata.FakeRegistration); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	// Run with -race to detect unsynchronized accesses.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				switch i {
+				case 0:
+					c.WriteMessage(websocket.TextMessage, testdata.FakeHealth)
+				case 1:
+					c.SetHeader(http.Header{"Authorization": {"Bearer " + strconv.Itoa(j)}})
+				case 2:
+					c.SetDialMessage(testdata.FakeRegistration)
+				case 3:
+					// Force reconnections from the server side.
+					fh.Close()
+				}
+				c.IsConnected()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if c.IsConnected() {
+		t.Error("IsConnected() should be false after Close()")
+	}
+	if err := c.WriteMessage(websocket.TextMessage, testdata.FakeHealth); !errors.Is(err, ErrNotDailed) {
+		t.Errorf("WriteMessage() error = %v, want %v", err, ErrNotDailed)
+	}
+}
+
+func Test_Close_WhileConnecting(t *testing.T) {
+	c := NewConn()
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	s.Close()
+
+	// Without Close, the backoff would retry forever.
+	c.InitialInterval = 50 * time.Millisecond
+	c.MaxElapsedTime = 0
+	errs := make(chan error)
+	go func() {
+		errs <- c.Dial(s.URL, http.Header{}, testdata.FakeRegistration)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Dial() error = %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Dial() did not return after Close()")
+	}
+}
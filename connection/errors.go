@@ -0,0 +1,58 @@
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/m-lab/locate/metrics"
+)
+
+// errorClasses are the classes of connection errors reported by the
+// ConnectionLastError metric.
+var errorClasses = []string{
+	"cancelled", "dns", "refused", "timeout", "tls", "http 4xx", "http 5xx", "other",
+}
+
+// errorClass classifies a connection error and, if available, the HTTP
+// response of a failed handshake.
+func errorClass(err error, resp *http.Response) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var record tls.RecordHeaderError
+	switch {
+	case resp != nil && resp.StatusCode >= 500:
+		return "http 5xx"
+	case resp != nil && resp.StatusCode >= 400:
+		return "http 4xx"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname),
+		errors.As(err, &invalid), errors.As(err, &record):
+		return "tls"
+	default:
+		return "other"
+	}
+}
+
+// setLastError sets the ConnectionLastError metric to the class of err.
+func setLastError(err error, resp *http.Response) {
+	class := errorClass(err, resp)
+	for _, c := range errorClasses {
+		metrics.ConnectionLastError.WithLabelValues(c).Set(0)
+	}
+	metrics.ConnectionLastError.WithLabelValues(class).Set(1)
+}
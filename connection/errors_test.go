@@ -0,0 +1,85 @@
+package connection
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func Test_errorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		resp *http.Response
+		want string
+	}{
+		{
+			name: "http-5xx",
+			err:  errors.New("websocket: bad handshake"),
+			resp: &http.Response{StatusCode: http.StatusServiceUnavailable},
+			want: "http 5xx",
+		},
+		{
+			name: "http-4xx",
+			err:  errors.New("websocket: bad handshake"),
+			resp: &http.Response{StatusCode: http.StatusForbidden},
+			want: "http 4xx",
+		},
+		{
+			name: "cancelled",
+			err:  fmt.Errorf("dial: %w", context.Canceled),
+			want: "cancelled",
+		},
+		{
+			name: "dns",
+			err:  &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "locate.invalid"}},
+			want: "dns",
+		},
+		{
+			name: "refused",
+			err:  &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want: "refused",
+		},
+		{
+			name: "timeout",
+			err:  context.DeadlineExceeded,
+			want: "timeout",
+		},
+		{
+			name: "tls",
+			err:  &tlsError{x509.UnknownAuthorityError{}},
+			want: "tls",
+		},
+		{
+			name: "other",
+			err:  errors.New("unexpected EOF"),
+			want: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorClass(tt.err, tt.resp); got != tt.want {
+				t.Errorf("errorClass() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// tlsError wraps a certificate verification error like crypto/tls does.
+type tlsError struct {
+	err error
+}
+
+func (e *tlsError) Error() string {
+	return "tls: failed to verify certificate: " + e.err.Error()
+}
+
+func (e *tlsError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,89 @@
+package connection
+
+import (
+	"log"
+
+	"github.com/m-lab/locate/metrics"
+)
+
+// message is a message queued by WriteMessage.
+type message struct {
+	messageType int
+	data        interface{}
+}
+
+// enqueue appends m to the queue, dropping the oldest message if the queue
+// is full, and starts a goroutine to flush the queue if none is running.
+func (c *Conn) enqueue(m message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queue) >= c.QueueSize {
+		c.queue = c.queue[1:]
+		metrics.ConnectionQueueDropsTotal.Inc()
+	}
+	c.queue = append(c.queue, m)
+	if !c.flushing {
+		c.flushing = true
+		go c.flush()
+	}
+}
+
+// flush sends the queued messages in order until the queue is empty,
+// reconnecting whenever the connection is lost. It stops without sending
+// the remaining messages if it cannot reconnect or Close is called.
+func (c *Conn) flush() {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 || c.state == stateClosed {
+			c.flushing = false
+			c.mu.Unlock()
+			return
+		}
+		m := c.queue[0]
+		c.queue = c.queue[1:]
+		ctx, cause := c.ctx, c.cause
+		c.mu.Unlock()
+
+		// Wait for concurrent synchronous writers, if any.
+		c.writeMu.Lock()
+		if !c.IsConnected() {
+			if cause == "" {
+				cause = "disconnected"
+			}
+			if err := c.closeAndReconnect(ctx, cause); err != nil {
+				log.Printf("could not reconnect to send queued messages, err: %v", err)
+				c.requeue(m)
+				c.writeMu.Unlock()
+				c.mu.Lock()
+				c.flushing = false
+				c.mu.Unlock()
+				return
+			}
+		}
+		if err := c.write(m.messageType, m.data); err != nil {
+			setLastError(err, nil)
+			// Send the message again after reconnecting.
+			c.requeue(m)
+			c.close()
+			c.mu.Lock()
+			c.cause = "write error"
+			c.mu.Unlock()
+		}
+		c.writeMu.Unlock()
+	}
+}
+
+// requeue puts m back at the front of the queue, unless the queue has been
+// filled up since m was taken from it.
+func (c *Conn) requeue(m message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == stateClosed {
+		return
+	}
+	if len(c.queue) >= c.QueueSize {
+		metrics.ConnectionQueueDropsTotal.Inc()
+		return
+	}
+	c.queue = append([]message{m}, c.queue...)
+}
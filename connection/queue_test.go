@@ -0,0 +1,74 @@
+package connection
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/m-lab/locate/connection/testdata"
+)
+
+func TestConn_enqueue(t *testing.T) {
+	c := NewConn()
+	c.QueueSize = 2
+	c.state = stateDisconnected
+	// Pretend a flush is in progress so that messages stay queued.
+	c.flushing = true
+
+	for _, d := range []string{"1", "2", "3"} {
+		c.enqueue(message{messageType: websocket.TextMessage, data: d})
+	}
+	want := []message{
+		{messageType: websocket.TextMessage, data: "2"},
+		{messageType: websocket.TextMessage, data: "3"},
+	}
+	if !reflect.DeepEqual(c.queue, want) {
+		t.Errorf("enqueue() queue = %v, want %v", c.queue, want)
+	}
+}
+
+func TestConn_WriteMessageQueued(t *testing.T) {
+	c := NewConn()
+	defer c.Close()
+	fh := testdata.FakeHandler{}
+	s := testdata.FakeServer(fh.Upgrade)
+	defer s.Close()
+
+	c.QueueSize = 10
+	c.InitialInterval = 100 * time.Millisecond
+	c.MaxElapsedTime = 5 * time.Second
+	if err := c.Dial(s.URL, http.Header{}, "dial"); err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if _, err := fh.Read(); err != nil {
+		t.Fatalf("could not read dial message: %v", err)
+	}
+
+	// Messages written while disconnected are sent in order after the dial
+	// message of the new connection.
+	c.close()
+	for _, d := range []string{"1", "2", "3"} {
+		if err := c.WriteMessage(websocket.TextMessage, d); err != nil {
+			t.Fatalf("WriteMessage() error = %v", err)
+		}
+	}
+
+	want := []string{`"dial"`, `"1"`, `"2"`, `"3"`}
+	var got []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(got) < len(want) && time.Now().Before(deadline) {
+		msg, err := fh.Read()
+		if err != nil {
+			// The server is still reading the old connection.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		got = append(got, strings.TrimSpace(string(msg)))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteMessage() sent %v, want %v", got, want)
+	}
+}
@@ -17,6 +17,14 @@ func FakeServer(handler func(http.ResponseWriter, *http.Request)) *httptest.Serv
 	return s
 }
 
+func FakeTLSServer(handler func(http.ResponseWriter, *http.Request)) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/v2/heartbeat", http.HandlerFunc(handler))
+	s := httptest.NewTLSServer(mux)
+	s.URL = strings.Replace(s.URL, "https", "wss", 1) + "/v2/heartbeat"
+	return s
+}
+
 type FakeHandler struct {
 	mu   sync.Mutex
 	conn *websocket.Conn
@@ -40,6 +48,18 @@ func (fh *FakeHandler) Read() ([]byte, error) {
 	return msg, err
 }
 
+func (fh *FakeHandler) Write(msg []byte) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	return fh.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+func (fh *FakeHandler) Ping(msg []byte) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	return fh.conn.WriteMessage(websocket.PingMessage, msg)
+}
+
 func (fh *FakeHandler) Close() {
 	fh.mu.Lock()
 	defer fh.mu.Unlock()
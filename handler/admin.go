@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/apikey"
+	"github.com/m-lab/locate/siteinfo"
+)
+
+// GeoReloader reloads client geo data and reports the build date of the
+// loaded database.
+type GeoReloader interface {
+	Reload(ctx context.Context)
+	BuildDate() time.Time
+}
+
+// ReloadResult is returned by the ReloadGeo handler.
+type ReloadResult struct {
+	// BuildDate is the build date of the database loaded after the reload.
+	BuildDate time.Time `json:"build_date"`
+}
+
+// ReloadGeo returns a handler that forces a reload of the given client geo
+// database and reports the build date of the loaded data. The handler should
+// only be registered behind an authenticating middleware.
+func ReloadGeo(r GeoReloader) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		r.Reload(req.Context())
+		result := ReloadResult{BuildDate: r.BuildDate()}
+		log.Infof("reloaded client geo database, build date: %s", result.BuildDate)
+		rw.Header().Set("Content-Type", "application/json")
+		writeResult(rw, http.StatusOK, &result)
+	}
+}
+
+// UsageReporter reports the daily requests of each integration.
+type UsageReporter interface {
+	Report(t time.Time) (*apikey.UsageReport, error)
+}
+
+// Usage returns a handler that reports the requests of each integration and
+// API key on the day given by the "date" parameter (YYYY-MM-DD), or today.
+// The handler should only be registered behind an authenticating middleware.
+func Usage(r UsageReporter) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		day := time.Now().UTC()
+		if date := req.URL.Query().Get("date"); date != "" {
+			var err error
+			day, err = time.Parse("2006-01-02", date)
+			if err != nil {
+				v2Error := v2.NewError(v2.ErrorTypeInvalidRequest, "Invalid date parameter; must be YYYY-MM-DD", http.StatusBadRequest)
+				writeResult(rw, v2Error.Status, v2Error)
+				return
+			}
+		}
+		report, err := r.Report(day)
+		if err != nil {
+			log.Errorf("Failed to read usage report: %v", err)
+			v2Error := v2.NewError(v2.ErrorTypeInternal, "Failed to read usage report", http.StatusInternalServerError)
+			writeResult(rw, v2Error.Status, v2Error)
+			return
+		}
+		writeResult(rw, http.StatusOK, report)
+	}
+}
+
+// RegistrationSource provides the authoritative siteinfo registrations.
+type RegistrationSource interface {
+	Get(ctx context.Context) (map[string]v2.Registration, error)
+}
+
+// SiteinfoDiff returns a handler that reports the machines registered with
+// Locate but missing from the siteinfo registrations, and vice versa. The
+// handler should only be registered behind an authenticating middleware.
+func (c *Client) SiteinfoDiff(src RegistrationSource) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		regs, err := src.Get(req.Context())
+		if err != nil {
+			log.Errorf("Failed to load siteinfo registrations: %v", err)
+			v2Error := v2.NewError(v2.ErrorTypeUnavailable, "Failed to load siteinfo registrations", http.StatusBadGateway)
+			writeResult(rw, v2Error.Status, v2Error)
+			return
+		}
+		result := siteinfo.Diff(c.LocatorV2.Instances(), regs)
+		writeResult(rw, http.StatusOK, &result)
+	}
+}
+
+// RuntimeStats summarizes the runtime state of the process.
+type RuntimeStats struct {
+	Goroutines   int    `json:"goroutines"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys_bytes"`
+	TotalAlloc   uint64 `json:"total_alloc_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"gc_pause_total_ns"`
+}
+
+// Runtime reports the RuntimeStats of the process. The handler should only
+// be registered behind an authenticating middleware.
+func Runtime(rw http.ResponseWriter, req *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	result := RuntimeStats{
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		Sys:          m.Sys,
+		TotalAlloc:   m.TotalAlloc,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	writeResult(rw, http.StatusOK, &result)
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/apikey"
+	"github.com/m-lab/locate/heartbeat/heartbeattest"
+	"github.com/m-lab/locate/siteinfo"
+)
+
+type fakeGeoReloader struct {
+	reloaded bool
+	date     time.Time
+}
+
+func (f *fakeGeoReloader) Reload(ctx context.Context) {
+	f.reloaded = true
+}
+
+func (f *fakeGeoReloader) BuildDate() time.Time {
+	return f.date
+}
+
+func TestReloadGeo(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		wantStatus   int
+		wantReloaded bool
+	}{
+		{
+			name:         "success",
+			method:       http.MethodPost,
+			wantStatus:   http.StatusOK,
+			wantReloaded: true,
+		},
+		{
+			name:       "error-method-not-allowed",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date := time.Date(2024, time.January, 2, 0, 0, 0, 0, time.UTC)
+			r := &fakeGeoReloader{date: date}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/v2/platform/admin/reload-geo", nil)
+
+			ReloadGeo(r).ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantStatus {
+				t.Errorf("ReloadGeo() wrong status; got %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if r.reloaded != tt.wantReloaded {
+				t.Errorf("ReloadGeo() reloaded = %t, want %t", r.reloaded, tt.wantReloaded)
+			}
+			if !tt.wantReloaded {
+				return
+			}
+			result := &ReloadResult{}
+			if err := json.Unmarshal(rw.Body.Bytes(), result); err != nil {
+				t.Fatalf("ReloadGeo() returned invalid JSON: %v", err)
+			}
+			if !result.BuildDate.Equal(date) {
+				t.Errorf("ReloadGeo() wrong build date; got %s, want %s", result.BuildDate, date)
+			}
+		})
+	}
+}
+
+type fakeUsageReporter struct {
+	day time.Time
+	err error
+}
+
+func (f *fakeUsageReporter) Report(t time.Time) (*apikey.UsageReport, error) {
+	f.day = t
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &apikey.UsageReport{
+		Date: t.Format("2006-01-02"),
+		Integrations: map[string]apikey.IntegrationUsage{
+			"partner": {Requests: 3, Keys: map[string]int64{"ki_1": 3}},
+		},
+	}, nil
+}
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		err        error
+		wantStatus int
+		wantDate   string
+	}{
+		{
+			name:       "success-date",
+			target:     "/v2/platform/admin/usage?date=2024-03-01",
+			wantStatus: http.StatusOK,
+			wantDate:   "2024-03-01",
+		},
+		{
+			name:       "success-today",
+			target:     "/v2/platform/admin/usage",
+			wantStatus: http.StatusOK,
+			wantDate:   time.Now().UTC().Format("2006-01-02"),
+		},
+		{
+			name:       "error-invalid-date",
+			target:     "/v2/platform/admin/usage?date=yesterday",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "error-report",
+			target:     "/v2/platform/admin/usage",
+			err:        errors.New("fake report error"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeUsageReporter{err: tt.err}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+
+			Usage(r).ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantStatus {
+				t.Errorf("Usage() wrong status; got %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			result := &apikey.UsageReport{}
+			if err := json.Unmarshal(rw.Body.Bytes(), result); err != nil {
+				t.Fatalf("Usage() returned invalid JSON: %v", err)
+			}
+			want := &apikey.UsageReport{
+				Date: tt.wantDate,
+				Integrations: map[string]apikey.IntegrationUsage{
+					"partner": {Requests: 3, Keys: map[string]int64{"ki_1": 3}},
+				},
+			}
+			if !reflect.DeepEqual(result, want) {
+				t.Errorf("Usage() = %+v, want %+v", result, want)
+			}
+		})
+	}
+}
+
+type fakeRegistrationSource struct {
+	regs map[string]v2.Registration
+	err  error
+}
+
+func (f *fakeRegistrationSource) Get(ctx context.Context) (map[string]v2.Registration, error) {
+	return f.regs, f.err
+}
+
+func TestClient_SiteinfoDiff(t *testing.T) {
+	tests := []struct {
+		name       string
+		src        *fakeRegistrationSource
+		wantStatus int
+		want       *siteinfo.DiffResult
+	}{
+		{
+			name: "success",
+			src: &fakeRegistrationSource{
+				regs: map[string]v2.Registration{
+					"mlab1-lga01.mlab-oti.measurement-lab.org": {},
+					"mlab2-lga01.mlab-oti.measurement-lab.org": {},
+				},
+			},
+			wantStatus: http.StatusOK,
+			want: &siteinfo.DiffResult{
+				MissingFromSiteinfo: []string{"ndt-mlab3-lga01.mlab-oti.measurement-lab.org"},
+				MissingFromLocate:   []string{"mlab2-lga01.mlab-oti.measurement-lab.org"},
+				Unparsed:            []string{},
+			},
+		},
+		{
+			name:       "error-source",
+			src:        &fakeRegistrationSource{err: errors.New("fake error")},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &heartbeattest.FakeStatusTracker{
+				FakeInstances: map[string]v2.HeartbeatMessage{
+					"ndt-mlab1-lga01.mlab-oti.measurement-lab.org": {},
+					"ndt-mlab3-lga01.mlab-oti.measurement-lab.org": {},
+				},
+			}
+			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{StatusTracker: tracker}, nil, nil, nil, nil, nil, nil, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/v2/platform/admin/siteinfo-diff", nil)
+
+			c.SiteinfoDiff(tt.src).ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantStatus {
+				t.Errorf("SiteinfoDiff() wrong status; got %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if tt.want == nil {
+				return
+			}
+			result := &siteinfo.DiffResult{}
+			if err := json.Unmarshal(rw.Body.Bytes(), result); err != nil {
+				t.Fatalf("SiteinfoDiff() returned invalid JSON: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.want) {
+				t.Errorf("SiteinfoDiff() = %+v, want %+v", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/runtime", nil)
+
+	Runtime(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Errorf("Runtime() wrong status; got %d, want %d", rw.Code, http.StatusOK)
+	}
+	result := &RuntimeStats{}
+	if err := json.Unmarshal(rw.Body.Bytes(), result); err != nil {
+		t.Fatalf("Runtime() returned invalid JSON: %v", err)
+	}
+	if result.Goroutines == 0 || result.HeapAlloc == 0 {
+		t.Errorf("Runtime() = %+v, want non-zero goroutines and heap", result)
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/host"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/m-lab/locate/heartbeat"
+)
+
+// decisionParams are the query parameters recorded with selection decisions.
+var decisionParams = []string{"machine-type", "org", "site", "country", "strict"}
+
+// Decision is an anonymized record of a successful target selection. It
+// contains no client IP address and only a coarse client location.
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	// Cell is the client "<lat>,<lon>" rounded to one decimal digit (about
+	// 11km), and Country is the client country used to select targets.
+	Cell    string `json:"cell"`
+	Country string `json:"country"`
+	// Candidates is the number of sites the targets were picked from.
+	Candidates int `json:"candidates"`
+	// Sites and Ranks are the site and metro rank of each target, in order.
+	Sites  []string          `json:"sites"`
+	Ranks  []int             `json:"ranks"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// DecisionExporter exports selection decisions.
+type DecisionExporter interface {
+	Export(d *Decision)
+}
+
+// DecisionLog is a DecisionExporter that writes decisions as JSON lines, e.g.
+// to stdout, where a Cloud Logging sink can route them to BigQuery. See the
+// README for the sink setup.
+type DecisionLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewDecisionLog returns a new DecisionLog writing to w.
+func NewDecisionLog(w io.Writer) *DecisionLog {
+	return &DecisionLog{enc: json.NewEncoder(w)}
+}
+
+// Export writes the decision as a single line of JSON.
+func (l *DecisionLog) Export(d *Decision) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(d); err != nil {
+		log.Errorf("Failed to export selection decision: %v", err)
+	}
+}
+
+// SetDecisionExporter exports a sample of the successful selection decisions,
+// with the given sample rate between 0 (none) and 1 (all).
+func (c *Client) SetDecisionExporter(e DecisionExporter, rate float64) error {
+	if !(rate >= 0 && rate <= 1) {
+		return fmt.Errorf("invalid decision sample rate %v: must be between 0 and 1", rate)
+	}
+	c.decisions = e
+	c.decisionRate = rate
+	return nil
+}
+
+// exportDecision exports a sample of the selection decisions, if an exporter
+// is set.
+func (c *Client) exportDecision(service string, lat, lon float64, country string, params map[string][]string, info *heartbeat.TargetInfo) {
+	if c.decisions == nil || rand.Float64() >= c.decisionRate {
+		return
+	}
+	d := &Decision{
+		Time:       time.Now().UTC(),
+		Service:    service,
+		Cell:       roundCell(lat) + "," + roundCell(lon),
+		Country:    country,
+		Candidates: info.Candidates,
+		Sites:      make([]string, 0, len(info.Targets)),
+		Ranks:      make([]int, 0, len(info.Targets)),
+	}
+	for _, t := range info.Targets {
+		site := ""
+		if name, err := host.Parse(t.Machine); err == nil {
+			site = name.Site
+		}
+		d.Sites = append(d.Sites, site)
+		d.Ranks = append(d.Ranks, info.Ranks[t.Machine])
+	}
+	for _, p := range decisionParams {
+		if v, ok := params[p]; ok && len(v) > 0 {
+			if d.Params == nil {
+				d.Params = make(map[string]string)
+			}
+			d.Params[p] = strings.Join(v, ",")
+		}
+	}
+	c.decisions.Export(d)
+}
+
+// roundCell rounds a coordinate to one decimal digit.
+func roundCell(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', 1, 64)
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/heartbeat"
+)
+
+type fakeDecisionExporter struct {
+	decisions []*Decision
+}
+
+func (f *fakeDecisionExporter) Export(d *Decision) {
+	f.decisions = append(f.decisions, d)
+}
+
+func TestDecisionLog_Export(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewDecisionLog(buf)
+	want := &Decision{
+		Time:       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Service:    "ndt/ndt7",
+		Cell:       "40.7,-74.0",
+		Country:    "US",
+		Candidates: 3,
+		Sites:      []string{"lga01", "lga02"},
+		Ranks:      []int{0, 0},
+	}
+	l.Export(want)
+	l.Export(want)
+
+	dec := json.NewDecoder(buf)
+	for i := 0; i < 2; i++ {
+		got := &Decision{}
+		if err := dec.Decode(got); err != nil {
+			t.Fatalf("DecisionLog.Export() wrote invalid JSON: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DecisionLog.Export() = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestClient_exportDecision(t *testing.T) {
+	info := &heartbeat.TargetInfo{
+		Targets: []v2.Target{
+			{Machine: "mlab1-lga01.mlab-oti.measurement-lab.org"},
+			{Machine: "mlab2-lax02.mlab-oti.measurement-lab.org"},
+			{Machine: "invalid"},
+		},
+		Ranks: map[string]int{
+			"mlab1-lga01.mlab-oti.measurement-lab.org": 0,
+			"mlab2-lax02.mlab-oti.measurement-lab.org": 3,
+		},
+		Candidates: 10,
+	}
+	params := map[string][]string{
+		"machine-type": {"virtual"},
+		"site":         {"lga01", "lax02"},
+		"client_name":  {"ignored"},
+	}
+
+	tests := []struct {
+		name     string
+		exporter bool
+		rate     float64
+		wantN    int
+	}{
+		{
+			name:  "no-exporter",
+			rate:  1,
+			wantN: 0,
+		},
+		{
+			name:     "rate-zero",
+			exporter: true,
+			rate:     0,
+			wantN:    0,
+		},
+		{
+			name:     "rate-one",
+			exporter: true,
+			rate:     1,
+			wantN:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{}, nil, nil, nil, nil, nil, nil, nil)
+			e := &fakeDecisionExporter{}
+			if tt.exporter {
+				rtx.Must(c.SetDecisionExporter(e, tt.rate), "Failed to set decision exporter")
+			}
+
+			c.exportDecision("ndt/ndt7", 40.7128, -74.0060, "US", params, info)
+
+			if len(e.decisions) != tt.wantN {
+				t.Fatalf("exportDecision() exported %d decisions, want %d", len(e.decisions), tt.wantN)
+			}
+			if tt.wantN == 0 {
+				return
+			}
+			got := e.decisions[0]
+			got.Time = time.Time{}
+			want := &Decision{
+				Service:    "ndt/ndt7",
+				Cell:       "40.7,-74.0",
+				Country:    "US",
+				Candidates: 10,
+				Sites:      []string{"lga01", "lax02", ""},
+				Ranks:      []int{0, 3, 0},
+				Params: map[string]string{
+					"machine-type": "virtual",
+					"site":         "lga01,lax02",
+				},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("exportDecision() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_SetDecisionExporter(t *testing.T) {
+	c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{}, nil, nil, nil, nil, nil, nil, nil)
+	for _, rate := range []float64{-0.1, 1.5, math.NaN()} {
+		if err := c.SetDecisionExporter(&fakeDecisionExporter{}, rate); err == nil {
+			t.Errorf("SetDecisionExporter(%v) expected error; got nil", rate)
+		}
+	}
+	if c.decisions != nil {
+		t.Errorf("SetDecisionExporter() set the exporter with an invalid rate")
+	}
+}
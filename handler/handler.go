@@ -9,10 +9,12 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +25,7 @@ import (
 
 	"github.com/m-lab/go/rtx"
 	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/apikey"
 	"github.com/m-lab/locate/clientgeo"
 	"github.com/m-lab/locate/heartbeat"
 	"github.com/m-lab/locate/limits"
@@ -33,9 +36,33 @@ import (
 	"github.com/prometheus/common/model"
 )
 
+// Response headers reporting the client country used to select targets.
+const (
+	hLocateClientCountry       = "X-Locate-Clientcountry"
+	hLocateClientCountryMethod = "X-Locate-Clientcountry-Method"
+)
+
+// Response headers reporting the client rate limit status.
+const (
+	hRateLimitLimit     = "X-RateLimit-Limit"
+	hRateLimitRemaining = "X-RateLimit-Remaining"
+	hRateLimitReset     = "X-RateLimit-Reset"
+)
+
+// Response headers reporting the API key quota status.
+const (
+	hLocateQuotaTier            = "X-Locate-Quota-Tier"
+	hLocateQuotaMinuteRemaining = "X-Locate-Quota-Minute-Remaining"
+	hLocateQuotaDayRemaining    = "X-Locate-Quota-Day-Remaining"
+)
+
 var (
 	errFailedToLookupClient = errors.New("Failed to look up client location")
+	errInvalidCountry       = errors.New("Invalid country parameter; must be an ISO 3166-1 alpha-2 code")
 	tooManyRequests         = "Too many periodic requests. Please contact support@measurementlab.net."
+	tooManyClientRequests   = "Too many requests from this client. Please contact support@measurementlab.net."
+	quotaExceeded           = "API key quota exceeded. Please contact support@measurementlab.net."
+	brakeEngaged            = "Service is temporarily limited to requests with API keys. Please retry later."
 )
 
 // Signer defines how access tokens are signed.
@@ -51,7 +78,17 @@ type Client struct {
 	ClientLocator
 	PrometheusClient
 	targetTmpl  *template.Template
-	agentLimits limits.Agents
+	agentLimits AgentLimiter
+	ipLimiter   Limiter
+	keyQuotas   QuotaChecker
+	exemptions  Exempter
+	brake       EmergencyBrake
+	tokenExpiry map[string]time.Duration
+	asnLabels   map[uint]bool
+	// decisions exports a sample of the selection decisions, with
+	// decisionRate between 0 and 1.
+	decisions    DecisionExporter
+	decisionRate float64
 }
 
 // LocatorV2 defines how the Nearest handler requests machines nearest to the
@@ -66,6 +103,38 @@ type ClientLocator interface {
 	Locate(req *http.Request) (*clientgeo.Location, error)
 }
 
+// AgentLimiter defines the interface for time-based User-Agent limits.
+type AgentLimiter interface {
+	IsLimited(agent string, t time.Time) bool
+}
+
+// Limiter defines the interface for rate limiting client requests for a
+// service by IP and User-Agent.
+type Limiter interface {
+	IsLimited(service, ip, ua string) (limits.LimitStatus, error)
+}
+
+// Exempter defines the interface for identifying clients that are exempt from
+// rate limits.
+type Exempter interface {
+	// IsExempt returns the reason the client IP is exempt, or an empty string.
+	IsExempt(ip string) string
+}
+
+// EmergencyBrake defines the interface for an operator-controlled mode that
+// serves only API-key traffic during platform-wide capacity incidents.
+type EmergencyBrake interface {
+	// IsEngaged reports whether the brake is engaged and how long anonymous
+	// clients should wait before retrying.
+	IsEngaged() (bool, time.Duration)
+}
+
+// QuotaChecker defines the interface for enforcing per-API-key quotas, given
+// the ID of a verified key.
+type QuotaChecker interface {
+	Check(keyID string) (limits.QuotaStatus, error)
+}
+
 // PrometheusClient defines the interface to query Prometheus.
 type PrometheusClient interface {
 	Query(ctx context.Context, query string, ts time.Time, opts ...prom.Option) (model.Value, prom.Warnings, error)
@@ -84,7 +153,7 @@ func init() {
 }
 
 // NewClient creates a new client.
-func NewClient(project string, private Signer, locatorV2 LocatorV2, client ClientLocator, prom PrometheusClient, lmts limits.Agents) *Client {
+func NewClient(project string, private Signer, locatorV2 LocatorV2, client ClientLocator, prom PrometheusClient, lmts AgentLimiter, limiter Limiter, quotas QuotaChecker, exemptions Exempter, brake EmergencyBrake) *Client {
 	return &Client{
 		Signer:           private,
 		project:          project,
@@ -93,6 +162,10 @@ func NewClient(project string, private Signer, locatorV2 LocatorV2, client Clien
 		PrometheusClient: prom,
 		targetTmpl:       template.Must(template.New("name").Parse("{{.Hostname}}{{.Ports}}")),
 		agentLimits:      lmts,
+		ipLimiter:        limiter,
+		keyQuotas:        quotas,
+		exemptions:       exemptions,
+		brake:            brake,
 	}
 }
 
@@ -109,6 +182,41 @@ func NewClientDirect(project string, private Signer, locatorV2 LocatorV2, client
 	}
 }
 
+// SetTokenExpiry sets the validity of the access tokens issued for each
+// service (e.g., "wehe/replay") or experiment (e.g., "wehe"), given as
+// durations like "5m". Other services use static.AccessTokenExpiry.
+func (c *Client) SetTokenExpiry(expiry map[string]string) error {
+	parsed := make(map[string]time.Duration, len(expiry))
+	for name, value := range expiry {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid access token expiry for %q: %w", name, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("invalid access token expiry for %q: must be positive", name)
+		}
+		parsed[name] = d
+	}
+	c.tokenExpiry = parsed
+	return nil
+}
+
+// SetASNLabels sets the client ASNs (e.g., "15169" or "AS15169") counted
+// individually by the ClientASNRequestsTotal metric. Requests from other
+// ASNs are counted as "other".
+func (c *Client) SetASNLabels(asns []string) error {
+	labels := make(map[uint]bool, len(asns))
+	for _, a := range asns {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(a), "AS"), 10, 32)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid ASN %q", a)
+		}
+		labels[uint(n)] = true
+	}
+	c.asnLabels = labels
+	return nil
+}
+
 func extraParams(hostname string, index int, p paramOpts) url.Values {
 	v := url.Values{}
 	// Add client parameters.
@@ -146,22 +254,59 @@ func (c *Client) Nearest(rw http.ResponseWriter, req *http.Request) {
 	result := v2.NearestResult{}
 	setHeaders(rw)
 
-	if c.limitRequest(time.Now().UTC(), req) {
-		result.Error = v2.NewError("client", tooManyRequests, http.StatusTooManyRequests)
+	experiment, service := getExperimentAndService(req.URL.Path)
+
+	// During capacity incidents, only requests with verified API keys are
+	// served.
+	integration, hasKey := priorityIntegration(req)
+	if engaged, retryAfter := c.isBrakeEngaged(); engaged && !hasKey {
+		result.Error = v2.NewError(v2.ErrorTypeOverloaded, brakeEngaged, http.StatusTooManyRequests)
+		setRetryAfter(rw, &result, retryAfter)
 		writeResult(rw, result.Error.Status, &result)
-		metrics.RequestsTotal.WithLabelValues("nearest", "request limit", http.StatusText(result.Error.Status)).Inc()
+		metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionBrake, http.StatusText(result.Error.Status)).Inc()
 		return
 	}
 
-	experiment, service := getExperimentAndService(req.URL.Path)
+	// Exempt clients, e.g. monitoring and trusted partners, are never limited.
+	exempt := c.isExempt(req)
+	if !exempt && c.limitRequest(time.Now().UTC(), req) {
+		result.Error = v2.NewError(v2.ErrorTypeRateLimited, tooManyRequests, http.StatusTooManyRequests)
+		writeResult(rw, result.Error.Status, &result)
+		metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionRateLimit, http.StatusText(result.Error.Status)).Inc()
+		return
+	}
+
+	// Verified integrations are subject to the quota of their key instead of
+	// the client rate limit.
+	if hasKey {
+		if status := c.checkKeyQuota(rw, integration.KeyID); status.IsLimited {
+			result.Error = v2.NewError(v2.ErrorTypeQuotaExceeded, quotaExceeded, http.StatusTooManyRequests)
+			writeResult(rw, result.Error.Status, &result)
+			metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionQuota, http.StatusText(result.Error.Status)).Inc()
+			return
+		}
+	} else if !exempt {
+		status := c.checkRateLimit(req, service)
+		setRateLimitHeaders(rw, status)
+		if status.IsLimited {
+			result.Error = v2.NewError(v2.ErrorTypeRateLimited, tooManyClientRequests, http.StatusTooManyRequests)
+			setRetryAfter(rw, &result, status.RetryAfter)
+			writeResult(rw, result.Error.Status, &result)
+			metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionRateLimit, http.StatusText(result.Error.Status)).Inc()
+			metrics.RateLimitedTotal.WithLabelValues(status.LimitType).Inc()
+			return
+		}
+	}
 
 	// Look up client location.
+	start := time.Now()
 	loc, err := c.checkClientLocation(rw, req)
+	observeStage("client location", start)
 	if err != nil {
 		status := http.StatusServiceUnavailable
-		result.Error = v2.NewError("nearest", "Failed to lookup nearest machines", status)
+		result.Error = v2.NewError(v2.ErrorTypeClientLocation, "Failed to lookup nearest machines", status)
 		writeResult(rw, result.Error.Status, &result)
-		metrics.RequestsTotal.WithLabelValues("nearest", "client location",
+		metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionClientGeo,
 			http.StatusText(result.Error.Status)).Inc()
 		return
 	}
@@ -170,9 +315,9 @@ func (c *Client) Nearest(rw http.ResponseWriter, req *http.Request) {
 	lat, errLat := strconv.ParseFloat(loc.Latitude, 64)
 	lon, errLon := strconv.ParseFloat(loc.Longitude, 64)
 	if errLat != nil || errLon != nil {
-		result.Error = v2.NewError("client", errFailedToLookupClient.Error(), http.StatusInternalServerError)
+		result.Error = v2.NewError(v2.ErrorTypeClientLocation, errFailedToLookupClient.Error(), http.StatusInternalServerError)
 		writeResult(rw, result.Error.Status, &result)
-		metrics.RequestsTotal.WithLabelValues("nearest", "parse client location",
+		metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionClientGeo,
 			http.StatusText(result.Error.Status)).Inc()
 		return
 	}
@@ -180,24 +325,43 @@ func (c *Client) Nearest(rw http.ResponseWriter, req *http.Request) {
 	// Find the nearest targets using the client parameters.
 	q := req.URL.Query()
 	t := q.Get("machine-type")
-	country := req.Header.Get("X-AppEngine-Country")
 	sites := q["site"]
 	org := q.Get("org")
-	strict := false
-	if qsStrict, err := strconv.ParseBool(q.Get("strict")); err == nil {
-		strict = qsStrict
+	addresses, _ := strconv.ParseBool(q.Get("addresses"))
+	country, strict, err := clientCountry(req, loc)
+	if err != nil {
+		result.Error = v2.NewError(v2.ErrorTypeInvalidRequest, err.Error(), http.StatusBadRequest)
+		writeResult(rw, result.Error.Status, &result)
+		metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionBadRequest,
+			http.StatusText(result.Error.Status)).Inc()
+		return
 	}
-	// If strict, override the country from the AppEngine header with the one in
-	// the querystring.
+	rw.Header().Set(hLocateClientCountry, country)
 	if strict {
-		country = q.Get("country")
+		rw.Header().Set(hLocateClientCountryMethod, "override")
+	} else {
+		rw.Header().Set(hLocateClientCountryMethod, "detected")
+	}
+	opts := &heartbeat.NearestOptions{
+		Type:       t,
+		Country:    country,
+		Sites:      sites,
+		Org:        org,
+		Strict:     strict,
+		AccuracyKm: loc.AccuracyKm,
+		Addresses:  addresses,
 	}
-	opts := &heartbeat.NearestOptions{Type: t, Country: country, Sites: sites, Org: org, Strict: strict}
+	start = time.Now()
 	targetInfo, err := c.LocatorV2.Nearest(service, lat, lon, opts)
+	observeStage("locator", start)
 	if err != nil {
-		result.Error = v2.NewError("nearest", "Failed to lookup nearest machines", http.StatusInternalServerError)
+		typ, condition := v2.ErrorTypeInternal, metrics.ConditionInternal
+		if errors.Is(err, heartbeat.ErrNoAvailableServers) {
+			typ, condition = v2.ErrorTypeNoServers, metrics.ConditionNoCapacity
+		}
+		result.Error = v2.NewError(typ, "Failed to lookup nearest machines", http.StatusInternalServerError)
 		writeResult(rw, result.Error.Status, &result)
-		metrics.RequestsTotal.WithLabelValues("nearest", "server location",
+		metrics.RequestsTotal.WithLabelValues("nearest", condition,
 			http.StatusText(result.Error.Status)).Inc()
 		return
 	}
@@ -209,10 +373,17 @@ func (c *Client) Nearest(rw http.ResponseWriter, req *http.Request) {
 		svcParams: static.ServiceParams,
 	}
 	// Populate target URLs and write out response.
-	c.populateURLs(targetInfo.Targets, targetInfo.URLs, experiment, pOpts)
+	start = time.Now()
+	c.populateURLs(targetInfo.Targets, targetInfo.URLs, experiment, c.accessTokenExpiry(service), pOpts)
+	observeStage("urls", start)
 	result.Results = targetInfo.Targets
+	result.Warnings = nearestWarnings(service, loc)
+	start = time.Now()
 	writeResult(rw, http.StatusOK, &result)
-	metrics.RequestsTotal.WithLabelValues("nearest", "success", http.StatusText(http.StatusOK)).Inc()
+	observeStage("encode", start)
+	metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionSuccess, http.StatusText(http.StatusOK)).Inc()
+	c.recordClientRequest(service, country, loc.ASN)
+	c.exportDecision(service, lat, lon, country, req.Form, targetInfo)
 }
 
 // Live is a minimal handler to indicate that the server is operating at all.
@@ -230,31 +401,114 @@ func (c *Client) Ready(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// Registrations returns information about registered machines. There are 3
-// supported query parameters:
+// Registrations returns information about registered machines. The
+// supported query parameters are:
 //
-// * format - defines the format of the returned JSON
+// * format - defines the format of the returned JSON ("sites" groups the
+// machines by site; by default, machines are returned by hostname)
 // * org - limits results to only records for the given organization
 // * exp - limits results to only records for the given experiment (e.g., ndt)
+// * country, metro, site, type - limit results to only records registered
+// with the given country code, metro, site or machine type
+//
+// Requests carrying an organization token (see OrgScope) are limited to the
+// records of that organization, regardless of the org parameter.
+//
+// Results are streamed one record at a time, so the response is never
+// marshaled into a single buffer.
 func (c *Client) Registrations(rw http.ResponseWriter, req *http.Request) {
-	var err error
-	var result interface{}
-
 	q := req.URL.Query()
+	if org, ok := orgFromContext(req.Context()); ok {
+		q.Set("org", org)
+	}
 	format := q.Get("format")
 
+	rw.Header().Set("Content-Type", "application/json")
 	switch format {
+	case "sites":
+		sites := siteinfo.Sites(c.LocatorV2.Instances(), q)
+		streamResult(rw, "[", "]", len(sites), func(i int) ([]byte, error) {
+			return json.Marshal(sites[i])
+		})
 	default:
-		result, err = siteinfo.Machines(c.LocatorV2.Instances(), q)
+		machines := siteinfo.Machines(c.LocatorV2.Instances(), q)
+		hostnames := make([]string, 0, len(machines))
+		for k := range machines {
+			hostnames = append(hostnames, k)
+		}
+		sort.Strings(hostnames)
+		streamResult(rw, "{", "}", len(hostnames), func(i int) ([]byte, error) {
+			k, err := json.Marshal(hostnames[i])
+			if err != nil {
+				return nil, err
+			}
+			v, err := json.Marshal(machines[hostnames[i]])
+			if err != nil {
+				return nil, err
+			}
+			return append(append(k, ':'), v...), nil
+		})
 	}
+}
 
-	if err != nil {
-		v2Error := v2.NewError("siteinfo", err.Error(), http.StatusInternalServerError)
-		writeResult(rw, http.StatusInternalServerError, v2Error)
-		return
+// observeStage records the latency of a stage of the Nearest handler started
+// at the given time.
+func observeStage(stage string, start time.Time) {
+	metrics.NearestStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// recordClientRequest counts a successful nearest request by service and
+// client country, and by client ASN. Only successful requests are counted, so
+// that the service label is bounded by the registered services. ASNs not
+// configured with SetASNLabels are counted as "other".
+func (c *Client) recordClientRequest(service, country string, asn uint) {
+	if _, ok := static.Countries[country]; !ok {
+		country = "unknown"
 	}
+	metrics.ClientCountryRequestsTotal.WithLabelValues(service, country).Inc()
 
-	writeResult(rw, http.StatusOK, result)
+	label := "unknown"
+	if asn != 0 {
+		label = "other"
+		if c.asnLabels[asn] {
+			label = "AS" + strconv.FormatUint(uint64(asn), 10)
+		}
+	}
+	metrics.ClientASNRequestsTotal.WithLabelValues(label).Inc()
+}
+
+// nearestWarnings returns the advisories for a nearest request, i.e. whether
+// the service is deprecated and whether results are degraded because only a
+// coarse client location (e.g., a country centroid) is known.
+func nearestWarnings(service string, loc *clientgeo.Location) []string {
+	var warnings []string
+	if w, ok := static.DeprecatedServices[service]; ok {
+		warnings = append(warnings, w)
+	}
+	if loc.AccuracyKm >= static.CoarseAccuracyKm {
+		warnings = append(warnings, "results degraded: coarse client location used")
+	}
+	return warnings
+}
+
+// clientCountry returns the client country used to select targets and whether
+// it is a strict override. With strict=true, a country query parameter
+// replaces the detected country and must be a country known to the locators.
+// Otherwise, the country reported by the Locator or AppEngine is returned.
+func clientCountry(req *http.Request, loc *clientgeo.Location) (string, bool, error) {
+	q := req.URL.Query()
+	strict, err := strconv.ParseBool(q.Get("strict"))
+	if country := strings.ToUpper(q.Get("country")); err == nil && strict && country != "" {
+		if !clientgeo.KnownCountry(country) {
+			return "", false, errInvalidCountry
+		}
+		return country, true, nil
+	}
+	if loc.Country != "" {
+		// Prefer a country reported by the locator, e.g. from an override.
+		return loc.Country, false, nil
+	}
+	return req.Header.Get("X-AppEngine-Country"), false, nil
 }
 
 // checkClientLocation looks up the client location and copies the location
@@ -275,17 +529,30 @@ func (c *Client) checkClientLocation(rw http.ResponseWriter, req *http.Request)
 }
 
 // populateURLs populates each set of URLs using the target configuration.
-func (c *Client) populateURLs(targets []v2.Target, ports static.Ports, exp string, pOpts paramOpts) {
+func (c *Client) populateURLs(targets []v2.Target, ports static.Ports, exp string, expiry time.Duration, pOpts paramOpts) {
 	for i, target := range targets {
-		token := c.getAccessToken(target.Machine, exp)
+		token := c.getAccessToken(target.Machine, exp, expiry)
 		params := extraParams(target.Machine, i, pOpts)
 		targets[i].URLs = c.getURLs(ports, target.Hostname, token, params)
 	}
 }
 
+// accessTokenExpiry returns the validity of the access tokens issued for the
+// service, e.g. "ndt/ndt7".
+func (c *Client) accessTokenExpiry(service string) time.Duration {
+	if d, ok := c.tokenExpiry[service]; ok {
+		return d
+	}
+	if d, ok := c.tokenExpiry[path.Dir(service)]; ok {
+		return d
+	}
+	return static.AccessTokenExpiry
+}
+
 // getAccessToken allocates a new access token using the given machine name as
-// the intended audience and the subject as the target service.
-func (c *Client) getAccessToken(machine, subject string) string {
+// the intended audience and the subject as the target service. The token is
+// valid until expiry has passed.
+func (c *Client) getAccessToken(machine, subject string, expiry time.Duration) string {
 	// Create the token. The same access token is reused for every URL of a
 	// target port.
 	// A uuid is added to the claims so that each new token is unique.
@@ -293,7 +560,7 @@ func (c *Client) getAccessToken(machine, subject string) string {
 		Issuer:   static.IssuerLocate,
 		Subject:  subject,
 		Audience: jwt.Audience{machine},
-		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		Expiry:   jwt.NewNumericDate(time.Now().Add(expiry)),
 		ID:       uuid.NewString(),
 	}
 	token, err := c.Sign(cl)
@@ -334,13 +601,119 @@ func (c *Client) getURLs(ports static.Ports, hostname, token string, extra url.V
 
 // limitRequest determines whether a client request should be rate-limited.
 func (c *Client) limitRequest(now time.Time, req *http.Request) bool {
-	agent := req.Header.Get("User-Agent")
-	l, ok := c.agentLimits[agent]
-	if !ok {
-		// No limit defined for user agent.
+	if c.agentLimits == nil {
+		// No limits defined.
 		return false
 	}
-	return l.IsLimited(now)
+	return c.agentLimits.IsLimited(req.Header.Get("User-Agent"), now)
+}
+
+// isExempt reports whether the client IP is exempt from limits.
+func (c *Client) isExempt(req *http.Request) bool {
+	if c.exemptions == nil {
+		return false
+	}
+	reason := c.exemptions.IsExempt(getRemoteIP(req))
+	if reason == "" {
+		return false
+	}
+	metrics.RateLimitExemptionsTotal.WithLabelValues(reason).Inc()
+	return true
+}
+
+// checkRateLimit checks the client IP and User-Agent against the rate limiter
+// for the requested service. Requests are not limited when the limiter fails.
+func (c *Client) checkRateLimit(req *http.Request, service string) limits.LimitStatus {
+	if c.ipLimiter == nil {
+		return limits.LimitStatus{}
+	}
+	status, err := c.ipLimiter.IsLimited(service, getRemoteIP(req), req.Header.Get("User-Agent"))
+	if err != nil {
+		log.Errorf("Failed to check rate limit: %v", err)
+		return limits.LimitStatus{}
+	}
+	return status
+}
+
+// checkKeyQuota counts the request against the quota of the API key ID and
+// reports the quota status in the response headers. Requests are not limited
+// when the quota check fails.
+func (c *Client) checkKeyQuota(rw http.ResponseWriter, keyID string) limits.QuotaStatus {
+	if c.keyQuotas == nil {
+		return limits.QuotaStatus{}
+	}
+	status, err := c.keyQuotas.Check(keyID)
+	if err != nil {
+		log.Errorf("Failed to check API key quota: %v", err)
+		return limits.QuotaStatus{}
+	}
+	if status.Tier != "" {
+		rw.Header().Set(hLocateQuotaTier, status.Tier)
+	}
+	if status.MinuteRemaining >= 0 {
+		rw.Header().Set(hLocateQuotaMinuteRemaining, strconv.Itoa(status.MinuteRemaining))
+	}
+	if status.DayRemaining >= 0 {
+		rw.Header().Set(hLocateQuotaDayRemaining, strconv.Itoa(status.DayRemaining))
+	}
+	return status
+}
+
+// priorityIntegration returns the Integration of a priority request whose API
+// key, signature or access token was verified by the apikey middleware. Keys
+// that were not verified are ignored.
+func priorityIntegration(req *http.Request) (*apikey.Integration, bool) {
+	if !strings.HasPrefix(req.URL.Path, "/v2/priority/") {
+		return nil, false
+	}
+	return apikey.FromContext(req.Context())
+}
+
+// isBrakeEngaged reports whether the emergency brake is engaged and how long
+// anonymous clients should wait before retrying.
+func (c *Client) isBrakeEngaged() (bool, time.Duration) {
+	if c.brake == nil {
+		return false, 0
+	}
+	return c.brake.IsEngaged()
+}
+
+// setRetryAfter sets the Retry-After header and error detail of a rejected
+// request, rounded up to whole seconds. A zero duration is not reported.
+func setRetryAfter(rw http.ResponseWriter, result *v2.NearestResult, d time.Duration) {
+	setErrorRetryAfter(rw, result.Error, d)
+}
+
+// setErrorRetryAfter is setRetryAfter for the error of any result type.
+func setErrorRetryAfter(rw http.ResponseWriter, e *v2.Error, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(d.Seconds())))
+	rw.Header().Set("Retry-After", retryAfter)
+	e.Detail = "Retry after " + retryAfter + " seconds."
+}
+
+// setRateLimitHeaders reports the rate limit status in the response headers.
+// X-RateLimit-Reset is the number of seconds until the limit is fully reset.
+func setRateLimitHeaders(rw http.ResponseWriter, status limits.LimitStatus) {
+	if status.Limit == 0 {
+		// No limit configured.
+		return
+	}
+	rw.Header().Set(hRateLimitLimit, strconv.Itoa(status.Limit))
+	rw.Header().Set(hRateLimitRemaining, strconv.Itoa(status.Remaining))
+	rw.Header().Set(hRateLimitReset, strconv.Itoa(int(math.Ceil(status.Reset.Seconds()))))
+}
+
+// getRemoteIP returns the client IP, as found by clientgeo.ClientIP, or the
+// request remote address if it cannot be parsed.
+func getRemoteIP(req *http.Request) string {
+	ip, err := clientgeo.ClientIP(req)
+	if err != nil || ip == nil {
+		return req.RemoteAddr
+	}
+	return ip.String()
 }
 
 // setHeaders sets the response headers for "nearest" requests.
@@ -362,6 +735,35 @@ func writeResult(rw http.ResponseWriter, status int, result interface{}) {
 	rw.Write(b)
 }
 
+// streamFlushInterval is the number of records written between flushes of
+// streamed results.
+const streamFlushInterval = 100
+
+// streamResult writes a JSON array or object of n records with a 200 status,
+// between the given start and end delimiters. Records are marshaled one at a
+// time by item and the response is flushed every streamFlushInterval records.
+func streamResult(rw http.ResponseWriter, start, end string, n int, item func(i int) ([]byte, error)) {
+	flusher, _ := rw.(http.Flusher)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte(start + "\n"))
+	for i := 0; i < n; i++ {
+		b, err := item(i)
+		// Errors are only possible when marshalling incompatible types, like functions.
+		rtx.PanicOnError(err, "Failed to format result")
+		if i < n-1 {
+			b = append(b, ',')
+		}
+		if _, err := rw.Write(append(b, '\n')); err != nil {
+			// The client is gone; there is no way to report the error.
+			return
+		}
+		if flusher != nil && (i+1)%streamFlushInterval == 0 {
+			flusher.Flush()
+		}
+	}
+	rw.Write([]byte(end + "\n"))
+}
+
 // getExperimentAndService takes an http request path and extracts the last two
 // fields. For correct requests (e.g. "/v2/nearest/ndt/ndt5"), this will be the
 // experiment name (e.g. "ndt") and the datatype (e.g. "ndt5").
@@ -3,6 +3,7 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -14,13 +15,16 @@ import (
 
 	"github.com/m-lab/go/rtx"
 	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/apikey"
 	"github.com/m-lab/locate/clientgeo"
 	"github.com/m-lab/locate/heartbeat"
 	"github.com/m-lab/locate/heartbeat/heartbeattest"
 	"github.com/m-lab/locate/limits"
+	"github.com/m-lab/locate/metrics"
 	"github.com/m-lab/locate/proxy"
 	"github.com/m-lab/locate/static"
 	prom "github.com/prometheus/client_golang/api/prometheus/v1"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/square/go-jose.v2/jwt"
 )
@@ -49,9 +53,11 @@ type fakeLocatorV2 struct {
 	err     error
 	targets []v2.Target
 	urls    []url.URL
+	opts    *heartbeat.NearestOptions // Options of the last request.
 }
 
 func (l *fakeLocatorV2) Nearest(service string, lat, lon float64, opts *heartbeat.NearestOptions) (*heartbeat.TargetInfo, error) {
+	l.opts = opts
 	if l.err != nil {
 		return nil, l.err
 	}
@@ -62,6 +68,32 @@ func (l *fakeLocatorV2) Nearest(service string, lat, lon float64, opts *heartbea
 	}, nil
 }
 
+type fakeLimiter struct {
+	status limits.LimitStatus
+	err    error
+}
+
+func (l *fakeLimiter) IsLimited(service, ip, ua string) (limits.LimitStatus, error) {
+	return l.status, l.err
+}
+
+type fakeExempter struct {
+	reason string
+}
+
+func (e *fakeExempter) IsExempt(ip string) string {
+	return e.reason
+}
+
+type fakeBrake struct {
+	engaged    bool
+	retryAfter time.Duration
+}
+
+func (b *fakeBrake) IsEngaged() (bool, time.Duration) {
+	return b.engaged, b.retryAfter
+}
+
 type fakeAppEngineLocator struct {
 	loc *clientgeo.Location
 	err error
@@ -81,10 +113,15 @@ func TestClient_Nearest(t *testing.T) {
 		project    string
 		latlon     string
 		limits     limits.Agents
+		limiter    Limiter
+		exemptions Exempter
 		header     http.Header
+		query      string
 		wantLatLon string
 		wantKey    string
 		wantStatus int
+		// wantCountry is "<country>,<method>" when checked.
+		wantCountry string
 	}{
 		{
 			name:   "error-unmatched-service",
@@ -149,6 +186,14 @@ func TestClient_Nearest(t *testing.T) {
 			},
 			wantStatus: http.StatusTooManyRequests,
 		},
+		{
+			name: "error-rate-limited",
+			path: "ndt/ndt5",
+			limiter: &fakeLimiter{
+				status: limits.LimitStatus{IsLimited: true, LimitType: limits.LimitTypeIP},
+			},
+			wantStatus: http.StatusTooManyRequests,
+		},
 		{
 			name:   "success-nearest-server",
 			path:   "ndt/ndt5",
@@ -202,24 +247,107 @@ func TestClient_Nearest(t *testing.T) {
 				"X-AppEngine-Country":     []string{"US"},
 				"X-AppEngine-CityLatLong": []string{"0.000000,0.000000"},
 			},
-			wantLatLon: "37.09024,-95.712891", // Country center.
+			wantLatLon:  "37.09024,-95.712891", // Country center.
+			wantKey:     "ws://:3001/ndt_protocol",
+			wantStatus:  http.StatusOK,
+			wantCountry: "US,detected",
+		},
+		{
+			name:   "success-nearest-server-using-strict-country",
+			path:   "ndt/ndt5",
+			signer: &fakeSigner{},
+			locator: &fakeLocatorV2{
+				targets: []v2.Target{{Machine: "mlab1-lga0t.measurement-lab.org"}},
+				urls: []url.URL{
+					{Scheme: "ws", Host: ":3001", Path: "/ndt_protocol"},
+					{Scheme: "wss", Host: ":3010", Path: "ndt_protocol"},
+				},
+			},
+			header: http.Header{
+				"X-AppEngine-Country":     []string{"US"},
+				"X-AppEngine-CityLatLong": []string{"40.3,-70.4"},
+			},
+			query:       "&country=ca&strict=true",
+			wantLatLon:  "40.3,-70.4",
+			wantKey:     "ws://:3001/ndt_protocol",
+			wantStatus:  http.StatusOK,
+			wantCountry: "CA,override",
+		},
+		{
+			name:   "success-nearest-server-using-strict-without-country",
+			path:   "ndt/ndt5",
+			signer: &fakeSigner{},
+			locator: &fakeLocatorV2{
+				targets: []v2.Target{{Machine: "mlab1-lga0t.measurement-lab.org"}},
+				urls: []url.URL{
+					{Scheme: "ws", Host: ":3001", Path: "/ndt_protocol"},
+					{Scheme: "wss", Host: ":3010", Path: "ndt_protocol"},
+				},
+			},
+			header: http.Header{
+				"X-AppEngine-Country":     []string{"US"},
+				"X-AppEngine-CityLatLong": []string{"40.3,-70.4"},
+			},
+			query:       "&strict=true",
+			wantLatLon:  "40.3,-70.4",
+			wantKey:     "ws://:3001/ndt_protocol",
+			wantStatus:  http.StatusOK,
+			wantCountry: "US,detected",
+		},
+		{
+			name:   "success-exempt-from-limits",
+			path:   "ndt/ndt5",
+			signer: &fakeSigner{},
+			locator: &fakeLocatorV2{
+				targets: []v2.Target{{Machine: "mlab1-lga0t.measurement-lab.org"}},
+				urls: []url.URL{
+					{Scheme: "ws", Host: ":3001", Path: "/ndt_protocol"},
+					{Scheme: "wss", Host: ":3010", Path: "ndt_protocol"},
+				},
+			},
+			limits: limits.Agents{
+				"foo": limits.NewCron("* * * * *", time.Minute),
+			},
+			limiter: &fakeLimiter{
+				status: limits.LimitStatus{IsLimited: true, LimitType: limits.LimitTypeIP},
+			},
+			exemptions: &fakeExempter{reason: "cidr"},
+			header: http.Header{
+				"User-Agent":              []string{"foo"},
+				"X-AppEngine-CityLatLong": []string{"40.3,-70.4"},
+			},
+			wantLatLon: "40.3,-70.4",
 			wantKey:    "ws://:3001/ndt_protocol",
 			wantStatus: http.StatusOK,
 		},
+		{
+			name:   "error-invalid-strict-country",
+			path:   "ndt/ndt5",
+			signer: &fakeSigner{},
+			locator: &fakeLocatorV2{
+				targets: []v2.Target{{Machine: "mlab1-lga0t.measurement-lab.org"}},
+			},
+			header: http.Header{
+				"X-AppEngine-CityLatLong": []string{"40.3,-70.4"},
+			},
+			query:      "&country=XX&strict=true",
+			wantLatLon: "40.3,-70.4",
+			wantStatus: http.StatusBadRequest,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.cl == nil {
 				tt.cl = clientgeo.NewAppEngineLocator()
 			}
-			c := NewClient(tt.project, tt.signer, tt.locator, tt.cl, prom.NewAPI(nil), tt.limits)
+			c := NewClient(tt.project, tt.signer, tt.locator, tt.cl, prom.NewAPI(nil), tt.limits, tt.limiter, nil, tt.exemptions, nil)
 
 			mux := http.NewServeMux()
 			mux.HandleFunc("/v2/nearest/", c.Nearest)
 			srv := httptest.NewServer(mux)
 			defer srv.Close()
 
-			req, err := http.NewRequest(http.MethodGet, srv.URL+"/v2/nearest/"+tt.path+"?client_name=foo", nil)
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/v2/nearest/"+tt.path+"?client_name=foo"+tt.query, nil)
 			rtx.Must(err, "Failed to create request")
 			req.Header = tt.header
 
@@ -246,6 +374,10 @@ func TestClient_Nearest(t *testing.T) {
 			if result.Error != nil {
 				return
 			}
+			country := resp.Header.Get("X-Locate-Clientcountry") + "," + resp.Header.Get("X-Locate-Clientcountry-Method")
+			if tt.wantCountry != "" && country != tt.wantCountry {
+				t.Errorf("Nearest() wrong client country headers; got %s, want %s", country, tt.wantCountry)
+			}
 			if result.Results == nil && tt.wantStatus == http.StatusOK {
 				t.Errorf("Nearest() wrong status; got %d, want %d", result.Error.Status, tt.wantStatus)
 			}
@@ -291,7 +423,7 @@ func TestClient_Ready(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{StatusTracker: &heartbeattest.FakeStatusTracker{Err: tt.fakeErr}}, nil, nil, nil)
+			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{StatusTracker: &heartbeattest.FakeStatusTracker{Err: tt.fakeErr}}, nil, nil, nil, nil, nil, nil, nil)
 
 			mux := http.NewServeMux()
 			mux.HandleFunc("/ready/", c.Ready)
@@ -323,6 +455,7 @@ func TestClient_Registrations(t *testing.T) {
 	tests := []struct {
 		name       string
 		instances  map[string]v2.HeartbeatMessage
+		format     string
 		fakeErr    error
 		wantStatus int
 	}{
@@ -334,12 +467,29 @@ func TestClient_Registrations(t *testing.T) {
 			wantStatus: http.StatusOK,
 		},
 		{
-			name: "error-status-500",
+			name: "success-sites-status-200",
+			instances: map[string]v2.HeartbeatMessage{
+				"ndt-mlab1-abc0t.mlab-sandbox.measurement-lab.org": {
+					Registration: &v2.Registration{Site: "abc0t"},
+				},
+			},
+			format:     "sites",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "success-sites-unparsed-status-200",
 			instances: map[string]v2.HeartbeatMessage{
 				"invalid-hostname.xyz": {},
 			},
-			fakeErr:    errors.New("fake error"),
-			wantStatus: http.StatusInternalServerError,
+			format:     "sites",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "success-unparsed-status-200",
+			instances: map[string]v2.HeartbeatMessage{
+				"invalid-hostname.xyz": {},
+			},
+			wantStatus: http.StatusOK,
 		},
 	}
 	for _, tt := range tests {
@@ -349,20 +499,72 @@ func TestClient_Registrations(t *testing.T) {
 		}
 
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{StatusTracker: fakeStatusTracker}, nil, nil, nil)
+			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{StatusTracker: fakeStatusTracker}, nil, nil, nil, nil, nil, nil, nil)
 
 			mux := http.NewServeMux()
 			mux.HandleFunc("/v2/siteinfo/registrations/", c.Registrations)
 			srv := httptest.NewServer(mux)
 			defer srv.Close()
 
-			req, err := http.NewRequest(http.MethodGet, srv.URL+"/v2/siteinfo/registrations?org=mlab", nil)
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/v2/siteinfo/registrations?org=mlab&format="+tt.format, nil)
 			rtx.Must(err, "failed to create request")
 			resp, err := http.DefaultClient.Do(req)
 			rtx.Must(err, "failed to issue request")
 			if resp.StatusCode != tt.wantStatus {
 				t.Errorf("Registrations() wrong status; got %d; want %d", resp.StatusCode, tt.wantStatus)
 			}
+			var result interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Errorf("Registrations() returned invalid JSON: %v", err)
+			}
+		})
+	}
+}
+
+func Test_streamResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		wantFlushed bool
+	}{
+		{
+			name: "empty",
+			n:    0,
+		},
+		{
+			name: "one",
+			n:    1,
+		},
+		{
+			name:        "flushed",
+			n:           2*streamFlushInterval + 1,
+			wantFlushed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			streamResult(rw, "[", "]", tt.n, func(i int) ([]byte, error) {
+				return json.Marshal(i)
+			})
+
+			if rw.Code != http.StatusOK {
+				t.Errorf("streamResult() wrong status; got %d, want %d", rw.Code, http.StatusOK)
+			}
+			if rw.Flushed != tt.wantFlushed {
+				t.Errorf("streamResult() flushed = %t, want %t", rw.Flushed, tt.wantFlushed)
+			}
+			got := []int{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+				t.Fatalf("streamResult() returned invalid JSON: %v", err)
+			}
+			want := []int{}
+			for i := 0; i < tt.n; i++ {
+				want = append(want, i)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("streamResult() = %v, want %v", got, want)
+			}
 		})
 	}
 }
@@ -578,3 +780,501 @@ func TestClient_limitRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_checkRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter Limiter
+		want    limits.LimitStatus
+	}{
+		{
+			name: "no-limiter",
+		},
+		{
+			name: "limited",
+			limiter: &fakeLimiter{
+				status: limits.LimitStatus{IsLimited: true, LimitType: limits.LimitTypeIPUA},
+			},
+			want: limits.LimitStatus{IsLimited: true, LimitType: limits.LimitTypeIPUA},
+		},
+		{
+			name: "limiter-error",
+			limiter: &fakeLimiter{
+				status: limits.LimitStatus{IsLimited: true},
+				err:    errors.New("fake redis error"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{ipLimiter: tt.limiter}
+			req := httptest.NewRequest(http.MethodGet, "/v2/nearest/ndt/ndt7", nil)
+			if got := c.checkRateLimit(req, "ndt/ndt7"); got != tt.want {
+				t.Errorf("checkRateLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetRemoteIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		forwarded  string
+		remoteAddr string
+		want       string
+	}{
+		{
+			name:       "forwarded-for",
+			forwarded:  "192.0.2.1, 198.51.100.1",
+			remoteAddr: "203.0.113.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "forwarded-for-forged",
+			forwarded:  "203.0.113.9, 192.0.2.1, 198.51.100.1",
+			remoteAddr: "203.0.113.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "forwarded-for-single",
+			forwarded:  "198.51.100.1",
+			remoteAddr: "203.0.113.1:1234",
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "remote-addr",
+			remoteAddr: "[2001:db8::1]:1234",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "remote-addr-without-port",
+			remoteAddr: "203.0.113.1",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "remote-addr-invalid",
+			remoteAddr: "invalid-ip:1234",
+			want:       "invalid-ip:1234",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := getRemoteIP(req); got != tt.want {
+				t.Errorf("getRemoteIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeQuotaChecker struct {
+	status limits.QuotaStatus
+	err    error
+	keyID  string
+}
+
+func (q *fakeQuotaChecker) Check(keyID string) (limits.QuotaStatus, error) {
+	q.keyID = keyID
+	return q.status, q.err
+}
+
+func TestClient_checkKeyQuota(t *testing.T) {
+	tests := []struct {
+		name        string
+		quotas      QuotaChecker
+		want        limits.QuotaStatus
+		wantHeaders http.Header
+	}{
+		{
+			name:        "no-quotas",
+			wantHeaders: http.Header{},
+		},
+		{
+			name: "limited",
+			quotas: &fakeQuotaChecker{
+				status: limits.QuotaStatus{IsLimited: true, Tier: "standard", MinuteRemaining: 0, DayRemaining: -1},
+			},
+			want: limits.QuotaStatus{IsLimited: true, Tier: "standard", MinuteRemaining: 0, DayRemaining: -1},
+			wantHeaders: http.Header{
+				hLocateQuotaTier:            []string{"standard"},
+				hLocateQuotaMinuteRemaining: []string{"0"},
+			},
+		},
+		{
+			name: "quota-error",
+			quotas: &fakeQuotaChecker{
+				status: limits.QuotaStatus{IsLimited: true},
+				err:    errors.New("fake redis error"),
+			},
+			wantHeaders: http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{keyQuotas: tt.quotas}
+			rw := httptest.NewRecorder()
+			if got := c.checkKeyQuota(rw, "ki_1"); got != tt.want {
+				t.Errorf("checkKeyQuota() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(rw.Header(), tt.wantHeaders) {
+				t.Errorf("checkKeyQuota() headers = %v, want %v", rw.Header(), tt.wantHeaders)
+			}
+		})
+	}
+}
+
+func TestClient_NearestKeyQuota(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		integration *apikey.Integration
+		wantType    string
+		wantCond    string
+	}{
+		{
+			name:        "priority-quota-exceeded",
+			path:        "/v2/priority/nearest/ndt/ndt7?key=mlabk.ki_1.secret",
+			integration: &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			wantType:    v2.ErrorTypeQuotaExceeded,
+			wantCond:    metrics.ConditionQuota,
+		},
+		{
+			name:        "priority-signed-quota-exceeded",
+			path:        "/v2/priority/nearest/ndt/ndt7?key_id=ki_1&signature=fake-signature",
+			integration: &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			wantType:    v2.ErrorTypeQuotaExceeded,
+			wantCond:    metrics.ConditionQuota,
+		},
+		{
+			name:     "priority-unverified-key-rate-limited",
+			path:     "/v2/priority/nearest/ndt/ndt7?key=fake-key",
+			wantType: v2.ErrorTypeRateLimited,
+			wantCond: metrics.ConditionRateLimit,
+		},
+		{
+			name:        "nearest-key-rate-limited",
+			path:        "/v2/nearest/ndt/ndt7?key=mlabk.ki_1.secret",
+			integration: &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			wantType:    v2.ErrorTypeRateLimited,
+			wantCond:    metrics.ConditionRateLimit,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quotas := &fakeQuotaChecker{status: limits.QuotaStatus{IsLimited: true}}
+			limiter := &fakeLimiter{status: limits.LimitStatus{IsLimited: true, LimitType: limits.LimitTypeIP}}
+			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{}, nil, nil, nil, limiter, quotas, nil, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.integration != nil {
+				req = req.WithContext(apikey.NewContext(req.Context(), tt.integration))
+			}
+			counter := metrics.RequestsTotal.WithLabelValues("nearest", tt.wantCond, http.StatusText(http.StatusTooManyRequests))
+			before := promtest.ToFloat64(counter)
+			c.Nearest(rw, req)
+			if after := promtest.ToFloat64(counter); after != before+1 {
+				t.Errorf("Nearest() did not count condition %q", tt.wantCond)
+			}
+			if rw.Code != http.StatusTooManyRequests {
+				t.Errorf("Nearest() wrong status; got %d, want %d", rw.Code, http.StatusTooManyRequests)
+			}
+			result := &v2.NearestResult{}
+			rtx.Must(json.Unmarshal(rw.Body.Bytes(), result), "failed to unmarshal result")
+			if result.Error == nil || result.Error.Type != tt.wantType {
+				t.Errorf("Nearest() wrong error; got %#v, want type %q", result.Error, tt.wantType)
+			}
+			if tt.wantType == v2.ErrorTypeQuotaExceeded && quotas.keyID != tt.integration.KeyID {
+				t.Errorf("Nearest() wrong quota key; got %q, want %q", quotas.keyID, tt.integration.KeyID)
+			}
+		})
+	}
+}
+
+func TestSetRateLimitHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		status limits.LimitStatus
+		want   http.Header
+	}{
+		{
+			name: "no-limit",
+			want: http.Header{},
+		},
+		{
+			name:   "limit",
+			status: limits.LimitStatus{Limit: 40, Remaining: 3, Reset: 1500 * time.Millisecond},
+			want: http.Header{
+				"X-Ratelimit-Limit":     []string{"40"},
+				"X-Ratelimit-Remaining": []string{"3"},
+				"X-Ratelimit-Reset":     []string{"2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			setRateLimitHeaders(rw, tt.status)
+			if !reflect.DeepEqual(rw.Header(), tt.want) {
+				t.Errorf("setRateLimitHeaders() = %v, want %v", rw.Header(), tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_NearestRetryAfter(t *testing.T) {
+	limiter := &fakeLimiter{
+		status: limits.LimitStatus{
+			IsLimited:  true,
+			LimitType:  limits.LimitTypeIP,
+			Limit:      10,
+			RetryAfter: 1500 * time.Millisecond,
+		},
+	}
+	c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{}, nil, nil, nil, limiter, nil, nil, nil)
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v2/nearest/ndt/ndt7", nil)
+	c.Nearest(rw, req)
+
+	if rw.Code != http.StatusTooManyRequests {
+		t.Errorf("Nearest() wrong status; got %d, want %d", rw.Code, http.StatusTooManyRequests)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Nearest() wrong Retry-After header; got %q, want %q", got, "2")
+	}
+	result := &v2.NearestResult{}
+	rtx.Must(json.Unmarshal(rw.Body.Bytes(), result), "failed to unmarshal result")
+	if result.Error == nil || result.Error.Detail != "Retry after 2 seconds." {
+		t.Errorf("Nearest() wrong error detail; got %#v", result.Error)
+	}
+}
+
+func TestClient_NearestEmergencyBrake(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		integration    *apikey.Integration
+		brake          EmergencyBrake
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:           "anonymous-rejected",
+			path:           "/v2/nearest/ndt/ndt7",
+			brake:          &fakeBrake{engaged: true, retryAfter: 90 * time.Second},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "90",
+		},
+		{
+			name:           "key-on-nearest-rejected",
+			path:           "/v2/nearest/ndt/ndt7?key=fake-key",
+			brake:          &fakeBrake{engaged: true, retryAfter: time.Minute},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+		{
+			name:           "priority-unverified-key-rejected",
+			path:           "/v2/priority/nearest/ndt/ndt7?key=fake-key",
+			brake:          &fakeBrake{engaged: true, retryAfter: time.Minute},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+		{
+			name:        "priority-verified-key-served",
+			path:        "/v2/priority/nearest/ndt/ndt7?key=mlabk.ki_1.secret",
+			integration: &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			brake:       &fakeBrake{engaged: true, retryAfter: time.Minute},
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+		{
+			name:           "nearest-verified-key-rejected",
+			path:           "/v2/nearest/ndt/ndt7?key=mlabk.ki_1.secret",
+			integration:    &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			brake:          &fakeBrake{engaged: true, retryAfter: time.Minute},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+		{
+			name:       "released",
+			path:       "/v2/nearest/ndt/ndt7",
+			brake:      &fakeBrake{},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := &fakeAppEngineLocator{err: errors.New("fake locate error")}
+			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{}, cl, nil, nil, nil, nil, nil, tt.brake)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.integration != nil {
+				req = req.WithContext(apikey.NewContext(req.Context(), tt.integration))
+			}
+			counter := metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionBrake, http.StatusText(http.StatusTooManyRequests))
+			before := promtest.ToFloat64(counter)
+			c.Nearest(rw, req)
+			if braked := promtest.ToFloat64(counter) == before+1; braked != (tt.wantStatus == http.StatusTooManyRequests) {
+				t.Errorf("Nearest() counted the brake condition = %v, want %v", braked, !braked)
+			}
+			if rw.Code != tt.wantStatus {
+				t.Errorf("Nearest() wrong status; got %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if got := rw.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Nearest() wrong Retry-After header; got %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestClient_SetTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		expiry  map[string]string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			expiry: map[string]string{"wehe/replay": "10m", "ndt": "2m"},
+			want: map[string]time.Duration{
+				"wehe/replay": 10 * time.Minute,
+				"ndt/ndt7":    2 * time.Minute,
+				"ndt/ndt5":    2 * time.Minute,
+				"msak/pair1":  static.AccessTokenExpiry,
+			},
+		},
+		{
+			name:   "default",
+			expiry: map[string]string{},
+			want: map[string]time.Duration{
+				"wehe/replay": static.AccessTokenExpiry,
+			},
+		},
+		{
+			name:    "error-invalid-duration",
+			expiry:  map[string]string{"wehe/replay": "forever"},
+			wantErr: true,
+		},
+		{
+			name:    "error-negative-duration",
+			expiry:  map[string]string{"wehe/replay": "-1m"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{}
+			err := c.SetTokenExpiry(tt.expiry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetTokenExpiry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for service, want := range tt.want {
+				if got := c.accessTokenExpiry(service); got != want {
+					t.Errorf("accessTokenExpiry(%q) = %v, want %v", service, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_SetASNLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		asns    []string
+		want    map[uint]bool
+		wantErr bool
+	}{
+		{
+			name: "success",
+			asns: []string{"15169", "AS13335", "as7922"},
+			want: map[uint]bool{15169: true, 13335: true, 7922: true},
+		},
+		{
+			name: "empty",
+			want: map[uint]bool{},
+		},
+		{
+			name:    "error-invalid",
+			asns:    []string{"google"},
+			wantErr: true,
+		},
+		{
+			name:    "error-zero",
+			asns:    []string{"AS0"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{}
+			err := c.SetASNLabels(tt.asns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetASNLabels() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(c.asnLabels, tt.want) {
+				t.Errorf("SetASNLabels() = %v, want %v", c.asnLabels, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_recordClientRequest(t *testing.T) {
+	c := &Client{}
+	if err := c.SetASNLabels([]string{"AS15169"}); err != nil {
+		t.Fatalf("SetASNLabels() returned error: %v", err)
+	}
+	tests := []struct {
+		asn  uint
+		want string
+	}{
+		{asn: 15169, want: "AS15169"},
+		{asn: 13335, want: "other"},
+		{asn: 0, want: "unknown"},
+	}
+	for _, tt := range tests {
+		before := promtest.ToFloat64(metrics.ClientASNRequestsTotal.WithLabelValues(tt.want))
+		c.recordClientRequest("ndt/ndt7", "US", tt.asn)
+		after := promtest.ToFloat64(metrics.ClientASNRequestsTotal.WithLabelValues(tt.want))
+		if after != before+1 {
+			t.Errorf("recordClientRequest(%d) did not count label %q", tt.asn, tt.want)
+		}
+	}
+}
+
+func Test_nearestWarnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		loc     *clientgeo.Location
+		want    []string
+	}{
+		{
+			name:    "none",
+			service: "ndt/ndt7",
+			loc:     &clientgeo.Location{AccuracyKm: 50},
+		},
+		{
+			name:    "deprecated",
+			service: "ndt/ndt5",
+			loc:     &clientgeo.Location{},
+			want:    []string{"ndt5 is deprecated: use ndt7"},
+		},
+		{
+			name:    "deprecated-and-coarse",
+			service: "ndt/ndt5",
+			loc:     &clientgeo.Location{AccuracyKm: static.CoarseAccuracyKm},
+			want:    []string{"ndt5 is deprecated: use ndt7", "results degraded: coarse client location used"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nearestWarnings(tt.service, tt.loc)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nearestWarnings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -32,11 +33,11 @@ func (c *Client) Heartbeat(rw http.ResponseWriter, req *http.Request) {
 	ws, err := upgrader.Upgrade(rw, req, nil)
 	if err != nil {
 		log.Errorf("failed to establish a connection: %v", err)
-		metrics.RequestsTotal.WithLabelValues("heartbeat", "establish connection",
+		metrics.RequestsTotal.WithLabelValues("heartbeat", metrics.ConditionBadRequest,
 			"error upgrading the HTTP server connection to the WebSocket protocol").Inc()
 		return
 	}
-	metrics.RequestsTotal.WithLabelValues("heartbeat", "establish connection", "OK").Inc()
+	metrics.RequestsTotal.WithLabelValues("heartbeat", metrics.ConditionSuccess, "OK").Inc()
 	go c.handleHeartbeats(ws)
 }
 
@@ -47,10 +48,13 @@ func (c *Client) handleHeartbeats(ws conn) error {
 
 	var hostname string
 	var experiment string
+	// The protocol version of the agent. New message types must only be sent
+	// to agents with a version that supports them.
+	var version string
 	for {
 		_, message, err := ws.ReadMessage()
 		if err != nil {
-			closeConnection(experiment, err)
+			closeConnection(experiment, version, err)
 			return err
 		}
 		if message != nil {
@@ -65,21 +69,23 @@ func (c *Client) handleHeartbeats(ws conn) error {
 			switch {
 			case hbm.Registration != nil:
 				if err := c.RegisterInstance(*hbm.Registration); err != nil {
-					closeConnection(experiment, err)
+					closeConnection(experiment, version, err)
 					return err
 				}
 
 				if hostname == "" {
 					hostname = hbm.Registration.Hostname
 					experiment = hbm.Registration.Experiment
+					version = strconv.Itoa(hbm.Registration.ProtocolVersion)
 					metrics.CurrentHeartbeatConnections.WithLabelValues(experiment).Inc()
+					metrics.HeartbeatProtocolVersions.WithLabelValues(version).Inc()
 				}
 
 				// Update Prometheus signals every time a Registration message is received.
 				c.UpdatePrometheusForMachine(context.Background(), hbm.Registration.Hostname)
 			case hbm.Health != nil:
 				if err := c.UpdateHealth(hostname, *hbm.Health); err != nil {
-					closeConnection(experiment, err)
+					closeConnection(experiment, version, err)
 					return err
 				}
 			}
@@ -93,9 +99,10 @@ func setReadDeadline(ws conn) {
 	ws.SetReadDeadline(deadline)
 }
 
-func closeConnection(experiment string, err error) {
+func closeConnection(experiment, version string, err error) {
 	if experiment != "" {
 		metrics.CurrentHeartbeatConnections.WithLabelValues(experiment).Dec()
+		metrics.HeartbeatProtocolVersions.WithLabelValues(version).Dec()
 	}
 	log.Errorf("closing connection, err: %v", err)
 }
@@ -70,7 +70,7 @@ func TestClient_handleHeartbeats(t *testing.T) {
 func fakeClient(t heartbeat.StatusTracker) *Client {
 	locatorv2 := fakeLocatorV2{StatusTracker: t}
 	return NewClient("mlab-sandbox", &fakeSigner{}, &locatorv2,
-		clientgeo.NewAppEngineLocator(), prom.NewAPI(nil), nil)
+		clientgeo.NewAppEngineLocator(), prom.NewAPI(nil), nil, nil, nil, nil, nil)
 }
 
 type fakeConn struct {
@@ -16,7 +16,7 @@ func (c *Client) Monitoring(rw http.ResponseWriter, req *http.Request) {
 	// Validate request.
 	cl := controller.GetClaim(req.Context())
 	if cl == nil {
-		result.Error = v2.NewError("claim", "Must provide access_token", http.StatusBadRequest)
+		result.Error = v2.NewError(v2.ErrorTypeInvalidRequest, "Must provide access_token", http.StatusBadRequest)
 		writeResult(rw, result.Error.Status, &result)
 		return
 	}
@@ -24,7 +24,7 @@ func (c *Client) Monitoring(rw http.ResponseWriter, req *http.Request) {
 	// Check that the given subject appears to be an M-Lab machine name.
 	m, err := host.Parse(cl.Subject)
 	if err != nil {
-		result.Error = v2.NewError("subject", "Subject must be specified", http.StatusBadRequest)
+		result.Error = v2.NewError(v2.ErrorTypeInvalidRequest, "Subject must be specified", http.StatusBadRequest)
 		writeResult(rw, result.Error.Status, &result)
 		return
 	}
@@ -33,7 +33,7 @@ func (c *Client) Monitoring(rw http.ResponseWriter, req *http.Request) {
 	experiment, service := getExperimentAndService(req.URL.Path)
 	ports, ok := static.Configs[service]
 	if !ok {
-		result.Error = v2.NewError("config", "Unknown service: "+service, http.StatusBadRequest)
+		result.Error = v2.NewError(v2.ErrorTypeUnknownService, "Unknown service: "+service, http.StatusBadRequest)
 		writeResult(rw, result.Error.Status, &result)
 		return
 	}
@@ -44,7 +44,7 @@ func (c *Client) Monitoring(rw http.ResponseWriter, req *http.Request) {
 
 	// Get monitoring subject access tokens for the given machine.
 	machine := cl.Subject
-	token := c.getAccessToken(cl.Subject, static.SubjectMonitoring)
+	token := c.getAccessToken(cl.Subject, static.SubjectMonitoring, static.AccessTokenExpiry)
 	// NOTE: v2 vs v3 naming
 	// v2 monitoring uses the non-service, machine name as the subject.
 	// v3 monitoring uses the service name as the subject, so this should be a noop.
@@ -53,7 +53,7 @@ func TestClient_Monitoring(t *testing.T) {
 			claim: nil,
 			path:  "ndt/ndt5",
 			wantErr: &v2.Error{
-				Type:   "claim",
+				Type:   v2.ErrorTypeInvalidRequest,
 				Title:  "Must provide access_token",
 				Status: http.StatusBadRequest,
 			},
@@ -68,7 +68,7 @@ func TestClient_Monitoring(t *testing.T) {
 			},
 			path: "ndt/ndt5",
 			wantErr: &v2.Error{
-				Type:   "subject",
+				Type:   v2.ErrorTypeInvalidRequest,
 				Title:  "Subject must be specified",
 				Status: http.StatusBadRequest,
 			},
@@ -83,7 +83,7 @@ func TestClient_Monitoring(t *testing.T) {
 			},
 			path: "ndt/this-is-an-invalid-service-name",
 			wantErr: &v2.Error{
-				Type:   "config",
+				Type:   v2.ErrorTypeUnknownService,
 				Title:  "Unknown service: ndt/this-is-an-invalid-service-name",
 				Status: http.StatusBadRequest,
 			},
@@ -92,7 +92,7 @@ func TestClient_Monitoring(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cl := clientgeo.NewAppEngineLocator()
-			c := NewClient("mlab-sandbox", tt.signer, tt.locator, cl, prom.NewAPI(nil), nil)
+			c := NewClient("mlab-sandbox", tt.signer, tt.locator, cl, prom.NewAPI(nil), nil, nil, nil, nil, nil)
 			rw := httptest.NewRecorder()
 			req := httptest.NewRequest(http.MethodGet, "/v2/platform/monitoring/"+tt.path, nil)
 			req = req.Clone(controller.SetClaim(req.Context(), tt.claim))
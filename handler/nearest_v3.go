@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	v3 "github.com/m-lab/locate/api/v3"
+	"github.com/m-lab/locate/clientgeo"
+	"github.com/m-lab/locate/heartbeat"
+	"github.com/m-lab/locate/metrics"
+	"github.com/m-lab/locate/static"
+)
+
+// maxRequestBytes is the maximum size of the body of a v3 request.
+const maxRequestBytes = 64 << 10
+
+// NearestV3 looks up the nearest servers for the v3.NearestRequest in the
+// JSON body of a POST request. The request is subject to the same limits as
+// v2 nearest requests without an API key.
+func (c *Client) NearestV3(rw http.ResponseWriter, req *http.Request) {
+	result := v3.NearestResult{}
+	setHeaders(rw)
+
+	switch req.Method {
+	case http.MethodOptions:
+		// Allow CORS preflight requests for JSON bodies.
+		rw.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		rw.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		rw.Header().Set("Allow", "POST, OPTIONS")
+		result.Error = v2.NewError(v2.ErrorTypeInvalidRequest, "Method must be POST", http.StatusMethodNotAllowed)
+		writeV3Error(rw, &result, metrics.ConditionBadRequest)
+		return
+	}
+
+	var nr v3.NearestRequest
+	dec := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&nr); err != nil {
+		result.Error = v2.NewError(v2.ErrorTypeInvalidRequest, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		writeV3Error(rw, &result, metrics.ConditionBadRequest)
+		return
+	}
+	if err := nr.Validate(); err != nil {
+		result.Error = v2.NewError(v2.ErrorTypeInvalidRequest, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		writeV3Error(rw, &result, metrics.ConditionBadRequest)
+		return
+	}
+	if _, ok := static.Configs[nr.Service]; !ok {
+		result.Error = v2.NewError(v2.ErrorTypeUnknownService, "Unknown service: "+nr.Service, http.StatusBadRequest)
+		writeV3Error(rw, &result, metrics.ConditionBadRequest)
+		return
+	}
+	// The body is already read, so only the query parameters are parsed.
+	req.ParseForm()
+
+	// During capacity incidents, only requests with API keys are served.
+	if engaged, retryAfter := c.isBrakeEngaged(); engaged {
+		result.Error = v2.NewError(v2.ErrorTypeOverloaded, brakeEngaged, http.StatusTooManyRequests)
+		setErrorRetryAfter(rw, result.Error, retryAfter)
+		writeV3Error(rw, &result, metrics.ConditionBrake)
+		return
+	}
+	if !c.isExempt(req) {
+		if c.limitRequest(time.Now().UTC(), req) {
+			result.Error = v2.NewError(v2.ErrorTypeRateLimited, tooManyRequests, http.StatusTooManyRequests)
+			writeV3Error(rw, &result, metrics.ConditionRateLimit)
+			return
+		}
+		status := c.checkRateLimit(req, nr.Service)
+		setRateLimitHeaders(rw, status)
+		if status.IsLimited {
+			result.Error = v2.NewError(v2.ErrorTypeRateLimited, tooManyClientRequests, http.StatusTooManyRequests)
+			setErrorRetryAfter(rw, result.Error, status.RetryAfter)
+			writeV3Error(rw, &result, metrics.ConditionRateLimit)
+			metrics.RateLimitedTotal.WithLabelValues(status.LimitType).Inc()
+			return
+		}
+	}
+
+	// Use the client location of the request, or look it up.
+	sel := &v3.Selection{LocationMethod: "request"}
+	loc := &clientgeo.Location{}
+	if nr.Latitude != nil {
+		sel.Latitude, sel.Longitude = *nr.Latitude, *nr.Longitude
+	} else {
+		var err error
+		loc, err = c.checkClientLocation(rw, req)
+		if err != nil {
+			result.Error = v2.NewError(v2.ErrorTypeClientLocation, "Failed to lookup client location", http.StatusServiceUnavailable)
+			writeV3Error(rw, &result, metrics.ConditionClientGeo)
+			return
+		}
+		lat, errLat := strconv.ParseFloat(loc.Latitude, 64)
+		lon, errLon := strconv.ParseFloat(loc.Longitude, 64)
+		if errLat != nil || errLon != nil {
+			result.Error = v2.NewError(v2.ErrorTypeClientLocation, errFailedToLookupClient.Error(), http.StatusInternalServerError)
+			writeV3Error(rw, &result, metrics.ConditionClientGeo)
+			return
+		}
+		sel.Latitude, sel.Longitude = lat, lon
+		sel.LocationMethod = "detected"
+		sel.Country = loc.Country
+		if sel.Country == "" {
+			sel.Country = req.Header.Get("X-AppEngine-Country")
+		}
+	}
+
+	opts := &heartbeat.NearestOptions{
+		Country:       sel.Country,
+		AccuracyKm:    loc.AccuracyKm,
+		Addresses:     true,
+		Count:         nr.Count,
+		Exclude:       nr.Exclude,
+		AddressFamily: nr.AddressFamily,
+	}
+	targetInfo, err := c.LocatorV2.Nearest(nr.Service, sel.Latitude, sel.Longitude, opts)
+	if err != nil {
+		typ, condition := v2.ErrorTypeInternal, metrics.ConditionInternal
+		if errors.Is(err, heartbeat.ErrNoAvailableServers) {
+			typ, condition = v2.ErrorTypeNoServers, metrics.ConditionNoCapacity
+		}
+		result.Error = v2.NewError(typ, "Failed to lookup nearest machines", http.StatusInternalServerError)
+		writeV3Error(rw, &result, condition)
+		return
+	}
+	sel.Candidates = targetInfo.Candidates
+
+	pOpts := paramOpts{
+		raw:       req.Form,
+		version:   "v3",
+		ranks:     targetInfo.Ranks,
+		svcParams: static.ServiceParams,
+	}
+	c.populateURLs(targetInfo.Targets, targetInfo.URLs, path.Dir(nr.Service), c.accessTokenExpiry(nr.Service), pOpts)
+	result.Results = v3Targets(targetInfo)
+	result.Selection = sel
+	result.Warnings = nearestWarnings(nr.Service, loc)
+	writeResult(rw, http.StatusOK, &result)
+	metrics.RequestsTotal.WithLabelValues("nearest_v3", metrics.ConditionSuccess, http.StatusText(http.StatusOK)).Inc()
+}
+
+// writeV3Error writes a v3 result with an error and counts the request with
+// the given condition.
+func writeV3Error(rw http.ResponseWriter, result *v3.NearestResult, condition string) {
+	writeResult(rw, result.Error.Status, result)
+	metrics.RequestsTotal.WithLabelValues("nearest_v3", condition, http.StatusText(result.Error.Status)).Inc()
+}
+
+// v3Targets converts the targets of a TargetInfo to v3 targets.
+func v3Targets(info *heartbeat.TargetInfo) []v3.Target {
+	targets := make([]v3.Target, len(info.Targets))
+	for i, t := range info.Targets {
+		targets[i] = v3.Target{
+			Machine:     t.Machine,
+			Hostname:    t.Hostname,
+			Location:    t.Location,
+			IPv4:        t.IPv4,
+			IPv6:        t.IPv6,
+			DistanceKm:  info.Distances[t.Machine],
+			MetroRank:   info.Ranks[t.Machine],
+			ServiceInfo: t.ServiceInfo,
+			URLs:        t.URLs,
+		}
+	}
+	return targets
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	v3 "github.com/m-lab/locate/api/v3"
+	"github.com/m-lab/locate/clientgeo"
+	"github.com/m-lab/locate/heartbeat"
+	prom "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestClient_NearestV3(t *testing.T) {
+	ndt7URLs := []url.URL{{Scheme: "wss", Path: "/ndt/v7/download"}}
+	target := v2.Target{
+		Machine:  "mlab1-lga0t.measurement-lab.org",
+		Hostname: "ndt-mlab1-lga0t.measurement-lab.org",
+		IPv4:     "192.0.2.1",
+	}
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		locator    *fakeLocatorV2
+		header     http.Header
+		wantStatus int
+		wantType   string
+		wantOpts   *heartbeat.NearestOptions
+		wantSel    *v3.Selection
+	}{
+		{
+			name:       "success-request-location",
+			method:     http.MethodPost,
+			body:       `{"service": "ndt/ndt7", "latitude": 40.7, "longitude": -74.0, "count": 2, "exclude": ["lga01"], "address_family": "ipv4"}`,
+			locator:    &fakeLocatorV2{targets: []v2.Target{target}, urls: ndt7URLs},
+			wantStatus: http.StatusOK,
+			wantOpts: &heartbeat.NearestOptions{
+				Addresses:     true,
+				Count:         2,
+				Exclude:       []string{"lga01"},
+				AddressFamily: "ipv4",
+			},
+			wantSel: &v3.Selection{Latitude: 40.7, Longitude: -74.0, LocationMethod: "request"},
+		},
+		{
+			name:    "success-detected-location",
+			method:  http.MethodPost,
+			body:    `{"service": "ndt/ndt7"}`,
+			locator: &fakeLocatorV2{targets: []v2.Target{target}, urls: ndt7URLs},
+			header: http.Header{
+				"X-AppEngine-CityLatLong": []string{"40.3,-70.4"},
+				"X-AppEngine-Country":     []string{"US"},
+			},
+			wantStatus: http.StatusOK,
+			wantOpts:   &heartbeat.NearestOptions{Country: "US", Addresses: true},
+			wantSel:    &v3.Selection{Latitude: 40.3, Longitude: -70.4, LocationMethod: "detected", Country: "US"},
+		},
+		{
+			name:       "error-method",
+			method:     http.MethodGet,
+			locator:    &fakeLocatorV2{},
+			wantStatus: http.StatusMethodNotAllowed,
+			wantType:   v2.ErrorTypeInvalidRequest,
+		},
+		{
+			name:       "error-unknown-field",
+			method:     http.MethodPost,
+			body:       `{"service": "ndt/ndt7", "lat": 40.7}`,
+			locator:    &fakeLocatorV2{},
+			wantStatus: http.StatusBadRequest,
+			wantType:   v2.ErrorTypeInvalidRequest,
+		},
+		{
+			name:       "error-invalid-request",
+			method:     http.MethodPost,
+			body:       `{"service": "ndt/ndt7", "count": 100}`,
+			locator:    &fakeLocatorV2{},
+			wantStatus: http.StatusBadRequest,
+			wantType:   v2.ErrorTypeInvalidRequest,
+		},
+		{
+			name:       "error-unknown-service",
+			method:     http.MethodPost,
+			body:       `{"service": "foo/bar"}`,
+			locator:    &fakeLocatorV2{},
+			wantStatus: http.StatusBadRequest,
+			wantType:   v2.ErrorTypeUnknownService,
+		},
+		{
+			name:       "error-client-location",
+			method:     http.MethodPost,
+			body:       `{"service": "ndt/ndt7"}`,
+			locator:    &fakeLocatorV2{},
+			wantStatus: http.StatusServiceUnavailable,
+			wantType:   v2.ErrorTypeClientLocation,
+		},
+		{
+			name:       "error-no-servers",
+			method:     http.MethodPost,
+			body:       `{"service": "ndt/ndt7", "latitude": 40.7, "longitude": -74.0}`,
+			locator:    &fakeLocatorV2{err: heartbeat.ErrNoAvailableServers},
+			wantStatus: http.StatusInternalServerError,
+			wantType:   v2.ErrorTypeNoServers,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("mlab-sandbox", &fakeSigner{}, tt.locator, clientgeo.NewAppEngineLocator(), prom.NewAPI(nil), nil, nil, nil, nil, nil)
+
+			req := httptest.NewRequest(tt.method, "/v3/nearest", strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v[0])
+			}
+			rw := httptest.NewRecorder()
+			c.NearestV3(rw, req)
+
+			if rw.Code != tt.wantStatus {
+				t.Errorf("NearestV3() status = %d, want %d", rw.Code, tt.wantStatus)
+			}
+			result := &v3.NearestResult{}
+			if err := json.Unmarshal(rw.Body.Bytes(), result); err != nil {
+				t.Fatalf("NearestV3() returned invalid JSON: %v", err)
+			}
+			if tt.wantType != "" {
+				if result.Error == nil || result.Error.Type != tt.wantType {
+					t.Errorf("NearestV3() error = %+v, want type %s", result.Error, tt.wantType)
+				}
+				return
+			}
+			if !reflect.DeepEqual(tt.locator.opts, tt.wantOpts) {
+				t.Errorf("NearestV3() options = %+v, want %+v", tt.locator.opts, tt.wantOpts)
+			}
+			if !reflect.DeepEqual(result.Selection, tt.wantSel) {
+				t.Errorf("NearestV3() selection = %+v, want %+v", result.Selection, tt.wantSel)
+			}
+			if len(result.Results) != 1 || result.Results[0].IPv4 != target.IPv4 || len(result.Results[0].URLs) != 1 {
+				t.Errorf("NearestV3() results = %+v, want 1 target with addresses and URLs", result.Results)
+			}
+		})
+	}
+}
+
+func TestClient_NearestV3Preflight(t *testing.T) {
+	c := NewClient("mlab-sandbox", &fakeSigner{}, &fakeLocatorV2{}, clientgeo.NewAppEngineLocator(), prom.NewAPI(nil), nil, nil, nil, nil, nil)
+	req := httptest.NewRequest(http.MethodOptions, "/v3/nearest", nil)
+	rw := httptest.NewRecorder()
+	c.NearestV3(rw, req)
+	if rw.Code != http.StatusNoContent || rw.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Errorf("NearestV3() preflight = %d %v, want 204 with allowed methods", rw.Code, rw.Header())
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/static"
+)
+
+// OrgVerifier verifies organization tokens.
+type OrgVerifier interface {
+	Verify(token string, exp jwt.Expected) (*jwt.Claims, error)
+}
+
+type orgKey struct{}
+
+// OrgScope returns a middleware that verifies the organization JWT carried
+// in the Authorization header ("Bearer <token>"), i.e. with the org issuer,
+// the locate audience and the organization as subject. Requests with a valid
+// token are annotated with the organization, which scopes the results of
+// Registrations to the organization's machines. Requests without a token are
+// passed through unchanged, and requests with an invalid token are rejected.
+func OrgScope(verifier OrgVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				next.ServeHTTP(rw, req)
+				return
+			}
+			cl, err := verifier.Verify(token, jwt.Expected{
+				Issuer:   static.IssuerOrg,
+				Audience: jwt.Audience{static.AudienceLocate},
+				Time:     time.Now(),
+			})
+			if err != nil || cl.Subject == "" {
+				log.Infof("Rejected organization token: %v", err)
+				v2Error := v2.NewError(v2.ErrorTypeUnauthorized, "Invalid organization token", http.StatusUnauthorized)
+				rw.Header().Set("Content-Type", "application/json")
+				writeResult(rw, v2Error.Status, v2Error)
+				return
+			}
+			ctx := context.WithValue(req.Context(), orgKey{}, cl.Subject)
+			next.ServeHTTP(rw, req.WithContext(ctx))
+		})
+	}
+}
+
+// orgFromContext returns the organization set by OrgScope, if any.
+func orgFromContext(ctx context.Context) (string, bool) {
+	org, ok := ctx.Value(orgKey{}).(string)
+	return org, ok
+}
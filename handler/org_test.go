@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/heartbeat/heartbeattest"
+	"github.com/m-lab/locate/static"
+)
+
+type fakeOrgVerifier struct {
+	claims *jwt.Claims
+	err    error
+	exp    jwt.Expected
+}
+
+func (f *fakeOrgVerifier) Verify(token string, exp jwt.Expected) (*jwt.Claims, error) {
+	f.exp = exp
+	return f.claims, f.err
+}
+
+func TestOrgScope(t *testing.T) {
+	instances := map[string]v2.HeartbeatMessage{
+		"ndt-oma7777-217f832a.mlab.sandbox.measurement-lab.org":    {},
+		"ndt-dfw8888-73a354f1.testorg.sandbox.measurement-lab.org": {},
+	}
+	tests := []struct {
+		name       string
+		target     string
+		auth       string
+		claims     *jwt.Claims
+		err        error
+		wantStatus int
+		wantKeys   []string
+	}{
+		{
+			name:       "success-no-token",
+			target:     "/v2/siteinfo/registrations",
+			wantStatus: http.StatusOK,
+			wantKeys: []string{
+				"ndt-dfw8888-73a354f1.testorg.sandbox.measurement-lab.org",
+				"ndt-oma7777-217f832a.mlab.sandbox.measurement-lab.org",
+			},
+		},
+		{
+			name:       "success-org-token",
+			target:     "/v2/siteinfo/registrations",
+			auth:       "Bearer token",
+			claims:     &jwt.Claims{Subject: "testorg"},
+			wantStatus: http.StatusOK,
+			wantKeys: []string{
+				"ndt-dfw8888-73a354f1.testorg.sandbox.measurement-lab.org",
+			},
+		},
+		{
+			name:       "success-org-token-overrides-org-param",
+			target:     "/v2/siteinfo/registrations?org=mlab",
+			auth:       "Bearer token",
+			claims:     &jwt.Claims{Subject: "testorg"},
+			wantStatus: http.StatusOK,
+			wantKeys: []string{
+				"ndt-dfw8888-73a354f1.testorg.sandbox.measurement-lab.org",
+			},
+		},
+		{
+			name:       "error-invalid-token",
+			target:     "/v2/siteinfo/registrations",
+			auth:       "Bearer token",
+			err:        errors.New("fake verify error"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "error-no-subject",
+			target:     "/v2/siteinfo/registrations",
+			auth:       "Bearer token",
+			claims:     &jwt.Claims{},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &heartbeattest.FakeStatusTracker{FakeInstances: instances}
+			c := NewClient("foo", &fakeSigner{}, &fakeLocatorV2{StatusTracker: tracker}, nil, nil, nil, nil, nil, nil, nil)
+			v := &fakeOrgVerifier{claims: tt.claims, err: tt.err}
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+
+			OrgScope(v)(http.HandlerFunc(c.Registrations)).ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantStatus {
+				t.Errorf("OrgScope() wrong status; got %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if tt.auth != "" && v.exp.Issuer != static.IssuerOrg {
+				t.Errorf("OrgScope() wrong issuer; got %q, want %q", v.exp.Issuer, static.IssuerOrg)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			result := map[string]v2.HeartbeatMessage{}
+			if err := json.Unmarshal(rw.Body.Bytes(), &result); err != nil {
+				t.Fatalf("OrgScope() returned invalid JSON: %v", err)
+			}
+			var keys []string
+			for k := range result {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("OrgScope() = %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
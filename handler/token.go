@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/square/go-jose.v2/jwt"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/apikey"
+	"github.com/m-lab/locate/metrics"
+	"github.com/m-lab/locate/static"
+)
+
+// Token issues an access token for the High Availability Pool to clients with
+// a valid API key, e.g. for /v2/token/ndt/ndt7. The token is returned with
+// the priority request URL for the service, which carries the token instead
+// of the key (see openapi.yaml). The request must be verified by the apikey
+// middleware.
+func (c *Client) Token(rw http.ResponseWriter, req *http.Request) {
+	result := v2.TokenResult{}
+	setHeaders(rw)
+
+	integration, ok := apikey.FromContext(req.Context())
+	if !ok {
+		result.Error = v2.NewError(v2.ErrorTypeUnauthorized, "Must provide a valid API key", http.StatusUnauthorized)
+		writeResult(rw, result.Error.Status, &result)
+		metrics.RequestsTotal.WithLabelValues("token", metrics.ConditionAuth, http.StatusText(result.Error.Status)).Inc()
+		return
+	}
+	_, service := getExperimentAndService(req.URL.Path)
+	if _, ok := static.Configs[service]; !ok {
+		result.Error = v2.NewError(v2.ErrorTypeUnknownService, "Unknown service: "+service, http.StatusBadRequest)
+		writeResult(rw, result.Error.Status, &result)
+		metrics.RequestsTotal.WithLabelValues("token", metrics.ConditionBadRequest, http.StatusText(result.Error.Status)).Inc()
+		return
+	}
+
+	now := time.Now()
+	cl := jwt.Claims{
+		Issuer:    static.IssuerLocate,
+		Subject:   integration.Subject(),
+		Audience:  jwt.Audience{static.AudienceLocate},
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(static.PriorityTokenExpiry)),
+		ID:        uuid.NewString(),
+	}
+	token, err := c.Sign(cl)
+	if err != nil {
+		log.Errorf("Failed to sign access token: %v", err)
+		result.Error = v2.NewError(v2.ErrorTypeInternal, "Failed to create access token", http.StatusInternalServerError)
+		writeResult(rw, result.Error.Status, &result)
+		metrics.RequestsTotal.WithLabelValues("token", metrics.ConditionInternal, http.StatusText(result.Error.Status)).Inc()
+		return
+	}
+
+	next := url.URL{
+		Scheme:   "https",
+		Host:     req.Host,
+		Path:     path.Join("/v2/priority/nearest", service),
+		RawQuery: url.Values{"access_token": {token}}.Encode(),
+	}
+	result.AccessToken = token
+	result.NextRequest = &v2.NextRequest{
+		NotBefore: cl.NotBefore.Time(),
+		Expires:   cl.Expiry.Time(),
+		URL:       next.String(),
+	}
+	writeResult(rw, http.StatusOK, &result)
+	metrics.RequestsTotal.WithLabelValues("token", metrics.ConditionSuccess, http.StatusText(http.StatusOK)).Inc()
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/apikey"
+	"github.com/m-lab/locate/static"
+)
+
+func TestClient_Token(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		integration *apikey.Integration
+		signErr     error
+		wantStatus  int
+		wantURL     string
+	}{
+		{
+			name:        "success",
+			path:        "/v2/token/ndt/ndt7",
+			integration: &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			wantStatus:  http.StatusOK,
+			wantURL:     "https://locate.measurementlab.net/v2/priority/nearest/ndt/ndt7?access_token=",
+		},
+		{
+			name:       "error-no-api-key",
+			path:       "/v2/token/ndt/ndt7",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:        "error-unknown-service",
+			path:        "/v2/token/foo/bar",
+			integration: &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "error-sign",
+			path:        "/v2/token/ndt/ndt7",
+			integration: &apikey.Integration{ID: "partner", KeyID: "ki_1"},
+			signErr:     errors.New("fake sign error"),
+			wantStatus:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("foo", &fakeSigner{err: tt.signErr}, &fakeLocatorV2{}, nil, nil, nil, nil, nil, nil, nil)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "https://locate.measurementlab.net"+tt.path, nil)
+			if tt.integration != nil {
+				req = req.WithContext(apikey.NewContext(req.Context(), tt.integration))
+			}
+
+			c.Token(rw, req)
+
+			if rw.Code != tt.wantStatus {
+				t.Fatalf("Token() wrong status; got %d, want %d", rw.Code, tt.wantStatus)
+			}
+			result := &v2.TokenResult{}
+			if err := json.Unmarshal(rw.Body.Bytes(), result); err != nil {
+				t.Fatalf("Token() returned invalid JSON: %v", err)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if result.Error == nil {
+					t.Errorf("Token() did not return an error")
+				}
+				return
+			}
+			// The fake signer token contains the audience, subject and issuer.
+			wantToken := static.AudienceLocate + "--partner/ki_1--" + static.IssuerLocate + "--"
+			if !strings.HasPrefix(result.AccessToken, wantToken) {
+				t.Errorf("Token() access token = %q, want prefix %q", result.AccessToken, wantToken)
+			}
+			if !strings.HasPrefix(result.NextRequest.URL, tt.wantURL) {
+				t.Errorf("Token() URL = %q, want prefix %q", result.NextRequest.URL, tt.wantURL)
+			}
+			if strings.Contains(result.NextRequest.URL, "key=") {
+				t.Errorf("Token() URL = %q, must not include the API key", result.NextRequest.URL)
+			}
+			if got := result.NextRequest.Expires.Sub(result.NextRequest.NotBefore); got != static.PriorityTokenExpiry {
+				t.Errorf("Token() token valid for %v, want %v", got, static.PriorityTokenExpiry)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"reflect"
 	"sync"
 	"time"
 
@@ -23,6 +24,8 @@ var (
 type heartbeatStatusTracker struct {
 	MemorystoreClient[v2.HeartbeatMessage]
 	instances  map[string]v2.HeartbeatMessage
+	healthy    map[string]int  // Healthy instance count per service.
+	sites      map[string]bool // Sites reported by the site health metric.
 	mu         sync.RWMutex
 	stop       chan bool
 	lastUpdate time.Time
@@ -78,8 +81,12 @@ func (h *heartbeatStatusTracker) RegisterInstance(rm v2.Registration) error {
 }
 
 // UpdateHealth updates the v2.Health field for the instance in the Memorystore client and
-// updates it locally.
+// updates it locally. Samples older than the current health of the instance, e.g.
+// replayed after a reconnect, are ignored.
 func (h *heartbeatStatusTracker) UpdateHealth(hostname string, hm v2.Health) error {
+	if h.isStale(hostname, hm) {
+		return nil
+	}
 	opts := &memorystore.PutOptions{FieldMustExist: "Registration", WithExpire: true}
 	if err := h.Put(hostname, "Health", &hm, opts); err != nil {
 		return fmt.Errorf("%w: failed to write Health message to Memorystore", err)
@@ -90,8 +97,16 @@ func (h *heartbeatStatusTracker) UpdateHealth(hostname string, hm v2.Health) err
 // UpdatePrometheus updates the v2.Prometheus field for the instances.
 func (h *heartbeatStatusTracker) UpdatePrometheus(hostnames, machines map[string]bool) error {
 	var err error
+	t := time.Now()
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	defer func() {
+		status := "OK"
+		if err != nil {
+			status = "error"
+		}
+		metrics.LocateMemorystoreRequestDuration.WithLabelValues("put batch", "Prometheus", status).Observe(time.Since(t).Seconds())
+	}()
 
 	for _, instance := range h.instances {
 		pm := constructPrometheusMessage(instance, hostnames, machines)
@@ -122,6 +137,14 @@ func (h *heartbeatStatusTracker) Instances() map[string]v2.HeartbeatMessage {
 	return c
 }
 
+// HealthyCount returns the number of healthy instances serving the given
+// service (e.g., ndt/ndt7) as of the last Memorystore import.
+func (h *heartbeatStatusTracker) HealthyCount(service string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.healthy[service]
+}
+
 // Ready reports whether the import to Memorystore has complete successfully
 // within 2x the export period.
 func (h *heartbeatStatusTracker) Ready() bool {
@@ -151,6 +174,19 @@ func (h *heartbeatStatusTracker) registerInstance(hostname string, rm v2.Registr
 	h.instances[hostname] = v2.HeartbeatMessage{Registration: &rm}
 }
 
+// isStale reports whether the health sample was taken before the current health
+// of the instance. Samples without a timestamp are never stale.
+func (h *heartbeatStatusTracker) isStale(hostname string, hm v2.Health) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	instance, found := h.instances[hostname]
+	if !found || instance.Health == nil || hm.Timestamp == 0 {
+		return false
+	}
+	return hm.Timestamp < instance.Health.Timestamp
+}
+
 func (h *heartbeatStatusTracker) updateHealth(hostname string, hm v2.Health) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -193,26 +229,92 @@ func (h *heartbeatStatusTracker) importMemorystore() {
 	metrics.ImportMemorystoreTotal.WithLabelValues("OK").Inc()
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	recordChanges(h.instances, values)
 	h.instances = values
 	h.lastUpdate = time.Now()
 	h.updateMetrics()
 }
 
+// recordChanges counts the instances added, removed, or with a changed
+// registration between the previous and next instances.
+func recordChanges(prev, next map[string]v2.HeartbeatMessage) {
+	var added, removed, changed float64
+	for k, v := range next {
+		p, ok := prev[k]
+		switch {
+		case !ok:
+			added++
+		case !reflect.DeepEqual(p.Registration, v.Registration):
+			changed++
+		}
+	}
+	for k := range prev {
+		if _, ok := next[k]; !ok {
+			removed++
+		}
+	}
+	metrics.ImportMemorystoreChangesTotal.WithLabelValues("added").Add(added)
+	metrics.ImportMemorystoreChangesTotal.WithLabelValues("removed").Add(removed)
+	metrics.ImportMemorystoreChangesTotal.WithLabelValues("changed").Add(changed)
+}
+
 // updateMetrics updates a Prometheus Gauge with the number of healthy instances per
-// experiment.
+// experiment, and the count of healthy instances per service.
 // Note that if an experiment is deleted (i.e., there are no more experiment instances),
 // the metric will still report the last known count.
+// It also updates the number of healthy instances per site and the probability
+// of each site. Sites without instances report zero healthy instances, so that
+// outages remain visible, and their last known probability.
 func (h *heartbeatStatusTracker) updateMetrics() {
 	healthy := make(map[string]float64)
+	services := make(map[string]int)
+	sites := make(map[string]float64)
+	probabilities := make(map[string]float64)
+	for site := range h.sites {
+		sites[site] = 0
+	}
 	for _, instance := range h.instances {
+		if r := instance.Registration; r != nil {
+			if _, ok := sites[r.Site]; !ok {
+				sites[r.Site] = 0
+			}
+			// All machines of a site should report the same probability.
+			if p, ok := probabilities[r.Site]; !ok || r.Probability > p {
+				probabilities[r.Site] = r.Probability
+			}
+		}
 		if isHealthy(instance) {
+			sites[instance.Registration.Site]++
 			healthy[instance.Registration.Experiment]++
+			for service := range instance.Registration.Services {
+				// A healthy machine may still report the service itself as unhealthy.
+				if instance.Health.ServiceScore(service) > 0 {
+					services[service]++
+				}
+			}
 		}
 	}
+	h.healthy = services
 
 	for experiment, count := range healthy {
 		metrics.LocateHealthStatus.WithLabelValues(experiment).Set(count)
 	}
+
+	if h.sites == nil {
+		h.sites = make(map[string]bool)
+	}
+	for site, count := range sites {
+		if !h.sites[site] {
+			if len(h.sites) >= maxSiteLabels {
+				continue
+			}
+			h.sites[site] = true
+		}
+		metrics.LocateSiteHealthyInstances.WithLabelValues(site).Set(count)
+		if p, ok := probabilities[site]; ok {
+			metrics.LocateSiteProbability.WithLabelValues(site).Set(p)
+		}
+	}
 }
 
 // constructPrometheusMessage constructs a v2.Prometheus message for a specific instance
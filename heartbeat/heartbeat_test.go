@@ -124,6 +124,43 @@ func TestUpdateHealth_Success(t *testing.T) {
 	}
 }
 
+func TestUpdateHealth_Stale(t *testing.T) {
+	h := NewHeartbeatStatusTracker(fakeDC)
+	defer h.StopImport()
+
+	err := h.RegisterInstance(*testdata.FakeRegistration.Registration)
+	testingx.Must(t, err, "failed to register instance")
+
+	tests := []struct {
+		name string
+		hm   v2.Health
+		want v2.Health
+	}{
+		{
+			name: "newer",
+			hm:   v2.Health{Score: 1, Timestamp: 2000},
+			want: v2.Health{Score: 1, Timestamp: 2000},
+		},
+		{
+			name: "older",
+			hm:   v2.Health{Score: 0, Timestamp: 1000},
+			want: v2.Health{Score: 1, Timestamp: 2000},
+		},
+		{
+			name: "no-timestamp",
+			hm:   v2.Health{Score: 0.5},
+			want: v2.Health{Score: 0.5},
+		},
+	}
+	for _, tt := range tests {
+		err := h.UpdateHealth(testdata.FakeHostname, tt.hm)
+		testingx.Must(t, err, "failed to update health")
+		if got := *h.instances[testdata.FakeHostname].Health; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("UpdateHealth(%s) health = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
 func TestUpdatePrometheus_PutError(t *testing.T) {
 	h := heartbeatStatusTracker{
 		MemorystoreClient: fakeErrDC,
@@ -263,6 +300,7 @@ func TestUpdateMetrics(t *testing.T) {
 			}
 
 			metrics.LocateHealthStatus.Reset()
+			metrics.LocateSiteHealthyInstances.Reset()
 			h.updateMetrics()
 
 			metric := &prometheus.Metric{}
@@ -273,10 +311,72 @@ func TestUpdateMetrics(t *testing.T) {
 			if got != tt.want {
 				t.Errorf("updateMetrics() failed; got: %f want %f", got, tt.want)
 			}
+			if count := h.HealthyCount("ndt/ndt7"); count != int(tt.want) {
+				t.Errorf("HealthyCount() = %d, want %d", count, int(tt.want))
+			}
+			if got := siteHealthyInstances(testdata.FakeRegistration.Registration.Site); got != tt.want {
+				t.Errorf("updateMetrics() site healthy instances = %f, want %f", got, tt.want)
+			}
 		})
 	}
 }
 
+func TestUpdateMetrics_ServiceScores(t *testing.T) {
+	h := heartbeatStatusTracker{
+		instances: map[string]v2.HeartbeatMessage{
+			testdata.FakeHostname: {
+				Registration: testdata.FakeRegistration.Registration,
+				Health:       &v2.Health{Score: 1, Services: map[string]float64{"ndt/ndt7": 0}},
+			},
+		},
+	}
+	h.updateMetrics()
+	if count := h.HealthyCount("ndt/ndt7"); count != 0 {
+		t.Errorf("HealthyCount() = %d, want 0 for an unhealthy service", count)
+	}
+}
+
+func TestUpdateMetrics_Sites(t *testing.T) {
+	site := testdata.FakeRegistration.Registration.Site
+	reg := *testdata.FakeRegistration.Registration
+	reg.Probability = 0.3
+	h := heartbeatStatusTracker{
+		instances: map[string]v2.HeartbeatMessage{
+			testdata.FakeHostname: {
+				Registration: &reg,
+				Health:       testdata.FakeHealth.Health,
+			},
+		},
+	}
+	metrics.LocateSiteHealthyInstances.Reset()
+	metrics.LocateSiteProbability.Reset()
+	h.updateMetrics()
+	if got := siteHealthyInstances(site); got != 1 {
+		t.Errorf("updateMetrics() site healthy instances = %f, want 1", got)
+	}
+	metric := &prometheus.Metric{}
+	metrics.LocateSiteProbability.WithLabelValues(site).Write(metric)
+	if got := metric.GetGauge().GetValue(); got != 0.3 {
+		t.Errorf("updateMetrics() site probability = %f, want 0.3", got)
+	}
+
+	// Sites that are no longer registered keep reporting zero.
+	h.instances = map[string]v2.HeartbeatMessage{}
+	h.updateMetrics()
+	if got := siteHealthyInstances(site); got != 0 {
+		t.Errorf("updateMetrics() site healthy instances = %f, want 0", got)
+	}
+	if !h.sites[site] {
+		t.Errorf("updateMetrics() stopped reporting site %s", site)
+	}
+}
+
+func siteHealthyInstances(site string) float64 {
+	metric := &prometheus.Metric{}
+	metrics.LocateSiteHealthyInstances.WithLabelValues(site).Write(metric)
+	return metric.GetGauge().GetValue()
+}
+
 func TestGetPrometheusMessage(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -368,3 +468,33 @@ func TestGetPrometheusMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordChanges(t *testing.T) {
+	changed := *testdata.FakeRegistration.Registration
+	changed.Probability = 0.5
+	prev := map[string]v2.HeartbeatMessage{
+		"removed":   {Registration: testdata.FakeRegistration.Registration},
+		"changed":   {Registration: testdata.FakeRegistration.Registration},
+		"unchanged": {Registration: testdata.FakeRegistration.Registration},
+	}
+	next := map[string]v2.HeartbeatMessage{
+		"added":   {Registration: testdata.FakeRegistration.Registration},
+		"changed": {Registration: &changed},
+		// Health updates do not change the instance.
+		"unchanged": {
+			Registration: testdata.FakeRegistration.Registration,
+			Health:       testdata.FakeHealth.Health,
+		},
+	}
+
+	metrics.ImportMemorystoreChangesTotal.Reset()
+	recordChanges(prev, next)
+
+	for _, change := range []string{"added", "removed", "changed"} {
+		metric := &prometheus.Metric{}
+		metrics.ImportMemorystoreChangesTotal.WithLabelValues(change).Write(metric)
+		if got := metric.GetCounter().GetValue(); got != 1 {
+			t.Errorf("recordChanges() %s = %f, want 1", change, got)
+		}
+	}
+}
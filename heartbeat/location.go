@@ -6,6 +6,8 @@ import (
 	"net/url"
 	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/m-lab/go/host"
 	"github.com/m-lab/go/mathx"
@@ -19,6 +21,29 @@ var (
 	ErrNoAvailableServers = errors.New("no available M-Lab servers")
 )
 
+// Exponential distribution rates used to pick sites by distance rank.
+const (
+	// A rate of 6 yields index 0 around 95% of the time, index 1 a little less
+	// than 5% of the time, and higher indices infrequently.
+	defaultPickRate = 6
+	// A rate of 2 spreads requests across more of the nearest sites. It is
+	// used when the client location is too coarse to trust the closest site.
+	coarsePickRate = 2
+)
+
+// defaultTargets is the number of targets returned when the request does not
+// give a count.
+const defaultTargets = 4
+
+// maxSiteLabels bounds the number of distinct site labels of the
+// SiteSelectionsTotal and LocateSiteHealthyInstances metrics.
+const maxSiteLabels = 1000
+
+var (
+	selectionSitesMu sync.Mutex
+	selectionSites   = make(map[string]bool)
+)
+
 // Locator manages requests to "locate" mlab-ns servers.
 type Locator struct {
 	StatusTracker
@@ -32,6 +57,22 @@ type NearestOptions struct {
 	Country string   // Bias results to prefer machines in this country.
 	Org     string   // Limit results to only machines from this organization.
 	Strict  bool     // When used with Country, limit results to only machines in this country.
+	// AccuracyKm is the accuracy radius of the client location, or zero if
+	// unknown. Coarse locations (e.g. country centroids) widen the search and
+	// bias results towards sites in Country.
+	AccuracyKm float64
+	// Addresses includes the registered IPv4 and IPv6 addresses of the
+	// machines in the targets.
+	Addresses bool
+	// Count is the maximum number of targets, or zero for the default of 4
+	// targets.
+	Count int
+	// Exclude lists machines (e.g., mlab1-lga0t.measurement-lab.org) or
+	// sites (e.g., lga0t) never returned as targets.
+	Exclude []string
+	// AddressFamily limits results to only machines with a registered
+	// address of this family ("ipv4" or "ipv6"), when given.
+	AddressFamily string
 }
 
 // TargetInfo returns the set of `v2.Target` to run the measurement on with the
@@ -40,18 +81,26 @@ type TargetInfo struct {
 	Targets []v2.Target    // Targets to run a measurement on.
 	URLs    []url.URL      // Service URL templates.
 	Ranks   map[string]int // Map of machines to metro rankings.
+	// Distances maps machines to their distance from the client in km.
+	Distances map[string]float64
+	// Candidates is the number of sites the targets were picked from.
+	Candidates int
 }
 
-// machine associates a machine name with its v2.Health value.
+// machine associates a machine name with its v2.Health value and, if
+// requested, its addresses.
 type machine struct {
 	name   string
 	host   string
 	health v2.Health
+	ipv4   string
+	ipv6   string
 }
 
 // site groups v2.HeartbeatMessage instances based on v2.Registration.Site.
 type site struct {
 	distance     float64
+	km           float64 // Distance from the client, without bias.
 	rank         int
 	metroRank    int
 	registration v2.Registration
@@ -79,7 +128,7 @@ func NewServerLocator(tracker StatusTracker) *Locator {
 // an exponentially distributed function based on distance.
 func (l *Locator) Nearest(service string, lat, lon float64, opts *NearestOptions) (*TargetInfo, error) {
 	// Filter.
-	sites := filterSites(service, lat, lon, l.Instances(), opts)
+	sites := filterSites(service, lat, lon, withoutExcluded(l.Instances(), opts), opts)
 
 	// Sort.
 	sortSites(sites)
@@ -88,7 +137,16 @@ func (l *Locator) Nearest(service string, lat, lon float64, opts *NearestOptions
 	rank(sites)
 
 	// Pick.
-	result := pickTargets(service, sites)
+	rate := float64(defaultPickRate)
+	if isCoarse(opts) {
+		rate = coarsePickRate
+	}
+	var result *TargetInfo
+	if opts.Count > 0 {
+		result = pickCountTargets(service, sites, rate, opts.Count)
+	} else {
+		result = pickTargets(service, sites, rate)
+	}
 
 	if len(result.Targets) == 0 {
 		return nil, ErrNoAvailableServers
@@ -109,21 +167,35 @@ func filterSites(service string, lat, lon float64, instances map[string]v2.Heart
 		}
 
 		r := v.Registration
+		km := distance
+		if isCoarse(opts) {
+			// The client could be anywhere in a large area around lat/lon, so
+			// prefer sites in the client country over nominally closer ones.
+			distance = biasedDistance(opts.Country, r, distance)
+		}
 		s, ok := m[r.Site]
 		if !ok {
 			s = &site{
 				distance:     distance,
+				km:           km,
 				registration: *r,
 				machines:     make([]machine, 0),
 			}
 			s.registration.Hostname = ""
 			s.registration.Machine = ""
+			s.registration.IPv4 = ""
+			s.registration.IPv6 = ""
 			m[r.Site] = s
 		}
-		s.machines = append(s.machines, machine{
+		mach := machine{
 			name:   machineName.String(),
 			host:   machineName.StringWithService(),
-			health: *v.Health})
+			health: *v.Health}
+		if opts.Addresses {
+			mach.ipv4 = r.IPv4
+			mach.ipv6 = r.IPv6
+		}
+		s.machines = append(s.machines, mach)
 	}
 
 	sites := make([]site, 0)
@@ -136,6 +208,34 @@ func filterSites(service string, lat, lon float64, instances map[string]v2.Heart
 	return sites
 }
 
+// withoutExcluded returns the instances allowed by the Exclude and
+// AddressFamily options. Instances are returned unchanged when neither option
+// is given.
+func withoutExcluded(instances map[string]v2.HeartbeatMessage, opts *NearestOptions) map[string]v2.HeartbeatMessage {
+	if len(opts.Exclude) == 0 && opts.AddressFamily == "" {
+		return instances
+	}
+	allowed := make(map[string]v2.HeartbeatMessage, len(instances))
+	for k, v := range instances {
+		r := v.Registration
+		if r == nil {
+			continue
+		}
+		machineName, err := host.Parse(r.Hostname)
+		if err != nil {
+			continue
+		}
+		if contains(opts.Exclude, machineName.String()) || contains(opts.Exclude, r.Site) {
+			continue
+		}
+		if (opts.AddressFamily == "ipv4" && r.IPv4 == "") || (opts.AddressFamily == "ipv6" && r.IPv6 == "") {
+			continue
+		}
+		allowed[k] = v
+	}
+	return allowed
+}
+
 // isValidInstance returns whether a v2.HeartbeatMessage signals a valid
 // instance that can serve a request given its parameters.
 func isValidInstance(service string, lat, lon float64, v v2.HeartbeatMessage, opts *NearestOptions) (bool, host.Name, float64) {
@@ -150,6 +250,11 @@ func isValidInstance(service string, lat, lon float64, v v2.HeartbeatMessage, op
 		return false, host.Name{}, 0
 	}
 
+	// Machines are never selected during planned maintenance.
+	if r.InMaintenance(time.Now()) {
+		return false, host.Name{}, 0
+	}
+
 	if opts.Type != "" && opts.Type != r.Type {
 		return false, host.Name{}, 0
 	}
@@ -178,6 +283,11 @@ func isValidInstance(service string, lat, lon float64, v v2.HeartbeatMessage, op
 		return false, host.Name{}, 0
 	}
 
+	// A healthy machine may still report the service itself as unhealthy.
+	if v.Health.ServiceScore(service) == 0 {
+		return false, host.Name{}, 0
+	}
+
 	distance := mathx.GetHaversineDistance(lat, lon, r.Latitude, r.Longitude)
 	if distance > static.EarthHalfCircumferenceKm {
 		return false, host.Name{}, 0
@@ -235,22 +345,28 @@ func rank(sites []site) {
 }
 
 // pickTargets picks up to 4 sites using an exponentially distributed function based
-// on distance. For each site, it picks a machine at random and returns them
-// as []v2.Target.
+// on distance with the given rate. For each site, it picks a machine at random
+// and returns them as []v2.Target.
 // For any of the picked targets, it also returns the service URL templates as []url.URL.
-func pickTargets(service string, sites []site) *TargetInfo {
-	numTargets := mathx.Min(4, len(sites))
+func pickTargets(service string, sites []site, rate float64) *TargetInfo {
+	return pickCountTargets(service, sites, rate, defaultTargets)
+}
+
+// pickCountTargets is pickTargets for up to count sites.
+func pickCountTargets(service string, sites []site, rate float64, count int) *TargetInfo {
+	candidates := len(sites)
+	numTargets := mathx.Min(count, len(sites))
 	targets := make([]v2.Target, numTargets)
 	ranks := make(map[string]int)
+	distances := make(map[string]float64)
 	var urls []url.URL
 
 	for i := 0; i < numTargets; i++ {
-		// A rate of 6 yields index 0 around 95% of the time, index 1 a little less
-		// than 5% of the time, and higher indices infrequently.
-		index := mathx.GetExpDistributedInt(6) % len(sites)
+		index := mathx.GetExpDistributedInt(rate) % len(sites)
 		s := sites[index]
 		metrics.ServerDistanceRanking.WithLabelValues(strconv.Itoa(i)).Observe(float64(s.rank))
 		metrics.MetroDistanceRanking.WithLabelValues(strconv.Itoa(i)).Observe(float64(s.metroRank))
+		recordSelection(s.registration, i)
 		// TODO(cristinaleon): Once health values range between 0 and 1,
 		// pick based on health. For now, pick at random.
 		machineIndex := mathx.GetRandomInt(len(s.machines))
@@ -264,9 +380,15 @@ func pickTargets(service string, sites []site) *TargetInfo {
 				City:    r.City,
 				Country: r.CountryCode,
 			},
+			IPv4: machine.ipv4,
+			IPv6: machine.ipv6,
 			URLs: make(map[string]string),
 		}
+		if info, ok := r.ServiceInfo[service]; ok {
+			targets[i].ServiceInfo = &info
+		}
 		ranks[machine.name] = s.metroRank
+		distances[machine.name] = s.km
 
 		// Remove the selected site from the set of candidates for the next target selection.
 		sites = append(sites[:index], sites[index+1:]...)
@@ -277,10 +399,39 @@ func pickTargets(service string, sites []site) *TargetInfo {
 	}
 
 	return &TargetInfo{
-		Targets: targets,
-		URLs:    urls,
-		Ranks:   ranks,
+		Targets:    targets,
+		URLs:       urls,
+		Ranks:      ranks,
+		Distances:  distances,
+		Candidates: candidates,
+	}
+}
+
+// recordSelection counts the site and metro of the server returned at the
+// given index. Once maxSiteLabels distinct sites have been counted,
+// further sites are counted as "other".
+func recordSelection(r v2.Registration, index int) {
+	position := "other"
+	if index == 0 {
+		position = "first"
+	}
+	site, metro := r.Site, r.Metro
+	selectionSitesMu.Lock()
+	if !selectionSites[site] {
+		if len(selectionSites) < maxSiteLabels {
+			selectionSites[site] = true
+		} else {
+			site, metro = "other", "other"
+		}
 	}
+	selectionSitesMu.Unlock()
+	metrics.SiteSelectionsTotal.WithLabelValues(site, metro, position).Inc()
+}
+
+// isCoarse reports whether the client location is too imprecise to rank sites
+// by distance alone.
+func isCoarse(opts *NearestOptions) bool {
+	return opts.AccuracyKm >= static.CoarseAccuracyKm
 }
 
 func alwaysPick(opts *NearestOptions) bool {
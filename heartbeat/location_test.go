@@ -6,7 +6,9 @@ import (
 	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/m-lab/go/host"
 	v2 "github.com/m-lab/locate/api/v2"
@@ -153,6 +155,7 @@ var (
 	// Test sites.
 	virtualSite = site{
 		distance: 296.04366543852825,
+		km:       296.04366543852825,
 		registration: v2.Registration{
 			City:          "New York",
 			CountryCode:   "US",
@@ -183,6 +186,7 @@ var (
 	}
 	physicalSite = site{
 		distance: 3838.617961615054,
+		km:       3838.617961615054,
 		registration: v2.Registration{
 			City:          "Los Angeles",
 			CountryCode:   "US",
@@ -208,6 +212,7 @@ var (
 	}
 	autonodeSite = site{
 		distance: 1701.749354381346,
+		km:       1701.749354381346,
 		registration: v2.Registration{
 			City:          "Council Bluffs",
 			CountryCode:   "US",
@@ -233,6 +238,7 @@ var (
 	}
 	weheSite = site{
 		distance: 3710.7679340078703,
+		km:       3710.7679340078703,
 		registration: v2.Registration{
 			City:          "Portland",
 			CountryCode:   "US",
@@ -312,9 +318,11 @@ func TestNearest(t *testing.T) {
 			lon:     -75.3242,
 			opts:    &NearestOptions{Type: "", Country: "US"},
 			expected: &TargetInfo{
-				Targets: []v2.Target{virtualTarget, physicalTarget},
-				URLs:    NDT7Urls,
-				Ranks:   map[string]int{virtualTarget.Machine: 0, physicalTarget.Machine: 1},
+				Targets:    []v2.Target{virtualTarget, physicalTarget},
+				URLs:       NDT7Urls,
+				Ranks:      map[string]int{virtualTarget.Machine: 0, physicalTarget.Machine: 1},
+				Distances:  map[string]float64{virtualTarget.Machine: virtualSite.km, physicalTarget.Machine: physicalSite.km},
+				Candidates: 2,
 			},
 			wantErr: false,
 		},
@@ -325,9 +333,11 @@ func TestNearest(t *testing.T) {
 			lon:     -75.3242,
 			opts:    &NearestOptions{Type: "physical", Country: "US"},
 			expected: &TargetInfo{
-				Targets: []v2.Target{physicalTarget},
-				URLs:    NDT7Urls,
-				Ranks:   map[string]int{physicalTarget.Machine: 0},
+				Targets:    []v2.Target{physicalTarget},
+				URLs:       NDT7Urls,
+				Ranks:      map[string]int{physicalTarget.Machine: 0},
+				Distances:  map[string]float64{physicalTarget.Machine: physicalSite.km},
+				Candidates: 1,
 			},
 			wantErr: false,
 		},
@@ -338,9 +348,11 @@ func TestNearest(t *testing.T) {
 			lon:     -75.3242,
 			opts:    &NearestOptions{Type: "virtual", Country: "US"},
 			expected: &TargetInfo{
-				Targets: []v2.Target{virtualTarget},
-				URLs:    NDT7Urls,
-				Ranks:   map[string]int{virtualTarget.Machine: 0},
+				Targets:    []v2.Target{virtualTarget},
+				URLs:       NDT7Urls,
+				Ranks:      map[string]int{virtualTarget.Machine: 0},
+				Distances:  map[string]float64{virtualTarget.Machine: virtualSite.km},
+				Candidates: 1,
 			},
 			wantErr: false,
 		},
@@ -357,7 +369,9 @@ func TestNearest(t *testing.T) {
 					Host:   "4443",
 					Path:   "/v0/envelope/access",
 				}},
-				Ranks: map[string]int{weheTarget.Machine: 0},
+				Ranks:      map[string]int{weheTarget.Machine: 0},
+				Distances:  map[string]float64{weheTarget.Machine: weheSite.km},
+				Candidates: 1,
 			},
 			wantErr: false,
 		},
@@ -369,9 +383,11 @@ func TestNearest(t *testing.T) {
 			lon:     -75.3242,
 			opts:    &NearestOptions{Type: "", Country: "US", Sites: []string{"lga00", "lax00"}},
 			expected: &TargetInfo{
-				Targets: []v2.Target{virtualTarget, physicalTarget},
-				URLs:    NDT7Urls,
-				Ranks:   map[string]int{virtualTarget.Machine: 0, physicalTarget.Machine: 1},
+				Targets:    []v2.Target{virtualTarget, physicalTarget},
+				URLs:       NDT7Urls,
+				Ranks:      map[string]int{virtualTarget.Machine: 0, physicalTarget.Machine: 1},
+				Distances:  map[string]float64{virtualTarget.Machine: virtualSite.km, physicalTarget.Machine: physicalSite.km},
+				Candidates: 2,
 			},
 			wantErr: false,
 		},
@@ -391,9 +407,11 @@ func TestNearest(t *testing.T) {
 			lon:     -75.3242,
 			opts:    &NearestOptions{Type: "", Country: "IT"},
 			expected: &TargetInfo{
-				Targets: []v2.Target{virtualTarget, physicalTarget},
-				URLs:    NDT7Urls,
-				Ranks:   map[string]int{virtualTarget.Machine: 0, physicalTarget.Machine: 1},
+				Targets:    []v2.Target{virtualTarget, physicalTarget},
+				URLs:       NDT7Urls,
+				Ranks:      map[string]int{virtualTarget.Machine: 0, physicalTarget.Machine: 1},
+				Distances:  map[string]float64{virtualTarget.Machine: virtualSite.km, physicalTarget.Machine: physicalSite.km},
+				Candidates: 2,
 			},
 			wantErr: false,
 		},
@@ -565,6 +583,217 @@ func TestFilterSites(t *testing.T) {
 	}
 }
 
+func TestFilterSites_Coarse(t *testing.T) {
+	instances := map[string]v2.HeartbeatMessage{"physical": physicalInstance}
+	tests := []struct {
+		name       string
+		country    string
+		accuracyKm float64
+		wantFactor float64
+	}{
+		{
+			name:       "precise-other-country",
+			country:    "IT",
+			wantFactor: 1,
+		},
+		{
+			name:       "coarse-same-country",
+			country:    "US",
+			accuracyKm: 1000,
+			wantFactor: 1,
+		},
+		{
+			name:       "coarse-other-country",
+			country:    "IT",
+			accuracyKm: 1000,
+			wantFactor: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := filterSites("ndt/ndt7", 43.1988, -75.3242, instances, &NearestOptions{})
+			opts := &NearestOptions{Country: tt.country, AccuracyKm: tt.accuracyKm}
+			got := filterSites("ndt/ndt7", 43.1988, -75.3242, instances, opts)
+			if len(got) != 1 || len(base) != 1 {
+				t.Fatalf("filterSites() wrong number of sites; got %d, want 1", len(got))
+			}
+			if got[0].distance != tt.wantFactor*base[0].distance {
+				t.Errorf("filterSites() distance = %f, want %f", got[0].distance, tt.wantFactor*base[0].distance)
+			}
+		})
+	}
+}
+
+func TestFilterSites_Addresses(t *testing.T) {
+	r := *physicalInstance.Registration
+	r.IPv4 = "192.0.2.1"
+	r.IPv6 = "2001:db8::1"
+	instances := map[string]v2.HeartbeatMessage{
+		"physical": {Registration: &r, Health: physicalInstance.Health},
+	}
+	tests := []struct {
+		name      string
+		addresses bool
+		wantIPv4  string
+		wantIPv6  string
+	}{
+		{
+			name: "without-addresses",
+		},
+		{
+			name:      "with-addresses",
+			addresses: true,
+			wantIPv4:  "192.0.2.1",
+			wantIPv6:  "2001:db8::1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &NearestOptions{Addresses: tt.addresses}
+			got := filterSites("ndt/ndt7", 43.1988, -75.3242, instances, opts)
+			if len(got) != 1 || len(got[0].machines) != 1 {
+				t.Fatalf("filterSites() wrong number of sites; got %d, want 1", len(got))
+			}
+			m := got[0].machines[0]
+			if m.ipv4 != tt.wantIPv4 || m.ipv6 != tt.wantIPv6 {
+				t.Errorf("filterSites() addresses = %q, %q, want %q, %q", m.ipv4, m.ipv6, tt.wantIPv4, tt.wantIPv6)
+			}
+			if got[0].registration.IPv4 != "" || got[0].registration.IPv6 != "" {
+				t.Errorf("filterSites() site registration has addresses: %+v", got[0].registration)
+			}
+		})
+	}
+}
+
+func TestWithoutExcluded(t *testing.T) {
+	ipv4Only := *physicalInstance.Registration
+	ipv4Only.IPv4 = "192.0.2.1"
+	instances := map[string]v2.HeartbeatMessage{
+		ipv4Only.Hostname: {Registration: &ipv4Only, Health: physicalInstance.Health},
+	}
+
+	tests := []struct {
+		name string
+		opts *NearestOptions
+		want bool
+	}{
+		{
+			name: "no-options",
+			opts: &NearestOptions{},
+			want: true,
+		},
+		{
+			name: "exclude-machine",
+			opts: &NearestOptions{Exclude: []string{"mlab1-lax00.mlab-sandbox.measurement-lab.org"}},
+			want: false,
+		},
+		{
+			name: "exclude-site",
+			opts: &NearestOptions{Exclude: []string{"lax00"}},
+			want: false,
+		},
+		{
+			name: "exclude-other-site",
+			opts: &NearestOptions{Exclude: []string{"lga00"}},
+			want: true,
+		},
+		{
+			name: "ipv4",
+			opts: &NearestOptions{AddressFamily: "ipv4"},
+			want: true,
+		},
+		{
+			name: "ipv6-missing",
+			opts: &NearestOptions{AddressFamily: "ipv6"},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withoutExcluded(instances, tt.opts)
+			if _, ok := got[ipv4Only.Hostname]; ok != tt.want {
+				t.Errorf("withoutExcluded() kept instance = %t, want %t", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidInstance_Maintenance(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		windows []v2.MaintenanceWindow
+		want    bool
+	}{
+		{
+			name: "no-windows",
+			want: true,
+		},
+		{
+			name:    "in-window",
+			windows: []v2.MaintenanceWindow{{Start: now.Add(-time.Hour), End: now.Add(time.Hour)}},
+			want:    false,
+		},
+		{
+			name: "past-and-future-windows",
+			windows: []v2.MaintenanceWindow{
+				{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)},
+				{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := *physicalInstance.Registration
+			r.Maintenance = tt.windows
+			v := v2.HeartbeatMessage{Registration: &r, Health: physicalInstance.Health}
+			got, _, _ := isValidInstance("ndt/ndt7", 43.1988, -75.3242, v, &NearestOptions{})
+			if got != tt.want {
+				t.Errorf("isValidInstance() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidInstance_ServiceHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		services map[string]float64
+		want     bool
+	}{
+		{
+			name: "machine-score",
+			want: true,
+		},
+		{
+			name:     "service-healthy",
+			services: map[string]float64{"ndt/ndt7": 0.5},
+			want:     true,
+		},
+		{
+			name:     "service-unhealthy",
+			services: map[string]float64{"ndt/ndt7": 0},
+			want:     false,
+		},
+		{
+			name:     "other-service-unhealthy",
+			services: map[string]float64{"msak/throughput1": 0},
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := v2.Health{Score: 1, Services: tt.services}
+			v := v2.HeartbeatMessage{Registration: physicalInstance.Registration, Health: &h}
+			got, _, _ := isValidInstance("ndt/ndt7", 43.1988, -75.3242, v, &NearestOptions{})
+			if got != tt.want {
+				t.Errorf("isValidInstance() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestIsValidInstance(t *testing.T) {
 	validHost := "ndt-mlab1-lga00.mlab-sandbox.measurement-lab.org"
 	validLat := 40.7667
@@ -825,6 +1054,7 @@ func TestPickTargets(t *testing.T) {
 	// Sites numbered by distance, which makes it easier to understand expected values.
 	site1 := site{
 		distance: 10,
+		km:       10,
 		registration: v2.Registration{
 			City:        "New York",
 			CountryCode: "US",
@@ -841,6 +1071,7 @@ func TestPickTargets(t *testing.T) {
 	}
 	site2 := site{
 		distance: 10,
+		km:       10,
 		registration: v2.Registration{
 			City:        "New York",
 			CountryCode: "US",
@@ -857,6 +1088,7 @@ func TestPickTargets(t *testing.T) {
 	}
 	site3 := site{
 		distance: 100,
+		km:       100,
 		registration: v2.Registration{
 			City:        "Los Angeles",
 			CountryCode: "US",
@@ -870,6 +1102,7 @@ func TestPickTargets(t *testing.T) {
 	}
 	site4 := site{
 		distance: 110,
+		km:       110,
 		registration: v2.Registration{
 			City:        "Portland",
 			CountryCode: "US",
@@ -882,6 +1115,10 @@ func TestPickTargets(t *testing.T) {
 		},
 	}
 
+	ndt7Info := v2.ServiceInfo{Versions: []string{"v1"}, Capabilities: []string{"bbr"}}
+	site1WithInfo := site1
+	site1WithInfo.registration.ServiceInfo = map[string]v2.ServiceInfo{"ndt/ndt7": ndt7Info}
+
 	tests := []struct {
 		name     string
 		sites    []site
@@ -938,6 +1175,13 @@ func TestPickTargets(t *testing.T) {
 					"mlab2-site2-metro0": 0,
 					"mlab3-site1-metro0": 0,
 				},
+				Distances: map[string]float64{
+					"mlab1-site3-metro1": site3.km,
+					"mlab1-site4-metro2": site4.km,
+					"mlab2-site2-metro0": site2.km,
+					"mlab3-site1-metro0": site1.km,
+				},
+				Candidates: 4,
 			},
 		},
 		{
@@ -957,8 +1201,34 @@ func TestPickTargets(t *testing.T) {
 						URLs: make(map[string]string),
 					},
 				},
-				URLs:  NDT7Urls,
-				Ranks: map[string]int{"mlab2-site1-metro0": 0},
+				URLs:       NDT7Urls,
+				Ranks:      map[string]int{"mlab2-site1-metro0": 0},
+				Distances:  map[string]float64{"mlab2-site1-metro0": site1.km},
+				Candidates: 1,
+			},
+		},
+		{
+			name: "1-site-with-service-info",
+			sites: []site{
+				site1WithInfo,
+			},
+			expected: &TargetInfo{
+				Targets: []v2.Target{
+					{
+						Machine:  "mlab2-site1-metro0",
+						Hostname: "ndt-mlab2-site1-metro0",
+						Location: &v2.Location{
+							City:    site1.registration.City,
+							Country: site1.registration.CountryCode,
+						},
+						ServiceInfo: &ndt7Info,
+						URLs:        make(map[string]string),
+					},
+				},
+				URLs:       NDT7Urls,
+				Ranks:      map[string]int{"mlab2-site1-metro0": 0},
+				Distances:  map[string]float64{"mlab2-site1-metro0": site1.km},
+				Candidates: 1,
 			},
 		},
 	}
@@ -967,7 +1237,7 @@ func TestPickTargets(t *testing.T) {
 			// Use a fixed seed so the pattern is only pseudorandom and can
 			// be verififed against expectations.
 			rand.Seed(1658340109320624212)
-			got := pickTargets("ndt/ndt7", tt.sites)
+			got := pickTargets("ndt/ndt7", tt.sites, defaultPickRate)
 
 			if !reflect.DeepEqual(got, tt.expected) {
 				t.Errorf("pickTargets() got: %+v, want: %+v", got, tt.expected)
@@ -1111,3 +1381,32 @@ func TestBiasedDistance(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordSelection(t *testing.T) {
+	selectionSitesMu.Lock()
+	saved := selectionSites
+	selectionSites = make(map[string]bool)
+	for i := 0; i < maxSiteLabels-1; i++ {
+		selectionSites[strconv.Itoa(i)] = true
+	}
+	selectionSitesMu.Unlock()
+	defer func() {
+		selectionSitesMu.Lock()
+		selectionSites = saved
+		selectionSitesMu.Unlock()
+	}()
+
+	recordSelection(v2.Registration{Site: "lga01", Metro: "lga"}, 0)
+	recordSelection(v2.Registration{Site: "lga01", Metro: "lga"}, 1)
+	recordSelection(v2.Registration{Site: "dfw02", Metro: "dfw"}, 0)
+
+	if !selectionSites["lga01"] {
+		t.Errorf("recordSelection() did not add site below the bound")
+	}
+	if selectionSites["dfw02"] {
+		t.Errorf("recordSelection() added site above the bound")
+	}
+	if len(selectionSites) != maxSiteLabels {
+		t.Errorf("recordSelection() sites = %d, want %d", len(selectionSites), maxSiteLabels)
+	}
+}
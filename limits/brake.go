@@ -0,0 +1,88 @@
+package limits
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/locate/metrics"
+)
+
+// Brake is an operator-controlled emergency brake for platform-wide capacity
+// incidents. While engaged, only API-key traffic should be served.
+//
+// The brake is engaged while its key exists in Redis, e.g.:
+//
+//	SET emergency-brake 1 EX 3600
+//	DEL emergency-brake
+//
+// When the key has an expiration, anonymous clients are asked to retry after
+// the time remaining. Otherwise, they are asked to retry after a default
+// interval.
+type Brake struct {
+	pool       *redis.Pool
+	key        string
+	retryAfter time.Duration
+	now        func() time.Time
+
+	mu      sync.RWMutex
+	engaged bool
+	until   time.Time // Zero if the key has no expiration.
+}
+
+// NewBrake returns a new Brake stored in the given Redis key. The brake is
+// released until Update is called.
+func NewBrake(pool *redis.Pool, key string, retryAfter time.Duration) *Brake {
+	return &Brake{
+		pool:       pool,
+		key:        key,
+		retryAfter: retryAfter,
+		now:        time.Now,
+	}
+}
+
+// Update reads the brake state from Redis. On error, the previous state
+// remains in use.
+func (b *Brake) Update() error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	// PTTL returns -2 if the key does not exist and -1 if it has no expiration.
+	ttl, err := redis.Int64(conn.Do("PTTL", b.key))
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.engaged = ttl != -2
+	b.until = time.Time{}
+	if ttl >= 0 {
+		b.until = b.now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+	if b.engaged {
+		metrics.EmergencyBrakeEngaged.Set(1)
+	} else {
+		metrics.EmergencyBrakeEngaged.Set(0)
+	}
+	return nil
+}
+
+// IsEngaged reports whether the brake is engaged and, if so, how long
+// anonymous clients should wait before retrying.
+func (b *Brake) IsEngaged() (bool, time.Duration) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.engaged {
+		return false, 0
+	}
+	if b.until.IsZero() {
+		return true, b.retryAfter
+	}
+	remaining := b.until.Sub(b.now())
+	if remaining <= 0 {
+		// The key has expired since the last update.
+		return false, 0
+	}
+	return true, remaining
+}
@@ -0,0 +1,79 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestBrake(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		reply          interface{}
+		err            error
+		elapsed        time.Duration
+		wantErr        bool
+		wantEngaged    bool
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:  "released",
+			reply: int64(-2),
+		},
+		{
+			name:           "engaged-no-expiration",
+			reply:          int64(-1),
+			wantEngaged:    true,
+			wantRetryAfter: 5 * time.Minute,
+		},
+		{
+			name:           "engaged-with-expiration",
+			reply:          int64(90000),
+			elapsed:        30 * time.Second,
+			wantEngaged:    true,
+			wantRetryAfter: time.Minute,
+		},
+		{
+			name:    "expired-since-update",
+			reply:   int64(1000),
+			elapsed: 2 * time.Second,
+		},
+		{
+			name:    "redis-error",
+			err:     errors.New("fake redis error"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := redigomock.NewConn()
+			pool := &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			cmd := conn.Command("PTTL", "emergency-brake")
+			if tt.err != nil {
+				cmd.ExpectError(tt.err)
+			} else {
+				cmd.Expect(tt.reply)
+			}
+
+			b := NewBrake(pool, "emergency-brake", 5*time.Minute)
+			b.now = func() time.Time { return now }
+			if err := b.Update(); (err != nil) != tt.wantErr {
+				t.Fatalf("Brake.Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			b.now = func() time.Time { return now.Add(tt.elapsed) }
+			engaged, retryAfter := b.IsEngaged()
+			if engaged != tt.wantEngaged || retryAfter != tt.wantRetryAfter {
+				t.Errorf("Brake.IsEngaged() = (%v, %v), want (%v, %v)",
+					engaged, retryAfter, tt.wantEngaged, tt.wantRetryAfter)
+			}
+		})
+	}
+}
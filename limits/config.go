@@ -1,17 +1,64 @@
 package limits
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"os"
+	"regexp"
+	"sync"
 	"time"
 
+	"github.com/m-lab/go/content"
 	"gopkg.in/yaml.v2"
 )
 
+// Agent match types supported by AgentConfig.
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+)
+
 // AgentConfig holds the limit configuration for a user agent.
 type AgentConfig struct {
 	Agent    string        `yaml:"agent"`
 	Schedule string        `yaml:"schedule"`
 	Duration time.Duration `yaml:"duration"`
+	// Match is how Agent is compared to the request User-Agent: "exact" (the
+	// default), "prefix" or "regex". Patterns allow one rule to limit a family
+	// of clients with versioned User-Agent strings.
+	Match string `yaml:"match"`
+}
+
+// key returns the Agents key for the agent limit.
+func (ac AgentConfig) key() string {
+	if ac.Match == "" || ac.Match == MatchExact {
+		return ac.Agent
+	}
+	return ac.Match + ":" + ac.Agent
+}
+
+// cron returns the Cron limit for the agent, or an error if the schedule or
+// pattern is invalid.
+func (ac AgentConfig) cron() (*Cron, error) {
+	c, err := parseCron(ac.Schedule, ac.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule for agent %q: %w", ac.Agent, err)
+	}
+	switch ac.Match {
+	case "", MatchExact:
+	case MatchPrefix:
+		c.pattern = regexp.MustCompile("^" + regexp.QuoteMeta(ac.Agent))
+	case MatchRegex:
+		c.pattern, err = regexp.Compile(ac.Agent)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for agent %q: %w", ac.Agent, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid match %q for agent %q", ac.Match, ac.Agent)
+	}
+	return c, nil
 }
 
 // Config holds the limit configuration for all user agents.
@@ -32,7 +79,161 @@ func ParseConfig(path string) (Agents, error) {
 
 	lmts := make(Agents)
 	for _, l := range *config {
-		lmts[l.Agent] = NewCron(l.Schedule, l.Duration)
+		c, cronErr := l.cron()
+		if cronErr != nil {
+			return nil, cronErr
+		}
+		lmts[l.key()] = c
 	}
 	return lmts, err
 }
+
+// Document holds the limiter configuration that may be reloaded while the
+// service is running.
+type Document struct {
+	Agents Config `yaml:"agents"`
+	// RateLimits replaces the RateLimiter limits when present. The IP, IP+UA
+	// and subnet limits omitted from the document keep the values the
+	// RateLimiter was created with; a limit is disabled with max_events: 0.
+	RateLimits *RateLimitConfig `yaml:"rate_limits"`
+	Exemptions ExemptionConfig  `yaml:"exemptions"`
+}
+
+// ParseDocument interprets a limiter configuration document. For
+// compatibility with ParseConfig, a plain list of agent limits is accepted.
+func ParseDocument(b []byte) (*Document, error) {
+	doc := &Document{}
+	if err := yaml.Unmarshal(b, &doc.Agents); err != nil {
+		doc = &Document{}
+		if err := yaml.UnmarshalStrict(b, doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// agents returns the set of agent limits, or an error if any schedule or
+// pattern is invalid.
+func (c Config) agents() (Agents, error) {
+	lmts := make(Agents)
+	for _, l := range c {
+		cron, err := l.cron()
+		if err != nil {
+			return nil, err
+		}
+		lmts[l.key()] = cron
+	}
+	return lmts, nil
+}
+
+// ConfigLoader loads agent limits and rate limits from a limiter
+// configuration Document, so that limits may change without a release.
+type ConfigLoader struct {
+	provider content.Provider
+	limiter  *RateLimiter
+
+	mu         sync.RWMutex
+	agents     *AgentMatcher
+	exemptions *Exemptions
+}
+
+// NewConfigLoader creates a new ConfigLoader and loads the Document from the
+// given provider. When the Document includes rate limits, they are applied to
+// limiter, which may be nil.
+func NewConfigLoader(ctx context.Context, provider content.Provider, limiter *RateLimiter) (*ConfigLoader, error) {
+	cl := &ConfigLoader{provider: provider, limiter: limiter}
+	if err := cl.load(ctx); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// Reload loads the Document again if it has changed. On error, the previous
+// limits remain in use.
+func (cl *ConfigLoader) Reload(ctx context.Context) {
+	if err := cl.load(ctx); err != nil {
+		log.Println("Could not reload limits config:", err)
+	}
+}
+
+// IsExempt returns the reason the client is exempt from limits, or an empty
+// string if it is not.
+func (cl *ConfigLoader) IsExempt(ip string) string {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.exemptions.IsExempt(ip)
+}
+
+// IsLimited returns whether the agent is limited at the input time.
+func (cl *ConfigLoader) IsLimited(agent string, t time.Time) bool {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.agents.IsLimited(agent, t)
+}
+
+func (cl *ConfigLoader) load(ctx context.Context) error {
+	b, err := cl.provider.Get(ctx)
+	if err == content.ErrNoChange {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	doc, err := ParseDocument(b)
+	if err != nil {
+		return err
+	}
+	if doc.RateLimits != nil {
+		if cl.limiter != nil {
+			if err := fillOmittedLimits(b, doc.RateLimits, cl.limiter.defaults); err != nil {
+				return err
+			}
+		}
+		if err := doc.RateLimits.Validate(); err != nil {
+			return err
+		}
+	}
+	agents, err := doc.Agents.agents()
+	if err != nil {
+		return err
+	}
+	exemptions, err := NewExemptions(doc.Exemptions)
+	if err != nil {
+		return err
+	}
+
+	cl.mu.Lock()
+	cl.agents = agents.Matcher()
+	cl.exemptions = exemptions
+	cl.mu.Unlock()
+	if doc.RateLimits != nil && cl.limiter != nil {
+		cl.limiter.SetLimits(*doc.RateLimits)
+	}
+	return nil
+}
+
+// fillOmittedLimits sets the IP, IP+UA and subnet limits that the document b
+// omits from its rate_limits to their values in base, so that omitting a
+// limit does not disable it.
+func fillOmittedLimits(b []byte, config *RateLimitConfig, base RateLimitConfig) error {
+	var present struct {
+		RateLimits struct {
+			IP     *LimitConfig `yaml:"ip"`
+			IPUA   *LimitConfig `yaml:"ipua"`
+			Subnet *LimitConfig `yaml:"subnet"`
+		} `yaml:"rate_limits"`
+	}
+	if err := yaml.Unmarshal(b, &present); err != nil {
+		return err
+	}
+	if present.RateLimits.IP == nil {
+		config.IP = base.IP
+	}
+	if present.RateLimits.IPUA == nil {
+		config.IPUA = base.IPUA
+	}
+	if present.RateLimits.Subnet == nil {
+		config.Subnet = base.Subnet
+	}
+	return nil
+}
@@ -1,9 +1,15 @@
 package limits
 
 import (
+	"context"
+	"errors"
+	"os"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/rtx"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -28,6 +34,12 @@ func TestParseConfig(t *testing.T) {
 			want:    nil,
 			wantErr: true,
 		},
+		{
+			name:    "invalid-pattern",
+			path:    "testdata/patterns-invalid.yaml",
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -42,3 +54,145 @@ func TestParseConfig(t *testing.T) {
 		})
 	}
 }
+
+type fakeProvider struct {
+	b   []byte
+	err error
+}
+
+func (f *fakeProvider) Get(ctx context.Context) ([]byte, error) {
+	return f.b, f.err
+}
+
+func TestParseDocument(t *testing.T) {
+	doc, err := os.ReadFile("testdata/document.yaml")
+	rtx.Must(err, "failed to read testdata")
+	list, err := os.ReadFile("testdata/config.yaml")
+	rtx.Must(err, "failed to read testdata")
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    *Document
+		wantErr bool
+	}{
+		{
+			name: "success-document",
+			b:    doc,
+			want: &Document{
+				Agents: Config{{Agent: "foo", Schedule: "* * * * *", Duration: time.Minute}},
+				RateLimits: &RateLimitConfig{
+					IP:   LimitConfig{Interval: time.Hour, MaxEvents: 200, Burst: 40},
+					IPUA: LimitConfig{Interval: time.Hour, MaxEvents: 40},
+					Services: map[string]RateLimitConfig{
+						"msak/throughput1": {IP: LimitConfig{Interval: time.Hour, MaxEvents: 20}},
+					},
+				},
+				Exemptions: ExemptionConfig{
+					CIDRs: []string{"192.0.2.0/24"},
+				},
+			},
+		},
+		{
+			name: "success-agent-list",
+			b:    list,
+			want: &Document{
+				Agents: Config{
+					{Agent: "foo", Schedule: "* * * * *", Duration: time.Minute},
+					{Agent: "bar", Schedule: "7,8 0,15,30,45 * * * * *", Duration: time.Minute},
+				},
+			},
+		},
+		{
+			name:    "error-unknown-field",
+			b:       []byte("agent-limits: []\n"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDocument(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDocument() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDocument() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigLoader(t *testing.T) {
+	ctx := context.Background()
+	doc, err := os.ReadFile("testdata/document.yaml")
+	rtx.Must(err, "failed to read testdata")
+	subnet := LimitConfig{Interval: time.Hour, MaxEvents: 2000, Burst: 400}
+	rl := NewRateLimiter(nil, RateLimitConfig{
+		IP:        LimitConfig{Interval: time.Minute, MaxEvents: 1},
+		Subnet:    subnet,
+		KeyPrefix: "fake:",
+	})
+
+	cl, err := NewConfigLoader(ctx, &fakeProvider{b: doc}, rl)
+	if err != nil {
+		t.Fatalf("NewConfigLoader() returned error: %v", err)
+	}
+	if !cl.IsLimited("foo", time.Now()) {
+		t.Errorf("ConfigLoader.IsLimited() = false, want true")
+	}
+	if got := cl.IsExempt("192.0.2.1"); got != ExemptCIDR {
+		t.Errorf("ConfigLoader.IsExempt() = %q, want %q", got, ExemptCIDR)
+	}
+	want := RateLimitConfig{
+		IP:   LimitConfig{Interval: time.Hour, MaxEvents: 200, Burst: 40},
+		IPUA: LimitConfig{Interval: time.Hour, MaxEvents: 40},
+		// The document omits the subnet limit, which keeps its initial value.
+		Subnet: subnet,
+		Services: map[string]RateLimitConfig{
+			"msak/throughput1": {IP: LimitConfig{Interval: time.Hour, MaxEvents: 20}},
+		},
+		KeyPrefix: "fake:",
+	}
+	if !reflect.DeepEqual(rl.config, want) {
+		t.Errorf("NewConfigLoader() rate limits = %v, want %v", rl.config, want)
+	}
+
+	// Failed reloads keep the previous limits.
+	for _, p := range []*fakeProvider{
+		{err: content.ErrNoChange},
+		{err: errors.New("fake error")},
+		{b: []byte("{")},
+		{b: []byte("- agent: foo\n  schedule: invalid\n")},
+		{b: []byte("exemptions:\n  cidrs: [invalid]\n")},
+		{b: []byte("rate_limits:\n  ip:\n    max_events: -1\n")},
+	} {
+		cl.provider = p
+		cl.Reload(ctx)
+		if !cl.IsLimited("foo", time.Now()) {
+			t.Errorf("ConfigLoader.Reload() changed limits on failure")
+		}
+	}
+
+	cl.provider = &fakeProvider{b: []byte("agents: []\n")}
+	cl.Reload(ctx)
+	if cl.IsLimited("foo", time.Now()) {
+		t.Errorf("ConfigLoader.Reload() did not replace agent limits")
+	}
+	if got := cl.IsExempt("192.0.2.1"); got != "" {
+		t.Errorf("ConfigLoader.Reload() did not replace exemptions; got %q", got)
+	}
+	if !reflect.DeepEqual(rl.config, want) {
+		t.Errorf("ConfigLoader.Reload() changed rate limits without rate_limits")
+	}
+
+	// Limits are only disabled explicitly.
+	cl.provider = &fakeProvider{b: []byte("rate_limits:\n  subnet:\n    max_events: 0\n")}
+	cl.Reload(ctx)
+	if rl.config.Subnet != (LimitConfig{}) || rl.config.IP != rl.defaults.IP {
+		t.Errorf("ConfigLoader.Reload() wrong rate limits; got %v", rl.config)
+	}
+
+	if _, err := NewConfigLoader(ctx, &fakeProvider{err: errors.New("fake error")}, nil); err == nil {
+		t.Errorf("NewConfigLoader() expected error")
+	}
+}
@@ -1,6 +1,7 @@
 package limits
 
 import (
+	"regexp"
 	"time"
 
 	"github.com/aptible/supercronic/cronexpr"
@@ -14,15 +15,77 @@ func NewCron(schedule string, duration time.Duration) *Cron {
 	}
 }
 
+// parseCron returns a new instance of Cron, or an error if the schedule is
+// invalid.
+func parseCron(schedule string, duration time.Duration) (*Cron, error) {
+	expr, err := cronexpr.Parse(schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &Cron{Expression: expr, duration: duration}, nil
+}
+
 // Cron infers time limits based on a cron schedule.
 type Cron struct {
 	*cronexpr.Expression
 	duration time.Duration
+	// pattern matches the user agents of a prefix or regex limit. It is nil
+	// for exact limits.
+	pattern *regexp.Regexp
 }
 
 // Agents holds the cron limits for a set of user agents.
 type Agents map[string]*Cron
 
+// IsLimited returns whether the agent is limited at the input time by an exact
+// limit or by any matching pattern limit. Agents without a limit are never
+// limited. Every limit is visited, so frequent callers should use Matcher.
+func (a Agents) IsLimited(agent string, t time.Time) bool {
+	if c, ok := a[agent]; ok && c.pattern == nil && c.IsLimited(t) {
+		return true
+	}
+	for _, c := range a {
+		if c.pattern != nil && c.pattern.MatchString(agent) && c.IsLimited(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matcher returns an AgentMatcher for the limits.
+func (a Agents) Matcher() *AgentMatcher {
+	m := &AgentMatcher{exact: make(map[string]*Cron)}
+	for agent, c := range a {
+		if c.pattern == nil {
+			m.exact[agent] = c
+		} else {
+			m.patterns = append(m.patterns, c)
+		}
+	}
+	return m
+}
+
+// AgentMatcher matches user agents against the limits of Agents. Exact limits
+// are looked up by agent, so only the pattern limits are evaluated in turn.
+type AgentMatcher struct {
+	exact    map[string]*Cron
+	patterns []*Cron
+}
+
+// IsLimited returns whether the agent is limited at the input time by an exact
+// limit or by any matching pattern limit.
+func (m *AgentMatcher) IsLimited(agent string, t time.Time) bool {
+	if c, ok := m.exact[agent]; ok && c.IsLimited(t) {
+		return true
+	}
+	for _, c := range m.patterns {
+		if c.pattern.MatchString(agent) && c.IsLimited(t) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsLimited returns whether the input time is within a time-limited
 // window [start, end).
 func (c *Cron) IsLimited(t time.Time) bool {
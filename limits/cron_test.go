@@ -51,3 +51,67 @@ func TestSchedule_IsLimited(t *testing.T) {
 		})
 	}
 }
+
+func TestAgents_IsLimited(t *testing.T) {
+	agents, err := ParseConfig("testdata/patterns.yaml")
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	now := time.Now().UTC()
+	tests := []struct {
+		name  string
+		agent string
+		want  bool
+	}{
+		{
+			name:  "exact",
+			agent: "foo",
+			want:  true,
+		},
+		{
+			name:  "exact-no-prefix-match",
+			agent: "foo/1.0",
+			want:  false,
+		},
+		{
+			name:  "prefix",
+			agent: "periodic-client/1.2.3",
+			want:  true,
+		},
+		{
+			name:  "prefix-not-at-start",
+			agent: "other periodic-client/1.2.3",
+			want:  false,
+		},
+		{
+			name:  "exact-unlimited-prefix",
+			agent: "periodic-client/2.0",
+			want:  true,
+		},
+		{
+			name:  "regex",
+			agent: "batch-42",
+			want:  true,
+		},
+		{
+			name:  "regex-no-match",
+			agent: "batch-x",
+			want:  false,
+		},
+		{
+			name:  "unknown",
+			agent: "bar",
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := agents.IsLimited(tt.agent, now); got != tt.want {
+				t.Errorf("Agents.IsLimited() = %v, want %v", got, tt.want)
+			}
+			if got := agents.Matcher().IsLimited(tt.agent, now); got != tt.want {
+				t.Errorf("AgentMatcher.IsLimited() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
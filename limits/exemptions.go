@@ -0,0 +1,49 @@
+package limits
+
+import (
+	"fmt"
+	"net"
+)
+
+// Exemption reasons returned by Exemptions.IsExempt.
+const (
+	ExemptCIDR = "cidr"
+)
+
+// ExemptionConfig lists the clients that are never rate limited, e.g.
+// monitoring services and trusted partners. Clients are identified by their
+// IP address only, since parameters like client_name are set by the client.
+type ExemptionConfig struct {
+	CIDRs []string `yaml:"cidrs"`
+}
+
+// Exemptions matches clients against an ExemptionConfig.
+type Exemptions struct {
+	networks []*net.IPNet
+}
+
+// NewExemptions returns new Exemptions, or an error if any CIDR is invalid.
+func NewExemptions(config ExemptionConfig) (*Exemptions, error) {
+	e := &Exemptions{}
+	for _, cidr := range config.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exemption cidr %q: %w", cidr, err)
+		}
+		e.networks = append(e.networks, network)
+	}
+	return e, nil
+}
+
+// IsExempt returns the reason the client IP is exempt, or an empty string if
+// it is not.
+func (e *Exemptions) IsExempt(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, n := range e.networks {
+			if n.Contains(parsed) {
+				return ExemptCIDR
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package limits
+
+import "testing"
+
+func TestExemptions_IsExempt(t *testing.T) {
+	e, err := NewExemptions(ExemptionConfig{
+		CIDRs: []string{"192.0.2.0/24", "2001:db8::/32"},
+	})
+	if err != nil {
+		t.Fatalf("NewExemptions() returned error: %v", err)
+	}
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{name: "ipv4-cidr", ip: "192.0.2.10", want: ExemptCIDR},
+		{name: "ipv6-cidr", ip: "2001:db8::1", want: ExemptCIDR},
+		{name: "not-exempt", ip: "198.51.100.1"},
+		{name: "invalid-ip", ip: "invalid-ip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.IsExempt(tt.ip); got != tt.want {
+				t.Errorf("Exemptions.IsExempt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewExemptions(ExemptionConfig{CIDRs: []string{"not-a-cidr"}}); err == nil {
+		t.Errorf("NewExemptions() expected error for invalid cidr")
+	}
+}
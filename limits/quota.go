@@ -0,0 +1,159 @@
+package limits
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// This is a Lua script that will be interpreted by the Redis server to
+	// atomically count one request against the per-minute and per-day quota
+	// windows of an API key.
+	//
+	// KEYS[1] and KEYS[2] are the minute and day counter keys. ARGV[1] and
+	// ARGV[2] are their expirations in seconds.
+	quotaScript = `local minute = redis.call('INCR', KEYS[1])
+		if minute == 1 then redis.call('EXPIRE', KEYS[1], ARGV[1]) end
+		local day = redis.call('INCR', KEYS[2])
+		if day == 1 then redis.call('EXPIRE', KEYS[2], ARGV[2]) end
+		return {minute, day}`
+)
+
+// Tier defines the request quotas for a class of API keys. A zero quota is
+// unlimited.
+type Tier struct {
+	Name      string `yaml:"name"`
+	PerMinute int    `yaml:"per_minute"`
+	PerDay    int    `yaml:"per_day"`
+}
+
+// QuotaConfig assigns API keys to quota tiers.
+type QuotaConfig struct {
+	Tiers []Tier `yaml:"tiers"`
+	// Keys maps API key IDs to tier names. Keys are identified by their ID,
+	// never by the secret key.
+	Keys map[string]string `yaml:"keys"`
+	// DefaultTier applies to keys not listed in Keys. When empty, unlisted
+	// keys are not limited.
+	DefaultTier string `yaml:"default_tier"`
+}
+
+// QuotaStatus reports the quota usage of an API key.
+type QuotaStatus struct {
+	IsLimited       bool
+	Tier            string
+	MinuteRemaining int // Negative if the minute quota is unlimited.
+	DayRemaining    int // Negative if the day quota is unlimited.
+}
+
+// ParseQuotaConfig reads the API key quota configuration file.
+func ParseQuotaConfig(path string) (*QuotaConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config := &QuotaConfig{}
+	if err := yaml.NewDecoder(f).Decode(config); err != nil {
+		return nil, err
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (qc *QuotaConfig) validate() error {
+	names := map[string]bool{}
+	for _, t := range qc.Tiers {
+		names[t.Name] = true
+	}
+	for _, tier := range qc.Keys {
+		if !names[tier] {
+			return fmt.Errorf("unknown quota tier %q", tier)
+		}
+	}
+	if qc.DefaultTier != "" && !names[qc.DefaultTier] {
+		return fmt.Errorf("unknown default quota tier %q", qc.DefaultTier)
+	}
+	return nil
+}
+
+// KeyQuotas enforces per-API-key request quotas using counters in Redis.
+type KeyQuotas struct {
+	pool      *redis.Pool
+	config    *QuotaConfig
+	tiers     map[string]Tier
+	keyPrefix string
+	now       func() time.Time
+}
+
+// NewKeyQuotas returns a new KeyQuotas using the given Redis pool. Redis keys
+// are prefixed by keyPrefix.
+func NewKeyQuotas(pool *redis.Pool, config *QuotaConfig, keyPrefix string) *KeyQuotas {
+	tiers := make(map[string]Tier, len(config.Tiers))
+	for _, t := range config.Tiers {
+		tiers[t.Name] = t
+	}
+	return &KeyQuotas{
+		pool:      pool,
+		config:    config,
+		tiers:     tiers,
+		keyPrefix: keyPrefix,
+		now:       time.Now,
+	}
+}
+
+// Check counts one request for the API key ID and reports whether the key has
+// exceeded its minute or day quota.
+func (kq *KeyQuotas) Check(keyID string) (QuotaStatus, error) {
+	name, ok := kq.config.Keys[keyID]
+	if !ok {
+		name = kq.config.DefaultTier
+	}
+	tier, ok := kq.tiers[name]
+	if !ok {
+		return QuotaStatus{MinuteRemaining: -1, DayRemaining: -1}, nil
+	}
+
+	conn := kq.pool.Get()
+	defer conn.Close()
+
+	now := kq.now().UTC()
+	minuteKey := fmt.Sprintf("%squota:%s:minute:%d", kq.keyPrefix, keyID, now.Unix()/60)
+	dayKey := fmt.Sprintf("%squota:%s:day:%s", kq.keyPrefix, keyID, now.Format("20060102"))
+	args := redis.Args{}.Add(quotaScript).Add(2).Add(minuteKey).Add(dayKey).
+		Add(int((2 * time.Minute).Seconds())).
+		Add(int((48 * time.Hour).Seconds()))
+	values, err := redis.Ints(conn.Do("EVAL", args...))
+	if err != nil {
+		return QuotaStatus{}, err
+	}
+	if len(values) != 2 {
+		return QuotaStatus{}, fmt.Errorf("unexpected quota counters: %v", values)
+	}
+
+	status := QuotaStatus{
+		Tier:            tier.Name,
+		MinuteRemaining: remaining(tier.PerMinute, values[0]),
+		DayRemaining:    remaining(tier.PerDay, values[1]),
+	}
+	status.IsLimited = (tier.PerMinute > 0 && values[0] > tier.PerMinute) ||
+		(tier.PerDay > 0 && values[1] > tier.PerDay)
+	return status, nil
+}
+
+// remaining returns the requests left in a quota, or -1 if unlimited.
+func remaining(quota, count int) int {
+	if quota <= 0 {
+		return -1
+	}
+	if count >= quota {
+		return 0
+	}
+	return quota - count
+}
@@ -0,0 +1,141 @@
+package limits
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func TestParseQuotaConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    *QuotaConfig
+		wantErr bool
+	}{
+		{
+			name: "success",
+			path: "testdata/quotas.yaml",
+			want: &QuotaConfig{
+				Tiers: []Tier{
+					{Name: "standard", PerMinute: 60, PerDay: 10000},
+					{Name: "partner", PerMinute: 600},
+				},
+				Keys:        map[string]string{"ki_partner": "partner"},
+				DefaultTier: "standard",
+			},
+		},
+		{
+			name:    "error-unknown-tier",
+			path:    "testdata/quotas-invalid.yaml",
+			wantErr: true,
+		},
+		{
+			name:    "error-file",
+			path:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuotaConfig(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseQuotaConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseQuotaConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyQuotas_Check(t *testing.T) {
+	config := &QuotaConfig{
+		Tiers: []Tier{
+			{Name: "standard", PerMinute: 10, PerDay: 100},
+		},
+		Keys: map[string]string{"ki_1": "standard"},
+	}
+	tests := []struct {
+		name      string
+		key       string
+		counters  []interface{}
+		evalErr   error
+		wantEvals int
+		want      QuotaStatus
+		wantErr   bool
+	}{
+		{
+			name:      "success-unlisted-key",
+			key:       "ki_2",
+			wantEvals: 0,
+			want:      QuotaStatus{MinuteRemaining: -1, DayRemaining: -1},
+		},
+		{
+			name:      "success-within-quota",
+			key:       "ki_1",
+			counters:  []interface{}{int64(4), int64(40)},
+			wantEvals: 1,
+			want:      QuotaStatus{Tier: "standard", MinuteRemaining: 6, DayRemaining: 60},
+		},
+		{
+			name:      "success-minute-exceeded",
+			key:       "ki_1",
+			counters:  []interface{}{int64(11), int64(40)},
+			wantEvals: 1,
+			want:      QuotaStatus{IsLimited: true, Tier: "standard", MinuteRemaining: 0, DayRemaining: 60},
+		},
+		{
+			name:      "success-day-exceeded",
+			key:       "ki_1",
+			counters:  []interface{}{int64(1), int64(101)},
+			wantEvals: 1,
+			want:      QuotaStatus{IsLimited: true, Tier: "standard", MinuteRemaining: 9, DayRemaining: 0},
+		},
+		{
+			name:      "error-eval",
+			key:       "ki_1",
+			evalErr:   errors.New("fake EVAL error"),
+			wantEvals: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "error-counters",
+			key:       "ki_1",
+			counters:  []interface{}{int64(1)},
+			wantEvals: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := redigomock.NewConn()
+			pool := &redis.Pool{
+				Dial: func() (redis.Conn, error) {
+					return conn, nil
+				},
+			}
+			cmd := conn.GenericCommand("EVAL")
+			if tt.evalErr != nil {
+				cmd.ExpectError(tt.evalErr)
+			} else {
+				cmd.Expect(tt.counters)
+			}
+
+			kq := NewKeyQuotas(pool, config, "")
+			got, err := kq.Check(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("KeyQuotas.Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("KeyQuotas.Check() = %v, want %v", got, tt.want)
+			}
+			if conn.Stats(cmd) != tt.wantEvals {
+				t.Errorf("KeyQuotas.Check() wrong EVAL count; got %d, want %d", conn.Stats(cmd), tt.wantEvals)
+			}
+		})
+	}
+}
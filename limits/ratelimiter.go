@@ -0,0 +1,327 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/m-lab/locate/metrics"
+	"github.com/m-lab/locate/static"
+)
+
+const (
+	// This is a Lua script that will be interpreted by the Redis server to
+	// atomically take one token from a token bucket. Running the script on the
+	// server keeps the limit consistent across all Locate instances.
+	//
+	// KEYS[1] is the bucket key. ARGV[1] is the bucket capacity, ARGV[2] is the
+	// refill rate in tokens per millisecond, ARGV[3] is the current time in
+	// milliseconds, and ARGV[4] is the key expiration in milliseconds.
+	//
+	// The script returns whether the token was taken and the tokens remaining.
+	// Lua numbers are truncated to integers by Redis, so the remaining tokens
+	// are returned as a string.
+	tokenBucketScript = `local capacity = tonumber(ARGV[1])
+		local rate = tonumber(ARGV[2])
+		local now = tonumber(ARGV[3])
+		local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+		local tokens = tonumber(bucket[1]) or capacity
+		local ts = tonumber(bucket[2]) or now
+		tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+		local allowed = 0
+		if tokens >= 1 then
+			tokens = tokens - 1
+			allowed = 1
+		end
+		redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+		redis.call('PEXPIRE', KEYS[1], ARGV[4])
+		return {allowed, tostring(tokens)}`
+)
+
+// Limit types reported by LimitStatus.
+const (
+	LimitTypeIP     = "ip"
+	LimitTypeIPUA   = "ipua"
+	LimitTypeSubnet = "subnet"
+)
+
+// LimitConfig configures a token bucket that refills with MaxEvents tokens per
+// Interval. A MaxEvents of zero disables the limit.
+type LimitConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	MaxEvents int           `yaml:"max_events"`
+	// Burst is the bucket capacity, i.e. the number of requests accepted at
+	// once after a quiet period. When zero, MaxEvents is used.
+	Burst int `yaml:"burst"`
+}
+
+// RateLimitConfig holds the limits applied to each client IP, to each client
+// IP and User-Agent pair, and to each client subnet (/24 for IPv4 and /48 for
+// IPv6). The subnet limit catches clients behind NAT or rotating addresses.
+type RateLimitConfig struct {
+	IP     LimitConfig `yaml:"ip"`
+	IPUA   LimitConfig `yaml:"ipua"`
+	Subnet LimitConfig `yaml:"subnet"`
+	// Services replaces the IP, IP+UA and subnet limits for specific services,
+	// e.g. "msak/throughput1". Services with their own limits are counted in
+	// separate buckets. The Services and KeyPrefix of each entry are ignored.
+	Services  map[string]RateLimitConfig `yaml:"services"`
+	Adaptive  AdaptiveConfig             `yaml:"adaptive"`
+	KeyPrefix string                     `yaml:"-"`
+}
+
+// AdaptiveConfig tightens the limits of a service while its healthy capacity
+// is low. A MinHealthy of zero disables adaptive limits.
+type AdaptiveConfig struct {
+	// MinHealthy is the number of healthy machines serving a service below
+	// which the limits are tightened.
+	MinHealthy int `yaml:"min_healthy"`
+	// Factor scales MaxEvents and Burst of tightened limits (e.g., 0.5).
+	Factor float64 `yaml:"factor"`
+}
+
+// Validate returns an error if any limit is invalid.
+func (c RateLimitConfig) Validate() error {
+	for name, lc := range map[string]LimitConfig{"ip": c.IP, "ipua": c.IPUA, "subnet": c.Subnet} {
+		if err := lc.validate(); err != nil {
+			return fmt.Errorf("invalid %s limit: %w", name, err)
+		}
+	}
+	for service, sc := range c.Services {
+		if err := sc.Validate(); err != nil {
+			return fmt.Errorf("service %s: %w", service, err)
+		}
+	}
+	if c.Adaptive.MinHealthy < 0 || c.Adaptive.Factor < 0 || c.Adaptive.Factor > 1 {
+		return fmt.Errorf("invalid adaptive config: %+v", c.Adaptive)
+	}
+	if c.Adaptive.MinHealthy > 0 && c.Adaptive.Factor == 0 {
+		return fmt.Errorf("invalid adaptive config: factor must be positive when min healthy is set: %+v", c.Adaptive)
+	}
+	return nil
+}
+
+func (lc LimitConfig) validate() error {
+	switch {
+	case lc.MaxEvents < 0:
+		return errors.New("max events must not be negative")
+	case lc.Burst < 0:
+		return errors.New("burst must not be negative")
+	case lc.MaxEvents > 0 && lc.Interval < time.Millisecond:
+		return errors.New("interval must be at least 1ms")
+	}
+	return nil
+}
+
+// CapacitySource reports the number of healthy machines serving a service.
+type CapacitySource interface {
+	HealthyCount(service string) int
+}
+
+// LimitStatus reports the result of a rate limit check.
+type LimitStatus struct {
+	IsLimited bool
+	LimitType string // The limit that was exceeded, if any.
+
+	// The state of the most constrained bucket, or of the exceeded bucket if
+	// limited. Limit is zero when no limit is enabled.
+	Limit     int           // Bucket capacity.
+	Remaining int           // Requests remaining in the bucket.
+	Reset     time.Duration // Time until the bucket is full again.
+	// RetryAfter is the time until the next request is allowed, or zero if
+	// not limited.
+	RetryAfter time.Duration
+}
+
+// RateLimiter implements Redis-backed token bucket rate limits.
+type RateLimiter struct {
+	pool     *redis.Pool
+	capacity CapacitySource
+	now      func() time.Time
+	// defaults holds the limits the RateLimiter was created with.
+	defaults RateLimitConfig
+
+	mu     sync.RWMutex
+	config RateLimitConfig
+}
+
+// NewRateLimiter returns a new RateLimiter using the given Redis pool.
+func NewRateLimiter(pool *redis.Pool, config RateLimitConfig) *RateLimiter {
+	return NewAdaptiveRateLimiter(pool, config, nil)
+}
+
+// NewAdaptiveRateLimiter returns a new RateLimiter that tightens limits using
+// config.Adaptive when capacity reports few healthy machines for a service.
+// Requests with API keys are not rate limited, so they are unaffected.
+func NewAdaptiveRateLimiter(pool *redis.Pool, config RateLimitConfig, capacity CapacitySource) *RateLimiter {
+	return &RateLimiter{
+		pool:     pool,
+		capacity: capacity,
+		config:   config,
+		defaults: config,
+		now:      time.Now,
+	}
+}
+
+// SetLimits replaces the IP, IP+UA and subnet limits. The key prefix is not
+// changed.
+func (rl *RateLimiter) SetLimits(config RateLimitConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	config.KeyPrefix = rl.config.KeyPrefix
+	rl.config = config
+}
+
+// IsLimited takes one token from the IP, IP+UA and subnet buckets of the
+// client for the given service, in that order, and reports the first bucket
+// that was empty.
+func (rl *RateLimiter) IsLimited(service, ip, ua string) (LimitStatus, error) {
+	conn := rl.pool.Get()
+	defer conn.Close()
+
+	rl.mu.RLock()
+	config := rl.config
+	rl.mu.RUnlock()
+	adaptive := config.Adaptive
+	prefix := config.KeyPrefix
+	if sc, ok := config.Services[service]; ok {
+		config = sc
+		prefix += service + ":"
+	}
+	if rl.isLowCapacity(service, adaptive) {
+		metrics.RateLimitAdaptiveTotal.WithLabelValues(service).Inc()
+		config.IP = config.IP.scale(adaptive.Factor)
+		config.IPUA = config.IPUA.scale(adaptive.Factor)
+		config.Subnet = config.Subnet.scale(adaptive.Factor)
+	}
+
+	now := rl.now()
+	checks := []struct {
+		limitType string
+		key       string
+		config    LimitConfig
+	}{
+		{LimitTypeIP, prefix + "ip:" + ip, config.IP},
+		{LimitTypeIPUA, prefix + "ipua:" + ip + ":" + ua, config.IPUA},
+		{LimitTypeSubnet, prefix + "subnet:" + subnet(ip), config.Subnet},
+	}
+	status := LimitStatus{}
+	for _, c := range checks {
+		if c.limitType == LimitTypeSubnet && subnet(ip) == "" {
+			// Without a valid IP there is no subnet to aggregate.
+			continue
+		}
+		b, err := take(conn, c.key, c.config, now)
+		if err != nil {
+			return LimitStatus{}, err
+		}
+		if b == nil {
+			continue
+		}
+		if status.Limit == 0 || b.remaining < status.Remaining {
+			status.Limit = b.capacity
+			status.Remaining = b.remaining
+			status.Reset = b.reset
+		}
+		if !b.allowed {
+			status.IsLimited = true
+			status.LimitType = c.limitType
+			status.RetryAfter = b.retryAfter
+			return status, nil
+		}
+	}
+	return status, nil
+}
+
+// isLowCapacity reports whether the healthy capacity of the service is below
+// the adaptive threshold. Unknown services, e.g. from misspelled request paths,
+// have no capacity to protect and are never tightened.
+func (rl *RateLimiter) isLowCapacity(service string, adaptive AdaptiveConfig) bool {
+	if rl.capacity == nil || adaptive.MinHealthy <= 0 || adaptive.Factor <= 0 {
+		return false
+	}
+	if _, ok := static.Configs[service]; !ok {
+		return false
+	}
+	return rl.capacity.HealthyCount(service) < adaptive.MinHealthy
+}
+
+// scale returns a copy of the limit with MaxEvents and Burst scaled by factor.
+// Enabled limits always allow at least one event.
+func (lc LimitConfig) scale(factor float64) LimitConfig {
+	if lc.MaxEvents <= 0 {
+		return lc
+	}
+	lc.MaxEvents = int(math.Max(1, math.Floor(float64(lc.MaxEvents)*factor)))
+	if lc.Burst > 0 {
+		lc.Burst = int(math.Max(1, math.Floor(float64(lc.Burst)*factor)))
+	}
+	return lc
+}
+
+// subnet returns the /24 IPv4 or /48 IPv6 prefix containing ip, or an empty
+// string if ip is invalid.
+func subnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// bucket is the state of a token bucket after taking a token.
+type bucket struct {
+	allowed    bool
+	capacity   int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration // Time until a token is available.
+}
+
+// take removes one token from the bucket stored in key and returns the bucket
+// state, or nil if the limit is disabled.
+func take(conn redis.Conn, key string, config LimitConfig, now time.Time) (*bucket, error) {
+	if config.MaxEvents <= 0 || config.Interval.Milliseconds() <= 0 {
+		return nil, nil
+	}
+	capacity := config.Burst
+	if capacity <= 0 {
+		capacity = config.MaxEvents
+	}
+	rate := float64(config.MaxEvents) / float64(config.Interval.Milliseconds())
+	// Keep the bucket until it would be full again.
+	ttl := time.Duration(float64(capacity)/rate) * time.Millisecond
+
+	args := redis.Args{}.Add(tokenBucketScript).Add(1).Add(key).
+		Add(capacity).
+		Add(strconv.FormatFloat(rate, 'f', -1, 64)).
+		Add(now.UnixMilli()).
+		Add(ttl.Milliseconds() + 1)
+	values, err := redis.Values(conn.Do("EVAL", args...))
+	if err != nil {
+		return nil, err
+	}
+	var allowed int
+	var tokens float64
+	if _, err := redis.Scan(values, &allowed, &tokens); err != nil {
+		return nil, err
+	}
+	b := &bucket{
+		allowed:   allowed == 1,
+		capacity:  capacity,
+		remaining: int(math.Floor(tokens)),
+		reset:     time.Duration((float64(capacity)-tokens)/rate) * time.Millisecond,
+	}
+	if tokens < 1 {
+		b.retryAfter = time.Duration((1-tokens)/rate) * time.Millisecond
+	}
+	return b, nil
+}
@@ -0,0 +1,303 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/rafaeljusto/redigomock"
+)
+
+func setUpRateLimiter(config RateLimitConfig) (*redigomock.Conn, *RateLimiter) {
+	conn := redigomock.NewConn()
+	pool := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return conn, nil
+		},
+	}
+	return conn, NewRateLimiter(pool, config)
+}
+
+type fakeCapacity struct {
+	healthy int
+}
+
+func (f *fakeCapacity) HealthyCount(service string) int {
+	return f.healthy
+}
+
+func bucketReply(allowed int, tokens string) []interface{} {
+	return []interface{}{int64(allowed), []byte(tokens)}
+}
+
+func TestRateLimiter_IsLimited(t *testing.T) {
+	enabled := LimitConfig{Interval: time.Hour, MaxEvents: 60, Burst: 10}
+	tests := []struct {
+		name      string
+		config    RateLimitConfig
+		capacity  CapacitySource
+		service   string
+		ip        string
+		responses []interface{}
+		evalErr   error
+		wantEvals int
+		want      LimitStatus
+		wantErr   bool
+	}{
+		{
+			name:      "success-disabled",
+			config:    RateLimitConfig{},
+			wantEvals: 0,
+		},
+		{
+			name:      "success-not-limited",
+			config:    RateLimitConfig{IP: enabled, IPUA: enabled},
+			responses: []interface{}{bucketReply(1, "8"), bucketReply(1, "4.5")},
+			wantEvals: 2,
+			// The IP+UA bucket is the most constrained. Tokens refill at one per
+			// minute, so the bucket is full again in 5.5 minutes.
+			want: LimitStatus{Limit: 10, Remaining: 4, Reset: 330 * time.Second},
+		},
+		{
+			name:      "success-ip-limited",
+			config:    RateLimitConfig{IP: enabled, IPUA: enabled},
+			responses: []interface{}{bucketReply(0, "0.5")},
+			wantEvals: 1,
+			want:      LimitStatus{IsLimited: true, LimitType: LimitTypeIP, Limit: 10, Remaining: 0, Reset: 570 * time.Second, RetryAfter: 30 * time.Second},
+		},
+		{
+			name:      "success-ipua-limited",
+			config:    RateLimitConfig{IP: enabled, IPUA: enabled},
+			responses: []interface{}{bucketReply(1, "3"), bucketReply(0, "0")},
+			wantEvals: 2,
+			want:      LimitStatus{IsLimited: true, LimitType: LimitTypeIPUA, Limit: 10, Remaining: 0, Reset: 10 * time.Minute, RetryAfter: time.Minute},
+		},
+		{
+			name:      "success-subnet-limited",
+			config:    RateLimitConfig{IP: enabled, Subnet: enabled},
+			responses: []interface{}{bucketReply(1, "9"), bucketReply(0, "0")},
+			wantEvals: 2,
+			want:      LimitStatus{IsLimited: true, LimitType: LimitTypeSubnet, Limit: 10, Remaining: 0, Reset: 10 * time.Minute, RetryAfter: time.Minute},
+		},
+		{
+			name:      "success-subnet-invalid-ip",
+			config:    RateLimitConfig{Subnet: enabled},
+			ip:        "invalid-ip",
+			wantEvals: 0,
+		},
+		{
+			name: "success-service-limited",
+			config: RateLimitConfig{
+				IP: LimitConfig{},
+				Services: map[string]RateLimitConfig{
+					"ndt/ndt7": {IP: enabled},
+				},
+			},
+			responses: []interface{}{bucketReply(0, "0")},
+			wantEvals: 1,
+			want:      LimitStatus{IsLimited: true, LimitType: LimitTypeIP, Limit: 10, Remaining: 0, Reset: 10 * time.Minute, RetryAfter: time.Minute},
+		},
+		{
+			name: "success-other-service-not-limited",
+			config: RateLimitConfig{
+				Services: map[string]RateLimitConfig{
+					"msak/throughput1": {IP: enabled},
+				},
+			},
+			wantEvals: 0,
+		},
+		{
+			name: "success-adaptive-low-capacity",
+			config: RateLimitConfig{
+				IP:       enabled,
+				Adaptive: AdaptiveConfig{MinHealthy: 10, Factor: 0.5},
+			},
+			capacity:  &fakeCapacity{healthy: 9},
+			responses: []interface{}{bucketReply(1, "2")},
+			wantEvals: 1,
+			// Tightened to 30 events per hour with a burst of 5.
+			want: LimitStatus{Limit: 5, Remaining: 2, Reset: 6 * time.Minute},
+		},
+		{
+			name: "success-adaptive-enough-capacity",
+			config: RateLimitConfig{
+				IP:       enabled,
+				Adaptive: AdaptiveConfig{MinHealthy: 10, Factor: 0.5},
+			},
+			capacity:  &fakeCapacity{healthy: 10},
+			responses: []interface{}{bucketReply(1, "2")},
+			wantEvals: 1,
+			want:      LimitStatus{Limit: 10, Remaining: 2, Reset: 8 * time.Minute},
+		},
+		{
+			name: "success-adaptive-unknown-service",
+			config: RateLimitConfig{
+				IP:       enabled,
+				Adaptive: AdaptiveConfig{MinHealthy: 10, Factor: 0.5},
+			},
+			capacity:  &fakeCapacity{healthy: 0},
+			service:   "foo/bar",
+			responses: []interface{}{bucketReply(1, "2")},
+			wantEvals: 1,
+			want:      LimitStatus{Limit: 10, Remaining: 2, Reset: 8 * time.Minute},
+		},
+		{
+			name:      "error-eval",
+			config:    RateLimitConfig{IP: enabled},
+			evalErr:   errors.New("fake EVAL error"),
+			wantEvals: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "error-scan",
+			config:    RateLimitConfig{IP: enabled},
+			responses: []interface{}{[]interface{}{[]byte("not-a-number")}},
+			wantEvals: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, rl := setUpRateLimiter(tt.config)
+			rl.capacity = tt.capacity
+			cmd := conn.GenericCommand("EVAL")
+			if tt.evalErr != nil {
+				cmd.ExpectError(tt.evalErr)
+			}
+			for _, r := range tt.responses {
+				cmd.Expect(r)
+			}
+
+			ip := tt.ip
+			if ip == "" {
+				ip = "192.0.2.1"
+			}
+			service := tt.service
+			if service == "" {
+				service = "ndt/ndt7"
+			}
+			got, err := rl.IsLimited(service, ip, "fake-agent")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RateLimiter.IsLimited() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("RateLimiter.IsLimited() = %v, want %v", got, tt.want)
+			}
+			if conn.Stats(cmd) != tt.wantEvals {
+				t.Errorf("RateLimiter.IsLimited() wrong EVAL count; got %d, want %d", conn.Stats(cmd), tt.wantEvals)
+			}
+		})
+	}
+}
+
+func TestSubnet(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "192.0.2.123", want: "192.0.2.0/24"},
+		{ip: "2001:db8:1:2::1", want: "2001:db8:1::/48"},
+		{ip: "::ffff:192.0.2.1", want: "192.0.2.0/24"},
+		{ip: "invalid-ip", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := subnet(tt.ip); got != tt.want {
+				t.Errorf("subnet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitConfig_scale(t *testing.T) {
+	tests := []struct {
+		name   string
+		config LimitConfig
+		factor float64
+		want   LimitConfig
+	}{
+		{
+			name:   "scaled",
+			config: LimitConfig{Interval: time.Hour, MaxEvents: 60, Burst: 10},
+			factor: 0.25,
+			want:   LimitConfig{Interval: time.Hour, MaxEvents: 15, Burst: 2},
+		},
+		{
+			name:   "at-least-one",
+			config: LimitConfig{Interval: time.Hour, MaxEvents: 3, Burst: 1},
+			factor: 0.1,
+			want:   LimitConfig{Interval: time.Hour, MaxEvents: 1, Burst: 1},
+		},
+		{
+			name:   "disabled",
+			config: LimitConfig{},
+			factor: 0.5,
+			want:   LimitConfig{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.scale(tt.factor); got != tt.want {
+				t.Errorf("LimitConfig.scale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitConfig_Validate(t *testing.T) {
+	valid := LimitConfig{Interval: time.Hour, MaxEvents: 60, Burst: 10}
+	tests := []struct {
+		name    string
+		config  RateLimitConfig
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			config: RateLimitConfig{IP: valid, IPUA: valid, Adaptive: AdaptiveConfig{MinHealthy: 5, Factor: 0.5}},
+		},
+		{
+			name:   "success-disabled",
+			config: RateLimitConfig{},
+		},
+		{
+			name:    "error-negative-max-events",
+			config:  RateLimitConfig{IP: LimitConfig{Interval: time.Hour, MaxEvents: -1}},
+			wantErr: true,
+		},
+		{
+			name:    "error-negative-burst",
+			config:  RateLimitConfig{IPUA: LimitConfig{Interval: time.Hour, MaxEvents: 1, Burst: -1}},
+			wantErr: true,
+		},
+		{
+			name:    "error-short-interval",
+			config:  RateLimitConfig{Subnet: LimitConfig{Interval: time.Microsecond, MaxEvents: 1}},
+			wantErr: true,
+		},
+		{
+			name: "error-service",
+			config: RateLimitConfig{Services: map[string]RateLimitConfig{
+				"ndt/ndt7": {IP: LimitConfig{MaxEvents: 1}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "error-adaptive-zero-factor",
+			config:  RateLimitConfig{Adaptive: AdaptiveConfig{MinHealthy: 5}},
+			wantErr: true,
+		},
+		{
+			name:    "error-adaptive-factor",
+			config:  RateLimitConfig{Adaptive: AdaptiveConfig{MinHealthy: 5, Factor: 2}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("RateLimitConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
@@ -23,6 +27,7 @@ import (
 	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/rtx"
 	v2 "github.com/m-lab/locate/api/v2"
+	"github.com/m-lab/locate/apikey"
 	"github.com/m-lab/locate/clientgeo"
 	"github.com/m-lab/locate/handler"
 	"github.com/m-lab/locate/heartbeat"
@@ -31,6 +36,7 @@ import (
 	"github.com/m-lab/locate/metrics"
 	"github.com/m-lab/locate/prometheus"
 	"github.com/m-lab/locate/secrets"
+	"github.com/m-lab/locate/siteinfo"
 	"github.com/m-lab/locate/static"
 )
 
@@ -40,15 +46,48 @@ var (
 	platform           string
 	locatorAE          bool
 	locatorMM          bool
+	locatorIPInfo      bool
+	locatorCDN         bool
 	legacyServer       string
 	signerSecretName   string
 	maxmind            = flagx.URL{}
+	maxmindASN         = flagx.URL{}
+	centroidsURL       = flagx.URL{}
+	siteinfoURL        = flagx.URL{}
+	overrideURL        = flagx.URL{}
+	ipinfoURL          = flagx.MustNewURL("https://ipinfo.io")
+	ipinfoToken        flagx.StringFile
+	ipinfoBudget       int
 	verifySecretName   string
+	locateVerifySecret string
+	orgVerifySecret    string
 	redisAddr          string
 	promUserSecretName string
 	promPassSecretName string
 	promURL            string
 	limitsPath         string
+	quotasPath         string
+	limitsURL          = flagx.URL{}
+	rateLimitEnable    bool
+	rateLimits         limits.RateLimitConfig
+	limitsReload       time.Duration
+	brakeKey           string
+	brakeRetryAfter    time.Duration
+	brakeRefresh       time.Duration
+	geoCacheTTL        time.Duration
+	latlonDigits       int
+	userLimit          int
+	userWindow         time.Duration
+	locatorOrder       string
+	locatorTimeout     time.Duration
+	apiKeyVerify       bool
+	apiKeyCacheTTL     time.Duration
+	apiKeyNegativeTTL  time.Duration
+	usageFlush         time.Duration
+	signatureSkew      time.Duration
+	tokenExpiry        flagx.KeyValue
+	decisionRate       float64
+	metricsASNs        flagx.StringArray
 	keySource          = flagx.Enum{
 		Options: []string{"secretmanager", "local"},
 		Value:   "secretmanager",
@@ -62,6 +101,8 @@ func init() {
 	flag.StringVar(&platform, "platform-project", "", "GCP project for platform machine names")
 	flag.StringVar(&signerSecretName, "signer-secret-name", "locate-service-signer-key", "Name of secret for locate signer key in Secret Manager")
 	flag.StringVar(&verifySecretName, "verify-secret-name", "locate-monitoring-service-verify-key", "Name of secret for monitoring verifier key in Secret Manager")
+	flag.StringVar(&locateVerifySecret, "locate-verify-secret-name", "locate-service-verify-key", "Name of secret for locate verifier key in Secret Manager, used with -api-key-verify to verify the access tokens issued by /v2/token")
+	flag.StringVar(&orgVerifySecret, "org-verify-secret-name", "", "Optional name of secret for organization token verifier key in Secret Manager; when set, registrations requests with an organization token are limited to that organization")
 	flag.StringVar(&redisAddr, "redis-address", "", "Primary endpoint for Redis instance")
 	flag.StringVar(&promUserSecretName, "prometheus-username-secret-name", "prometheus-support-build-prom-auth-user",
 		"Name of secret for Prometheus username")
@@ -71,8 +112,50 @@ func init() {
 	flag.BoolVar(&locatorAE, "locator-appengine", true, "Use the AppEngine clientgeo locator")
 	flag.BoolVar(&locatorMM, "locator-maxmind", false, "Use the MaxMind clientgeo locator")
 	flag.Var(&maxmind, "maxmind-url", "When -locator-maxmind is true, the tar URL of MaxMind IP database. May be: gs://bucket/file or file:./relativepath/file")
+	flag.Var(&maxmindASN, "maxmind-asn-url", "When -locator-maxmind is true, the optional tar URL of the MaxMind ASN database. May be: gs://bucket/file or file:./relativepath/file")
+	flag.DurationVar(&geoCacheTTL, "locator-cache-ttl", time.Minute, "Duration to cache IP-based clientgeo results per client network prefix (0 disables caching)")
+	flag.IntVar(&clientgeo.TrustedProxies, "trusted-proxies", 1, "Number of X-Forwarded-For entries appended after the client IP by trusted proxies (1 for the Google load balancer)")
+	flag.IntVar(&latlonDigits, "privacy-latlon-digits", -1, "Decimal digits of client coordinates exposed in logs and headers (1 is about city precision, -1 disables rounding)")
+	flag.StringVar(&locatorOrder, "locator-order", "override,user,appengine,cdn,maxmind,ipinfo",
+		"Comma separated precedence of the clientgeo locators. Every enabled locator must be listed; omit \"user\" to ignore user-provided locations")
+	flag.DurationVar(&locatorTimeout, "locator-timeout", 0,
+		"When greater than zero, query all clientgeo locators concurrently and ignore those slower than this timeout (note: every locator is queried for every request)")
+	flag.IntVar(&userLimit, "user-location-limit", 0, "Maximum user-provided locations accepted per client IP per -user-location-window (0 means unlimited)")
+	flag.DurationVar(&userWindow, "user-location-window", time.Minute, "Window for -user-location-limit")
+	flag.BoolVar(&locatorCDN, "locator-cdn", false, "Use the CDN (Cloudflare, Fastly, GCLB) header clientgeo locator")
+	flag.Var(&centroidsURL, "centroids-url", "Optional URL of a JSON dataset correcting the country and region centroids. May be: gs://bucket/file or file:./relativepath/file")
+	flag.Var(&siteinfoURL, "siteinfo-registrations-url", "Optional URL of the authoritative siteinfo registration data compared with the registered machines by /v2/platform/admin/siteinfo-diff. May be: https://host/file, gs://bucket/file or file:./relativepath/file")
+	flag.Var(&overrideURL, "locator-override-url", "Optional URL of a YAML table of client prefix locations that override other locators. May be: gs://bucket/file or file:./relativepath/file")
+	flag.BoolVar(&locatorIPInfo, "locator-ipinfo", false, "Use the IPinfo API clientgeo locator")
+	flag.Var(&ipinfoURL, "ipinfo-url", "When -locator-ipinfo is true, the base URL of the IPinfo API")
+	flag.Var(&ipinfoToken, "ipinfo-token", "When -locator-ipinfo is true, the IPinfo API token (may be read from @/path/file)")
+	flag.IntVar(&ipinfoBudget, "ipinfo-daily-budget", 50000, "Maximum number of IPinfo API lookups per day (0 means unlimited)")
 	flag.Var(&keySource, "key-source", "Where to load signer and verifier keys")
 	flag.StringVar(&limitsPath, "limits-path", "/go/src/github.com/m-lab/locate/limits/config.yaml", "Path to the limits config file")
+	flag.Var(&limitsURL, "limits-url", "Optional URL of a limits config document with agent limits, rate limits (used with -ratelimit-enable) and exemptions, replacing -limits-path and reloaded periodically. May be: gs://bucket/file or file:./relativepath/file")
+	flag.DurationVar(&limitsReload, "limits-reload-interval", 5*time.Minute, "Expected interval between reloads of -limits-url")
+	flag.StringVar(&brakeKey, "emergency-brake-key", "emergency-brake", "Redis key that, while present, limits service to requests with API keys")
+	flag.DurationVar(&brakeRetryAfter, "emergency-brake-retry-after", 5*time.Minute, "Retry-After reported while the emergency brake key has no expiration")
+	flag.DurationVar(&brakeRefresh, "emergency-brake-refresh-interval", 10*time.Second, "Interval between checks of -emergency-brake-key")
+	flag.BoolVar(&rateLimitEnable, "ratelimit-enable", false, "Rate limit nearest requests per client IP, IP and User-Agent, and subnet using token buckets in Redis")
+	flag.DurationVar(&rateLimits.IP.Interval, "ratelimit-ip-interval", time.Hour, "Interval of the per-IP rate limit")
+	flag.IntVar(&rateLimits.IP.MaxEvents, "ratelimit-ip-max-events", 200, "Requests allowed per client IP per -ratelimit-ip-interval (0 disables the limit)")
+	flag.IntVar(&rateLimits.IP.Burst, "ratelimit-ip-burst", 40, "Requests allowed at once per client IP (0 means -ratelimit-ip-max-events)")
+	flag.DurationVar(&rateLimits.IPUA.Interval, "ratelimit-ipua-interval", time.Hour, "Interval of the per-IP and User-Agent rate limit")
+	flag.IntVar(&rateLimits.IPUA.MaxEvents, "ratelimit-ipua-max-events", 40, "Requests allowed per client IP and User-Agent per -ratelimit-ipua-interval (0 disables the limit)")
+	flag.IntVar(&rateLimits.IPUA.Burst, "ratelimit-ipua-burst", 20, "Requests allowed at once per client IP and User-Agent (0 means -ratelimit-ipua-max-events)")
+	flag.DurationVar(&rateLimits.Subnet.Interval, "ratelimit-subnet-interval", time.Hour, "Interval of the per-subnet (/24 or /48) rate limit")
+	flag.IntVar(&rateLimits.Subnet.MaxEvents, "ratelimit-subnet-max-events", 2000, "Requests allowed per client subnet per -ratelimit-subnet-interval (0 disables the limit)")
+	flag.IntVar(&rateLimits.Subnet.Burst, "ratelimit-subnet-burst", 400, "Requests allowed at once per client subnet (0 means -ratelimit-subnet-max-events)")
+	flag.Float64Var(&decisionRate, "decision-sample-rate", 0, "Fraction (0 to 1) of the successful selection decisions written to stdout as anonymized JSON lines, e.g. for export to BigQuery by a log sink")
+	flag.Var(&metricsASNs, "metrics-client-asns", "Client ASNs (e.g. AS15169) counted individually by the client ASN request metric; other ASNs are counted as \"other\". May be repeated or comma separated")
+	flag.Var(&tokenExpiry, "access-token-expiry", "Validity of the access tokens issued for a service or experiment, e.g. wehe/replay=10m (default 1m). May be repeated or comma separated")
+	flag.StringVar(&quotasPath, "key-quotas-path", "", "Optional path to the API key quota tiers config file")
+	flag.BoolVar(&apiKeyVerify, "api-key-verify", false, "Verify M-Lab API keys (mlabk.*) on priority requests against the key hashes stored in Redis. The API gateway must not require Google Cloud API keys for priority requests (see cloudbuild.yaml)")
+	flag.DurationVar(&apiKeyCacheTTL, "api-key-cache-ttl", 5*time.Minute, "Duration to cache verified API keys")
+	flag.DurationVar(&apiKeyNegativeTTL, "api-key-negative-cache-ttl", time.Minute, "Duration to cache unknown, disabled or mismatched API keys")
+	flag.DurationVar(&signatureSkew, "api-key-signature-skew", 5*time.Minute, "When -api-key-verify is true, maximum clock skew accepted for the timestamp of signed requests")
+	flag.DurationVar(&usageFlush, "api-key-usage-flush-interval", time.Minute, "When -api-key-verify is true, interval between flushes of the per-integration request counts to Redis")
 
 	// Enable logging with line numbers to trace error locations.
 	log.SetFlags(log.LUTC | log.Llongfile)
@@ -111,16 +194,67 @@ func main() {
 	signer, err := cfg.LoadSigner(mainCtx, signerSecretName)
 	rtx.Must(err, "Failed to load signer key")
 
-	locators := clientgeo.MultiLocator{clientgeo.NewUserLocator()}
+	order := strings.Split(locatorOrder, ",")
+	available := map[string]clientgeo.Locator{}
+	for _, name := range order {
+		// User-provided locations are only accepted when "user" is ordered.
+		if name == "user" {
+			available["user"] = clientgeo.NewUserLocatorWithLimit(userLimit, userWindow)
+		}
+	}
+	if overrideURL.URL != nil {
+		p, err := content.FromURL(mainCtx, overrideURL.URL)
+		rtx.Must(err, "failed to load override url: %s", overrideURL.URL)
+		available["override"], err = clientgeo.NewOverrideLocator(mainCtx, p)
+		rtx.Must(err, "failed to load override table")
+	}
 	if locatorAE {
-		aeLocator := clientgeo.NewAppEngineLocator()
-		locators = append(locators, aeLocator)
+		available["appengine"] = clientgeo.NewAppEngineLocatorWithPrecision(latlonDigits)
+	}
+	if locatorCDN {
+		available["cdn"] = clientgeo.NewCDNLocator()
 	}
 	if locatorMM {
 		mm, err := content.FromURL(mainCtx, maxmind.URL)
 		rtx.Must(err, "failed to load maxmindurl: %s", maxmind.URL)
-		mmLocator := clientgeo.NewMaxmindLocator(mainCtx, mm)
-		locators = append(locators, mmLocator)
+		var asn content.Provider
+		if maxmindASN.URL != nil {
+			asn, err = content.FromURL(mainCtx, maxmindASN.URL)
+			rtx.Must(err, "failed to load maxmind asn url: %s", maxmindASN.URL)
+		}
+		available["maxmind"] = withPrefixCache(clientgeo.NewMaxmindLocatorWithASN(mainCtx, mm, asn))
+	}
+	if locatorIPInfo {
+		ipinfoLocator := clientgeo.NewIPInfoLocator(clientgeo.IPInfoConfig{
+			URL:          ipinfoURL.URL,
+			Token:        ipinfoToken.Value,
+			Budget:       ipinfoBudget,
+			BudgetPeriod: 24 * time.Hour,
+			CacheTTL:     time.Hour,
+			CacheSize:    100000,
+			Timeout:      time.Second,
+		})
+		available["ipinfo"] = withPrefixCache(ipinfoLocator)
+	}
+	ml, err := clientgeo.NewMultiLocator(order, available)
+	rtx.Must(err, "invalid -locator-order")
+	var locators clientgeo.Locator = ml
+	if locatorTimeout > 0 {
+		locators = clientgeo.NewParallelLocator(ml, locatorTimeout)
+	}
+
+	var centroids *clientgeo.CentroidLoader
+	if centroidsURL.URL != nil {
+		p, err := content.FromURL(mainCtx, centroidsURL.URL)
+		rtx.Must(err, "failed to load centroids url: %s", centroidsURL.URL)
+		centroids, err = clientgeo.NewCentroidLoader(mainCtx, p)
+		rtx.Must(err, "failed to load centroids dataset")
+	}
+	reloadGeo := func() {
+		locators.Reload(mainCtx)
+		if centroids != nil {
+			centroids.Reload(mainCtx)
+		}
 	}
 
 	pool := redis.Pool{
@@ -138,9 +272,76 @@ func main() {
 	promClient, err := prometheus.NewClient(creds, promURL)
 	rtx.Must(err, "failed to create Prometheus client")
 
-	lmts, err := limits.ParseConfig(limitsPath)
-	rtx.Must(err, "failed to parse limits config")
-	c := handler.NewClient(project, signer, srvLocatorV2, locators, promClient, lmts)
+	// Keep rate limit keys out of the heartbeat database, which the tracker
+	// scans in full.
+	limitPool := redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redisAddr, redis.DialDatabase(1))
+		},
+	}
+	// The rate limiter adds Redis round trips to every request, so it is
+	// only used when enabled.
+	var rateLimiter *limits.RateLimiter
+	var ipLimiter handler.Limiter
+	if rateLimitEnable {
+		rtx.Must(rateLimits.Validate(), "invalid rate limit flags")
+		rateLimits.KeyPrefix = "ratelimit:"
+		rateLimiter = limits.NewAdaptiveRateLimiter(&limitPool, rateLimits, tracker)
+		ipLimiter = rateLimiter
+	}
+	var lmts handler.AgentLimiter
+	var exemptions handler.Exempter
+	if limitsURL.URL != nil {
+		p, err := content.FromURL(mainCtx, limitsURL.URL)
+		rtx.Must(err, "failed to load limits url: %s", limitsURL.URL)
+		loader, err := limits.NewConfigLoader(mainCtx, p, rateLimiter)
+		rtx.Must(err, "failed to load limits config")
+		lmts = loader
+		exemptions = loader
+		go func() {
+			tick, err := memoryless.NewTicker(mainCtx, memoryless.Config{
+				Min:      limitsReload / 2,
+				Max:      2 * limitsReload,
+				Expected: limitsReload,
+			})
+			rtx.Must(err, "Could not create ticker for reloading limits")
+			for range tick.C {
+				loader.Reload(mainCtx)
+			}
+		}()
+	} else {
+		agents, err := limits.ParseConfig(limitsPath)
+		rtx.Must(err, "failed to parse limits config")
+		lmts = agents.Matcher()
+	}
+	var keyQuotas handler.QuotaChecker
+	if quotasPath != "" {
+		quotaConfig, err := limits.ParseQuotaConfig(quotasPath)
+		rtx.Must(err, "failed to parse key quotas config")
+		keyQuotas = limits.NewKeyQuotas(&limitPool, quotaConfig, "")
+	}
+	brake := limits.NewBrake(&limitPool, brakeKey, brakeRetryAfter)
+	go func() {
+		tick := time.NewTicker(brakeRefresh)
+		defer tick.Stop()
+		for {
+			if err := brake.Update(); err != nil {
+				log.Println("Could not update emergency brake:", err)
+			}
+			select {
+			case <-mainCtx.Done():
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	c := handler.NewClient(project, signer, srvLocatorV2, clientgeo.NewPrivacyLocator(locators, latlonDigits),
+		promClient, lmts, ipLimiter, keyQuotas, exemptions, brake)
+	rtx.Must(c.SetTokenExpiry(tokenExpiry.Get()), "invalid -access-token-expiry")
+	rtx.Must(c.SetASNLabels(metricsASNs), "invalid -metrics-client-asns")
+	if decisionRate != 0 {
+		rtx.Must(c.SetDecisionExporter(handler.NewDecisionLog(os.Stdout), decisionRate), "invalid -decision-sample-rate")
+	}
 
 	go func() {
 		// Check and reload db at least once a day.
@@ -152,7 +353,22 @@ func main() {
 		tick, err := memoryless.NewTicker(mainCtx, reloadConfig)
 		rtx.Must(err, "Could not create ticker for reloading")
 		for range tick.C {
-			locators.Reload(mainCtx)
+			reloadGeo()
+		}
+	}()
+
+	go func() {
+		// Reload on SIGHUP for deployments outside of AppEngine.
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for {
+			select {
+			case <-mainCtx.Done():
+				return
+			case <-sighup:
+				log.Println("received SIGHUP, reloading client geo data")
+				reloadGeo()
+			}
 		}
 	}()
 
@@ -174,7 +390,47 @@ func main() {
 	rtx.Must(err, "Failed to create token controller")
 	monitoringChain := alice.New(tc.Limit).Then(http.HandlerFunc(c.Monitoring))
 
-	// TODO: add verifier for optional access tokens to support NextRequest.
+	// ORGANIZATION VERIFIER - for the organization tokens scoping the
+	// registrations to the machines of an organization.
+	registrationsChain := alice.New()
+	if orgVerifySecret != "" {
+		orgVerifier, err := cfg.LoadVerifier(mainCtx, orgVerifySecret)
+		rtx.Must(err, "Failed to create organization verifier")
+		registrationsChain = alice.New(handler.OrgScope(orgVerifier))
+	}
+
+	// API KEY VERIFIER - for M-Lab API keys provided, or used to sign
+	// requests, by integrations, and for the optional access tokens issued to
+	// them to support NextRequest.
+	priorityChain := alice.New()
+	var tokenChain alice.Chain
+	var usage *apikey.Usage
+	if apiKeyVerify {
+		locateVerifier, err := cfg.LoadVerifier(mainCtx, locateVerifySecret)
+		rtx.Must(err, "Failed to create locate verifier")
+		keyStore := apikey.NewRedisStore(&limitPool, "")
+		keyVerifier := apikey.NewVerifier(keyStore, 10000, apiKeyCacheTTL, apiKeyNegativeTTL)
+		sigVerifier := apikey.NewSignatureVerifier(keyStore, &limitPool, "", signatureSkew)
+		usage = apikey.NewUsage(&limitPool, "")
+		tokenChain = alice.New(keyVerifier.Limit, sigVerifier.Limit, usage.Count)
+		priorityChain = alice.New(apikey.NewAccessTokens(locateVerifier).Limit).Extend(tokenChain)
+		go func() {
+			tick := time.NewTicker(usageFlush)
+			defer tick.Stop()
+			for {
+				select {
+				case <-mainCtx.Done():
+				case <-tick.C:
+				}
+				if err := usage.Flush(); err != nil {
+					log.Println("Could not flush API key usage:", err)
+				}
+				if mainCtx.Err() != nil {
+					return
+				}
+			}
+		}()
+	}
 
 	mux := http.NewServeMux()
 	// PLATFORM APIs
@@ -191,22 +447,60 @@ func main() {
 		metrics.RequestHandlerDuration.MustCurryWith(promet.Labels{"path": "/v2/platform/monitoring/"}),
 		monitoringChain))
 
+	// Operators force a reload of the MaxMind database. The reload goes
+	// through the prefix cache, which is flushed as well.
+	if mm, ok := available["maxmind"].(handler.GeoReloader); ok {
+		mux.Handle("/v2/platform/admin/reload-maxmind", alice.New(tc.Limit).Then(handler.ReloadGeo(mm)))
+	}
+
+	// Operators inspect the runtime, e.g. of the Nearest path. CPU and memory
+	// profiles are served by prometheusx under /debug/pprof on the metrics
+	// port instead.
+	mux.Handle("/debug/runtime", alice.New(tc.Limit).ThenFunc(handler.Runtime))
+
+	// Operators and billing read the daily requests of each integration.
+	if usage != nil {
+		mux.Handle("/v2/platform/admin/usage", alice.New(tc.Limit).Then(handler.Usage(usage)))
+	}
+
+	// Operators compare the registered machines with the siteinfo
+	// registrations.
+	if siteinfoURL.URL != nil {
+		p, err := content.FromURL(mainCtx, siteinfoURL.URL)
+		rtx.Must(err, "failed to load siteinfo registrations url: %s", siteinfoURL.URL)
+		mux.Handle("/v2/platform/admin/siteinfo-diff", alice.New(tc.Limit).Then(c.SiteinfoDiff(siteinfo.NewRegistrations(p))))
+	}
+
 	// USER APIs
 	// Clients request access tokens for specific services.
 	mux.HandleFunc("/v2/nearest/", promhttp.InstrumentHandlerDuration(
 		metrics.RequestHandlerDuration.MustCurryWith(promet.Labels{"path": "/v2/nearest/"}),
 		http.HandlerFunc(c.Nearest)))
-	// REQUIRED: API keys parameters required for priority requests.
-	mux.HandleFunc("/v2/priority/nearest/", promhttp.InstrumentHandlerDuration(
+	// Priority requests carry a Google Cloud API key checked by the API gateway
+	// or, with -api-key-verify, credentials verified by priorityChain.
+	mux.Handle("/v2/priority/nearest/", promhttp.InstrumentHandlerDuration(
 		metrics.RequestHandlerDuration.MustCurryWith(promet.Labels{"path": "/v2/priority/nearest/"}),
-		http.HandlerFunc(c.Nearest)))
+		priorityChain.Then(http.HandlerFunc(c.Nearest))))
+
+	// Draft v3 API: clients describe the request in a typed JSON body.
+	mux.HandleFunc("/v3/nearest", promhttp.InstrumentHandlerDuration(
+		metrics.RequestHandlerDuration.MustCurryWith(promet.Labels{"path": "/v3/nearest"}),
+		http.HandlerFunc(c.NearestV3)))
+
+	// Clients with API keys request access tokens for the High Availability
+	// Pool.
+	if apiKeyVerify {
+		mux.Handle("/v2/token/", promhttp.InstrumentHandlerDuration(
+			metrics.RequestHandlerDuration.MustCurryWith(promet.Labels{"path": "/v2/token/"}),
+			tokenChain.Then(http.HandlerFunc(c.Token))))
+	}
 
 	// Liveness and Readiness checks to support deployments.
 	mux.HandleFunc("/v2/live", c.Live)
 	mux.HandleFunc("/v2/ready", c.Ready)
 
 	// Return list of all heartbeat registrations
-	mux.HandleFunc("/v2/siteinfo/registrations", c.Registrations)
+	mux.Handle("/v2/siteinfo/registrations", registrationsChain.Then(http.HandlerFunc(c.Registrations)))
 
 	srv := &http.Server{
 		Addr:    ":" + listenPort,
@@ -217,3 +511,12 @@ func main() {
 	defer srv.Close()
 	<-mainCtx.Done()
 }
+
+// withPrefixCache wraps an IP-based clientgeo.Locator with a per-prefix cache
+// when -locator-cache-ttl is positive.
+func withPrefixCache(l clientgeo.Locator) clientgeo.Locator {
+	if geoCacheTTL <= 0 {
+		return l
+	}
+	return clientgeo.NewPrefixCache(l, geoCacheTTL, 100000)
+}
@@ -46,7 +46,7 @@ func (c *client[V]) Put(key string, field string, value redis.Scanner, opts *Put
 
 	b, err := json.Marshal(value)
 	if err != nil {
-		metrics.LocateMemorystoreRequestDuration.WithLabelValues("put", field, "marshal error").Observe(time.Since(t).Seconds())
+		observe("put", field, "marshal error", t)
 		return err
 	}
 
@@ -54,30 +54,30 @@ func (c *client[V]) Put(key string, field string, value redis.Scanner, opts *Put
 		args := redis.Args{}.Add(script).Add(1).Add(key).Add(opts.FieldMustExist).Add(field).AddFlat(string(b))
 		_, err = conn.Do("EVAL", args...)
 		if err != nil {
-			metrics.LocateMemorystoreRequestDuration.WithLabelValues("put", field, "EVAL error").Observe(time.Since(t).Seconds())
+			observe("put", field, "EVAL error", t)
 			return err
 		}
 	} else {
 		args := redis.Args{}.Add(key).Add(field).AddFlat(string(b))
 		_, err = conn.Do("HSET", args...)
 		if err != nil {
-			metrics.LocateMemorystoreRequestDuration.WithLabelValues("put", field, "HSET error").Observe(time.Since(t).Seconds())
+			observe("put", field, "HSET error", t)
 			return err
 		}
 	}
 
 	if !opts.WithExpire {
-		metrics.LocateMemorystoreRequestDuration.WithLabelValues("put", field, "OK").Observe(time.Since(t).Seconds())
+		observe("put", field, "OK", t)
 		return nil
 	}
 
 	_, err = conn.Do("EXPIRE", key, static.RedisKeyExpirySecs)
 	if err != nil {
-		metrics.LocateMemorystoreRequestDuration.WithLabelValues("put", field, "EXPIRE error").Observe(time.Since(t).Seconds())
+		observe("put", field, "EXPIRE error", t)
 		return err
 	}
 
-	metrics.LocateMemorystoreRequestDuration.WithLabelValues("put", field+" with expiration", "OK").Observe(time.Since(t).Seconds())
+	observe("put", field, "OK", t)
 	return nil
 }
 
@@ -89,11 +89,11 @@ func (c *client[V]) Del(key string) error {
 
 	_, err := conn.Do("DEL", key)
 	if err != nil {
-		metrics.LocateMemorystoreRequestDuration.WithLabelValues("del", "", "DEL error").Observe(time.Since(t).Seconds())
+		observe("del", "all", "DEL error", t)
 		return err
 	}
 
-	metrics.LocateMemorystoreRequestDuration.WithLabelValues("del", "", "OK").Observe(time.Since(t).Seconds())
+	observe("del", "all", "OK", t)
 	return nil
 }
 
@@ -113,33 +113,41 @@ func (c *client[V]) GetAll() (map[string]V, error) {
 	for {
 		keys, err := redis.Values(conn.Do("SCAN", iter))
 		if err != nil {
-			metrics.LocateMemorystoreRequestDuration.WithLabelValues("get", "all", "SCAN error").Observe(time.Since(t).Seconds())
+			observe("get", "all", "SCAN error", t)
 			return nil, err
 		}
 
 		var temp []string
 		keys, err = redis.Scan(keys, &iter, &temp)
 		if err != nil {
-			metrics.LocateMemorystoreRequestDuration.WithLabelValues("get", "all", "SCAN copy error").Observe(time.Since(t).Seconds())
+			observe("get", "all", "SCAN copy error", t)
 			return nil, err
 		}
 
 		for _, k := range temp {
 			v, err := c.get(k, conn)
 			if err != nil {
-				metrics.LocateMemorystoreRequestDuration.WithLabelValues("get", "all", "HGETALL error").Observe(time.Since(t).Seconds())
+				observe("get", "all", "HGETALL error", t)
 				return nil, err
 			}
 			values[k] = v
 		}
 
 		if iter == 0 {
-			metrics.LocateMemorystoreRequestDuration.WithLabelValues("get", "all", "OK").Observe(time.Since(t).Seconds())
+			observe("get", "all", "OK", t)
 			return values, nil
 		}
 	}
 }
 
+// observe records the duration of a Memorystore request started at t. The
+// type is the kind of request ("put", "get" or "del"), the field is the hash
+// field written, or "all" for requests on whole entries, and the status is
+// "OK" or the failed command.
+func observe(typ, field, status string, t time.Time) {
+	metrics.LocateMemorystoreRequestDuration.WithLabelValues(typ, field, status).Observe(time.Since(t).Seconds())
+}
+
 func (c *client[V]) get(key string, conn redis.Conn) (V, error) {
 	v := new(V)
 	val, err := redis.Values(conn.Do("HGETALL", key))
@@ -5,12 +5,37 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Conditions of RequestsTotal. Every handler uses the same fixed set of
+// values, so that alerts can target specific failure classes.
+const (
+	// ConditionSuccess is used for successful requests.
+	ConditionSuccess = "success"
+	// ConditionClientGeo is used when the client location cannot be found.
+	ConditionClientGeo = "client_geo"
+	// ConditionRateLimit is used when the client is rate limited.
+	ConditionRateLimit = "rate_limit"
+	// ConditionQuota is used when an API key exceeds its quota.
+	ConditionQuota = "quota"
+	// ConditionBrake is used when the client is refused while the emergency
+	// brake is engaged.
+	ConditionBrake = "brake"
+	// ConditionNoCapacity is used when no servers are available.
+	ConditionNoCapacity = "no_capacity"
+	// ConditionAuth is used when the client credentials are missing or
+	// invalid.
+	ConditionAuth = "auth"
+	// ConditionBadRequest is used for invalid request parameters or paths.
+	ConditionBadRequest = "bad_request"
+	// ConditionInternal is used for any other server error.
+	ConditionInternal = "internal"
+)
+
 var (
 	// RequestsTotal counts the number of requests served by
-	// the Locate service.
+	// the Locate service. The condition is one of the Condition constants.
 	//
 	// Example usage:
-	// metrics.RequestsTotal.WithLabelValues("nearest", "200").Inc()
+	// metrics.RequestsTotal.WithLabelValues("nearest", metrics.ConditionSuccess, "OK").Inc()
 	RequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "locate_requests_total",
@@ -32,6 +57,190 @@ var (
 		[]string{"country"},
 	)
 
+	// ClientCountryRequestsTotal counts the number of successful nearest
+	// requests by service and client country, to compare demand with capacity
+	// per region. Unknown countries are counted as "unknown".
+	//
+	// Example usage:
+	// metrics.ClientCountryRequestsTotal.WithLabelValues("ndt/ndt7", "US").Inc()
+	ClientCountryRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_client_country_requests_total",
+			Help: "Number of successful nearest requests by service and client country.",
+		},
+		[]string{"service", "country"},
+	)
+
+	// ClientASNRequestsTotal counts the number of successful nearest requests
+	// by client ASN. Only a configured list of ASNs is counted individually;
+	// requests from other ASNs are counted as "other", and from unknown ASNs
+	// as "unknown".
+	//
+	// Example usage:
+	// metrics.ClientASNRequestsTotal.WithLabelValues("AS15169").Inc()
+	ClientASNRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_client_asn_requests_total",
+			Help: "Number of successful nearest requests by client ASN.",
+		},
+		[]string{"asn"},
+	)
+
+	// ClientASNLookupsTotal counts the number of client ASN lookups made by
+	// the MaxMind locator.
+	//
+	// Example usage:
+	// metrics.ClientASNLookupsTotal.WithLabelValues("OK").Inc()
+	ClientASNLookupsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_client_asn_lookups_total",
+			Help: "Number of client ASN lookups.",
+		},
+		[]string{"status"},
+	)
+
+	// ClientgeoCacheTotal counts the number of client geo prefix cache
+	// lookups.
+	//
+	// Example usage:
+	// metrics.ClientgeoCacheTotal.WithLabelValues("hit").Inc()
+	ClientgeoCacheTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_clientgeo_cache_total",
+			Help: "Number of client geo prefix cache lookups.",
+		},
+		[]string{"status"},
+	)
+
+	// ClientgeoLocatorTotal counts the number of client location attempts
+	// made by each clientgeo Locator of a MultiLocator.
+	//
+	// Example usage:
+	// metrics.ClientgeoLocatorTotal.WithLabelValues("maxmind", "error").Inc()
+	ClientgeoLocatorTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_clientgeo_locator_total",
+			Help: "Number of client location attempts per clientgeo locator.",
+		},
+		[]string{"locator", "status"},
+	)
+
+	// ClientgeoSelectedTotal counts the number of times each clientgeo Locator
+	// produced the final client location of a MultiLocator.
+	//
+	// Example usage:
+	// metrics.ClientgeoSelectedTotal.WithLabelValues("appengine").Inc()
+	ClientgeoSelectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_clientgeo_selected_total",
+			Help: "Number of client locations produced per clientgeo locator.",
+		},
+		[]string{"locator"},
+	)
+
+	// RateLimitedTotal counts the number of requests rejected by the rate
+	// limiter, by the type of limit exceeded.
+	//
+	// Example usage:
+	// metrics.RateLimitedTotal.WithLabelValues("ip").Inc()
+	RateLimitedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_rate_limited_total",
+			Help: "Number of requests rejected by the rate limiter.",
+		},
+		[]string{"type"},
+	)
+
+	// RateLimitAdaptiveTotal counts the number of rate limit checks using
+	// tightened limits because of low healthy capacity, by service.
+	//
+	// Example usage:
+	// metrics.RateLimitAdaptiveTotal.WithLabelValues("ndt/ndt7").Inc()
+	RateLimitAdaptiveTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_rate_limit_adaptive_total",
+			Help: "Number of rate limit checks using limits tightened for low capacity.",
+		},
+		[]string{"service"},
+	)
+
+	// RateLimitExemptionsTotal counts the number of requests exempted from
+	// limits, by the reason for the exemption.
+	//
+	// Example usage:
+	// metrics.RateLimitExemptionsTotal.WithLabelValues("cidr").Inc()
+	RateLimitExemptionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_rate_limit_exemptions_total",
+			Help: "Number of requests exempted from limits.",
+		},
+		[]string{"reason"},
+	)
+
+	// EmergencyBrakeEngaged is set to 1 while the emergency brake is engaged
+	// and only API-key traffic is served, and 0 otherwise.
+	//
+	// Example usage:
+	// metrics.EmergencyBrakeEngaged.Set(1)
+	EmergencyBrakeEngaged = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "locate_emergency_brake_engaged",
+			Help: "Whether the emergency brake is engaged.",
+		},
+	)
+
+	// APIKeyVerificationsTotal counts the number of requests with an M-Lab
+	// API key, by verification result.
+	//
+	// Example usage:
+	// metrics.APIKeyVerificationsTotal.WithLabelValues("valid").Inc()
+	APIKeyVerificationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_api_key_verifications_total",
+			Help: "Number of API key verifications.",
+		},
+		[]string{"status"},
+	)
+
+	// APIKeyCacheTotal counts the number of API key verification cache
+	// lookups.
+	//
+	// Example usage:
+	// metrics.APIKeyCacheTotal.WithLabelValues("hit").Inc()
+	APIKeyCacheTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_api_key_cache_total",
+			Help: "Number of API key verification cache lookups.",
+		},
+		[]string{"status"},
+	)
+
+	// IntegrationRequestsTotal counts the number of requests with a verified
+	// API key, by integration.
+	//
+	// Example usage:
+	// metrics.IntegrationRequestsTotal.WithLabelValues("partner").Inc()
+	IntegrationRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_integration_requests_total",
+			Help: "Number of requests with a verified API key, by integration.",
+		},
+		[]string{"integration"},
+	)
+
+	// APIKeyUsageFlushesTotal counts the number of times the per-integration
+	// usage counts are flushed to Redis.
+	//
+	// Example usage:
+	// metrics.APIKeyUsageFlushesTotal.WithLabelValues("OK").Inc()
+	APIKeyUsageFlushesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_api_key_usage_flushes_total",
+			Help: "Number of flushes of the per-integration usage counts.",
+		},
+		[]string{"status"},
+	)
+
 	// CurrentHeartbeatConnections counts the number of currently active
 	// Heartbeat connections.
 	//
@@ -45,6 +254,19 @@ var (
 		[]string{"experiment"},
 	)
 
+	// HeartbeatProtocolVersions counts the number of currently active
+	// Heartbeat connections by the protocol version of the agent.
+	//
+	// Example usage:
+	// metrics.HeartbeatProtocolVersions.WithLabelValues("1").Inc()
+	HeartbeatProtocolVersions = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "locate_heartbeat_protocol_versions",
+			Help: "Number of currently active Heartbeat connections by agent protocol version.",
+		},
+		[]string{"version"},
+	)
+
 	// LocateHealthStatus exposes the health status collected by the Locate Service.
 	LocateHealthStatus = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -54,8 +276,43 @@ var (
 		[]string{"experiment"},
 	)
 
+	// LocateSiteHealthyInstances exposes the number of healthy instances per
+	// site, so that site-level outages can be alerted on. Sites without
+	// instances report zero. The number of distinct sites is bounded.
+	//
+	// Example usage:
+	// metrics.LocateSiteHealthyInstances.WithLabelValues("lga03").Set(4)
+	LocateSiteHealthyInstances = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "locate_site_healthy_instances",
+			Help: "Number of healthy instances per site collected by the Locate Service.",
+		},
+		[]string{"site"},
+	)
+
+	// LocateSiteProbability exposes the probability in effect for each site,
+	// i.e. the probability of keeping the site as a candidate for requests
+	// without site, org or virtual machine-type filters. The number of
+	// distinct sites is bounded.
+	//
+	// Example usage:
+	// metrics.LocateSiteProbability.WithLabelValues("lga03").Set(0.5)
+	LocateSiteProbability = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "locate_site_probability",
+			Help: "Probability in effect for each site collected by the Locate Service.",
+		},
+		[]string{"site"},
+	)
+
 	// LocateMemorystoreRequestDuration is a histogram that tracks the latency of
-	// requests from the Locate to Memorystore.
+	// requests from the Locate to Memorystore. The type is "put", "get", "del"
+	// or "put batch" (e.g., the Prometheus health updates of all instances),
+	// the field is the hash field written or "all", and the status is "OK" or
+	// the failed step.
+	//
+	// Example usage:
+	// metrics.LocateMemorystoreRequestDuration.WithLabelValues("put", "Health", "OK").Observe(0.001)
 	LocateMemorystoreRequestDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name: "locate_memorystore_request_duration",
@@ -76,6 +333,20 @@ var (
 		[]string{"status"},
 	)
 
+	// ImportMemorystoreChangesTotal counts the number of instances added,
+	// removed, or with a changed registration on each import of the data in
+	// Memorystore, making mass-expiry events visible.
+	//
+	// Example usage:
+	// metrics.ImportMemorystoreChangesTotal.WithLabelValues("removed").Add(3)
+	ImportMemorystoreChangesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_import_memorystore_changes_total",
+			Help: "Number of instances added, removed or changed by the imports of the data in Memorystore.",
+		},
+		[]string{"change"},
+	)
+
 	// RequestHandlerDuration is a histogram that tracks the latency of each request handler.
 	RequestHandlerDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -85,6 +356,21 @@ var (
 		[]string{"path", "code"},
 	)
 
+	// NearestStageDuration is a histogram that tracks the latency of each
+	// stage of the Nearest handler, decomposing RequestHandlerDuration. The
+	// stages are "client location", "locator", "urls" and "encode".
+	//
+	// Example usage:
+	// metrics.NearestStageDuration.WithLabelValues("locator").Observe(0.001)
+	NearestStageDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "locate_nearest_stage_duration",
+			Help:    "A histogram of latencies for each stage of the nearest handler.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		},
+		[]string{"stage"},
+	)
+
 	// ServerDistanceRanking is a histogram that tracks the ranked distance of the returned servers
 	// with respect to the client.
 	// Numbering is zero-based.
@@ -115,6 +401,21 @@ var (
 		[]string{"index"},
 	)
 
+	// SiteSelectionsTotal counts the sites and metros of the returned servers.
+	// The position is "first" for the 1st server in the list and "other" for
+	// the rest. The number of distinct sites is bounded; the selections of
+	// further sites are counted with the "other" site and metro.
+	//
+	// Example usage:
+	// metrics.SiteSelectionsTotal.WithLabelValues("lga03", "lga", "first").Inc()
+	SiteSelectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "locate_site_selections_total",
+			Help: "Number of times each site and metro was returned, by position in the list.",
+		},
+		[]string{"site", "metro", "position"},
+	)
+
 	// ConnectionRequestsTotal counts the number of (re)connection requests the Heartbeat Service
 	// makes to the Locate Service.
 	ConnectionRequestsTotal = promauto.NewCounterVec(
@@ -125,6 +426,56 @@ var (
 		[]string{"status"},
 	)
 
+	// ConnectionReconnectsTotal counts the number of times the Heartbeat
+	// Service reconnects to the Locate Service, by the cause of the
+	// disconnection (e.g., "write error" or "pong timeout").
+	ConnectionReconnectsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "connection_reconnects_total",
+			Help: "Number of reconnections from the HBS to the Locate Service by cause.",
+		},
+		[]string{"cause"},
+	)
+
+	// ConnectionBackoffSecondsTotal counts the time the Heartbeat Service
+	// spends waiting to retry failed connection requests.
+	ConnectionBackoffSecondsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "connection_backoff_seconds_total",
+			Help: "Cumulative time the HBS waited to retry connections to the Locate Service.",
+		},
+	)
+
+	// ConnectionEstablishedTime is the Unix time at which the current
+	// connection to the Locate Service was established, or 0 while
+	// disconnected. The connection age is the difference with time().
+	ConnectionEstablishedTime = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "connection_established_time",
+			Help: "Unix time at which the current HBS connection was established.",
+		},
+	)
+
+	// ConnectionLastError is 1 for the class of the last connection error of
+	// the Heartbeat Service (e.g., "dns", "refused" or "http 5xx"), and 0
+	// for the other classes.
+	ConnectionLastError = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "connection_last_error",
+			Help: "Class of the last connection error of the HBS.",
+		},
+		[]string{"class"},
+	)
+
+	// ConnectionQueueDropsTotal counts the number of messages dropped because
+	// the outgoing message queue of a connection was full.
+	ConnectionQueueDropsTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "connection_queue_drops_total",
+			Help: "Number of messages dropped from the HBS outgoing message queue.",
+		},
+	)
+
 	// PortChecksTotal counts the number of port checks performed by the Heartbeat
 	// Service.
 	PortChecksTotal = promauto.NewCounterVec(
@@ -155,6 +506,36 @@ var (
 		[]string{"status"},
 	)
 
+	// HealthExecChecksTotal counts the number of health checks performed by
+	// running an operator-provided program in the Heartbeat Service.
+	HealthExecChecksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "heartbeat_health_exec_checks_total",
+			Help: "Number of health checks the HBS has done by running a program",
+		},
+		[]string{"status"},
+	)
+
+	// HealthSystemScore tracks the latest health score of each system
+	// resource (cpu, nic, disk) computed by the Heartbeat Service.
+	HealthSystemScore = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "heartbeat_health_system_score",
+			Help: "Latest health score of each system resource",
+		},
+		[]string{"resource"},
+	)
+
+	// LoadChecksTotal counts the number of times the Heartbeat Service read
+	// the number of tests in progress from the local experiment.
+	LoadChecksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "heartbeat_load_checks_total",
+			Help: "Number of local experiment load checks the HBS has done",
+		},
+		[]string{"status"},
+	)
+
 	// KubernetesRequestTimeHistogram tracks the request latency from the Heartbeat
 	// Service to the Kubernetes API server (in seconds).
 	KubernetesRequestTimeHistogram = promauto.NewHistogramVec(
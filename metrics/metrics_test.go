@@ -9,15 +9,43 @@ import (
 func TestLintMetrics(t *testing.T) {
 	RequestsTotal.WithLabelValues("type", "condition", "status")
 	AppEngineTotal.WithLabelValues("country")
+	ClientCountryRequestsTotal.WithLabelValues("service", "country")
+	ClientASNRequestsTotal.WithLabelValues("asn")
+	ClientASNLookupsTotal.WithLabelValues("status")
+	ClientgeoCacheTotal.WithLabelValues("status")
+	ClientgeoLocatorTotal.WithLabelValues("locator", "status")
+	ClientgeoSelectedTotal.WithLabelValues("locator")
+	RateLimitedTotal.WithLabelValues("type")
+	RateLimitExemptionsTotal.WithLabelValues("reason")
+	RateLimitAdaptiveTotal.WithLabelValues("service")
+	EmergencyBrakeEngaged.Set(0)
+	APIKeyVerificationsTotal.WithLabelValues("status")
+	APIKeyCacheTotal.WithLabelValues("status")
+	IntegrationRequestsTotal.WithLabelValues("integration")
+	APIKeyUsageFlushesTotal.WithLabelValues("status")
 	CurrentHeartbeatConnections.WithLabelValues("experiment").Set(0)
+	HeartbeatProtocolVersions.WithLabelValues("version").Set(0)
 	LocateHealthStatus.WithLabelValues("experiment").Set(0)
+	LocateSiteHealthyInstances.WithLabelValues("site").Set(0)
+	LocateSiteProbability.WithLabelValues("site").Set(0)
 	LocateMemorystoreRequestDuration.WithLabelValues("type", "command", "status")
 	ImportMemorystoreTotal.WithLabelValues("status")
+	ImportMemorystoreChangesTotal.WithLabelValues("change")
 	RequestHandlerDuration.WithLabelValues("path", "code")
+	NearestStageDuration.WithLabelValues("stage")
 	ServerDistanceRanking.WithLabelValues("index")
 	MetroDistanceRanking.WithLabelValues("index")
+	SiteSelectionsTotal.WithLabelValues("site", "metro", "position")
 	ConnectionRequestsTotal.WithLabelValues("status")
+	ConnectionReconnectsTotal.WithLabelValues("cause")
+	ConnectionQueueDropsTotal.Add(0)
+	ConnectionBackoffSecondsTotal.Add(0)
+	ConnectionEstablishedTime.Set(0)
+	ConnectionLastError.WithLabelValues("class").Set(0)
 	PortChecksTotal.WithLabelValues("status")
+	HealthExecChecksTotal.WithLabelValues("status")
+	HealthSystemScore.WithLabelValues("resource").Set(0)
+	LoadChecksTotal.WithLabelValues("status")
 	KubernetesRequestsTotal.WithLabelValues("type", "status")
 	KubernetesRequestTimeHistogram.WithLabelValues("healthy")
 	RegistrationUpdateTime.Set(0)
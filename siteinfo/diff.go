@@ -0,0 +1,104 @@
+package siteinfo
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/host"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+// Registrations loads the authoritative registration data published by
+// siteinfo (e.g., /v2/sites/registration.json). The data is a map of machine
+// names (physical machines) or service names (autonodes) to registrations.
+type Registrations struct {
+	provider content.Provider
+	mu       sync.Mutex
+	regs     map[string]v2.Registration
+}
+
+// NewRegistrations creates a new Registrations reading from the given
+// provider. The data is loaded on the first call to Get.
+func NewRegistrations(provider content.Provider) *Registrations {
+	return &Registrations{provider: provider}
+}
+
+// Get returns the current registration data. When the data is unchanged since
+// the last call, the previously loaded registrations are returned.
+func (r *Registrations) Get(ctx context.Context) (map[string]v2.Registration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := r.provider.Get(ctx)
+	if err == content.ErrNoChange && r.regs != nil {
+		return r.regs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	regs := make(map[string]v2.Registration)
+	if err := json.Unmarshal(b, &regs); err != nil {
+		return nil, err
+	}
+	r.regs = regs
+	return regs, nil
+}
+
+// DiffResult reports the differences between the machines registered with
+// Locate and the siteinfo registrations. All lists are sorted.
+type DiffResult struct {
+	// MissingFromSiteinfo are the Locate hostnames without a siteinfo
+	// registration.
+	MissingFromSiteinfo []string `json:"missing_from_siteinfo"`
+	// MissingFromLocate are the siteinfo names without a machine registered
+	// with Locate. This includes machines that have stopped sending
+	// heartbeats.
+	MissingFromLocate []string `json:"missing_from_locate"`
+	// Unparsed are the Locate hostnames that could not be parsed, and thus
+	// could not be compared.
+	Unparsed []string `json:"unparsed"`
+}
+
+// Diff compares the machines that Locate knows about with the given siteinfo
+// registrations. Like the heartbeat registration loader, a Locate hostname
+// matches a siteinfo registration by either its machine name or its service
+// name.
+func Diff(msgs map[string]v2.HeartbeatMessage, regs map[string]v2.Registration) DiffResult {
+	result := DiffResult{
+		MissingFromSiteinfo: []string{},
+		MissingFromLocate:   []string{},
+		Unparsed:            []string{},
+	}
+
+	found := make(map[string]bool)
+	for k := range msgs {
+		h, err := host.Parse(k)
+		if err != nil {
+			result.Unparsed = append(result.Unparsed, k)
+			continue
+		}
+		matched := false
+		for _, name := range []string{h.String(), h.StringWithService()} {
+			if _, ok := regs[name]; ok {
+				found[name] = true
+				matched = true
+			}
+		}
+		if !matched {
+			result.MissingFromSiteinfo = append(result.MissingFromSiteinfo, k)
+		}
+	}
+	for name := range regs {
+		if !found[name] {
+			result.MissingFromLocate = append(result.MissingFromLocate, name)
+		}
+	}
+
+	sort.Strings(result.MissingFromSiteinfo)
+	sort.Strings(result.MissingFromLocate)
+	sort.Strings(result.Unparsed)
+	return result
+}
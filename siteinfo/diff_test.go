@@ -0,0 +1,120 @@
+package siteinfo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/content"
+	v2 "github.com/m-lab/locate/api/v2"
+)
+
+type fakeProvider struct {
+	b   []byte
+	err error
+}
+
+func (f *fakeProvider) Get(ctx context.Context) ([]byte, error) {
+	return f.b, f.err
+}
+
+func TestRegistrations_Get(t *testing.T) {
+	ctx := context.Background()
+	p := &fakeProvider{err: content.ErrNoChange}
+	r := NewRegistrations(p)
+
+	// Nothing has been loaded yet.
+	if _, err := r.Get(ctx); err != content.ErrNoChange {
+		t.Errorf("Registrations.Get() error = %v, want %v", err, content.ErrNoChange)
+	}
+
+	p.b, p.err = []byte(`{"mlab1-lga01.mlab-oti.measurement-lab.org": {"Site": "lga01"}}`), nil
+	want := map[string]v2.Registration{
+		"mlab1-lga01.mlab-oti.measurement-lab.org": {Site: "lga01"},
+	}
+	got, err := r.Get(ctx)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Registrations.Get() = %v, %v, want %v", got, err, want)
+	}
+
+	// Unchanged data returns the previous registrations.
+	p.b, p.err = nil, content.ErrNoChange
+	got, err = r.Get(ctx)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Registrations.Get() = %v, %v, want %v", got, err, want)
+	}
+
+	p.b, p.err = nil, errors.New("fake error")
+	if _, err := r.Get(ctx); err == nil {
+		t.Errorf("Registrations.Get() expected error")
+	}
+
+	p.b, p.err = []byte("{"), nil
+	if _, err := r.Get(ctx); err == nil {
+		t.Errorf("Registrations.Get() expected error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs map[string]v2.HeartbeatMessage
+		regs map[string]v2.Registration
+		want DiffResult
+	}{
+		{
+			name: "success-no-drift",
+			msgs: map[string]v2.HeartbeatMessage{
+				// Physical machines match by machine name.
+				"ndt-mlab1-lga01.mlab-oti.measurement-lab.org":  {},
+				"wehe-mlab1-lga01.mlab-oti.measurement-lab.org": {},
+				// Autonodes match by service name.
+				"ndt-lga1234-217f832a.mlab.autojoin.measurement-lab.org": {},
+			},
+			regs: map[string]v2.Registration{
+				"mlab1-lga01.mlab-oti.measurement-lab.org":               {},
+				"ndt-lga1234-217f832a.mlab.autojoin.measurement-lab.org": {},
+			},
+			want: DiffResult{
+				MissingFromSiteinfo: []string{},
+				MissingFromLocate:   []string{},
+				Unparsed:            []string{},
+			},
+		},
+		{
+			name: "success-drift",
+			msgs: map[string]v2.HeartbeatMessage{
+				"ndt-mlab1-lga01.mlab-oti.measurement-lab.org":           {},
+				"ndt-mlab2-lga01.mlab-oti.measurement-lab.org":           {},
+				"ndt-lga1234-217f832a.mlab.autojoin.measurement-lab.org": {},
+				"invalid.hostname": {},
+			},
+			regs: map[string]v2.Registration{
+				"mlab1-lga01.mlab-oti.measurement-lab.org":               {},
+				"mlab3-lga01.mlab-oti.measurement-lab.org":               {},
+				"ndt-lga5678-73a354f1.mlab.autojoin.measurement-lab.org": {},
+			},
+			want: DiffResult{
+				MissingFromSiteinfo: []string{
+					"ndt-lga1234-217f832a.mlab.autojoin.measurement-lab.org",
+					"ndt-mlab2-lga01.mlab-oti.measurement-lab.org",
+				},
+				MissingFromLocate: []string{
+					"mlab3-lga01.mlab-oti.measurement-lab.org",
+					"ndt-lga5678-73a354f1.mlab.autojoin.measurement-lab.org",
+				},
+				Unparsed: []string{
+					"invalid.hostname",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.msgs, tt.regs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
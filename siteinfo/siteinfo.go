@@ -1,47 +1,154 @@
 package siteinfo
 
 import (
-	"fmt"
 	"net/url"
+	"sort"
+	"strings"
 
 	"github.com/m-lab/go/host"
 	v2 "github.com/m-lab/locate/api/v2"
 )
 
+// Unparsed is the name of the Sites entry that groups machines whose
+// hostnames could not be parsed while filtering by org or exp.
+const Unparsed = "unparsed"
+
 // Machines returns a map of machines that Locate knows about. The map values
 // are a combination of a machine's heartbeat registration information and
 // health informatiom from both heartbeat and Prometheus.
-func Machines(msgs map[string]v2.HeartbeatMessage, v url.Values) (map[string]v2.HeartbeatMessage, error) {
-	machines := make(map[string]v2.HeartbeatMessage)
+//
+// Machines are filtered by the org, exp, country, metro, site and type
+// parameters, when given (see filterHosts). Machines whose hostnames cannot
+// be parsed never match the org or exp filters.
+func Machines(msgs map[string]v2.HeartbeatMessage, v url.Values) map[string]v2.HeartbeatMessage {
+	machines, _ := filterHosts(msgs, v)
+	return machines
+}
 
+// filterHosts returns the machines matching all of the given parameters:
+//
+// * org - the organization in the hostname (e.g., mlab)
+// * exp - the experiment in the hostname (e.g., ndt)
+// * country - the registered country code (e.g., US)
+// * metro - the registered metro (e.g., lga)
+// * site - the registered site (e.g., lga01)
+// * type - the registered machine type (e.g., physical, virtual)
+//
+// Machines without a registration never match the country, metro, site or
+// type filters. When filtering by org or exp, the machines whose hostnames
+// cannot be parsed, but which match the other filters, are returned
+// separately as unparsed.
+func filterHosts(msgs map[string]v2.HeartbeatMessage, v url.Values) (machines, unparsed map[string]v2.HeartbeatMessage) {
 	org := v.Get("org")
 	exp := v.Get("exp")
+	country := v.Get("country")
+	metro := v.Get("metro")
+	site := v.Get("site")
+	typ := v.Get("type")
+
+	if org == "" && exp == "" && country == "" && metro == "" && site == "" && typ == "" {
+		return msgs, nil
+	}
 
-	if org != "" || exp != "" {
-		for k, v := range msgs {
+	machines = make(map[string]v2.HeartbeatMessage)
+	unparsed = make(map[string]v2.HeartbeatMessage)
+	for k, m := range msgs {
+		if country != "" || metro != "" || site != "" || typ != "" {
+			r := m.Registration
+			if r == nil {
+				continue
+			}
+			if (country != "" && !strings.EqualFold(country, r.CountryCode)) ||
+				(metro != "" && metro != r.Metro) ||
+				(site != "" && site != r.Site) ||
+				(typ != "" && typ != r.Type) {
+				continue
+			}
+		}
+		if org != "" || exp != "" {
 			parts, err := host.Parse(k)
 			if err != nil {
-				returnError := fmt.Errorf("failed to parse hostname: %s", k)
-				return nil, returnError
+				unparsed[k] = m
+				continue
 			}
-			if org != "" && exp == "" {
-				if org == parts.Org {
-					machines[k] = v
-				}
-			} else if org == "" && exp != "" {
-				if exp == parts.Service {
-					machines[k] = v
-				}
-			} else {
-				if org == parts.Org && exp == parts.Service {
-					machines[k] = v
-				}
+			if (org != "" && org != parts.Org) || (exp != "" && exp != parts.Service) {
+				continue
 			}
 		}
-	} else {
-		machines = msgs
+		machines[k] = m
 	}
+	return machines, unparsed
+}
+
+// Site summarizes the machines of a site.
+type Site struct {
+	Site        string  `json:"site"`
+	Metro       string  `json:"metro"`
+	CountryCode string  `json:"country_code"`
+	Machines    int     `json:"machines"`
+	Healthy     int     `json:"healthy"`
+	Probability float64 `json:"probability"`
+	Uplink      string  `json:"uplink"`
+}
 
-	return machines, nil
+// Sites returns the machines that Locate knows about grouped by site, sorted
+// by site name. Machines are filtered like in Machines, and machines without
+// a registration are ignored. Machines whose hostnames cannot be parsed while
+// filtering by org or exp are counted in a final entry named Unparsed.
+func Sites(msgs map[string]v2.HeartbeatMessage, v url.Values) []Site {
+	machines, unparsed := filterHosts(msgs, v)
 
+	sites := make(map[string]*Site)
+	for _, m := range machines {
+		r := m.Registration
+		if r == nil {
+			continue
+		}
+		s, ok := sites[r.Site]
+		if !ok {
+			s = &Site{
+				Site:        r.Site,
+				Metro:       r.Metro,
+				CountryCode: r.CountryCode,
+				Uplink:      r.Uplink,
+			}
+			sites[r.Site] = s
+		}
+		s.Machines++
+		if isHealthy(m) {
+			s.Healthy++
+		}
+		// All machines of a site should report the same probability.
+		if r.Probability > s.Probability {
+			s.Probability = r.Probability
+		}
+	}
+
+	result := make([]Site, 0, len(sites))
+	for _, s := range sites {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Site < result[j].Site
+	})
+
+	if len(unparsed) > 0 {
+		u := Site{Site: Unparsed, Machines: len(unparsed)}
+		for _, m := range unparsed {
+			if isHealthy(m) {
+				u.Healthy++
+			}
+		}
+		result = append(result, u)
+	}
+	return result
+}
+
+// isHealthy reports whether the machine is healthy according to both the
+// heartbeat and Prometheus.
+func isHealthy(m v2.HeartbeatMessage) bool {
+	if m.Registration == nil || m.Health == nil || m.Health.Score == 0 {
+		return false
+	}
+	return m.Prometheus == nil || m.Prometheus.Health
 }
@@ -114,7 +114,6 @@ func TestMachines(t *testing.T) {
 		params       url.Values
 		expectedKeys []string
 		instances    map[string]v2.HeartbeatMessage
-		wantErr      bool
 	}{
 		{
 			name:      "success-return-all-records",
@@ -167,26 +166,26 @@ func TestMachines(t *testing.T) {
 			},
 		},
 		{
-			name: "error-invalid-hostname",
+			name: "success-invalid-hostname-excluded",
 			instances: map[string]v2.HeartbeatMessage{
 				"invalid.hostname": {},
+				"ndt-oma7777-217f832a.mlab.sandbox.measurement-lab.org": {},
 			},
 			params: url.Values{
 				"org": {
 					"mlab",
 				},
 			},
-			wantErr: true,
+			expectedKeys: []string{
+				"ndt-oma7777-217f832a.mlab.sandbox.measurement-lab.org",
+			},
 		},
 	}
 
 	for _, test := range tests {
 		var resultKeys []string
 
-		result, err := Machines(test.instances, test.params)
-		if (err != nil) != test.wantErr {
-			t.Errorf("Machines() error = %v, wantErr %v", err, test.wantErr)
-		}
+		result := Machines(test.instances, test.params)
 
 		for k := range result {
 			resultKeys = append(resultKeys, k)
@@ -199,3 +198,211 @@ func TestMachines(t *testing.T) {
 		}
 	}
 }
+
+func TestSites(t *testing.T) {
+	instances := map[string]v2.HeartbeatMessage{
+		// A second, unhealthy machine at the oma7777 site.
+		"ndt-oma7777-5e3c1d2b.mlab.sandbox.measurement-lab.org": {
+			Health: &v2.Health{
+				Score: 0,
+			},
+			Registration: &v2.Registration{
+				CountryCode: "US",
+				Experiment:  "ndt",
+				Hostname:    "ndt-oma7777-5e3c1d2b.mlab.sandbox.measurement-lab.org",
+				Machine:     "5e3c1d2b",
+				Metro:       "oma",
+				Probability: 0.1,
+				Site:        "oma7777",
+				Uplink:      "unknown",
+			},
+		},
+		// Machines without a registration are ignored.
+		"ndt-lga9999-5e3c1d2b.mlab.sandbox.measurement-lab.org": {
+			Health: &v2.Health{
+				Score: 1,
+			},
+		},
+		// Machines with invalid hostnames are unparsed when filtering by org.
+		"invalid.hostname": {
+			Health: &v2.Health{
+				Score: 1,
+			},
+			Registration: &v2.Registration{
+				Site: "xyz0t",
+			},
+		},
+	}
+	for k, v := range testInstances {
+		instances[k] = v
+	}
+
+	tests := []struct {
+		name   string
+		params url.Values
+		want   []Site
+	}{
+		{
+			name: "success-all-sites",
+			want: []Site{
+				{Site: "chs9999", Metro: "chs", CountryCode: "US", Machines: 1, Healthy: 0, Probability: 0.5, Uplink: "unknown"},
+				{Site: "dfw8888", Metro: "dfw", CountryCode: "US", Machines: 1, Healthy: 1, Probability: 0.1, Uplink: "unknown"},
+				{Site: "oma7777", Metro: "oma", CountryCode: "US", Machines: 2, Healthy: 1, Probability: 0.1, Uplink: "unknown"},
+				{Site: "xyz0t", Machines: 1, Healthy: 1},
+			},
+		},
+		{
+			name: "success-filtered",
+			params: url.Values{
+				"org": {"testorg"},
+			},
+			want: []Site{
+				{Site: "dfw8888", Metro: "dfw", CountryCode: "US", Machines: 1, Healthy: 1, Probability: 0.1, Uplink: "unknown"},
+				{Site: Unparsed, Machines: 1, Healthy: 1},
+			},
+		},
+		{
+			name: "success-filtered-without-unparsed",
+			params: url.Values{
+				"org":   {"mlab"},
+				"metro": {"chs"},
+			},
+			want: []Site{
+				{Site: "chs9999", Metro: "chs", CountryCode: "US", Machines: 1, Healthy: 0, Probability: 0.5, Uplink: "unknown"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Sites(instances, tt.params)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sites() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_filterHosts(t *testing.T) {
+	instances := map[string]v2.HeartbeatMessage{
+		"ndt-lga1234-217f832a.mlab.sandbox.measurement-lab.org": {
+			Registration: &v2.Registration{CountryCode: "US", Metro: "lga", Site: "lga1234", Type: "virtual"},
+		},
+		"ndt-lga5678-73a354f1.testorg.sandbox.measurement-lab.org": {
+			Registration: &v2.Registration{CountryCode: "US", Metro: "lga", Site: "lga5678", Type: "physical"},
+		},
+		"msak-ham1234-ab285f12.mlab.sandbox.measurement-lab.org": {
+			Registration: &v2.Registration{CountryCode: "DE", Metro: "ham", Site: "ham1234", Type: "virtual"},
+		},
+		// Machines without a registration only match hostname filters.
+		"ndt-ham5678-5e3c1d2b.mlab.sandbox.measurement-lab.org": {},
+		// Machines with invalid hostnames never match hostname filters.
+		"invalid.hostname": {
+			Registration: &v2.Registration{CountryCode: "DE", Metro: "ham", Site: "ham0t", Type: "virtual"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		params       url.Values
+		want         []string
+		wantUnparsed []string
+	}{
+		{
+			name: "country",
+			params: url.Values{
+				"country": {"de"},
+			},
+			want: []string{
+				"invalid.hostname",
+				"msak-ham1234-ab285f12.mlab.sandbox.measurement-lab.org",
+			},
+		},
+		{
+			name: "metro-and-type",
+			params: url.Values{
+				"metro": {"lga"},
+				"type":  {"physical"},
+			},
+			want: []string{
+				"ndt-lga5678-73a354f1.testorg.sandbox.measurement-lab.org",
+			},
+		},
+		{
+			name: "site",
+			params: url.Values{
+				"site": {"lga1234"},
+			},
+			want: []string{
+				"ndt-lga1234-217f832a.mlab.sandbox.measurement-lab.org",
+			},
+		},
+		{
+			name: "org-exp-and-type",
+			params: url.Values{
+				"org":  {"mlab"},
+				"exp":  {"ndt"},
+				"type": {"virtual"},
+			},
+			want: []string{
+				"ndt-lga1234-217f832a.mlab.sandbox.measurement-lab.org",
+			},
+			wantUnparsed: []string{
+				"invalid.hostname",
+			},
+		},
+		{
+			name: "org-and-exp-without-registration",
+			params: url.Values{
+				"org": {"mlab"},
+				"exp": {"ndt"},
+			},
+			want: []string{
+				"ndt-ham5678-5e3c1d2b.mlab.sandbox.measurement-lab.org",
+				"ndt-lga1234-217f832a.mlab.sandbox.measurement-lab.org",
+			},
+			wantUnparsed: []string{
+				"invalid.hostname",
+			},
+		},
+		{
+			name: "org-and-country-unparsed",
+			params: url.Values{
+				"org":     {"mlab"},
+				"country": {"DE"},
+			},
+			want: []string{
+				"msak-ham1234-ab285f12.mlab.sandbox.measurement-lab.org",
+			},
+			wantUnparsed: []string{
+				"invalid.hostname",
+			},
+		},
+		{
+			name: "no-match",
+			params: url.Values{
+				"country": {"US"},
+				"metro":   {"ham"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unparsed := filterHosts(instances, tt.params)
+			if keys := sortedKeys(got); !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("filterHosts() = %v, want %v", keys, tt.want)
+			}
+			if keys := sortedKeys(unparsed); !reflect.DeepEqual(keys, tt.wantUnparsed) {
+				t.Errorf("filterHosts() unparsed = %v, want %v", keys, tt.wantUnparsed)
+			}
+		})
+	}
+}
+
+func sortedKeys(m map[string]v2.HeartbeatMessage) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -13,8 +13,11 @@ const (
 	AudienceLocate             = "locate"
 	IssuerMonitoring           = "monitoring"
 	SubjectMonitoring          = "monitoring"
+	IssuerOrg                  = "org"
 	WebsocketBufferSize        = 1 << 10 // 1024 bytes.
 	WebsocketReadDeadline      = 30 * time.Second
+	WebsocketPingInterval      = 10 * time.Second
+	WebsocketPongTimeout       = 5 * time.Second
 	BackoffInitialInterval     = time.Second
 	BackoffRandomizationFactor = 0.5
 	BackoffMultiplier          = 2
@@ -22,13 +25,19 @@ const (
 	BackoffMaxElapsedTime      = 0
 	HealthEndpointTimeout      = 5 * time.Second
 	HeartbeatPeriod            = 10 * time.Second
+	HeartbeatPeriodMin         = time.Second
+	HeartbeatPeriodMax         = 20 * time.Second // Below RedisKeyExpirySecs.
+	HealthEndpointTimeoutMin   = 100 * time.Millisecond
 	MemorystoreExportPeriod    = 10 * time.Second
 	PrometheusCheckPeriod      = time.Minute
 	RedisKeyExpirySecs         = 30
+	AccessTokenExpiry          = time.Minute
+	PriorityTokenExpiry        = 10 * time.Minute
 	RegistrationLoadMin        = 3 * time.Hour
 	RegistrationLoadExpected   = 12 * time.Hour
 	RegistrationLoadMax        = 24 * time.Hour
 	EarthHalfCircumferenceKm   = 20038
+	CoarseAccuracyKm           = 500
 	EarlyExitParameter         = "early_exit"
 	MaxCwndGainParameter       = "max_cwnd_gain"
 	MaxElapsedTimeParameter    = "max_elapsed_time"
@@ -79,6 +88,12 @@ var Configs = map[string]Ports{
 	},
 }
 
+// DeprecatedServices maps the services scheduled for retirement to the
+// warning returned with their nearest results.
+var DeprecatedServices = map[string]string{
+	"ndt/ndt5": "ndt5 is deprecated: use ndt7",
+}
+
 // Ports maps names to URLs.
 type Ports []url.URL
 